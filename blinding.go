@@ -0,0 +1,174 @@
+package echalotte
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// Errors used by blinded keys.
+const (
+	ErrInvalidBlindingPeriod = "blinding period should be at least one second"
+	ErrInvalidBlinding       = "DHT key doesn't match the record's blinded peer ID"
+	ErrBlindingPeriodExpired = "DHT key blinded for another time period"
+)
+
+// blindingInfo separates blinded DHT keys from other uses of peer IDs.
+const blindingInfo = "echalotte/blind/v1"
+
+// BlindedKeys is an option to store encryption key records under DHT keys
+// blinded with the current time period instead of the peer ID.
+// Only peers that already know the relay's ID can compute its key for the
+// current period, so DHT keys can't be linked to relays or across periods.
+// Records still contain the relay's signature key though: DHT nodes that
+// store them learn the identity of the relays they store records for.
+// Records are also published for the next period, so that lookups don't
+// fail around period boundaries.
+// All hosts of the network should use the same period.
+func BlindedKeys(period time.Duration) HostOption {
+	return func(opts *HostOptions) error {
+		if period < time.Second {
+			return errors.New(ErrInvalidBlindingPeriod)
+		}
+
+		opts.BlindingPeriod = period
+		return nil
+	}
+}
+
+// KeyCacheBlindingPeriod is an option to fetch keys stored under blinded
+// DHT keys (see BlindedKeys).
+func KeyCacheBlindingPeriod(period time.Duration) KeyCacheOption {
+	return func(opts *KeyCacheOptions) error {
+		if period < time.Second {
+			return errors.New(ErrInvalidBlindingPeriod)
+		}
+
+		opts.BlindingPeriod = period
+		return nil
+	}
+}
+
+// CreateBlindedKey returns the DHT key of the given peer's encryption key
+// for the given time period.
+// Keys are in the form `/enc/$periodSeconds/$period/$blindedID`.
+func (pkv PublicKeyValidator) CreateBlindedKey(peerID peer.ID, period time.Duration, index uint64) string {
+	seconds := uint64(period / time.Second)
+	return fmt.Sprintf("/%s/%d/%d/%s", EncryptionNamespace, seconds, index, blindPeerID(peerID, seconds, index))
+}
+
+// publicationKeys returns the DHT keys the given peer's records should be
+// published under.
+func (pkv PublicKeyValidator) publicationKeys(peerID peer.ID, now time.Time) []string {
+	if pkv.BlindingPeriod == 0 {
+		return []string{pkv.CreateKey(peerID)}
+	}
+
+	index := KeyEpoch(now, pkv.BlindingPeriod)
+	return []string{
+		pkv.CreateBlindedKey(peerID, pkv.BlindingPeriod, index),
+		pkv.CreateBlindedKey(peerID, pkv.BlindingPeriod, index+1),
+	}
+}
+
+// blindPeerID hashes the peer ID with the time period.
+func blindPeerID(peerID peer.ID, seconds, index uint64) string {
+	var period [16]byte
+	binary.BigEndian.PutUint64(period[:8], seconds)
+	binary.BigEndian.PutUint64(period[8:], index)
+
+	hash := sha256.New()
+	hash.Write([]byte(blindingInfo))
+	hash.Write(period[:])
+	hash.Write([]byte(peerID))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isBlindedKey returns true if the key is in the form
+// `/enc/$periodSeconds/$period/$blindedID`.
+func isBlindedKey(key string) bool {
+	return strings.Count(key, "/") == 4
+}
+
+// getBlindedPeerID takes a key in the form
+// `/enc/$periodSeconds/$period/$blindedID` and returns the ID of the peer
+// that signed the record, after checking that the key is its blinded ID for
+// a current time period.
+// Records can be stored one period in advance.
+func (pkv PublicKeyValidator) getBlindedPeerID(key string, signatureKeyBytes []byte, now time.Time) (peer.ID, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 || parts[0] != "" {
+		return "", errors.New(ErrInvalidKeyFormat)
+	}
+
+	if parts[1] != EncryptionNamespace {
+		return "", errors.New(ErrInvalidNamespace)
+	}
+
+	seconds, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil || seconds == 0 {
+		return "", errors.New(ErrInvalidKeyFormat)
+	}
+
+	index, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return "", errors.New(ErrInvalidKeyFormat)
+	}
+
+	period := time.Duration(seconds) * time.Second
+	if index < KeyEpoch(now.Add(-pkv.clockSkew()), period) || index > KeyEpoch(now.Add(pkv.clockSkew()), period)+1 {
+		return "", errors.New(ErrBlindingPeriodExpired)
+	}
+
+	signatureKey, err := crypto.UnmarshalPublicKey(signatureKeyBytes)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	peerID, err := peer.IDFromPublicKey(signatureKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if blindPeerID(peerID, seconds, index) != parts[4] {
+		return "", errors.New(ErrInvalidBlinding)
+	}
+
+	return peerID, nil
+}
+
+// publishBlindedKeys publishes our key record for the next time period at
+// the beginning of every period, until the context is done.
+func (h *Host) publishBlindedKeys(ctx context.Context) {
+	period := h.validator.BlindingPeriod
+	for {
+		_, end := epochBounds(KeyEpoch(time.Now(), period), period)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(end)):
+		}
+
+		h.keysLock.Lock()
+		record := h.keyRecord
+		h.keysLock.Unlock()
+
+		for _, key := range h.validator.publicationKeys(h.ID(), time.Now()) {
+			err := h.dht.PutValue(ctx, key, record)
+			if err != nil {
+				log.Errorf("Could not publish blinded encryption key: %s", err.Error())
+			}
+		}
+	}
+}
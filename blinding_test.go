@@ -0,0 +1,107 @@
+package echalotte_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func TestBlindedKeys(t *testing.T) {
+	t.Run("BlindedKeys()", func(t *testing.T) {
+		options := &echalotte.HostOptions{}
+		assert.EqualError(t, options.Apply(echalotte.BlindedKeys(time.Millisecond)), echalotte.ErrInvalidBlindingPeriod)
+		assert.NoError(t, options.Apply(echalotte.BlindedKeys(time.Hour)))
+		assert.Equal(t, time.Hour, options.BlindingPeriod)
+
+		_, err := echalotte.NewKeyCache(echalottetesting.NewInMemoryDHT(), echalotte.KeyCacheBlindingPeriod(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidBlindingPeriod)
+	})
+
+	t.Run("CreateBlindedKey()", func(t *testing.T) {
+		peerID, record := randomKeyRecord(t)
+		otherPeerID, otherRecord := randomKeyRecord(t)
+
+		pkv := echalotte.PublicKeyValidator{BlindingPeriod: time.Hour}
+		current := echalotte.KeyEpoch(time.Now(), time.Hour)
+
+		key := pkv.CreateKey(peerID)
+		assert.Equal(t, pkv.CreateBlindedKey(peerID, time.Hour, current), key)
+		assert.NotEqual(t, pkv.CreateBlindedKey(peerID, time.Hour, current+1), key)
+		assert.NotEqual(t, pkv.CreateBlindedKey(otherPeerID, time.Hour, current), key)
+		assert.False(t, strings.Contains(key, peerID.Pretty()))
+
+		// Blinded keys are validated without knowing the period in advance.
+		assert.NoError(t, echalotte.PublicKeyValidator{}.Validate(key, record))
+		assert.NoError(t, pkv.Validate(pkv.CreateBlindedKey(peerID, time.Hour, current+1), record))
+		assert.NoError(t, pkv.Validate(pkv.CreateBlindedKey(peerID, time.Minute, echalotte.KeyEpoch(time.Now(), time.Minute)), record))
+
+		assert.EqualError(t, pkv.Validate(key, otherRecord), echalotte.ErrInvalidBlinding)
+		assert.EqualError(t, pkv.Validate(pkv.CreateBlindedKey(peerID, time.Hour, current-2), record), echalotte.ErrBlindingPeriodExpired)
+		assert.EqualError(t, pkv.Validate(pkv.CreateBlindedKey(peerID, time.Hour, current+3), record), echalotte.ErrBlindingPeriodExpired)
+		assert.EqualError(t, pkv.Validate("/enc/0/0/"+peerID.Pretty(), record), echalotte.ErrInvalidKeyFormat)
+		assert.EqualError(t, pkv.Validate("/desc/3600/0/"+peerID.Pretty(), record), echalotte.ErrInvalidNamespace)
+
+		// Plain keys are still supported.
+		assert.NoError(t, pkv.Validate(echalotte.PublicKeyValidator{}.CreateKey(peerID), record))
+	})
+
+	t.Run("publishes blinded records", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.BlindedKeys(time.Hour),
+		)
+		require.NoError(t, err)
+
+		_, err = dht.GetValue(ctx, echalotte.PublicKeyValidator{}.CreateKey(relay.ID()))
+		assert.Error(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		next := echalotte.KeyEpoch(time.Now(), time.Hour) + 1
+		_, err = dht.GetValue(ctx, pkv.CreateBlindedKey(relay.ID(), time.Hour, next))
+		assert.NoError(t, err)
+
+		plainCache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		_, err = plainCache.Get(ctx, relay.ID())
+		assert.Error(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht, echalotte.KeyCacheBlindingPeriod(time.Hour))
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, relay.ID())
+		require.NoError(t, err)
+
+		expected, err := relay.EncryptionKey()
+		require.NoError(t, err)
+		assert.Equal(t, expected, key)
+
+		// Senders using the same period find the relay.
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay.ID()}, echalotte.CircuitSize(1)),
+			echalotte.BlindedKeys(time.Hour),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), time.Hour)
+		assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Il était un petit navire")))
+	})
+}
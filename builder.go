@@ -2,9 +2,9 @@ package echalotte
 
 import (
 	"context"
-	crand "crypto/rand"
-	"math/big"
+	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -38,8 +38,13 @@ type CircuitOption func(opts *CircuitOptions) error
 
 // CircuitOptions is a set of circuit options.
 type CircuitOptions struct {
-	Size    int
-	Timeout time.Duration
+	Size       int
+	Timeout    time.Duration
+	Reputation *Reputation
+	Filters    []RelayFilter
+	Exclude    []peer.ID
+	Selector   RelaySelector
+	Rand       *rand.Rand
 }
 
 // Apply the given options to this CircuitOptions.
@@ -73,6 +78,56 @@ func CircuitTimeout(timeout time.Duration) CircuitOption {
 	}
 }
 
+// CircuitReputation is an option to exclude misbehaving relays and favor
+// reliable ones when building circuits.
+func CircuitReputation(reputation *Reputation) CircuitOption {
+	return func(opts *CircuitOptions) error {
+		opts.Reputation = reputation
+		return nil
+	}
+}
+
+// CircuitSelector is an option to choose the policy used to select relays
+// among candidates. Defaults to WeightedSelector.
+func CircuitSelector(selector RelaySelector) CircuitOption {
+	return func(opts *CircuitOptions) error {
+		opts.Selector = selector
+		return nil
+	}
+}
+
+// CircuitRandomness is an option to choose the source of randomness used to
+// select relays.
+// The default source is cryptographically secure: a seeded source should only
+// be used to get reproducible circuits in tests.
+func CircuitRandomness(src rand.Source) CircuitOption {
+	return func(opts *CircuitOptions) error {
+		opts.Rand = rand.New(&lockedSource{src: src})
+		return nil
+	}
+}
+
+// CircuitExclude is an option to never use the given peers as relays.
+// It can be used multiple times.
+func CircuitExclude(peers ...peer.ID) CircuitOption {
+	return func(opts *CircuitOptions) error {
+		opts.Exclude = append(opts.Exclude, peers...)
+		return nil
+	}
+}
+
+// RelayFilter decides whether a discovered relay can be used in circuits.
+type RelayFilter func(context.Context, peerstore.PeerInfo) bool
+
+// CircuitFilter is an option to only use relays accepted by the given filter.
+// It can be used multiple times: relays need to be accepted by all filters.
+func CircuitFilter(filter RelayFilter) CircuitOption {
+	return func(opts *CircuitOptions) error {
+		opts.Filters = append(opts.Filters, filter)
+		return nil
+	}
+}
+
 // CircuitBuilder lets you build random circuits for onion routing.
 type CircuitBuilder interface {
 	Build(context.Context, ...CircuitOption) (Circuit, error)
@@ -81,45 +136,47 @@ type CircuitBuilder interface {
 // DiscoveryCircuitBuilder lets you build random circuits for onion routing
 // based on a discovery.Discoverer.
 type DiscoveryCircuitBuilder struct {
-	discover discovery.Discoverer
+	discover discovery.Discovery
 	options  CircuitOptions
 }
 
 // NewCircuitBuilder creates a new circuit builder that leverages the given
 // discovery component to find other peers that provide onion relays.
+// Hosts with the RoleRelay role advertise themselves through it (see
+// Advertise).
 func NewCircuitBuilder(ctx context.Context, discover discovery.Discovery, opts ...CircuitOption) (CircuitBuilder, error) {
 	options := &CircuitOptions{
 		Size:    DefaultCircuitSize,
 		Timeout: DefaultCircuitTimeout,
+		Rand:    newCryptoRand(),
 	}
 	err := options.Apply(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info("Advertising onion relay...")
-
-	// TODO: set appropriate TTL option and auto-refresh in go routine.
-	// This will prevent peers that aren't responsive from being chosen in an
-	// onion circuit.
-	// Test how it behaves in a real network.
-	_, err = discover.Advertise(ctx, OnionRelay)
-	if err != nil {
-		return nil, errors.Wrap(err, ErrAdvertise)
-	}
-
 	return &DiscoveryCircuitBuilder{
 		discover: discover,
 		options:  *options,
 	}, nil
 }
 
+// Advertise the host as an onion relay.
+// It returns how long the advertisement lasts.
+func (cb *DiscoveryCircuitBuilder) Advertise(ctx context.Context) (time.Duration, error) {
+	ttl, err := cb.discover.Advertise(ctx, OnionRelay)
+	if err != nil {
+		return 0, errors.Wrap(err, ErrAdvertise)
+	}
+
+	return ttl, nil
+}
+
 // Build a random circuit between network relay peers.
 func (cb *DiscoveryCircuitBuilder) Build(ctx context.Context, opts ...CircuitOption) (Circuit, error) {
-	options := &CircuitOptions{
-		Size:    cb.options.Size,
-		Timeout: cb.options.Timeout,
-	}
+	options := cb.options
+	options.Filters = append([]RelayFilter(nil), cb.options.Filters...)
+	options.Exclude = append([]peer.ID(nil), cb.options.Exclude...)
 	err := options.Apply(opts...)
 	if err != nil {
 		return nil, err
@@ -127,79 +184,166 @@ func (cb *DiscoveryCircuitBuilder) Build(ctx context.Context, opts ...CircuitOpt
 
 	// Randomize the limit to prevent attackers from discovering the circuit
 	// size by analyzing DHT requests.
-	rlimit, _ := crand.Int(crand.Reader, big.NewInt(int64(2*options.Size)))
-	limit := 4*options.Size + int(rlimit.Int64())
+	limit := 4*options.Size + options.Rand.Intn(2*options.Size)
+
+	// Discovery stops as soon as we collected enough relays.
+	findCtx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
 
-	peerChan, err := cb.discover.FindPeers(ctx, OnionRelay, discovery.Limit(limit))
+	peerChan, err := cb.discover.FindPeers(findCtx, OnionRelay, discovery.Limit(limit))
 	if err != nil {
 		return nil, errors.Wrap(err, ErrFindRelays)
 	}
 
 	// Collect more peers than the circuit size.
 	// Randomize the number of peers chosen to obfuscate circuit size.
-	rcount, _ := crand.Int(crand.Reader, big.NewInt(int64(options.Size)))
-	minRelaysCount := 2*options.Size + int(rcount.Int64())
-	relays, err := cb.findRelays(peerChan, minRelaysCount, options.Timeout)
+	minRelaysCount := 2*options.Size + options.Rand.Intn(options.Size)
+	relays, err := findRelays(findCtx, peerChan, minRelaysCount, options)
+	cancel()
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugf("Collected %d relay nodes for circuit of size %d", len(relays), options.Size)
 
-	circuitRelays := cb.selectRelays(relays, options.Size)
-	return circuitRelays, nil
+	if len(options.Filters) > 0 {
+		relays = filterRelays(ctx, relays, options.Filters, options.Timeout)
+		if len(relays) < options.Size {
+			return nil, errors.Wrap(errors.New("not enough relays accepted by filters"), ErrFindRelays)
+		}
+	}
+
+	if options.Reputation != nil {
+		relays = options.Reputation.filter(relays, options.Size)
+	}
+
+	return options.selectCircuit(relays)
 }
 
-// findRelays synchronously finds the request number of relay peers.
-// If it can't find enough peers before the timeout expires, it will return an
-// error.
-func (cb *DiscoveryCircuitBuilder) findRelays(
+// selectCircuit selects the circuit relays among the candidates with the
+// configured selector.
+func (opts *CircuitOptions) selectCircuit(candidates []peerstore.PeerInfo) (Circuit, error) {
+	selector := opts.Selector
+	if selector == nil {
+		selector = WeightedSelector{}
+	}
+
+	return selector.Select(candidates, SelectionConstraints{
+		Size:       opts.Size,
+		Exclude:    opts.Exclude,
+		Reputation: opts.Reputation,
+		Rand:       opts.Rand,
+	})
+}
+
+// findRelays collects the requested number of distinct relay peers.
+// Excluded peers are ignored.
+// If the peers channel is closed or the context is done before enough relays
+// are found, it returns the relays collected so far if there are enough of
+// them to build a circuit, and an error otherwise.
+func findRelays(
+	ctx context.Context,
 	peerChan <-chan peerstore.PeerInfo,
 	count int,
-	timeout time.Duration,
+	options CircuitOptions,
 ) ([]peerstore.PeerInfo, error) {
-	relays := make([]peerstore.PeerInfo, count)
+	seen := make(map[peer.ID]struct{}, count+len(options.Exclude))
+	for _, p := range options.Exclude {
+		seen[p] = struct{}{}
+	}
+
+	relays := make([]peerstore.PeerInfo, 0, count)
+	partial := func(err error) ([]peerstore.PeerInfo, error) {
+		if len(relays) < options.Size {
+			return nil, errors.Wrap(err, ErrFindRelays)
+		}
+
+		log.Debugf("Collected only %d/%d relay nodes: %s", len(relays), count, err.Error())
+		return relays, nil
+	}
+
+	for len(relays) < count {
+		select {
+		case peerInfo, ok := <-peerChan:
+			if !ok {
+				return partial(errors.New("peers channel closed"))
+			}
+
+			if _, ok := seen[peerInfo.ID]; ok {
+				continue
+			}
 
-	errChan := make(chan error, count)
+			seen[peerInfo.ID] = struct{}{}
+			relays = append(relays, peerInfo)
+		case <-ctx.Done():
+			return partial(errors.New("peers channel timed out"))
+		}
+	}
+
+	return relays, nil
+}
+
+// filterRelays concurrently applies the given filters to the relays.
+func filterRelays(
+	ctx context.Context,
+	relays []peerstore.PeerInfo,
+	filters []RelayFilter,
+	timeout time.Duration,
+) []peerstore.PeerInfo {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	accepted := make([]bool, len(relays))
 	wg := sync.WaitGroup{}
 
-	for i := 0; i < count; i++ {
+	for i := range relays {
 		wg.Add(1)
 
 		go func(i int) {
 			defer wg.Done()
 
-			select {
-			case peerInfo, ok := <-peerChan:
-				if !ok {
-					errChan <- errors.New("peers channel closed")
+			for _, filter := range filters {
+				if !filter(ctx, relays[i]) {
 					return
 				}
-
-				relays[i] = peerInfo
-			case <-time.After(timeout):
-				errChan <- errors.New("peers channel timed out")
 			}
+
+			accepted[i] = true
 		}(i)
 	}
 
 	wg.Wait()
 
-	select {
-	case err := <-errChan:
-		return nil, errors.Wrap(err, ErrFindRelays)
-	default:
-		break
+	var filtered []peerstore.PeerInfo
+	for i, relay := range relays {
+		if accepted[i] {
+			filtered = append(filtered, relay)
+		}
 	}
 
-	return relays, nil
+	log.Debugf("%d/%d relay nodes accepted by filters", len(filtered), len(relays))
+
+	return filtered
 }
 
 // selectRelays randomly selects a subset of the available relays.
-func (cb *DiscoveryCircuitBuilder) selectRelays(relays []peerstore.PeerInfo, count int) Circuit {
-	seed, _ := crand.Int(crand.Reader, big.NewInt(1<<62))
-	rand.Seed(seed.Int64())
-	rand.Shuffle(len(relays), func(i, j int) { relays[i], relays[j] = relays[j], relays[i] })
+// If a reputation is provided, relays with better scores are more likely to
+// be selected.
+// Relays are sorted before being shuffled so that the selection only depends
+// on the random generator and not on the order in which relays were found.
+func selectRelays(relays []peerstore.PeerInfo, count int, reputation *Reputation, rng *rand.Rand) Circuit {
+	sort.Slice(relays, func(i, j int) bool { return relays[i].ID < relays[j].ID })
+	rng.Shuffle(len(relays), func(i, j int) { relays[i], relays[j] = relays[j], relays[i] })
+
+	if reputation != nil {
+		// Weighted random sampling (Efraimidis-Spirakis): sort by u^(1/w).
+		keys := make(map[peer.ID]float64, len(relays))
+		for _, relay := range relays {
+			keys[relay.ID] = math.Pow(rng.Float64(), 1/reputation.Score(relay.ID))
+		}
+
+		sort.SliceStable(relays, func(i, j int) bool { return keys[relays[i].ID] > keys[relays[j].ID] })
+	}
 
 	c := make([]peer.ID, count)
 	for i := 0; i < count; i++ {
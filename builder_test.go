@@ -2,6 +2,7 @@ package echalotte_test
 
 import (
 	"context"
+	"math/rand"
 	"strings"
 	"testing"
 	"time"
@@ -15,12 +16,11 @@ import (
 	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
 	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
 	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmemYsfqwAbyvqwFiApk1GfLKhDkMm8ZQK6fCvzDbaRNyX/go-libp2p-discovery"
 )
 
 // Create a test circuit builder using the given discovery mock.
 func newTestCircuitBuilder(t *testing.T, discover *mocks.MockDiscovery) echalotte.CircuitBuilder {
-	discover.EXPECT().Advertise(gomock.Any(), echalotte.OnionRelay)
-
 	cb, err := echalotte.NewCircuitBuilder(context.Background(), discover)
 	require.NoError(t, err)
 
@@ -28,7 +28,7 @@ func newTestCircuitBuilder(t *testing.T, discover *mocks.MockDiscovery) echalott
 }
 
 func TestCircuitBuilder(t *testing.T) {
-	t.Run("New()", func(t *testing.T) {
+	t.Run("Advertise()", func(t *testing.T) {
 		t.Run("wraps advertiser error", func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
@@ -39,9 +39,9 @@ func TestCircuitBuilder(t *testing.T) {
 				errors.New("fatal"),
 			)
 
-			cb, err := echalotte.NewCircuitBuilder(context.Background(), discover)
+			cb := newTestCircuitBuilder(t, discover)
+			_, err := cb.(*echalotte.DiscoveryCircuitBuilder).Advertise(context.Background())
 			assert.EqualError(t, errors.Cause(err), "fatal")
-			assert.Nil(t, cb)
 		})
 
 		t.Run("advertises onion relay", func(t *testing.T) {
@@ -49,8 +49,12 @@ func TestCircuitBuilder(t *testing.T) {
 			defer ctrl.Finish()
 
 			discover := mocks.NewMockDiscovery(ctrl)
+			discover.EXPECT().Advertise(gomock.Any(), echalotte.OnionRelay).Return(time.Hour, nil)
+
 			cb := newTestCircuitBuilder(t, discover)
-			assert.NotNil(t, cb)
+			ttl, err := cb.(*echalotte.DiscoveryCircuitBuilder).Advertise(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, time.Hour, ttl)
 		})
 	})
 
@@ -135,6 +139,99 @@ func TestCircuitBuilder(t *testing.T) {
 			assert.Nil(t, c)
 		})
 
+		t.Run("ignores duplicate and excluded relays", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			// Only relays 5 to 9 are usable.
+			relaysChan := make(chan peerstore.PeerInfo)
+			go func() {
+				for n := 0; n < 10; n++ {
+					for i := 0; i < 10; i++ {
+						relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+					}
+				}
+
+				close(relaysChan)
+			}()
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			var excluded []peer.ID
+			for i := 0; i < 5; i++ {
+				excluded = append(excluded, peer.ID(i))
+			}
+
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+				echalotte.CircuitExclude(excluded...),
+			)
+			require.NoError(t, err)
+			require.Len(t, c, 5)
+			assert.ElementsMatch(t, c, []peer.ID{peer.ID(5), peer.ID(6), peer.ID(7), peer.ID(8), peer.ID(9)})
+		})
+
+		t.Run("stops once enough relays are collected", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			// Discovery never ends until its context is cancelled.
+			var discoveryCtx context.Context
+			relaysChan := make(chan peerstore.PeerInfo)
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, _ string, _ ...discovery.Option) (<-chan peerstore.PeerInfo, error) {
+					discoveryCtx = ctx
+					go func() {
+						for i := 0; ; i++ {
+							select {
+							case relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}:
+							case <-ctx.Done():
+								return
+							}
+						}
+					}()
+
+					return relaysChan, nil
+				},
+			)
+
+			start := time.Now()
+			c, err := cb.Build(context.Background(), echalotte.CircuitTimeout(time.Second))
+			require.NoError(t, err)
+			require.Len(t, c, 5)
+			assert.True(t, time.Since(start) < 500*time.Millisecond)
+			assert.Error(t, discoveryCtx.Err())
+		})
+
+		t.Run("uses relays collected before timeout", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			// Only a few relays are found before discovery stalls.
+			relaysChan := make(chan peerstore.PeerInfo, 4)
+			for i := 0; i < 4; i++ {
+				relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+			}
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitSize(3),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+			)
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+		})
+
 		t.Run("builds random circuit", func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
@@ -161,5 +258,172 @@ func TestCircuitBuilder(t *testing.T) {
 			require.Len(t, c, 5)
 			assert.NotSubset(t, c, []peer.ID{peer.ID(0), peer.ID(1), peer.ID(2), peer.ID(3), peer.ID(4)})
 		})
+
+		t.Run("builds reproducible circuits with seeded randomness", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			build := func() echalotte.Circuit {
+				relaysChan := make(chan peerstore.PeerInfo)
+				go func() {
+					for i := 0; i < 100; i++ {
+						relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+					}
+
+					close(relaysChan)
+				}()
+
+				discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+				c, err := cb.Build(context.Background(),
+					echalotte.CircuitTimeout(10*time.Millisecond),
+					echalotte.CircuitRandomness(rand.NewSource(42)),
+				)
+				require.NoError(t, err)
+
+				return c
+			}
+
+			assert.Equal(t, build(), build())
+		})
+
+		t.Run("uses relay selector", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			relaysChan := make(chan peerstore.PeerInfo)
+			go func() {
+				for i := 0; i < 20; i++ {
+					relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+				}
+
+				close(relaysChan)
+			}()
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			gs := echalotte.NewGuardSelector(1, time.Hour, nil)
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitSize(3),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+				echalotte.CircuitSelector(gs),
+			)
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+			assert.Equal(t, gs.Guards(), []peer.ID{c[2]})
+		})
+
+		t.Run("applies relay filters", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			relaysChan := make(chan peerstore.PeerInfo)
+			go func() {
+				for i := 0; i < 20; i++ {
+					relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+				}
+
+				close(relaysChan)
+			}()
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			oddRelays := func(_ context.Context, relay peerstore.PeerInfo) bool {
+				return relay.ID[0]%2 == 1
+			}
+
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitSize(3),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+				echalotte.CircuitFilter(oddRelays),
+			)
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+			for _, relay := range c {
+				assert.Equal(t, byte(1), relay[0]%2)
+			}
+		})
+
+		t.Run("fails when filters reject too many relays", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			relaysChan := make(chan peerstore.PeerInfo)
+			go func() {
+				for i := 0; i < 20; i++ {
+					relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+				}
+
+				close(relaysChan)
+			}()
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			noRelays := func(context.Context, peerstore.PeerInfo) bool { return false }
+
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+				echalotte.CircuitFilter(noRelays),
+			)
+			assert.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), echalotte.ErrFindRelays))
+			assert.Nil(t, c)
+		})
+
+		t.Run("excludes misbehaving relays", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			discover := mocks.NewMockDiscovery(ctrl)
+			cb := newTestCircuitBuilder(t, discover)
+
+			reputation, err := echalotte.NewReputation(
+				echalotte.ReputationFailureInterval(0),
+				echalotte.ReputationExclusion(1, 0.4),
+			)
+			require.NoError(t, err)
+
+			// Exclude half of the relays.
+			var excluded []peer.ID
+			for i := 0; i < 20; i += 2 {
+				excluded = append(excluded, peer.ID(i))
+				reputation.Record(peer.ID(i), echalotte.OutcomeFailure)
+				reputation.Record(peer.ID(i), echalotte.OutcomeFailure)
+			}
+
+			relaysChan := make(chan peerstore.PeerInfo)
+			go func() {
+				for i := 0; i < 20; i++ {
+					relaysChan <- peerstore.PeerInfo{ID: peer.ID(i)}
+				}
+
+				close(relaysChan)
+			}()
+
+			discover.EXPECT().FindPeers(gomock.Any(), echalotte.OnionRelay, gomock.Any()).Return(relaysChan, nil)
+
+			c, err := cb.Build(context.Background(),
+				echalotte.CircuitSize(3),
+				echalotte.CircuitTimeout(10*time.Millisecond),
+				echalotte.CircuitReputation(reputation),
+			)
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+			for _, relay := range c {
+				assert.NotContains(t, excluded, relay)
+			}
+		})
 	})
 }
@@ -18,3 +18,41 @@ func (c Circuit) String() string {
 
 	return strings.Join(relays, " -> ")
 }
+
+// contains returns true if one of the given peers is part of the circuit.
+func (c Circuit) contains(peers []peer.ID) bool {
+	for _, p := range peers {
+		if containsPeer(c, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameRelays returns true if both circuits use the same relays, possibly in
+// a different order.
+func (c Circuit) sameRelays(other Circuit) bool {
+	if len(c) != len(other) {
+		return false
+	}
+
+	for _, relay := range other {
+		if !containsPeer(c, relay) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsPeer returns true if the peer is in the given list.
+func containsPeer(peers []peer.ID, p peer.ID) bool {
+	for _, candidate := range peers {
+		if candidate == p {
+			return true
+		}
+	}
+
+	return false
+}
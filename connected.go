@@ -0,0 +1,121 @@
+package echalotte
+
+import (
+	"context"
+
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmaoXrM4Z41PD48JY36YqQGKQpLGjyLA2cKcLsES7YddAq/go-libp2p-host"
+)
+
+// ConnectedCircuitBuilder builds random circuits between relays we are
+// connected to or that are known by our peerstore.
+// Relays are peers that support RelayProtocolID (learnt via identify).
+// This works well in small or private networks where DHT discovery is
+// unreliable.
+type ConnectedCircuitBuilder struct {
+	host     host.Host
+	fallback CircuitBuilder
+	options  CircuitOptions
+}
+
+// NewConnectedCircuitBuilder creates a circuit builder that selects relays
+// among known peers.
+// If a fallback builder is provided (for example a DiscoveryCircuitBuilder),
+// it is used when we don't know enough relays.
+func NewConnectedCircuitBuilder(h host.Host, fallback CircuitBuilder, opts ...CircuitOption) (*ConnectedCircuitBuilder, error) {
+	options := &CircuitOptions{
+		Size:    DefaultCircuitSize,
+		Timeout: DefaultCircuitTimeout,
+		Rand:    newCryptoRand(),
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConnectedCircuitBuilder{
+		host:     h,
+		fallback: fallback,
+		options:  *options,
+	}, nil
+}
+
+// Build a random circuit between known relays.
+func (cb *ConnectedCircuitBuilder) Build(ctx context.Context, opts ...CircuitOption) (Circuit, error) {
+	options := cb.options
+	options.Filters = append([]RelayFilter(nil), cb.options.Filters...)
+	options.Exclude = append([]peer.ID(nil), cb.options.Exclude...)
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	relays := cb.relays(options.Exclude)
+	log.Debugf("Found %d known relay nodes", len(relays))
+
+	if len(options.Filters) > 0 {
+		relays = filterRelays(ctx, relays, options.Filters, options.Timeout)
+	}
+
+	if options.Reputation != nil {
+		relays = options.Reputation.filter(relays, options.Size)
+	}
+
+	if len(relays) < options.Size {
+		if cb.fallback != nil {
+			log.Debug("Not enough known relays, falling back")
+			return cb.fallback.Build(ctx, opts...)
+		}
+
+		return nil, errors.Wrap(errors.New("not enough known relays"), ErrFindRelays)
+	}
+
+	return options.selectCircuit(relays)
+}
+
+// PeerEncryptionKey returns the encryption key of a relay if the fallback
+// builder knows it.
+func (cb *ConnectedCircuitBuilder) PeerEncryptionKey(relay peer.ID) (*[32]byte, bool) {
+	if provider, ok := cb.fallback.(EncryptionKeyProvider); ok {
+		return provider.PeerEncryptionKey(relay)
+	}
+
+	return nil, false
+}
+
+// relays returns connected peers and peers from the peerstore that support
+// the relay protocol.
+func (cb *ConnectedCircuitBuilder) relays(exclude []peer.ID) []peerstore.PeerInfo {
+	ps := cb.host.Peerstore()
+	seen := map[peer.ID]struct{}{cb.host.ID(): {}}
+	for _, p := range exclude {
+		seen[p] = struct{}{}
+	}
+
+	var candidates []peer.ID
+	for _, conn := range cb.host.Network().Conns() {
+		candidates = append(candidates, conn.RemotePeer())
+	}
+
+	candidates = append(candidates, ps.Peers()...)
+
+	var relays []peerstore.PeerInfo
+	for _, p := range candidates {
+		if _, ok := seen[p]; ok {
+			continue
+		}
+
+		seen[p] = struct{}{}
+
+		supported, err := ps.SupportsProtocols(p, string(RelayProtocolID))
+		if err != nil || len(supported) == 0 {
+			continue
+		}
+
+		relays = append(relays, ps.PeerInfo(p))
+	}
+
+	return relays
+}
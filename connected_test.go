@@ -0,0 +1,71 @@
+package echalotte_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func TestConnectedCircuitBuilder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := echalottetesting.RandomHost(ctx, t)
+
+	var relays []peer.ID
+	for i := 0; i < 5; i++ {
+		relay := peer.ID([]byte{byte(i)})
+		relays = append(relays, relay)
+		require.NoError(t, h.Peerstore().AddProtocols(relay, string(echalotte.ProtocolID), string(echalotte.RelayProtocolID)))
+	}
+
+	// Peers that don't support the relay protocol aren't relays.
+	require.NoError(t, h.Peerstore().AddProtocols(peer.ID("not-a-relay"), "/ipfs/kad/1.0.0"))
+	require.NoError(t, h.Peerstore().AddProtocols(peer.ID("client"), string(echalotte.ProtocolID)))
+	require.NoError(t, h.Peerstore().AddProtocols(h.ID(), string(echalotte.ProtocolID), string(echalotte.RelayProtocolID)))
+
+	t.Run("selects known relays", func(t *testing.T) {
+		cb, err := echalotte.NewConnectedCircuitBuilder(h, nil, echalotte.CircuitSize(5))
+		require.NoError(t, err)
+
+		c, err := cb.Build(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, relays, c)
+	})
+
+	t.Run("ignores excluded relays", func(t *testing.T) {
+		cb, err := echalotte.NewConnectedCircuitBuilder(h, nil, echalotte.CircuitSize(3))
+		require.NoError(t, err)
+
+		c, err := cb.Build(ctx, echalotte.CircuitExclude(relays[0], relays[1]))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, relays[2:], c)
+	})
+
+	t.Run("fails without enough relays", func(t *testing.T) {
+		cb, err := echalotte.NewConnectedCircuitBuilder(h, nil, echalotte.CircuitSize(6))
+		require.NoError(t, err)
+
+		_, err = cb.Build(ctx)
+		assert.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), echalotte.ErrFindRelays))
+	})
+
+	t.Run("falls back without enough relays", func(t *testing.T) {
+		fallback := echalottetesting.NewDummyCircuitBuilder(t, echalotte.CircuitSize(6))
+		cb, err := echalotte.NewConnectedCircuitBuilder(h, fallback, echalotte.CircuitSize(6))
+		require.NoError(t, err)
+
+		c, err := cb.Build(ctx)
+		require.NoError(t, err)
+		assert.Len(t, c, 6)
+		assert.NotSubset(t, relays, c)
+	})
+}
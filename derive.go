@@ -0,0 +1,234 @@
+package echalotte
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/curve25519"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// Errors used by key derivation.
+const (
+	ErrNotEd25519Key       = "identity key is not an Ed25519 key"
+	ErrInvalidEd25519Key   = "invalid Ed25519 key"
+	ErrUnknownPublicKey    = "public key of peer is unknown"
+	ErrDerivedKeysConflict = "derived keys can't be combined with epoch or hybrid keys"
+)
+
+// derivationInfo separates derived encryption keys from the identity key.
+const derivationInfo = "echalotte/derive/v1"
+
+// curve25519P is the field prime 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// DerivedEncryptionKeys is an option to encrypt to keys derived from Ed25519
+// identity keys.
+// Relays advertise in their key record that they accept derived keys, and
+// senders only derive the keys of relays that do. The record is still
+// fetched and checked like any other (revocations, key log, gossip), the
+// derived key only replaces the key it carries.
+// Peers whose identity isn't an Ed25519 key keep using their record's key.
+//
+// The identity is mapped to X25519 with the birational map from Ed25519 (as
+// in libsodium's crypto_sign_ed25519_pk_to_curve25519), then multiplied by a
+// tweak hashed from the mapped key, so that the Diffie-Hellman scalar is
+// never the Ed25519 signing scalar itself.
+// Derived keys can't be rotated or revoked independently of the identity,
+// so this option can't be combined with EpochEncryptionKeys or
+// HybridEncryptionKeys.
+func DerivedEncryptionKeys() HostOption {
+	return func(opts *HostOptions) error {
+		opts.DerivedKeys = true
+		return nil
+	}
+}
+
+// Ed25519PublicToCurve25519 converts an Ed25519 public key to the
+// corresponding Curve25519 public key.
+func Ed25519PublicToCurve25519(pk crypto.PubKey) (*[32]byte, error) {
+	if _, ok := pk.(*crypto.Ed25519PublicKey); !ok {
+		return nil, errors.New(ErrNotEd25519Key)
+	}
+
+	raw, err := pk.Raw()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(raw) != 32 {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	// The Edwards y coordinate is encoded in little-endian, with the sign of
+	// x in the top bit.
+	var encoded [32]byte
+	for i := range encoded {
+		encoded[i] = raw[31-i]
+	}
+	encoded[0] &= 0x7f
+
+	y := new(big.Int).SetBytes(encoded[:])
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	// u = (1 + y) / (1 - y)
+	denominator := new(big.Int).Sub(big.NewInt(1), y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+
+	var publicKey [32]byte
+	b := u.Bytes()
+	for i := range b {
+		publicKey[i] = b[len(b)-1-i]
+	}
+
+	// Low-order points would make the shared secret predictable.
+	// Clamped scalars are multiples of the cofactor, so they map these points
+	// to zero.
+	var one, product, zero [32]byte
+	one[0] = 1
+	curve25519.ScalarMult(&product, &one, &publicKey)
+	if product == zero {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	return &publicKey, nil
+}
+
+// DerivedEncryptionKey returns the encryption key derived from the given
+// Ed25519 identity key (see DerivedEncryptionKeys).
+func DerivedEncryptionKey(pk crypto.PubKey) (*[32]byte, error) {
+	u, err := Ed25519PublicToCurve25519(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	var derived [32]byte
+	curve25519.ScalarMult(&derived, derivationTweak(u), u)
+
+	return &derived, nil
+}
+
+// derivationTweak hashes the X25519 key mapped from an identity into a
+// clamped scalar.
+func derivationTweak(u *[32]byte) *[32]byte {
+	h := sha512.New()
+	h.Write([]byte(derivationInfo))
+	h.Write(u[:])
+
+	var tweak [32]byte
+	copy(tweak[:], h.Sum(nil))
+	tweak[0] &= 248
+	tweak[31] &= 127
+	tweak[31] |= 64
+
+	return &tweak
+}
+
+// openDerived opens a message sealed to the key derived from our identity.
+// The shared secret is [tweak]([scalar]E), which is what the sender computes
+// as [e]([tweak][scalar]B).
+func openDerived(sk crypto.PrivKey, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 32 {
+		return nil, errors.New(ErrCouldNotDecrypt)
+	}
+
+	scalar, err := Ed25519PrivateToCurve25519(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := Ed25519PublicToCurve25519(sk.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	tweak := derivationTweak(u)
+
+	var pubKey [32]byte
+	curve25519.ScalarMult(&pubKey, tweak, u)
+
+	var epk [32]byte
+	copy(epk[:], ciphertext[:32])
+
+	expectedNonce := sha256.Sum256(append(epk[:], pubKey[:]...))
+	var nonce [24]byte
+	copy(nonce[:], expectedNonce[:])
+
+	var partial, sharedKey [32]byte
+	curve25519.ScalarMult(&partial, scalar, &epk)
+	box.Precompute(&sharedKey, &partial, tweak)
+
+	decrypted, ok := box.OpenAfterPrecomputation(nil, ciphertext[32:], &nonce, &sharedKey)
+	if !ok {
+		return nil, errors.New(ErrCouldNotDecrypt)
+	}
+
+	return decrypted, nil
+}
+
+// DecapsulateDerived decrypts a layer encrypted to the key derived from the
+// peer's identity (see DerivedEncryptionKey).
+func (l *OnionMessage) DecapsulateDerived(signingKey crypto.PrivKey) (*OnionMessage, error) {
+	return l.decapsulateWith(signingKey, func(content []byte) ([]byte, error) {
+		return openDerived(signingKey, content)
+	})
+}
+
+// Ed25519PrivateToCurve25519 converts an Ed25519 private key to the
+// corresponding Curve25519 private key.
+func Ed25519PrivateToCurve25519(sk crypto.PrivKey) (*[32]byte, error) {
+	if _, ok := sk.(*crypto.Ed25519PrivateKey); !ok {
+		return nil, errors.New(ErrNotEd25519Key)
+	}
+
+	raw, err := sk.Raw()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(raw) < 32 {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	// The Ed25519 scalar is the clamped first half of the seed's hash.
+	h := sha512.Sum512(raw[:32])
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+
+	var privateKey [32]byte
+	copy(privateKey[:], h[:32])
+
+	return &privateKey, nil
+}
+
+// derivedEncryptionKey derives a peer's encryption key from its identity.
+func (h *Host) derivedEncryptionKey(peerID peer.ID) (*[32]byte, error) {
+	pk, err := peerID.ExtractPublicKey()
+	if err != nil || pk == nil {
+		pk = h.Peerstore().PubKey(peerID)
+	}
+
+	if pk == nil {
+		return nil, errors.New(ErrUnknownPublicKey)
+	}
+
+	if !peerID.MatchesPublicKey(pk) {
+		return nil, errors.New(ErrInvalidEd25519Key)
+	}
+
+	return DerivedEncryptionKey(pk)
+}
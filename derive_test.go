@@ -0,0 +1,190 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/curve25519"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func TestDerivedKeys(t *testing.T) {
+	t.Run("Ed25519ToCurve25519()", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			sk, pk, err := crypto.GenerateEd25519Key(crand.Reader)
+			require.NoError(t, err)
+
+			privateKey, err := echalotte.Ed25519PrivateToCurve25519(sk)
+			require.NoError(t, err)
+
+			publicKey, err := echalotte.Ed25519PublicToCurve25519(pk)
+			require.NoError(t, err)
+
+			var expected [32]byte
+			curve25519.ScalarBaseMult(&expected, privateKey)
+			assert.Equal(t, expected, *publicKey)
+		}
+	})
+
+	t.Run("rejects invalid public keys", func(t *testing.T) {
+		// The neutral element (y = 1).
+		neutral := make([]byte, 32)
+		neutral[0] = 1
+
+		// y = -1 has order 2.
+		minusOne := make([]byte, 32)
+		minusOne[0] = 0xec
+		for i := 1; i < 31; i++ {
+			minusOne[i] = 0xff
+		}
+		minusOne[31] = 0x7f
+
+		// y >= p is not canonical.
+		nonCanonical := make([]byte, 32)
+		for i := range nonCanonical {
+			nonCanonical[i] = 0xff
+		}
+		nonCanonical[31] = 0x7f
+
+		for _, raw := range [][]byte{neutral, minusOne, nonCanonical, make([]byte, 16)} {
+			pk, err := crypto.UnmarshalEd25519PublicKey(raw)
+			require.NoError(t, err)
+
+			_, err = echalotte.Ed25519PublicToCurve25519(pk)
+			assert.EqualError(t, err, echalotte.ErrInvalidEd25519Key)
+		}
+	})
+
+	t.Run("decrypts with derived key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sk, pk, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		untweaked, err := echalotte.Ed25519PublicToCurve25519(pk)
+		require.NoError(t, err)
+
+		derivedKey, err := echalotte.DerivedEncryptionKey(pk)
+		require.NoError(t, err)
+		assert.NotEqual(t, *untweaked, *derivedKey)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.HostWithIdentity(ctx, t, sk),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		senderKey, _, _ := crypto.GenerateEd25519Key(crand.Reader)
+		senderID, _ := peer.IDFromPrivateKey(senderKey)
+
+		m, err := echalotte.NewMessage(senderID, senderKey, []byte("Les jambes en l'air, comme une femme lubrique,"))
+		require.NoError(t, err)
+
+		derived, err := m.Encapsulate(h.ID(), derivedKey)
+		require.NoError(t, err)
+
+		assert.Error(t, sendTo(ctx, h, derived))
+
+		h, err = echalotte.Connect(
+			ctx,
+			echalottetesting.HostWithIdentity(ctx, t, sk),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.DerivedEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		assert.NoError(t, sendTo(ctx, h, derived))
+
+		// The identity's scalar is never used directly.
+		m, err = m.Encapsulate(h.ID(), untweaked)
+		require.NoError(t, err)
+		assert.Error(t, sendTo(ctx, h, m))
+	})
+
+	t.Run("derives only for relays that advertise it", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.DerivedEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		classic, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		assert.False(t, cache.DerivedKey(relay.ID()))
+
+		for _, relayID := range []peer.ID{relay.ID(), classic.ID()} {
+			_, err = cache.Get(ctx, relayID)
+			require.NoError(t, err)
+		}
+
+		assert.True(t, cache.DerivedKey(relay.ID()))
+		assert.False(t, cache.DerivedKey(classic.ID()))
+
+		// The classic relay can only decrypt layers encrypted to its record's
+		// key, so the client must not derive its key.
+		for _, r := range []*echalotte.Host{relay, classic} {
+			client, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				dht,
+				echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{r.ID()}, echalotte.CircuitSize(1)),
+				echalotte.EncryptionKeyCache(cache),
+				echalotte.DerivedEncryptionKeys(),
+			)
+			require.NoError(t, err)
+
+			client.Peerstore().AddAddrs(r.ID(), r.Addrs(), peerstore.AddressTTL)
+			assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Brûlante et suant les poisons,")))
+		}
+	})
+
+	t.Run("can't be combined with epoch or hybrid keys", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		for _, opt := range []echalotte.HostOption{
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+			echalotte.HybridEncryptionKeys(),
+		} {
+			_, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				echalottetesting.NewInMemoryDHT(),
+				echalottetesting.NewDummyCircuitBuilder(t),
+				echalotte.DerivedEncryptionKeys(),
+				opt,
+			)
+			assert.EqualError(t, err, echalotte.ErrDerivedKeysConflict)
+		}
+	})
+}
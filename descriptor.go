@@ -0,0 +1,396 @@
+package echalotte
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+const (
+	// DescriptorNamespace is the namespace used for storing relay descriptors
+	// on a DHT.
+	DescriptorNamespace = "desc"
+
+	// DescriptorLifetime is the validity duration of a relay descriptor.
+	// Relays republish their descriptor before it expires.
+	DescriptorLifetime = 24 * time.Hour
+)
+
+// Errors used by the relay descriptor validator.
+const (
+	ErrDescriptorExpired     = "relay descriptor expired"
+	ErrInvalidValidity       = "invalid relay descriptor validity window"
+	ErrUnsupportedProtocol   = "relay does not support our protocol version"
+	ErrNoExitRelay           = "no relay of the circuit is an exit"
+	ErrSameFamily            = "circuit relays belong to the same family"
+	ErrDescriptorKeyExpired  = "relay descriptor encryption key expired"
+	ErrDescriptorKeyMismatch = "relay descriptor does not match the encryption key"
+)
+
+// DescriptorValidator validates relay descriptors before storing them in the
+// DHT.
+type DescriptorValidator struct{}
+
+// CreateKey returns a namespaced DHT key for the given peer's descriptor.
+func (dv DescriptorValidator) CreateKey(peerID peer.ID) string {
+	return fmt.Sprintf("/%s/%s", DescriptorNamespace, peerID.Pretty())
+}
+
+// CreateRecord signs the given descriptor.
+// This record is suitable for storage on a DHT.
+func (dv DescriptorValidator) CreateRecord(signingKey crypto.PrivKey, descriptor *pb.RelayDescriptor) ([]byte, error) {
+	signed := *descriptor
+	signed.CreatedAt = ptypes.TimestampNow()
+	signed.SignatureKey = nil
+	signed.Signature = nil
+
+	toSign, err := proto.Marshal(&signed)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signed.Signature, err = signingKey.Sign(toSign)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signed.SignatureKey, err = signingKey.GetPublic().Bytes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	serialized, err := proto.Marshal(&signed)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return serialized, nil
+}
+
+// Validate the relay descriptor.
+// It must be signed by the relay, and neither the descriptor nor the
+// encryption key it describes may be expired.
+func (dv DescriptorValidator) Validate(key string, value []byte) error {
+	peerID, err := parseKey(key, DescriptorNamespace)
+	if err != nil {
+		return err
+	}
+
+	var descriptor pb.RelayDescriptor
+	err = proto.Unmarshal(value, &descriptor)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	validAfter, err := ptypes.TimestampFromProto(descriptor.ValidAfter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	validUntil, err := ptypes.TimestampFromProto(descriptor.ValidUntil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !validUntil.After(validAfter) {
+		return errors.New(ErrInvalidValidity)
+	}
+
+	if time.Now().After(validUntil) {
+		return errors.New(ErrDescriptorExpired)
+	}
+
+	keyNotBefore, err := ptypes.TimestampFromProto(descriptor.KeyNotBefore)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keyNotAfter, err := ptypes.TimestampFromProto(descriptor.KeyNotAfter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !keyNotAfter.After(keyNotBefore) {
+		return errors.New(ErrInvalidKeyValidity)
+	}
+
+	if time.Now().After(keyNotAfter) {
+		return errors.New(ErrDescriptorKeyExpired)
+	}
+
+	signatureKey := descriptor.SignatureKey
+	signature := descriptor.Signature
+
+	descriptor.SignatureKey = nil
+	descriptor.Signature = nil
+
+	signedBytes, err := proto.Marshal(&descriptor)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return verifySignature(peerID, signatureKey, signature, signedBytes)
+}
+
+// Select the most recent descriptor.
+func (dv DescriptorValidator) Select(_ string, values [][]byte) (int, error) {
+	i := 0
+	var newest time.Time
+
+	for index, value := range values {
+		var descriptor pb.RelayDescriptor
+		err := proto.Unmarshal(value, &descriptor)
+		if err != nil {
+			continue
+		}
+
+		createdAt, err := ptypes.TimestampFromProto(descriptor.CreatedAt)
+		if err != nil {
+			continue
+		}
+
+		if createdAt.After(newest) {
+			i = index
+			newest = createdAt
+		}
+	}
+
+	return i, nil
+}
+
+// FetchDescriptor fetches and validates a relay's descriptor.
+func (dv DescriptorValidator) FetchDescriptor(ctx context.Context, dht DHT, relay peer.ID) (*pb.RelayDescriptor, error) {
+	key := dv.CreateKey(relay)
+	record, err := dht.GetValue(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = dv.Validate(key, record)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptor pb.RelayDescriptor
+	err = proto.Unmarshal(record, &descriptor)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &descriptor, nil
+}
+
+// SupportsProtocol returns true if the descriptor lists the given protocol.
+func SupportsProtocol(descriptor *pb.RelayDescriptor, p protocol.ID) bool {
+	for _, supported := range descriptor.Protocols {
+		if supported == string(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Capabilities that relays must have to be used in circuits.
+type Capabilities struct {
+	// Protocol that relays must support. Defaults to ProtocolID.
+	Protocol protocol.ID
+
+	// Roles that relays must have. Defaults to RoleRelay.
+	Roles Roles
+
+	// MinBandwidth is the minimum bandwidth class of relays.
+	MinBandwidth pb.BandwidthClass
+}
+
+// CapabilityFilter returns a filter that only accepts relays that published
+// a valid descriptor matching the given capabilities.
+func CapabilityFilter(dht DHT, capabilities Capabilities) RelayFilter {
+	if capabilities.Protocol == "" {
+		capabilities.Protocol = ProtocolID
+	}
+
+	if capabilities.Roles == 0 {
+		capabilities.Roles = RoleRelay
+	}
+
+	return func(ctx context.Context, relay peerstore.PeerInfo) bool {
+		descriptor, err := DescriptorValidator{}.FetchDescriptor(ctx, dht, relay.ID)
+		if err != nil {
+			log.Debugf("No valid descriptor found for %s: %s", relay.ID.Pretty(), err.Error())
+			return false
+		}
+
+		return SupportsProtocol(descriptor, capabilities.Protocol) &&
+			Roles(descriptor.Roles).Has(capabilities.Roles) &&
+			descriptor.Bandwidth >= capabilities.MinBandwidth
+	}
+}
+
+// publishDescriptors publishes the host's descriptor until the context is
+// done.
+// A new descriptor is published halfway through the previous one's lifetime,
+// so that role changes are eventually reflected.
+// Nothing is published while the host isn't a relay.
+func (h *Host) publishDescriptors(ctx context.Context, template pb.RelayDescriptor) {
+	for {
+		if h.Roles().Has(RoleRelay) {
+			h.publishDescriptor(ctx, template)
+		} else {
+			log.Debug("Not a relay, skipping relay descriptor")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DescriptorLifetime / 2):
+		}
+	}
+}
+
+// publishDescriptor publishes a descriptor with the host's current roles
+// and the validity window of its current encryption key.
+// Keys without bounds are valid as long as the descriptor.
+func (h *Host) publishDescriptor(ctx context.Context, template pb.RelayDescriptor) {
+	now := time.Now()
+	descriptor := template
+	descriptor.Protocols = []string{string(ProtocolID)}
+	descriptor.Roles = uint32(h.Roles())
+	descriptor.ValidAfter, _ = ptypes.TimestampProto(now)
+	descriptor.ValidUntil, _ = ptypes.TimestampProto(now.Add(DescriptorLifetime))
+
+	h.keysLock.Lock()
+	keyRecord := h.keyRecord
+	h.keysLock.Unlock()
+
+	var publicKey pb.PublicKey
+	err := proto.Unmarshal(keyRecord, &publicKey)
+	if err != nil {
+		log.Errorf("Could not read encryption key record: %s", err.Error())
+		return
+	}
+
+	notBefore, notAfter := keyWindow(&publicKey, now.Add(DescriptorLifetime))
+	descriptor.KeyNotBefore, _ = ptypes.TimestampProto(notBefore)
+	descriptor.KeyNotAfter, _ = ptypes.TimestampProto(notAfter)
+
+	validator := DescriptorValidator{}
+	record, err := validator.CreateRecord(h.Peerstore().PrivKey(h.ID()), &descriptor)
+	if err != nil {
+		log.Errorf("Could not create relay descriptor: %s", err.Error())
+		return
+	}
+
+	err = h.dht.PutValue(ctx, validator.CreateKey(h.ID()), record)
+	if err != nil {
+		log.Errorf("Could not publish relay descriptor: %s", err.Error())
+		return
+	}
+
+	log.Infof("Relay descriptor published with roles %s", h.Roles())
+}
+
+// keyWindow returns the validity window of the given key record.
+// Bounds that the record doesn't set default to its creation time and to
+// the given time.
+func keyWindow(publicKey *pb.PublicKey, defaultNotAfter time.Time) (time.Time, time.Time) {
+	notBefore, err := ptypes.TimestampFromProto(publicKey.NotBefore)
+	if publicKey.NotBefore == nil || err != nil {
+		notBefore, _ = ptypes.TimestampFromProto(publicKey.CreatedAt)
+	}
+
+	notAfter, err := ptypes.TimestampFromProto(publicKey.NotAfter)
+	if publicKey.NotAfter == nil || err != nil {
+		notAfter = defaultNotAfter
+	}
+
+	return notBefore, notAfter
+}
+
+// checkDescriptorKey verifies that the encryption key record of a relay is
+// valid during the key validity window of its descriptor.
+func (h *Host) checkDescriptorKey(ctx context.Context, relay peer.ID, descriptor *pb.RelayDescriptor) error {
+	_, record, err := h.fetchEncryptionKey(ctx, relay)
+	if err != nil {
+		return err
+	}
+
+	var publicKey pb.PublicKey
+	err = proto.Unmarshal(record, &publicKey)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	descriptorNotBefore, err := ptypes.TimestampFromProto(descriptor.KeyNotBefore)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	descriptorNotAfter, err := ptypes.TimestampFromProto(descriptor.KeyNotAfter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Records without an expiry are valid until a newer key is published.
+	notBefore, notAfter := keyWindow(&publicKey, descriptorNotAfter)
+	if !notBefore.Before(descriptorNotAfter) || !descriptorNotBefore.Before(notAfter) {
+		return errors.New(ErrDescriptorKeyMismatch)
+	}
+
+	return nil
+}
+
+// checkDescriptors verifies that circuit relays support our protocol
+// version, that their descriptor matches their encryption key record, that
+// none of them declares another one as family and that the last hop is an
+// exit.
+// If the last hop isn't an exit, it is swapped with another relay of the
+// circuit that is.
+func (h *Host) checkDescriptors(ctx context.Context, circuit Circuit) (Circuit, error) {
+	exit := -1
+	for i, relay := range circuit {
+		descriptor, err := DescriptorValidator{}.FetchDescriptor(ctx, h.dht, relay)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get descriptor for %s", relay.Pretty())
+		}
+
+		if !SupportsProtocol(descriptor, ProtocolID) {
+			return nil, errors.Wrapf(errors.New(ErrUnsupportedProtocol), "relay %s", relay.Pretty())
+		}
+
+		err = h.checkDescriptorKey(ctx, relay, descriptor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "relay %s", relay.Pretty())
+		}
+
+		for _, member := range descriptor.Family {
+			if peer.ID(member) != relay && containsPeer(circuit, peer.ID(member)) {
+				return nil, errors.Wrapf(errors.New(ErrSameFamily), "relays %s and %s", relay.Pretty(), peer.ID(member).Pretty())
+			}
+		}
+
+		if exit < 0 && Roles(descriptor.Roles).Has(RoleExit) {
+			exit = i
+		}
+	}
+
+	if exit < 0 {
+		return nil, errors.New(ErrNoExitRelay)
+	}
+
+	// The first relay of the circuit is its last hop.
+	circuit = append(Circuit(nil), circuit...)
+	circuit[0], circuit[exit] = circuit[exit], circuit[0]
+
+	return circuit, nil
+}
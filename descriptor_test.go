@@ -0,0 +1,382 @@
+package echalotte_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// newDescriptor creates a descriptor valid for the given duration.
+func newDescriptor(t *testing.T, protocols []string, roles echalotte.Roles, bandwidth pb.BandwidthClass, validFor time.Duration) *pb.RelayDescriptor {
+	now := time.Now()
+	validAfter, err := ptypes.TimestampProto(now.Add(-time.Minute))
+	require.NoError(t, err)
+
+	validUntil, err := ptypes.TimestampProto(now.Add(validFor))
+	require.NoError(t, err)
+
+	return &pb.RelayDescriptor{
+		Protocols:    protocols,
+		Roles:        uint32(roles),
+		Bandwidth:    bandwidth,
+		ValidAfter:   validAfter,
+		ValidUntil:   validUntil,
+		KeyNotBefore: validAfter,
+		KeyNotAfter:  validUntil,
+	}
+}
+
+func TestDescriptorValidator(t *testing.T) {
+	aliceSigPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	alice, err := peer.IDFromPrivateKey(aliceSigPrivKey)
+	require.NoError(t, err)
+
+	dv := echalotte.DescriptorValidator{}
+	protocols := []string{string(echalotte.ProtocolID)}
+
+	aliceRecord, err := dv.CreateRecord(
+		aliceSigPrivKey,
+		newDescriptor(t, protocols, echalotte.RoleRelay|echalotte.RoleExit, pb.BandwidthClass_High, time.Hour),
+	)
+	require.NoError(t, err)
+
+	t.Run("Validate()", func(t *testing.T) {
+		t.Run("Invalid key namespace", func(t *testing.T) {
+			err := dv.Validate(fmt.Sprintf("/enc/%s", alice.Pretty()), aliceRecord)
+			assert.EqualError(t, err, echalotte.ErrInvalidNamespace)
+		})
+
+		t.Run("Invalid message format", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(alice), []byte{42})
+			assert.Error(t, err)
+		})
+
+		t.Run("Signature key mismatch", func(t *testing.T) {
+			_, pk, err := crypto.GenerateEd25519Key(rand.Reader)
+			require.NoError(t, err)
+
+			otherPeerID, err := peer.IDFromPublicKey(pk)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(otherPeerID), aliceRecord)
+			assert.Error(t, err)
+		})
+
+		t.Run("Expired descriptor", func(t *testing.T) {
+			expired, err := dv.CreateRecord(
+				aliceSigPrivKey,
+				newDescriptor(t, protocols, echalotte.RoleRelay, pb.BandwidthClass_High, -time.Second),
+			)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(alice), expired)
+			assert.Error(t, err)
+		})
+
+		t.Run("Invalid key validity window", func(t *testing.T) {
+			descriptor := newDescriptor(t, protocols, echalotte.RoleRelay, pb.BandwidthClass_High, time.Hour)
+			descriptor.KeyNotBefore, descriptor.KeyNotAfter = descriptor.KeyNotAfter, descriptor.KeyNotBefore
+
+			record, err := dv.CreateRecord(aliceSigPrivKey, descriptor)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(alice), record)
+			assert.EqualError(t, err, echalotte.ErrInvalidKeyValidity)
+
+			descriptor.KeyNotBefore = nil
+			record, err = dv.CreateRecord(aliceSigPrivKey, descriptor)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(alice), record)
+			assert.Error(t, err)
+		})
+
+		t.Run("Expired encryption key", func(t *testing.T) {
+			descriptor := newDescriptor(t, protocols, echalotte.RoleRelay, pb.BandwidthClass_High, time.Hour)
+			descriptor.KeyNotAfter, _ = ptypes.TimestampProto(time.Now().Add(-time.Second))
+
+			record, err := dv.CreateRecord(aliceSigPrivKey, descriptor)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(alice), record)
+			assert.EqualError(t, err, echalotte.ErrDescriptorKeyExpired)
+		})
+
+		t.Run("Valid descriptor", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(alice), aliceRecord)
+			assert.NoError(t, err)
+		})
+	})
+
+	t.Run("Select()", func(t *testing.T) {
+		// Make sure the new record has a more recent creation time.
+		<-time.After(time.Millisecond)
+		newRecord, err := dv.CreateRecord(
+			aliceSigPrivKey,
+			newDescriptor(t, protocols, echalotte.RoleRelay, pb.BandwidthClass_Low, time.Hour),
+		)
+		require.NoError(t, err)
+
+		i, err := dv.Select(dv.CreateKey(alice), [][]byte{aliceRecord, []byte{42}, newRecord})
+		require.NoError(t, err)
+		assert.Equal(t, 2, i)
+	})
+
+	t.Run("CapabilityFilter()", func(t *testing.T) {
+		ctx := context.Background()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		err := dht.PutValue(ctx, dv.CreateKey(alice), aliceRecord)
+		require.NoError(t, err)
+
+		filter := echalotte.CapabilityFilter(dht, echalotte.Capabilities{})
+		assert.True(t, filter(ctx, peerstore.PeerInfo{ID: alice}))
+		assert.False(t, filter(ctx, peerstore.PeerInfo{ID: peer.ID("bob")}))
+
+		exitFilter := echalotte.CapabilityFilter(dht, echalotte.Capabilities{
+			Roles:        echalotte.RoleRelay | echalotte.RoleExit,
+			MinBandwidth: pb.BandwidthClass_Medium,
+		})
+		assert.True(t, exitFilter(ctx, peerstore.PeerInfo{ID: alice}))
+
+		futureFilter := echalotte.CapabilityFilter(dht, echalotte.Capabilities{
+			Protocol: "/echalotte/v2.0.0",
+		})
+		assert.False(t, futureFilter(ctx, peerstore.PeerInfo{ID: alice}))
+	})
+}
+
+func TestRelayDescriptors(t *testing.T) {
+	t.Run("published by relays", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.PublishRelayDescriptor(pb.BandwidthClass_Medium),
+		)
+		require.NoError(t, err)
+
+		var descriptor *pb.RelayDescriptor
+		for i := 0; i < 100 && descriptor == nil; i++ {
+			descriptor, _ = echalotte.DescriptorValidator{}.FetchDescriptor(ctx, dht, h.ID())
+			<-time.After(5 * time.Millisecond)
+		}
+
+		require.NotNil(t, descriptor)
+		assert.True(t, echalotte.SupportsProtocol(descriptor, echalotte.ProtocolID))
+		assert.Equal(t, uint32(echalotte.DefaultRoles), descriptor.Roles)
+		assert.Equal(t, pb.BandwidthClass_Medium, descriptor.Bandwidth)
+
+		keyNotAfter, err := ptypes.TimestampFromProto(descriptor.KeyNotAfter)
+		require.NoError(t, err)
+		assert.True(t, keyNotAfter.After(time.Now()))
+	})
+
+	t.Run("descriptor key window checked against the key record", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		dv := echalotte.DescriptorValidator{}
+
+		sk, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+		pk, _, _ := box.GenerateKey(rand.Reader)
+		relayID, _ := peer.IDFromPrivateKey(sk)
+
+		now := time.Now()
+		v := &echalotte.PublicKeyValidator{}
+		record, err := v.CreateRecordWithValidity(sk, pk, now.Add(-time.Minute), now.Add(time.Hour))
+		require.NoError(t, err)
+		require.NoError(t, dht.PutValue(ctx, v.CreateKey(relayID), record))
+
+		// The descriptor describes a key that only becomes valid after the
+		// record expires.
+		descriptor := newDescriptor(t, []string{string(echalotte.ProtocolID)}, echalotte.DefaultRoles, pb.BandwidthClass_High, 3*time.Hour)
+		descriptor.KeyNotBefore, _ = ptypes.TimestampProto(now.Add(2 * time.Hour))
+
+		descriptorRecord, err := dv.CreateRecord(sk, descriptor)
+		require.NoError(t, err)
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(relayID), descriptorRecord))
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relayID}, echalotte.CircuitSize(1)),
+			echalotte.CheckRelayDescriptors(),
+		)
+		require.NoError(t, err)
+
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Vous me rendez l'azur du ciel immense et rond"))
+		require.Error(t, err)
+		assert.True(t, strings.HasSuffix(err.Error(), echalotte.ErrDescriptorKeyMismatch))
+	})
+
+	t.Run("protocol mismatch detected before sending", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		dv := echalotte.DescriptorValidator{}
+
+		var relays []peer.ID
+		for i := 0; i < 5; i++ {
+			sk, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+			pk, _, _ := box.GenerateKey(rand.Reader)
+			relayID, _ := peer.IDFromPrivateKey(sk)
+			relays = append(relays, relayID)
+
+			v := &echalotte.PublicKeyValidator{}
+			record, _ := v.CreateRecord(sk, pk)
+			dht.PutValue(ctx, v.CreateKey(relayID), record)
+
+			descriptor, err := dv.CreateRecord(
+				sk,
+				newDescriptor(t, []string{"/echalotte/v0.1.0"}, echalotte.RoleRelay, pb.BandwidthClass_High, time.Hour),
+			)
+			require.NoError(t, err)
+			dht.PutValue(ctx, dv.CreateKey(relayID), descriptor)
+		}
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, relays),
+			echalotte.CheckRelayDescriptors(),
+		)
+		require.NoError(t, err)
+
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Brûlante et suant les poisons,"))
+		require.Error(t, err)
+		assert.True(t, strings.HasSuffix(err.Error(), echalotte.ErrUnsupportedProtocol))
+	})
+
+	t.Run("uses exits as last hops", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+
+		var relays []*echalotte.Host
+		for _, roles := range []echalotte.Roles{echalotte.RoleRelay, echalotte.DefaultRoles} {
+			relay, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				dht,
+				echalottetesting.NewDummyCircuitBuilder(t),
+				echalotte.HostRoles(roles),
+				echalotte.PublishRelayDescriptor(pb.BandwidthClass_High),
+			)
+			require.NoError(t, err)
+			relays = append(relays, relay)
+		}
+
+		for _, relay := range relays {
+			var descriptor *pb.RelayDescriptor
+			for i := 0; i < 100 && descriptor == nil; i++ {
+				descriptor, _ = echalotte.DescriptorValidator{}.FetchDescriptor(ctx, dht, relay.ID())
+				<-time.After(5 * time.Millisecond)
+			}
+
+			require.NotNil(t, descriptor)
+		}
+
+		middle, exit := relays[0], relays[1]
+		middle.Peerstore().AddAddrs(exit.ID(), exit.Addrs(), peerstore.AddressTTL)
+
+		// The builder chooses the middle relay as last hop: the host swaps it
+		// with the exit, so the middle relay becomes the first hop.
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{middle.ID(), exit.ID()}, echalotte.CircuitSize(2)),
+			echalotte.CheckRelayDescriptors(),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(middle.ID(), middle.Addrs(), peerstore.AddressTTL)
+		assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Ma cigarette est presque finie")))
+
+		noExitClient, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{middle.ID()}, echalotte.CircuitSize(1)),
+			echalotte.CheckRelayDescriptors(),
+		)
+		require.NoError(t, err)
+
+		err = noExitClient.SendMessage(ctx, peer.ID("alice"), []byte("Ma cigarette est presque finie"))
+		assert.EqualError(t, err, echalotte.ErrNoExitRelay)
+	})
+
+	t.Run("rejects circuits with relays of the same family", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		dv := echalotte.DescriptorValidator{}
+
+		var keys []crypto.PrivKey
+		var relays []peer.ID
+		for i := 0; i < 2; i++ {
+			sk, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+			relayID, _ := peer.IDFromPrivateKey(sk)
+			keys = append(keys, sk)
+			relays = append(relays, relayID)
+		}
+
+		for i, sk := range keys {
+			pk, _, _ := box.GenerateKey(rand.Reader)
+			v := &echalotte.PublicKeyValidator{}
+			record, _ := v.CreateRecord(sk, pk)
+			dht.PutValue(ctx, v.CreateKey(relays[i]), record)
+
+			descriptor := newDescriptor(t, []string{string(echalotte.ProtocolID)}, echalotte.DefaultRoles, pb.BandwidthClass_High, time.Hour)
+			if i == 0 {
+				descriptor.Family = [][]byte{[]byte(relays[1])}
+			}
+
+			descriptorRecord, err := dv.CreateRecord(sk, descriptor)
+			require.NoError(t, err)
+			dht.PutValue(ctx, dv.CreateKey(relays[i]), descriptorRecord)
+		}
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, relays, echalotte.CircuitSize(2)),
+			echalotte.CheckRelayDescriptors(),
+		)
+		require.NoError(t, err)
+
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Cheveux bleus, pavillon de ténèbres tendues,"))
+		require.Error(t, err)
+		assert.True(t, strings.HasSuffix(err.Error(), echalotte.ErrSameFamily))
+	})
+}
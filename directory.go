@@ -0,0 +1,681 @@
+package echalotte
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNTCey11oxhb1AxDnQBRHtdhap6Ctud872NjAYPYYXPuc/go-multiaddr"
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+const (
+	// DirectoryNamespace is the namespace used for storing directory consensus
+	// documents on a DHT.
+	DirectoryNamespace = "dir"
+
+	// DefaultConsensusInterval is the default interval between two consensus
+	// documents.
+	// A consensus is fresh for one interval and valid for three.
+	DefaultConsensusInterval = time.Hour
+
+	// Relay flags that can be set by directory authorities.
+	FlagExit   = "Exit"
+	FlagGuard  = "Guard"
+	FlagStable = "Stable"
+)
+
+// Errors used by the directory components.
+const (
+	ErrConsensusExpired    = "directory consensus expired"
+	ErrConsensusSignatures = "not enough authority signatures on directory consensus"
+	ErrUnknownAuthority    = "directory consensus signed by unknown authority"
+)
+
+// DirectoryValidator validates consensus documents signed by directory
+// authorities.
+type DirectoryValidator struct {
+	// Authorities are the peer IDs of the trusted directory authorities.
+	Authorities []peer.ID
+
+	// Threshold is the number of authority signatures required to trust a
+	// consensus. Defaults to a majority of the authorities.
+	Threshold int
+
+	// Interval between two consensus documents.
+	// Defaults to DefaultConsensusInterval.
+	Interval time.Duration
+}
+
+// CreateKey returns the DHT key of the consensus document for the period
+// starting at validAfter.
+// Each period has its own key so that a document still collecting
+// signatures doesn't compete with the previous period's trusted one.
+func (dv DirectoryValidator) CreateKey(validAfter time.Time) string {
+	return fmt.Sprintf("/%s/consensus/%d", DirectoryNamespace, validAfter.Unix())
+}
+
+func (dv DirectoryValidator) interval() time.Duration {
+	if dv.Interval <= 0 {
+		return DefaultConsensusInterval
+	}
+
+	return dv.Interval
+}
+
+func (dv DirectoryValidator) threshold() int {
+	if dv.Threshold <= 0 {
+		return len(dv.Authorities)/2 + 1
+	}
+
+	return dv.Threshold
+}
+
+// Validate the consensus document before storing it in the DHT.
+// Since authorities add their signatures one after the other, partially
+// signed documents are accepted as long as all their signatures are valid.
+// Clients should use Verify before trusting a consensus.
+func (dv DirectoryValidator) Validate(key string, value []byte) error {
+	var consensus pb.Consensus
+	err := proto.Unmarshal(value, &consensus)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	validAfter, err := ptypes.TimestampFromProto(consensus.ValidAfter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if key != dv.CreateKey(validAfter) {
+		return errors.New(ErrInvalidKeyFormat)
+	}
+
+	validUntil, err := ptypes.TimestampFromProto(consensus.ValidUntil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if time.Now().After(validUntil) {
+		return errors.New(ErrConsensusExpired)
+	}
+
+	count, err := dv.signatures(&consensus)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return errors.New(ErrConsensusSignatures)
+	}
+
+	return nil
+}
+
+// Verify that a consensus is currently valid and signed by enough
+// authorities.
+func (dv DirectoryValidator) Verify(consensus *pb.Consensus) error {
+	validAfter, err := ptypes.TimestampFromProto(consensus.ValidAfter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	validUntil, err := ptypes.TimestampFromProto(consensus.ValidUntil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := time.Now()
+	if now.Before(validAfter) || now.After(validUntil) {
+		return errors.New(ErrConsensusExpired)
+	}
+
+	count, err := dv.signatures(consensus)
+	if err != nil {
+		return err
+	}
+
+	if count < dv.threshold() {
+		return errors.New(ErrConsensusSignatures)
+	}
+
+	return nil
+}
+
+// Select the most recent consensus signed by enough authorities.
+// If none is, select the one with the most signatures.
+// Since every period has its own key, values usually all belong to the same
+// period.
+func (dv DirectoryValidator) Select(_ string, values [][]byte) (int, error) {
+	best := 0
+	var bestTrusted bool
+	var bestValidAfter int64
+	var bestCount int
+
+	for index, value := range values {
+		var consensus pb.Consensus
+		err := proto.Unmarshal(value, &consensus)
+		if err != nil {
+			continue
+		}
+
+		count, err := dv.signatures(&consensus)
+		if err != nil {
+			continue
+		}
+
+		trusted := count >= dv.threshold()
+		validAfter := consensus.ValidAfter.GetSeconds()
+
+		better := false
+		switch {
+		case trusted != bestTrusted:
+			better = trusted
+		case validAfter != bestValidAfter:
+			better = validAfter > bestValidAfter
+		default:
+			better = count > bestCount
+		}
+
+		if better {
+			best = index
+			bestTrusted = trusted
+			bestValidAfter = validAfter
+			bestCount = count
+		}
+	}
+
+	return best, nil
+}
+
+// signatures returns the number of distinct authorities that signed the
+// consensus.
+// It fails if a signature is invalid or from an unknown authority.
+func (dv DirectoryValidator) signatures(consensus *pb.Consensus) (int, error) {
+	signedBytes, err := consensusSignedBytes(consensus)
+	if err != nil {
+		return 0, err
+	}
+
+	signers := make(map[peer.ID]struct{})
+	for _, s := range consensus.Signatures {
+		authority, err := dv.authority(s.SignatureKey)
+		if err != nil {
+			return 0, err
+		}
+
+		err = verifySignature(authority, s.SignatureKey, s.Signature, signedBytes)
+		if err != nil {
+			return 0, err
+		}
+
+		signers[authority] = struct{}{}
+	}
+
+	return len(signers), nil
+}
+
+// authority returns the authority owning the given signature key.
+func (dv DirectoryValidator) authority(signatureKey []byte) (peer.ID, error) {
+	pk, err := crypto.UnmarshalPublicKey(signatureKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for _, authority := range dv.Authorities {
+		if authority.MatchesPublicKey(pk) {
+			return authority, nil
+		}
+	}
+
+	return "", errors.New(ErrUnknownAuthority)
+}
+
+// consensusSignedBytes returns the consensus bytes covered by authority
+// signatures.
+func consensusSignedBytes(consensus *pb.Consensus) ([]byte, error) {
+	unsigned := *consensus
+	unsigned.Signatures = nil
+
+	b, err := proto.Marshal(&unsigned)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return b, nil
+}
+
+// NewConsensus creates an unsigned consensus document for the period starting
+// at validAfter.
+func NewConsensus(relays []*pb.DirectoryEntry, validAfter time.Time, interval time.Duration) (*pb.Consensus, error) {
+	// Sort relays so that authorities with the same view produce identical
+	// documents.
+	sorted := append([]*pb.DirectoryEntry(nil), relays...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].PeerId, sorted[j].PeerId) < 0 })
+
+	start, err := ptypes.TimestampProto(validAfter)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	freshUntil, err := ptypes.TimestampProto(validAfter.Add(interval))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	validUntil, err := ptypes.TimestampProto(validAfter.Add(3 * interval))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &pb.Consensus{
+		ValidAfter: start,
+		FreshUntil: freshUntil,
+		ValidUntil: validUntil,
+		Relays:     sorted,
+	}, nil
+}
+
+// SignConsensus adds an authority signature to the consensus.
+func SignConsensus(consensus *pb.Consensus, signingKey crypto.PrivKey) error {
+	signedBytes, err := consensusSignedBytes(consensus)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signingKey.Sign(signedBytes)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	signatureKey, err := signingKey.GetPublic().Bytes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	consensus.Signatures = append(consensus.Signatures, &pb.AuthoritySignature{
+		SignatureKey: signatureKey,
+		Signature:    signature,
+	})
+
+	return nil
+}
+
+// DirectoryAuthority periodically signs and publishes the consensus.
+// Its interval should match the validator's.
+// When another authority already published a consensus identical to ours for
+// the current period, we add our signature to it.
+type DirectoryAuthority struct {
+	dht       DHT
+	key       crypto.PrivKey
+	validator DirectoryValidator
+	interval  time.Duration
+	relays    func(context.Context) ([]*pb.DirectoryEntry, error)
+}
+
+// NewDirectoryAuthority creates a directory authority.
+// The relays function returns the authority's view of the network's relays.
+func NewDirectoryAuthority(
+	dht DHT,
+	key crypto.PrivKey,
+	validator DirectoryValidator,
+	interval time.Duration,
+	relays func(context.Context) ([]*pb.DirectoryEntry, error),
+) *DirectoryAuthority {
+	return &DirectoryAuthority{
+		dht:       dht,
+		key:       key,
+		validator: validator,
+		interval:  interval,
+		relays:    relays,
+	}
+}
+
+// Run publishes a consensus every interval until the context is done.
+func (da *DirectoryAuthority) Run(ctx context.Context) {
+	for {
+		err := da.Publish(ctx)
+		if err != nil {
+			log.Errorf("Could not publish directory consensus: %s", err.Error())
+		}
+
+		next := time.Now().Truncate(da.interval).Add(da.interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// Publish the consensus for the current period.
+func (da *DirectoryAuthority) Publish(ctx context.Context) error {
+	relays, err := da.relays(ctx)
+	if err != nil {
+		return err
+	}
+
+	validAfter := time.Now().Truncate(da.interval)
+	consensus, err := NewConsensus(relays, validAfter, da.interval)
+	if err != nil {
+		return err
+	}
+
+	expected, err := consensusSignedBytes(consensus)
+	if err != nil {
+		return err
+	}
+
+	key := da.validator.CreateKey(validAfter)
+	existingBytes, err := da.dht.GetValue(ctx, key)
+	if err == nil && da.validator.Validate(key, existingBytes) == nil {
+		var existing pb.Consensus
+		err = proto.Unmarshal(existingBytes, &existing)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		existingSigned, err := consensusSignedBytes(&existing)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(existingSigned, expected) {
+			if da.hasSigned(&existing) {
+				log.Debug("Directory consensus already signed")
+				return nil
+			}
+
+			consensus = &existing
+		}
+	}
+
+	err = SignConsensus(consensus, da.key)
+	if err != nil {
+		return err
+	}
+
+	value, err := proto.Marshal(consensus)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = da.dht.PutValue(ctx, key, value)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Infof("Directory consensus published with %d signature(s)", len(consensus.Signatures))
+
+	return nil
+}
+
+func (da *DirectoryAuthority) hasSigned(consensus *pb.Consensus) bool {
+	ours, err := da.key.GetPublic().Bytes()
+	if err != nil {
+		return false
+	}
+
+	for _, s := range consensus.Signatures {
+		if bytes.Equal(s.SignatureKey, ours) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DirectoryCircuitBuilder builds random circuits from the relays listed in
+// the consensus published by directory authorities.
+// It doesn't rely on DHT provider records, which an adversary can more
+// easily partition or eclipse.
+type DirectoryCircuitBuilder struct {
+	dht       DHT
+	validator DirectoryValidator
+	peerstore peerstore.Peerstore
+	options   CircuitOptions
+	flags     []string
+
+	lock      sync.Mutex
+	consensus *pb.Consensus
+	keys      map[peer.ID]*[32]byte
+}
+
+// NewDirectoryCircuitBuilder creates a circuit builder that fetches the
+// consensus from the DHT.
+// If a peerstore is provided, relay addresses from the consensus are added to
+// it.
+func NewDirectoryCircuitBuilder(
+	dht DHT,
+	validator DirectoryValidator,
+	ps peerstore.Peerstore,
+	opts ...CircuitOption,
+) (*DirectoryCircuitBuilder, error) {
+	options := &CircuitOptions{
+		Size:    DefaultCircuitSize,
+		Timeout: DefaultCircuitTimeout,
+		Rand:    newCryptoRand(),
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectoryCircuitBuilder{
+		dht:       dht,
+		validator: validator,
+		peerstore: ps,
+		options:   *options,
+		keys:      make(map[peer.ID]*[32]byte),
+	}, nil
+}
+
+// RequireFlags restricts circuits to relays that have all the given flags.
+func (cb *DirectoryCircuitBuilder) RequireFlags(flags ...string) *DirectoryCircuitBuilder {
+	cb.flags = flags
+	return cb
+}
+
+// Build a random circuit between relays listed in the consensus.
+func (cb *DirectoryCircuitBuilder) Build(ctx context.Context, opts ...CircuitOption) (Circuit, error) {
+	options := cb.options
+	options.Filters = append([]RelayFilter(nil), cb.options.Filters...)
+	options.Exclude = append([]peer.ID(nil), cb.options.Exclude...)
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	consensus, err := cb.currentConsensus(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrFindRelays)
+	}
+
+	relays := cb.relays(consensus, options.Exclude)
+	if len(options.Filters) > 0 {
+		relays = filterRelays(ctx, relays, options.Filters, options.Timeout)
+	}
+
+	if options.Reputation != nil {
+		relays = options.Reputation.filter(relays, options.Size)
+	}
+
+	if len(relays) < options.Size {
+		return nil, errors.Wrap(errors.New("not enough relays in consensus"), ErrFindRelays)
+	}
+
+	return options.selectCircuit(relays)
+}
+
+// PeerEncryptionKey returns the encryption key listed in the consensus.
+func (cb *DirectoryCircuitBuilder) PeerEncryptionKey(relay peer.ID) (*[32]byte, bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	key, ok := cb.keys[relay]
+	return key, ok
+}
+
+// relays returns the relays listed in the consensus that have the required
+// flags and aren't excluded.
+func (cb *DirectoryCircuitBuilder) relays(consensus *pb.Consensus, exclude []peer.ID) []peerstore.PeerInfo {
+	var relays []peerstore.PeerInfo
+	for _, entry := range consensus.Relays {
+		if !hasFlags(entry.Flags, cb.flags) {
+			continue
+		}
+
+		relayID, err := peer.IDFromBytes(entry.PeerId)
+		if err != nil || containsPeer(exclude, relayID) {
+			continue
+		}
+
+		relay := peerstore.PeerInfo{ID: relayID}
+		for _, b := range entry.Addrs {
+			addr, err := multiaddr.NewMultiaddrBytes(b)
+			if err != nil {
+				continue
+			}
+
+			relay.Addrs = append(relay.Addrs, addr)
+		}
+
+		relays = append(relays, relay)
+	}
+
+	return relays
+}
+
+// currentConsensus returns the cached consensus, fetching a new one once it's
+// not fresh anymore.
+// A stale consensus is still used while it is valid if we can't fetch a new
+// one.
+func (cb *DirectoryCircuitBuilder) currentConsensus(ctx context.Context) (*pb.Consensus, error) {
+	cb.lock.Lock()
+	cached := cb.consensus
+	cb.lock.Unlock()
+
+	if cached != nil {
+		freshUntil, err := ptypes.TimestampFromProto(cached.FreshUntil)
+		if err == nil && time.Now().Before(freshUntil) {
+			return cached, nil
+		}
+	}
+
+	consensus, err := cb.fetchConsensus(ctx)
+	if err != nil {
+		if cached != nil && cb.validator.Verify(cached) == nil {
+			log.Errorf("Could not refresh directory consensus: %s", err.Error())
+			return cached, nil
+		}
+
+		return nil, err
+	}
+
+	keys := make(map[peer.ID]*[32]byte)
+	for _, entry := range consensus.Relays {
+		relayID, err := peer.IDFromBytes(entry.PeerId)
+		if err != nil {
+			continue
+		}
+
+		if cb.peerstore != nil {
+			for _, b := range entry.Addrs {
+				addr, err := multiaddr.NewMultiaddrBytes(b)
+				if err == nil {
+					cb.peerstore.AddAddr(relayID, addr, peerstore.ProviderAddrTTL)
+				}
+			}
+		}
+
+		if len(entry.EncryptionKey) == 32 {
+			var key [32]byte
+			copy(key[:], entry.EncryptionKey)
+			keys[relayID] = &key
+		}
+	}
+
+	cb.lock.Lock()
+	cb.consensus = consensus
+	cb.keys = keys
+	cb.lock.Unlock()
+
+	log.Debugf("Directory consensus fetched with %d relay(s)", len(consensus.Relays))
+
+	return consensus, nil
+}
+
+// fetchConsensus fetches and verifies the consensus of the current period.
+// While authorities are still signing it, the previous period's consensus is
+// used.
+func (cb *DirectoryCircuitBuilder) fetchConsensus(ctx context.Context) (*pb.Consensus, error) {
+	interval := cb.validator.interval()
+	current := time.Now().Truncate(interval)
+
+	consensus, err := cb.fetchPeriodConsensus(ctx, current)
+	if err == nil {
+		return consensus, nil
+	}
+
+	previous, previousErr := cb.fetchPeriodConsensus(ctx, current.Add(-interval))
+	if previousErr == nil {
+		return previous, nil
+	}
+
+	return nil, err
+}
+
+// fetchPeriodConsensus fetches and verifies the consensus of the period
+// starting at validAfter.
+func (cb *DirectoryCircuitBuilder) fetchPeriodConsensus(ctx context.Context, validAfter time.Time) (*pb.Consensus, error) {
+	value, err := cb.dht.GetValue(ctx, cb.validator.CreateKey(validAfter))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var consensus pb.Consensus
+	err = proto.Unmarshal(value, &consensus)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = cb.validator.Verify(&consensus)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consensus, nil
+}
+
+// hasFlags returns true if all the required flags are set.
+func hasFlags(flags []string, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, f := range flags {
+			if f == r {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
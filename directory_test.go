@@ -0,0 +1,291 @@
+package echalotte_test
+
+import (
+	"context"
+	"crypto/rand"
+	mrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func generateAuthorities(t *testing.T, count int) ([]crypto.PrivKey, []peer.ID) {
+	var keys []crypto.PrivKey
+	var authorities []peer.ID
+	for i := 0; i < count; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+
+		authority, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		keys = append(keys, sk)
+		authorities = append(authorities, authority)
+	}
+
+	return keys, authorities
+}
+
+// directoryRelay returns the i-th relay listed in directory entries.
+// Relays are derived from fixed seeds so that authorities agree on entries.
+func directoryRelay(i int) peer.ID {
+	sk, _, err := crypto.GenerateEd25519Key(mrand.New(mrand.NewSource(int64(i))))
+	if err != nil {
+		panic(err)
+	}
+
+	relay, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		panic(err)
+	}
+
+	return relay
+}
+
+func directoryEntries(count int) []*pb.DirectoryEntry {
+	var entries []*pb.DirectoryEntry
+	for i := 0; i < count; i++ {
+		key := [32]byte{byte(i)}
+		entry := &pb.DirectoryEntry{
+			PeerId:        []byte(directoryRelay(i)),
+			EncryptionKey: key[:],
+		}
+
+		if i%2 == 0 {
+			entry.Flags = []string{echalotte.FlagExit}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func signedConsensus(t *testing.T, validAfter time.Time, keys ...crypto.PrivKey) []byte {
+	consensus, err := echalotte.NewConsensus(directoryEntries(10), validAfter, time.Hour)
+	require.NoError(t, err)
+
+	for _, sk := range keys {
+		require.NoError(t, echalotte.SignConsensus(consensus, sk))
+	}
+
+	value, err := proto.Marshal(consensus)
+	require.NoError(t, err)
+
+	return value
+}
+
+func TestDirectoryValidator(t *testing.T) {
+	keys, authorities := generateAuthorities(t, 3)
+	dv := echalotte.DirectoryValidator{Authorities: authorities}
+	now := time.Now().Truncate(time.Hour)
+
+	t.Run("Validate()", func(t *testing.T) {
+		t.Run("Invalid key", func(t *testing.T) {
+			err := dv.Validate("/dir/other", signedConsensus(t, now, keys[0]))
+			assert.EqualError(t, err, echalotte.ErrInvalidKeyFormat)
+
+			err = dv.Validate(dv.CreateKey(now.Add(time.Hour)), signedConsensus(t, now, keys[0]))
+			assert.EqualError(t, err, echalotte.ErrInvalidKeyFormat)
+		})
+
+		t.Run("Invalid message format", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(now), []byte{42})
+			assert.Error(t, err)
+		})
+
+		t.Run("Expired consensus", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(now.Add(-4*time.Hour)), signedConsensus(t, now.Add(-4*time.Hour), keys[0]))
+			assert.EqualError(t, err, echalotte.ErrConsensusExpired)
+		})
+
+		t.Run("Unsigned consensus", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(now), signedConsensus(t, now))
+			assert.EqualError(t, err, echalotte.ErrConsensusSignatures)
+		})
+
+		t.Run("Unknown authority", func(t *testing.T) {
+			otherKeys, _ := generateAuthorities(t, 1)
+			err := dv.Validate(dv.CreateKey(now), signedConsensus(t, now, keys[0], otherKeys[0]))
+			assert.EqualError(t, err, echalotte.ErrUnknownAuthority)
+		})
+
+		t.Run("Invalid signature", func(t *testing.T) {
+			var consensus pb.Consensus
+			require.NoError(t, proto.Unmarshal(signedConsensus(t, now, keys[0]), &consensus))
+
+			consensus.Relays = consensus.Relays[1:]
+			value, err := proto.Marshal(&consensus)
+			require.NoError(t, err)
+
+			err = dv.Validate(dv.CreateKey(now), value)
+			assert.Error(t, err)
+		})
+
+		t.Run("Partially signed consensus", func(t *testing.T) {
+			err := dv.Validate(dv.CreateKey(now), signedConsensus(t, now, keys[0]))
+			assert.NoError(t, err)
+		})
+	})
+
+	t.Run("Verify()", func(t *testing.T) {
+		var partial pb.Consensus
+		require.NoError(t, proto.Unmarshal(signedConsensus(t, now, keys[0]), &partial))
+		assert.EqualError(t, dv.Verify(&partial), echalotte.ErrConsensusSignatures)
+
+		var duplicate pb.Consensus
+		require.NoError(t, proto.Unmarshal(signedConsensus(t, now, keys[0], keys[0]), &duplicate))
+		assert.EqualError(t, dv.Verify(&duplicate), echalotte.ErrConsensusSignatures)
+
+		var future pb.Consensus
+		require.NoError(t, proto.Unmarshal(signedConsensus(t, now.Add(2*time.Hour), keys...), &future))
+		assert.EqualError(t, dv.Verify(&future), echalotte.ErrConsensusExpired)
+
+		var trusted pb.Consensus
+		require.NoError(t, proto.Unmarshal(signedConsensus(t, now, keys[0], keys[2]), &trusted))
+		assert.NoError(t, dv.Verify(&trusted))
+	})
+
+	t.Run("Select()", func(t *testing.T) {
+		partialRecent := signedConsensus(t, now, keys[0])
+		trustedOld := signedConsensus(t, now.Add(-time.Hour), keys[0], keys[1])
+		trustedRecent := signedConsensus(t, now, keys...)
+
+		i, err := dv.Select(dv.CreateKey(now), [][]byte{partialRecent, []byte{42}, trustedOld})
+		require.NoError(t, err)
+		assert.Equal(t, 2, i)
+
+		i, err = dv.Select(dv.CreateKey(now), [][]byte{trustedOld, trustedRecent, partialRecent})
+		require.NoError(t, err)
+		assert.Equal(t, 1, i)
+	})
+}
+
+func TestDirectoryAuthority(t *testing.T) {
+	ctx := context.Background()
+	keys, authorities := generateAuthorities(t, 3)
+	dv := echalotte.DirectoryValidator{Authorities: authorities}
+	dht := echalottetesting.NewInMemoryDHT()
+
+	relays := func(context.Context) ([]*pb.DirectoryEntry, error) {
+		return directoryEntries(5), nil
+	}
+
+	var das []*echalotte.DirectoryAuthority
+	for _, sk := range keys {
+		das = append(das, echalotte.NewDirectoryAuthority(dht, sk, dv, time.Hour, relays))
+	}
+
+	fetch := func() *pb.Consensus {
+		value, err := dht.GetValue(ctx, dv.CreateKey(time.Now().Truncate(time.Hour)))
+		require.NoError(t, err)
+
+		var consensus pb.Consensus
+		require.NoError(t, proto.Unmarshal(value, &consensus))
+
+		return &consensus
+	}
+
+	require.NoError(t, das[0].Publish(ctx))
+	assert.Len(t, fetch().Signatures, 1)
+	assert.Error(t, dv.Verify(fetch()))
+
+	// Publishing twice shouldn't add a duplicate signature.
+	require.NoError(t, das[0].Publish(ctx))
+	assert.Len(t, fetch().Signatures, 1)
+
+	require.NoError(t, das[1].Publish(ctx))
+	assert.Len(t, fetch().Signatures, 2)
+	assert.NoError(t, dv.Verify(fetch()))
+
+	require.NoError(t, das[2].Publish(ctx))
+	assert.Len(t, fetch().Signatures, 3)
+	assert.Len(t, fetch().Relays, 5)
+}
+
+func TestDirectoryCircuitBuilder(t *testing.T) {
+	ctx := context.Background()
+	keys, authorities := generateAuthorities(t, 3)
+	dv := echalotte.DirectoryValidator{Authorities: authorities}
+	now := time.Now().Truncate(time.Hour)
+
+	t.Run("fails without consensus", func(t *testing.T) {
+		cb, err := echalotte.NewDirectoryCircuitBuilder(echalottetesting.NewInMemoryDHT(), dv, nil)
+		require.NoError(t, err)
+
+		_, err = cb.Build(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects untrusted consensus", func(t *testing.T) {
+		dht := echalottetesting.NewInMemoryDHT()
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(now), signedConsensus(t, now, keys[0])))
+
+		cb, err := echalotte.NewDirectoryCircuitBuilder(dht, dv, nil)
+		require.NoError(t, err)
+
+		_, err = cb.Build(ctx)
+		assert.EqualError(t, errors.Cause(err), echalotte.ErrConsensusSignatures)
+	})
+
+	t.Run("builds circuits from consensus", func(t *testing.T) {
+		dht := echalottetesting.NewInMemoryDHT()
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(now), signedConsensus(t, now, keys...)))
+
+		cb, err := echalotte.NewDirectoryCircuitBuilder(dht, dv, nil, echalotte.CircuitSize(4))
+		require.NoError(t, err)
+
+		circuit, err := cb.Build(ctx)
+		require.NoError(t, err)
+		assert.Len(t, circuit, 4)
+
+		for _, relay := range circuit {
+			key, ok := cb.PeerEncryptionKey(relay)
+			require.True(t, ok)
+			assert.Equal(t, directoryRelay(int(key[0])), relay)
+		}
+	})
+
+	t.Run("uses previous consensus while signing", func(t *testing.T) {
+		dht := echalottetesting.NewInMemoryDHT()
+		previous := now.Add(-time.Hour)
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(previous), signedConsensus(t, previous, keys...)))
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(now), signedConsensus(t, now, keys[0])))
+
+		cb, err := echalotte.NewDirectoryCircuitBuilder(dht, dv, nil, echalotte.CircuitSize(4))
+		require.NoError(t, err)
+
+		_, err = cb.Build(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("requires flags", func(t *testing.T) {
+		dht := echalottetesting.NewInMemoryDHT()
+		require.NoError(t, dht.PutValue(ctx, dv.CreateKey(now), signedConsensus(t, now, keys...)))
+
+		cb, err := echalotte.NewDirectoryCircuitBuilder(dht, dv, nil, echalotte.CircuitSize(5))
+		require.NoError(t, err)
+		cb.RequireFlags(echalotte.FlagExit)
+
+		circuit, err := cb.Build(ctx)
+		require.NoError(t, err)
+
+		for _, relay := range circuit {
+			key, _ := cb.PeerEncryptionKey(relay)
+			assert.Equal(t, 0, int(key[0])%2)
+		}
+
+		_, err = cb.Build(ctx, echalotte.CircuitSize(6))
+		assert.Error(t, err)
+	})
+}
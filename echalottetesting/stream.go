@@ -0,0 +1,73 @@
+package echalottetesting
+
+import (
+	"net"
+	"time"
+
+	inet "gx/ipfs/QmNgLg1NTw37iWbYPKcyK85YJ9Whs1MkPtJwhfqbNYAyKg/go-libp2p-net"
+	"gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+)
+
+// PipeStream is an in-memory stream without an underlying connection.
+type PipeStream struct {
+	pipe     net.Conn
+	protocol protocol.ID
+}
+
+// NewPipeStreams creates two connected in-memory streams.
+func NewPipeStreams(p protocol.ID) (*PipeStream, *PipeStream) {
+	a, b := net.Pipe()
+	return &PipeStream{pipe: a, protocol: p}, &PipeStream{pipe: b, protocol: p}
+}
+
+// Read from the stream.
+func (s *PipeStream) Read(b []byte) (int, error) {
+	return s.pipe.Read(b)
+}
+
+// Write to the stream.
+func (s *PipeStream) Write(b []byte) (int, error) {
+	return s.pipe.Write(b)
+}
+
+// Close the stream.
+func (s *PipeStream) Close() error {
+	return s.pipe.Close()
+}
+
+// Reset closes the stream.
+func (s *PipeStream) Reset() error {
+	return s.pipe.Close()
+}
+
+// SetDeadline sets read and write deadlines.
+func (s *PipeStream) SetDeadline(t time.Time) error {
+	return s.pipe.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (s *PipeStream) SetReadDeadline(t time.Time) error {
+	return s.pipe.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (s *PipeStream) SetWriteDeadline(t time.Time) error {
+	return s.pipe.SetWriteDeadline(t)
+}
+
+// Protocol of the stream.
+func (s *PipeStream) Protocol() protocol.ID {
+	return s.protocol
+}
+
+// SetProtocol of the stream.
+func (s *PipeStream) SetProtocol(p protocol.ID) {
+	s.protocol = p
+}
+
+// Conn returns nil: pipe streams don't have an underlying connection.
+func (s *PipeStream) Conn() inet.Conn {
+	return nil
+}
+
+var _ inet.Stream = (*PipeStream)(nil)
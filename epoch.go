@@ -0,0 +1,364 @@
+package echalotte
+
+import (
+	"context"
+	crand "crypto/rand"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+const (
+	// DefaultKeyEpochDuration is the default lifetime of epoch keys.
+	DefaultKeyEpochDuration = time.Hour
+
+	// DefaultKeyEpochsAhead is the default number of future epoch keys
+	// published in advance.
+	DefaultKeyEpochsAhead = 3
+)
+
+// Errors used by epoch keys.
+const (
+	ErrInvalidKeyEpochs = "key epoch duration must be positive and at least one epoch must be published in advance"
+	ErrInvalidEpochKey  = "invalid epoch key"
+	ErrNoEpochKey       = "no encryption key for the current epoch"
+)
+
+// KeyEpochs configures forward-secure per-epoch encryption keys.
+type KeyEpochs struct {
+	// Duration of an epoch.
+	Duration time.Duration
+
+	// Ahead is the number of future epochs whose keys are published in
+	// advance, so that senders with a slightly stale record can still
+	// encrypt to the right key.
+	Ahead int
+}
+
+// epochKey is an encryption key pair only used during an epoch.
+type epochKey struct {
+	epoch     uint64
+	notBefore time.Time
+	notAfter  time.Time
+	public    *[32]byte
+	key       *[32]byte
+	hybrid    *hybridKey
+}
+
+// EpochEncryptionKeys is an option to generate a new encryption key every
+// epoch and publish the keys of the next epochs in advance.
+// Private keys are deleted once their epoch is over, so that compromising a
+// relay doesn't expose the messages it relayed in previous epochs.
+// The long-term key is marked as unusable in the record and layers
+// encrypted to it are rejected, since it would never be deleted.
+func EpochEncryptionKeys(duration time.Duration, ahead int) HostOption {
+	return func(opts *HostOptions) error {
+		if duration <= 0 || ahead < 1 {
+			return errors.New(ErrInvalidKeyEpochs)
+		}
+
+		opts.KeyEpochs = &KeyEpochs{Duration: duration, Ahead: ahead}
+		return nil
+	}
+}
+
+// KeyEpoch returns the epoch containing the given time.
+func KeyEpoch(t time.Time, duration time.Duration) uint64 {
+	return uint64(t.UnixNano() / int64(duration))
+}
+
+// epochBounds returns the start and end of the given epoch.
+func epochBounds(epoch uint64, duration time.Duration) (time.Time, time.Time) {
+	start := time.Unix(0, int64(epoch)*int64(duration))
+	return start, start.Add(duration)
+}
+
+// CurrentEpochKey returns the epoch key of the record that is valid at the
+// given time.
+// Records without epoch keys return their long-term key, or the next key
+// once it is valid (see RotateEncryptionKey).
+func CurrentEpochKey(publicKey *pb.PublicKey, t time.Time) (*[32]byte, error) {
+	if len(publicKey.EpochKeys) == 0 {
+		data := publicKey.Data
+		if isNextKeyValid(publicKey, t) {
+			data = publicKey.Next.Data
+		}
+
+		var key [32]byte
+		copy(key[:], data)
+		return &key, nil
+	}
+
+	for _, epochKey := range publicKey.EpochKeys {
+		notBefore, err := ptypes.TimestampFromProto(epochKey.NotBefore)
+		if err != nil {
+			continue
+		}
+
+		notAfter, err := ptypes.TimestampFromProto(epochKey.NotAfter)
+		if err != nil {
+			continue
+		}
+
+		if !t.Before(notBefore) && t.Before(notAfter) {
+			var key [32]byte
+			copy(key[:], epochKey.Data)
+			return &key, nil
+		}
+	}
+
+	return nil, errors.New(ErrNoEpochKey)
+}
+
+// validateEpochKeys checks that the epoch keys of a record are well-formed.
+func validateEpochKeys(publicKey *pb.PublicKey) error {
+	if publicKey.EpochKeysOnly && len(publicKey.EpochKeys) == 0 {
+		return errors.New(ErrInvalidEpochKey)
+	}
+
+	for _, epochKey := range publicKey.EpochKeys {
+		if len(epochKey.Data) != 32 {
+			return errors.New(ErrInvalidEpochKey)
+		}
+
+		notBefore, err := ptypes.TimestampFromProto(epochKey.NotBefore)
+		if err != nil {
+			return errors.Wrap(err, ErrInvalidEpochKey)
+		}
+
+		notAfter, err := ptypes.TimestampFromProto(epochKey.NotAfter)
+		if err != nil {
+			return errors.Wrap(err, ErrInvalidEpochKey)
+		}
+
+		if !notAfter.After(notBefore) {
+			return errors.New(ErrInvalidEpochKey)
+		}
+	}
+
+	return nil
+}
+
+// lastEpochEnd returns the end of the last epoch published in the record,
+// or the zero time if it has no epoch keys.
+func lastEpochEnd(publicKey *pb.PublicKey) time.Time {
+	var end time.Time
+	for _, epochKey := range publicKey.EpochKeys {
+		notAfter, err := ptypes.TimestampFromProto(epochKey.NotAfter)
+		if err == nil && notAfter.After(end) {
+			end = notAfter
+		}
+	}
+
+	return end
+}
+
+// eraseEpochKeys deletes the private keys of epochs that are over, once
+// senders with skewed clocks can't use them anymore.
+// It returns whether keys were deleted.
+// The lock must be held by the caller.
+func (h *Host) eraseEpochKeys(now time.Time) bool {
+	var kept []epochKey
+	for _, k := range h.epochKeys {
+		if now.Add(-h.validator.clockSkew()).Before(k.notAfter) {
+			kept = append(kept, k)
+			continue
+		}
+
+		eraseKey(k.key, k.hybrid)
+	}
+
+	erased := len(kept) < len(h.epochKeys)
+	h.epochKeys = kept
+
+	return erased
+}
+
+// eraseKey overwrites a private key so that it can't be recovered from
+// memory.
+func eraseKey(key *[32]byte, hybrid *hybridKey) {
+	for i := range key {
+		key[i] = 0
+	}
+
+	if hybrid != nil {
+		for i := range hybrid.seed {
+			hybrid.seed[i] = 0
+		}
+	}
+}
+
+// nextEpochKeyErasure returns when the next epoch key should be erased, or
+// the zero time if there are no epoch keys.
+// The lock must be held by the caller.
+func (h *Host) nextEpochKeyErasure() time.Time {
+	var next time.Time
+	for _, k := range h.epochKeys {
+		erase := k.notAfter.Add(h.validator.clockSkew())
+		if next.IsZero() || erase.Before(next) {
+			next = erase
+		}
+	}
+
+	return next
+}
+
+// updateEpochKeys generates the keys of the current and next epochs and
+// deletes the private keys of epochs that are over.
+// The lock must be held by the caller.
+func (h *Host) updateEpochKeys(now time.Time) error {
+	current := KeyEpoch(now, h.keyEpochs.Duration)
+
+	h.eraseEpochKeys(now)
+	kept := h.epochKeys
+
+	for epoch := current; epoch <= current+uint64(h.keyEpochs.Ahead); epoch++ {
+		exists := false
+		for _, k := range kept {
+			exists = exists || k.epoch == epoch
+		}
+
+		if exists {
+			continue
+		}
+
+		publicKey, privateKey, err := box.GenerateKey(crand.Reader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		hybrid, err := h.newHybridKeyFor()
+		if err != nil {
+			return err
+		}
+
+		notBefore, notAfter := epochBounds(epoch, h.keyEpochs.Duration)
+		kept = append(kept, epochKey{
+			epoch:     epoch,
+			notBefore: notBefore,
+			notAfter:  notAfter,
+			public:    publicKey,
+			key:       privateKey,
+			hybrid:    hybrid,
+		})
+	}
+
+	h.epochKeys = kept
+
+	return nil
+}
+
+// publishEpochKeys updates, persists and publishes the epoch keys.
+func (h *Host) publishEpochKeys(ctx context.Context) error {
+	h.keysLock.Lock()
+	err := h.updateEpochKeys(time.Now())
+	h.keysLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = h.saveEncryptionKeys()
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := h.EncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	return h.publishEncryptionKey(ctx, publicKey)
+}
+
+// runKeyEpochs publishes new epoch keys at the start of every epoch and
+// erases expired keys as soon as possible until the context is done.
+func (h *Host) runKeyEpochs(ctx context.Context) {
+	for {
+		_, end := epochBounds(KeyEpoch(time.Now(), h.keyEpochs.Duration), h.keyEpochs.Duration)
+
+		wake := end
+		h.keysLock.Lock()
+		erase := h.nextEpochKeyErasure()
+		h.keysLock.Unlock()
+		if !erase.IsZero() && erase.Before(wake) {
+			wake = erase
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(wake)):
+		}
+
+		if time.Now().Before(end) {
+			err := h.eraseExpiredEpochKeys()
+			if err != nil {
+				log.Errorf("Could not erase expired epoch keys: %s", err.Error())
+			}
+
+			continue
+		}
+
+		err := h.publishEpochKeys(ctx)
+		if err != nil {
+			log.Errorf("Could not publish epoch keys: %s", err.Error())
+		}
+	}
+}
+
+// eraseExpiredEpochKeys deletes the private keys of epochs that are over
+// and removes them from the keystore.
+func (h *Host) eraseExpiredEpochKeys() error {
+	h.keysLock.Lock()
+	erased := h.eraseEpochKeys(time.Now())
+	h.keysLock.Unlock()
+	if !erased {
+		return nil
+	}
+
+	return h.saveEncryptionKeys()
+}
+
+// epochDecryptionKeys returns the private keys of the epochs that are valid
+// now, tolerating clock skew with senders.
+// The lock must be held by the caller.
+func (h *Host) epochDecryptionKeys(now time.Time) []decryptionKey {
+	var keys []decryptionKey
+	skew := h.validator.clockSkew()
+	for _, k := range h.epochKeys {
+		if now.Add(skew).Before(k.notBefore) || !now.Add(-skew).Before(k.notAfter) {
+			continue
+		}
+
+		keys = append(keys, decryptionKey{key: copyKey(k.key), hybrid: k.hybrid})
+	}
+
+	return keys
+}
+
+// epochKeysProto returns the published form of the epoch keys.
+// The lock must be held by the caller.
+func (h *Host) epochKeysProto() []*pb.EpochKey {
+	var keys []*pb.EpochKey
+	for _, k := range h.epochKeys {
+		notBefore, _ := ptypes.TimestampProto(k.notBefore)
+		notAfter, _ := ptypes.TimestampProto(k.notAfter)
+		epochKey := &pb.EpochKey{
+			Epoch:     k.epoch,
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+			Data:      k.public[:],
+		}
+		if k.hybrid != nil {
+			epochKey.KemData = k.hybrid.public
+		}
+
+		keys = append(keys, epochKey)
+	}
+
+	return keys
+}
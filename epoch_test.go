@@ -0,0 +1,318 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	inet "gx/ipfs/QmNgLg1NTw37iWbYPKcyK85YJ9Whs1MkPtJwhfqbNYAyKg/go-libp2p-net"
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// keyProvidingCircuitBuilder knows the long-term keys of some peers, like
+// directory or static circuit builders.
+type keyProvidingCircuitBuilder struct {
+	*echalottetesting.DummyCircuitBuilder
+	keys map[peer.ID]*[32]byte
+}
+
+func (cb *keyProvidingCircuitBuilder) PeerEncryptionKey(p peer.ID) (*[32]byte, bool) {
+	key, ok := cb.keys[p]
+	return key, ok
+}
+
+// messageTo creates an onion message that only the given peer can decrypt
+// with the given key.
+func messageTo(t *testing.T, to peer.ID, key *[32]byte) *echalotte.OnionMessage {
+	sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	from, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	m, err := echalotte.NewMessage(from, sk, []byte("Et ce monde rendait une étrange musique,"))
+	require.NoError(t, err)
+
+	m, err = m.Encapsulate(to, key)
+	require.NoError(t, err)
+
+	return m
+}
+
+func TestEpochKeys(t *testing.T) {
+	t.Run("EpochEncryptionKeys()", func(t *testing.T) {
+		options := &echalotte.HostOptions{}
+		assert.EqualError(t, options.Apply(echalotte.EpochEncryptionKeys(0, 1)), echalotte.ErrInvalidKeyEpochs)
+		assert.EqualError(t, options.Apply(echalotte.EpochEncryptionKeys(time.Hour, 0)), echalotte.ErrInvalidKeyEpochs)
+		assert.NoError(t, options.Apply(echalotte.EpochEncryptionKeys(time.Hour, 2)))
+		assert.Equal(t, &echalotte.KeyEpochs{Duration: time.Hour, Ahead: 2}, options.KeyEpochs)
+	})
+
+	t.Run("CurrentEpochKey()", func(t *testing.T) {
+		now := time.Now()
+		epoch := echalotte.KeyEpoch(now, time.Hour)
+		assert.Equal(t, epoch+1, echalotte.KeyEpoch(now.Add(time.Hour), time.Hour))
+
+		longTerm := &[32]byte{1}
+		key, err := echalotte.CurrentEpochKey(&pb.PublicKey{Data: longTerm[:]}, now)
+		require.NoError(t, err)
+		assert.Equal(t, longTerm, key)
+
+		epochKey := func(data byte, from, to time.Time) *pb.EpochKey {
+			notBefore, _ := ptypes.TimestampProto(from)
+			notAfter, _ := ptypes.TimestampProto(to)
+			return &pb.EpochKey{NotBefore: notBefore, NotAfter: notAfter, Data: []byte{data}}
+		}
+
+		publicKey := &pb.PublicKey{
+			Data: longTerm[:],
+			EpochKeys: []*pb.EpochKey{
+				epochKey(2, now.Add(-2*time.Hour), now.Add(-time.Hour)),
+				epochKey(3, now.Add(-time.Hour), now.Add(time.Hour)),
+			},
+		}
+
+		key, err = echalotte.CurrentEpochKey(publicKey, now)
+		require.NoError(t, err)
+		assert.Equal(t, &[32]byte{3}, key)
+
+		_, err = echalotte.CurrentEpochKey(publicKey, now.Add(2*time.Hour))
+		assert.EqualError(t, err, echalotte.ErrNoEpochKey)
+	})
+
+	t.Run("publishes keys in advance", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.EpochEncryptionKeys(time.Hour, 2),
+		)
+		require.NoError(t, err)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		assert.Len(t, stored.Epochs, 3)
+
+		current := echalotte.KeyEpoch(time.Now(), time.Hour)
+		for i, epochKey := range stored.Epochs {
+			assert.Equal(t, current+uint64(i), epochKey.Epoch)
+		}
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		assert.Equal(t, stored.Epochs[0].PublicKey, key[:])
+
+		longTerm, err := h.EncryptionKey()
+		require.NoError(t, err)
+		assert.NotEqual(t, longTerm, key)
+
+		assert.NoError(t, sendTo(ctx, h, messageTo(t, h.ID(), key)))
+
+		// The long-term key is never deleted, so it must not be used.
+		raw, ok := cache.Record(h.ID())
+		require.True(t, ok)
+
+		var record pb.PublicKey
+		require.NoError(t, proto.Unmarshal(raw, &record))
+		assert.True(t, record.EpochKeysOnly)
+		assert.Error(t, sendTo(ctx, h, messageTo(t, h.ID(), longTerm)))
+
+		// Future epoch keys can't be used yet.
+		var future [32]byte
+		copy(future[:], stored.Epochs[2].PublicKey)
+		assert.Error(t, sendTo(ctx, h, messageTo(t, h.ID(), &future)))
+	})
+
+	t.Run("deletes expired keys", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		publicKey, privateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		expiredPublicKey, expiredPrivateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		expiredEpoch := echalotte.KeyEpoch(time.Now(), time.Hour) - 2
+		require.NoError(t, ks.Store(&echalotte.StoredKeys{
+			Current: echalotte.StoredKey{
+				PublicKey:  publicKey[:],
+				PrivateKey: privateKey[:],
+			},
+			Epochs: []echalotte.StoredKey{{
+				PublicKey:  expiredPublicKey[:],
+				PrivateKey: expiredPrivateKey[:],
+				Epoch:      expiredEpoch,
+				NotBefore:  time.Unix(int64(expiredEpoch)*3600, 0),
+				NotAfter:   time.Unix(int64(expiredEpoch+1)*3600, 0),
+			}},
+		}))
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+		)
+		require.NoError(t, err)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		require.Len(t, stored.Epochs, 2)
+		for _, epochKey := range stored.Epochs {
+			assert.NotEqual(t, expiredEpoch, epochKey.Epoch)
+		}
+
+		assert.Error(t, sendTo(ctx, h, messageTo(t, h.ID(), expiredPublicKey)))
+	})
+
+	t.Run("erases keys once senders can't use them", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		publicKey, privateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		expiringPublicKey, expiringPrivateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		// The key is over but still tolerated for senders with skewed clocks.
+		notAfter := time.Now().Add(-echalotte.DefaultClockSkew).Add(500 * time.Millisecond)
+		expiringEpoch := echalotte.KeyEpoch(time.Now(), time.Hour) - 1
+		require.NoError(t, ks.Store(&echalotte.StoredKeys{
+			Current: echalotte.StoredKey{
+				PublicKey:  publicKey[:],
+				PrivateKey: privateKey[:],
+			},
+			Epochs: []echalotte.StoredKey{{
+				PublicKey:  expiringPublicKey[:],
+				PrivateKey: expiringPrivateKey[:],
+				Epoch:      expiringEpoch,
+				NotBefore:  notAfter.Add(-time.Hour),
+				NotAfter:   notAfter,
+			}},
+		}))
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+		)
+		require.NoError(t, err)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		assert.Len(t, stored.Epochs, 3)
+		assert.NoError(t, sendTo(ctx, h, messageTo(t, h.ID(), expiringPublicKey)))
+
+		// The key is erased without waiting for the next epoch.
+		assert.Eventually(t, func() bool {
+			stored, err := ks.Load()
+			return err == nil && len(stored.Epochs) == 2
+		}, 3*time.Second, 50*time.Millisecond)
+
+		assert.Error(t, sendTo(ctx, h, messageTo(t, h.ID(), expiringPublicKey)))
+	})
+
+	t.Run("circuit builder keys don't bypass epoch keys", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+		)
+		require.NoError(t, err)
+
+		longTermKey, err := relay.EncryptionKey()
+		require.NoError(t, err)
+
+		// The last hop didn't publish a record: the builder's key is used.
+		received := make(chan []byte, 1)
+		recipientKey, recipientPrivateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		recipient := echalottetesting.RandomHost(ctx, t)
+		recipient.SetStreamHandler(echalotte.ProtocolID, func(stream inet.Stream) {
+			defer stream.Close()
+
+			var m echalotte.OnionMessage
+			if json.NewDecoder(stream).Decode(&m) != nil {
+				return
+			}
+
+			decapsulated, err := m.Decapsulate(recipient.Peerstore().PrivKey(recipient.ID()), recipientPrivateKey)
+			if err == nil {
+				received <- decapsulated.Content
+			}
+		})
+
+		relay.Peerstore().AddAddrs(recipient.ID(), recipient.Addrs(), peerstore.AddressTTL)
+
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			&keyProvidingCircuitBuilder{
+				DummyCircuitBuilder: echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{recipient.ID(), relay.ID()}, echalotte.CircuitSize(2)),
+				keys: map[peer.ID]*[32]byte{
+					relay.ID():     longTermKey,
+					recipient.ID(): recipientKey,
+				},
+			},
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), peerstore.AddressTTL)
+		require.NoError(t, client.SendMessage(ctx, recipient.ID(), []byte("Et le ciel regardait la carcasse superbe")))
+
+		select {
+		case content := <-received:
+			assert.Equal(t, []byte("Et le ciel regardait la carcasse superbe"), content)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message not relayed")
+		}
+	})
+}
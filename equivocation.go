@@ -0,0 +1,531 @@
+package echalotte
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	inet "gx/ipfs/QmNgLg1NTw37iWbYPKcyK85YJ9Whs1MkPtJwhfqbNYAyKg/go-libp2p-net"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+const (
+	// GossipProtocolID is the ID of the protocol used to compare observed
+	// encryption keys with neighbors.
+	GossipProtocolID = protocol.ID("/echalotte/gossip/v1.0.0")
+
+	// DefaultGossipInterval is the default interval between two gossip
+	// rounds.
+	DefaultGossipInterval = 10 * time.Minute
+
+	// DefaultGossipPeers is the number of neighbors contacted every round.
+	DefaultGossipPeers = 3
+
+	// DefaultGossipRecords is the number of key records sent to neighbors
+	// every round.
+	DefaultGossipRecords = 8
+
+	// maxGossipRecords and maxGossipMessageSize limit the gossip messages we
+	// decode.
+	maxGossipRecords     = 4 * DefaultGossipRecords
+	maxGossipMessageSize = 1 << 20
+
+	// maxGossipLookups is the number of records fetched from the DHT every
+	// gossip round.
+	maxGossipLookups = 2
+)
+
+// Errors used by key gossip.
+const (
+	ErrInvalidGossipInterval = "gossip interval should be strictly positive"
+	ErrGossipTooLarge        = "gossip message is too large"
+)
+
+// gossipMessage contains signed key records of relays.
+// Requests contain the records of random peers, so that they don't reveal
+// the relays we use. Responses only contain our records that contradict the
+// request's records, so that the requester can verify the disagreement
+// itself.
+type gossipMessage struct {
+	Records []gossipRecord `json:"records"`
+}
+
+// gossipRecord is a signed key record of a relay.
+type gossipRecord struct {
+	Relay  []byte `json:"relay"`
+	Record []byte `json:"record"`
+}
+
+// Equivocation proves that a relay published two different keys for the
+// same epoch: both records are signed by the relay.
+// For long-term keys, Epoch is the time (in seconds) at which both records
+// are valid with different keys.
+type Equivocation struct {
+	Relay    peer.ID
+	Epoch    uint64
+	LongTerm bool
+	Records  [2][]byte
+}
+
+// observationKey identifies the epoch key of a relay.
+type observationKey struct {
+	relay peer.ID
+	epoch uint64
+}
+
+// observedKey is the first key seen for an observation key.
+type observedKey struct {
+	hash       [32]byte
+	record     []byte
+	observedAt time.Time
+}
+
+// observedRecord is a key record of a relay, kept to compare its long-term
+// key with the relay's other records.
+type observedRecord struct {
+	publicKey  *pb.PublicKey
+	record     []byte
+	observedAt time.Time
+}
+
+// KeyObserver records the encryption keys observed for relays and detects
+// relays that publish different keys for the same epoch, for example to
+// serve different keys to different clients.
+// Long-term keys are compared over the validity windows of their records.
+type KeyObserver struct {
+	validator PublicKeyValidator
+
+	lock          sync.Mutex
+	observations  map[observationKey]*observedKey
+	records       map[peer.ID][]*observedRecord
+	equivocations map[peer.ID]Equivocation
+}
+
+// NewKeyObserver creates an empty key observer.
+func NewKeyObserver() *KeyObserver {
+	return &KeyObserver{
+		observations:  make(map[observationKey]*observedKey),
+		records:       make(map[peer.ID][]*observedRecord),
+		equivocations: make(map[peer.ID]Equivocation),
+	}
+}
+
+// longTermWindow returns the validity window of a record's long-term key.
+// Records without bounds are valid until DHT nodes drop them.
+func (o *KeyObserver) longTermWindow(publicKey *pb.PublicKey) (time.Time, time.Time, error) {
+	createdAt, err := ptypes.TimestampFromProto(publicKey.CreatedAt)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.WithStack(err)
+	}
+
+	notBefore, notAfter := createdAt, createdAt.Add(o.validator.maxAge())
+	if publicKey.NotBefore != nil {
+		notBefore, err = ptypes.TimestampFromProto(publicKey.NotBefore)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.WithStack(err)
+		}
+	}
+
+	if publicKey.NotAfter != nil {
+		notAfter, err = ptypes.TimestampFromProto(publicKey.NotAfter)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.WithStack(err)
+		}
+	}
+
+	return notBefore, notAfter, nil
+}
+
+// longTermKeyAt returns the long-term key senders use at the given time.
+func longTermKeyAt(publicKey *pb.PublicKey, t time.Time) []byte {
+	if isNextKeyValid(publicKey, t) {
+		return publicKey.Next.Data
+	}
+
+	return publicKey.Data
+}
+
+// longTermConflict returns a time at which both records are valid with
+// different long-term keys, if any.
+// A record that revokes the other record's key legitimately replaces it.
+func (o *KeyObserver) longTermConflict(a, b *pb.PublicKey) (time.Time, bool) {
+	if a.EpochKeysOnly || b.EpochKeysOnly {
+		return time.Time{}, false
+	}
+
+	if isRevokedKey(a.Data, b.Revocations) || isRevokedKey(b.Data, a.Revocations) {
+		return time.Time{}, false
+	}
+
+	startA, endA, err := o.longTermWindow(a)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	startB, endB, err := o.longTermWindow(b)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	start, end := startA, endA
+	if startB.After(start) {
+		start = startB
+	}
+	if endB.Before(end) {
+		end = endB
+	}
+
+	// The current key of a record only changes when its next key becomes
+	// valid.
+	instants := []time.Time{start}
+	for _, publicKey := range []*pb.PublicKey{a, b} {
+		if publicKey.Next == nil {
+			continue
+		}
+
+		notBefore, err := ptypes.TimestampFromProto(publicKey.Next.NotBefore)
+		if err == nil && notBefore.After(start) {
+			instants = append(instants, notBefore)
+		}
+	}
+
+	for _, t := range instants {
+		if !t.Before(end) {
+			continue
+		}
+
+		if !bytes.Equal(longTermKeyAt(a, t), longTermKeyAt(b, t)) {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Observe a key record of a relay.
+// It returns the equivocations the record proves, if any.
+func (o *KeyObserver) Observe(relay peer.ID, record []byte) ([]Equivocation, error) {
+	err := o.validator.Validate(o.validator.CreateKey(relay), record)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey pb.PublicKey
+	err = proto.Unmarshal(record, &publicKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var equivocations []Equivocation
+	now := time.Now()
+	for _, epochKey := range publicKey.EpochKeys {
+		key := observationKey{relay: relay, epoch: epochKey.Epoch}
+		hash := sha256.Sum256(epochKey.Data)
+
+		observed, ok := o.observations[key]
+		if !ok {
+			o.observations[key] = &observedKey{hash: hash, record: record, observedAt: now}
+			continue
+		}
+
+		if observed.hash == hash {
+			continue
+		}
+
+		equivocations = append(equivocations, Equivocation{
+			Relay:   relay,
+			Epoch:   key.epoch,
+			Records: [2][]byte{observed.record, record},
+		})
+	}
+
+	known := false
+	for _, observed := range o.records[relay] {
+		if bytes.Equal(observed.record, record) {
+			known = true
+			continue
+		}
+
+		at, ok := o.longTermConflict(observed.publicKey, &publicKey)
+		if !ok {
+			continue
+		}
+
+		equivocations = append(equivocations, Equivocation{
+			Relay:    relay,
+			Epoch:    uint64(at.Unix()),
+			LongTerm: true,
+			Records:  [2][]byte{observed.record, record},
+		})
+	}
+
+	if !known {
+		o.records[relay] = append(o.records[relay], &observedRecord{
+			publicKey:  &publicKey,
+			record:     record,
+			observedAt: now,
+		})
+	}
+
+	for _, equivocation := range equivocations {
+		o.equivocations[relay] = equivocation
+	}
+
+	return equivocations, nil
+}
+
+// Equivocations returns the equivocations detected so far, at most one per
+// relay.
+func (o *KeyObserver) Equivocations() []Equivocation {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var equivocations []Equivocation
+	for _, equivocation := range o.equivocations {
+		equivocations = append(equivocations, equivocation)
+	}
+
+	return equivocations
+}
+
+// Prune forgets observations older than the given time.
+func (o *KeyObserver) Prune(before time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for key, observed := range o.observations {
+		if observed.observedAt.Before(before) {
+			delete(o.observations, key)
+		}
+	}
+
+	for relay, records := range o.records {
+		var kept []*observedRecord
+		for _, observed := range records {
+			if !observed.observedAt.Before(before) {
+				kept = append(kept, observed)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(o.records, relay)
+		} else {
+			o.records[relay] = kept
+		}
+	}
+}
+
+// KeyConsistencyGossip is an option to compare the encryption keys we
+// observe with our neighbors every interval.
+// Relays caught publishing two different keys for the same epoch are
+// blacklisted (if RelayReputation is used) and reported to onEquivocation,
+// which can be nil.
+// Relays should persist their keys with EncryptionKeystore, otherwise
+// restarting generates new keys while their previous records are still
+// valid.
+func KeyConsistencyGossip(interval time.Duration, onEquivocation func(Equivocation)) HostOption {
+	return func(opts *HostOptions) error {
+		if interval <= 0 {
+			return errors.New(ErrInvalidGossipInterval)
+		}
+
+		opts.GossipInterval = interval
+		opts.OnEquivocation = onEquivocation
+		return nil
+	}
+}
+
+// observeRecord records a key record of a relay and handles the
+// equivocations it reveals.
+func (h *Host) observeRecord(peerID peer.ID, record []byte) []Equivocation {
+	equivocations, err := h.keyObserver.Observe(peerID, record)
+	if err != nil {
+		log.Debugf("Invalid key record for %s: %s", peerID.Pretty(), err.Error())
+		return nil
+	}
+
+	for _, equivocation := range equivocations {
+		log.Warningf("Relay %s published different keys for epoch %d", peerID.Pretty(), equivocation.Epoch)
+
+		h.keyCache.Invalidate(peerID)
+		if h.reputation != nil {
+			h.reputation.Blacklist(peerID)
+		}
+
+		if h.onEquivocation != nil {
+			h.onEquivocation(equivocation)
+		}
+	}
+
+	return equivocations
+}
+
+// gossipRecords picks the key records of random peers to send to our
+// neighbors.
+// They aren't chosen among the relays we use, which would reveal them.
+// Cached records are used when possible, and at most maxGossipLookups
+// records of peers that support the relay protocol are fetched every round.
+// Fetched records aren't cached, since we may never use these relays.
+func (h *Host) gossipRecords(ctx context.Context) []gossipRecord {
+	peers := h.Peerstore().Peers()
+	newCryptoRand().Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	var records []gossipRecord
+	lookups := 0
+	for _, peerID := range peers {
+		if len(records) == DefaultGossipRecords {
+			break
+		}
+
+		record, ok := h.keyCache.Record(peerID)
+		if !ok {
+			if lookups == maxGossipLookups || !h.isKnownRelay(peerID) {
+				continue
+			}
+
+			lookups++
+			entry, err := h.keyCache.fetch(ctx, peerID)
+			if err != nil {
+				continue
+			}
+
+			record = entry.raw
+		}
+
+		h.observeRecord(peerID, record)
+		records = append(records, gossipRecord{Relay: []byte(peerID), Record: record})
+	}
+
+	return records
+}
+
+// isKnownRelay returns true if the peer supports the relay protocol,
+// according to our peerstore.
+func (h *Host) isKnownRelay(peerID peer.ID) bool {
+	supported, err := h.Peerstore().SupportsProtocols(peerID, string(RelayProtocolID))
+	return err == nil && len(supported) > 0
+}
+
+// decodeGossip decodes a gossip message, rejecting messages that are too
+// large.
+func decodeGossip(r io.Reader) (*gossipMessage, error) {
+	var message gossipMessage
+	err := json.NewDecoder(io.LimitReader(r, maxGossipMessageSize)).Decode(&message)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(message.Records) > maxGossipRecords {
+		return nil, errors.New(ErrGossipTooLarge)
+	}
+
+	return &message, nil
+}
+
+// Gossip sends the key records of random peers to the given neighbor, which
+// answers with its records that contradict them.
+func (h *Host) Gossip(ctx context.Context, neighbor peer.ID) error {
+	records := h.gossipRecords(ctx)
+
+	stream, err := h.NewStream(ctx, neighbor, GossipProtocolID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	err = json.NewEncoder(stream).Encode(&gossipMessage{Records: records})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	response, err := decodeGossip(stream)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range response.Records {
+		h.observeRecord(peer.ID(record.Relay), record.Record)
+	}
+
+	return nil
+}
+
+// handleGossip answers a neighbor with our records that contradict the
+// signed records it presents.
+func (h *Host) handleGossip(stream inet.Stream) error {
+	defer stream.Close()
+
+	request, err := decodeGossip(stream)
+	if err != nil {
+		return err
+	}
+
+	var records []gossipRecord
+	sent := make(map[string]bool)
+	for _, record := range request.Records {
+		relay := peer.ID(record.Relay)
+		for _, equivocation := range h.observeRecord(relay, record.Record) {
+			ours := equivocation.Records[0]
+			if sent[string(ours)] {
+				continue
+			}
+
+			sent[string(ours)] = true
+			records = append(records, gossipRecord{Relay: record.Relay, Record: ours})
+		}
+	}
+
+	return json.NewEncoder(stream).Encode(&gossipMessage{Records: records})
+}
+
+// gossipKeys gossips with random neighbors every interval until the context
+// is done.
+func (h *Host) gossipKeys(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		h.keyObserver.Prune(time.Now().Add(-DefaultKeyMaxAge))
+
+		neighbors := h.Network().Peers()
+		newCryptoRand().Shuffle(len(neighbors), func(i, j int) {
+			neighbors[i], neighbors[j] = neighbors[j], neighbors[i]
+		})
+
+		if len(neighbors) > DefaultGossipPeers {
+			neighbors = neighbors[:DefaultGossipPeers]
+		}
+
+		for _, neighbor := range neighbors {
+			gossipCtx, cancel := context.WithTimeout(ctx, DefaultKeyExchangeTimeout)
+			err := h.Gossip(gossipCtx, neighbor)
+			cancel()
+
+			if err != nil {
+				log.Debugf("Could not gossip with %s: %s", neighbor.Pretty(), err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	ropts "gx/ipfs/QmTiRqrF5zkdZyrdsL5qndG1UbeWi8k8N2pYxCtXWrahR2/go-libp2p-routing/options"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// countingDHT counts reads.
+type countingDHT struct {
+	*echalottetesting.InMemoryDHT
+
+	lock  sync.Mutex
+	reads int
+}
+
+func (dht *countingDHT) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	dht.lock.Lock()
+	dht.reads++
+	dht.lock.Unlock()
+
+	return dht.InMemoryDHT.GetValue(ctx, key, opts...)
+}
+
+func (dht *countingDHT) Reads() int {
+	dht.lock.Lock()
+	defer dht.lock.Unlock()
+	return dht.reads
+}
+
+// equivocatingRecords creates two records signed by the same relay with
+// different keys for the same epoch.
+func equivocatingRecords(t *testing.T) (crypto.PrivKey, peer.ID, [2][]byte) {
+	sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	relay, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	createdAt := ptypes.TimestampNow()
+	notBefore, _ := ptypes.TimestampProto(time.Now().Add(-time.Hour))
+	notAfter, _ := ptypes.TimestampProto(time.Now().Add(time.Hour))
+
+	var records [2][]byte
+	for i := range records {
+		epochKey := make([]byte, 32)
+		_, err := crand.Read(epochKey)
+		require.NoError(t, err)
+
+		records[i] = signKeyRecord(t, sk, &pb.PublicKey{
+			Type:      pb.KeyType_Curve25519,
+			CreatedAt: createdAt,
+			Data:      make([]byte, 32),
+			EpochKeys: []*pb.EpochKey{{
+				Epoch:     42,
+				NotBefore: notBefore,
+				NotAfter:  notAfter,
+				Data:      epochKey,
+			}},
+		})
+	}
+
+	return sk, relay, records
+}
+
+func TestKeyConsistency(t *testing.T) {
+	t.Run("KeyConsistencyGossip()", func(t *testing.T) {
+		options := &echalotte.HostOptions{}
+		assert.EqualError(t, options.Apply(echalotte.KeyConsistencyGossip(0, nil)), echalotte.ErrInvalidGossipInterval)
+		assert.NoError(t, options.Apply(echalotte.KeyConsistencyGossip(time.Minute, nil)))
+		assert.Equal(t, time.Minute, options.GossipInterval)
+	})
+
+	t.Run("detects equivocation", func(t *testing.T) {
+		_, relay, records := equivocatingRecords(t)
+		observer := echalotte.NewKeyObserver()
+
+		equivocations, err := observer.Observe(relay, records[0])
+		require.NoError(t, err)
+		assert.Empty(t, equivocations)
+
+		equivocations, err = observer.Observe(relay, records[0])
+		require.NoError(t, err)
+		assert.Empty(t, equivocations)
+
+		_, err = observer.Observe(peer.ID("mallory"), records[1])
+		assert.Error(t, err)
+
+		equivocations, err = observer.Observe(relay, records[1])
+		require.NoError(t, err)
+		require.Len(t, equivocations, 1)
+		assert.Equal(t, relay, equivocations[0].Relay)
+		assert.Equal(t, uint64(42), equivocations[0].Epoch)
+		assert.False(t, equivocations[0].LongTerm)
+		assert.Equal(t, records, equivocations[0].Records)
+		assert.Equal(t, equivocations, observer.Equivocations())
+
+		observer.Prune(time.Now().Add(time.Minute))
+
+		equivocations, err = observer.Observe(relay, records[1])
+		require.NoError(t, err)
+		assert.Empty(t, equivocations)
+	})
+
+	t.Run("compares long-term keys over validity windows", func(t *testing.T) {
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+		relay, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		now := time.Now()
+		timestamp := func(d time.Duration) *ptypes.Timestamp {
+			ts, err := ptypes.TimestampProto(now.Add(d))
+			require.NoError(t, err)
+			return ts
+		}
+
+		var keys [4][32]byte
+		for i := range keys {
+			_, err := crand.Read(keys[i][:])
+			require.NoError(t, err)
+		}
+
+		record := func(key [32]byte, createdAt, notBefore, notAfter time.Duration) *pb.PublicKey {
+			return &pb.PublicKey{
+				Type:      pb.KeyType_Curve25519,
+				CreatedAt: timestamp(createdAt),
+				Data:      key[:],
+				NotBefore: timestamp(notBefore),
+				NotAfter:  timestamp(notAfter),
+			}
+		}
+
+		observe := func(observer *echalotte.KeyObserver, publicKey *pb.PublicKey) []echalotte.Equivocation {
+			equivocations, err := observer.Observe(relay, signKeyRecord(t, sk, publicKey))
+			require.NoError(t, err)
+			return equivocations
+		}
+
+		observer := echalotte.NewKeyObserver()
+		assert.Empty(t, observe(observer, record(keys[0], -time.Hour, -time.Hour, time.Hour)))
+
+		// Republishing the same key isn't an equivocation.
+		assert.Empty(t, observe(observer, record(keys[0], -time.Minute, -time.Minute, time.Hour)))
+
+		// Neither is a different key once the previous records expired.
+		assert.Empty(t, observe(observer, record(keys[1], 0, time.Hour, 2*time.Hour)))
+
+		equivocations := observe(observer, record(keys[2], 0, 0, time.Hour))
+		require.Len(t, equivocations, 2)
+		for _, equivocation := range equivocations {
+			assert.True(t, equivocation.LongTerm)
+			assert.Equal(t, relay, equivocation.Relay)
+		}
+
+		// Keys announced in advance replace the current key.
+		observer = echalotte.NewKeyObserver()
+		announcing := record(keys[0], -time.Hour, -time.Hour, time.Hour)
+		announcing.Next = &pb.NextKey{
+			Data:      keys[1][:],
+			NotBefore: timestamp(30 * time.Minute),
+			NotAfter:  timestamp(2 * time.Hour),
+		}
+
+		assert.Empty(t, observe(observer, announcing))
+		assert.Empty(t, observe(observer, record(keys[1], 0, 30*time.Minute, 2*time.Hour)))
+		assert.NotEmpty(t, observe(observer, record(keys[2], 0, 0, 40*time.Minute)))
+
+		// Revoked keys are legitimately replaced.
+		observer = echalotte.NewKeyObserver()
+		assert.Empty(t, observe(observer, record(keys[0], -time.Hour, -time.Hour, time.Hour)))
+
+		revocation, err := echalotte.PublicKeyValidator{}.CreateRevocation(sk, &keys[0], "compromised")
+		require.NoError(t, err)
+
+		revoking := record(keys[3], 0, 0, time.Hour)
+		revoking.Revocations = []*pb.KeyRevocation{revocation}
+		assert.Empty(t, observe(observer, revoking))
+	})
+
+	t.Run("gossips with neighbors", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sk, relay, records := equivocatingRecords(t)
+		pkv := echalotte.PublicKeyValidator{}
+
+		// The relay serves a different record to each host.
+		var hosts []*echalotte.Host
+		for _, record := range records {
+			dht := echalottetesting.NewInMemoryDHT()
+			require.NoError(t, dht.PutValue(ctx, pkv.CreateKey(relay), record))
+
+			h, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				dht,
+				echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay}, echalotte.CircuitSize(1)),
+				echalotte.KeyConsistencyGossip(time.Hour, nil),
+			)
+			require.NoError(t, err)
+
+			// The relay is unreachable, but its key is observed.
+			assert.Error(t, h.SendMessage(ctx, peer.ID("alice"), []byte("Les amants se jettent dans les bras")))
+			hosts = append(hosts, h)
+		}
+
+		reputation, err := echalotte.NewReputation()
+		require.NoError(t, err)
+
+		detected := make(chan echalotte.Equivocation, 1)
+		dht := echalottetesting.NewInMemoryDHT()
+		require.NoError(t, dht.PutValue(ctx, pkv.CreateKey(relay), records[0]))
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay}, echalotte.CircuitSize(1)),
+			echalotte.RelayReputation(reputation),
+			echalotte.KeyConsistencyGossip(time.Hour, func(e echalotte.Equivocation) { detected <- e }),
+		)
+		require.NoError(t, err)
+		assert.Error(t, h.SendMessage(ctx, peer.ID("alice"), []byte("Des amours qui n'ont plus de mots")))
+
+		// Gossiped records are picked among known peers, not only among the
+		// relays we used.
+		h.Peerstore().AddPubKey(relay, sk.GetPublic())
+
+		// A neighbor that saw the same record doesn't report anything.
+		h.Peerstore().AddAddrs(hosts[0].ID(), hosts[0].Addrs(), peerstore.AddressTTL)
+		require.NoError(t, h.Gossip(ctx, hosts[0].ID()))
+		assert.False(t, reputation.Blacklisted(relay))
+
+		h.Peerstore().AddAddrs(hosts[1].ID(), hosts[1].Addrs(), peerstore.AddressTTL)
+		require.NoError(t, h.Gossip(ctx, hosts[1].ID()))
+		assert.True(t, reputation.Blacklisted(relay))
+
+		select {
+		case e := <-detected:
+			assert.Equal(t, relay, e.Relay)
+			assert.Equal(t, records, e.Records)
+		default:
+			assert.Fail(t, "equivocation not reported")
+		}
+	})
+
+	t.Run("limits gossip lookups", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := &countingDHT{InMemoryDHT: echalottetesting.NewInMemoryDHT()}
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeyCache(cache),
+			echalotte.KeyConsistencyGossip(time.Hour, nil),
+		)
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		for i := 0; i < 2*echalotte.DefaultGossipRecords; i++ {
+			peerID, record := randomKeyRecord(t)
+			require.NoError(t, dht.PutValue(ctx, pkv.CreateKey(peerID), record))
+			h.Peerstore().AddAddr(peerID, h.Addrs()[0], peerstore.AddressTTL)
+
+			// Peers that aren't known relays are never looked up.
+			if i%2 == 0 {
+				require.NoError(t, h.Peerstore().AddProtocols(peerID, string(echalotte.RelayProtocolID)))
+			}
+		}
+
+		reads := dht.Reads()
+		cached := cache.Len()
+
+		// The gossip fails since the neighbor doesn't exist, but records are
+		// fetched first.
+		assert.Error(t, h.Gossip(ctx, peer.ID("bob")))
+		assert.Equal(t, 2, dht.Reads()-reads)
+		assert.Equal(t, cached, cache.Len())
+	})
+
+	t.Run("ignores oversized requests", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		neighbor, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.KeyConsistencyGossip(time.Hour, nil),
+		)
+		require.NoError(t, err)
+
+		h := echalottetesting.RandomHost(ctx, t)
+		h.Peerstore().AddAddrs(neighbor.ID(), neighbor.Addrs(), peerstore.AddressTTL)
+
+		_, _, records := equivocatingRecords(t)
+		var request []map[string][]byte
+		for i := 0; i <= 4*echalotte.DefaultGossipRecords; i++ {
+			request = append(request, map[string][]byte{"relay": []byte(neighbor.ID()), "record": records[0]})
+		}
+
+		stream, err := h.NewStream(ctx, neighbor.ID(), echalotte.GossipProtocolID)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		require.NoError(t, json.NewEncoder(stream).Encode(map[string]interface{}{"records": request}))
+
+		var response map[string]interface{}
+		assert.Error(t, json.NewDecoder(stream).Decode(&response))
+	})
+}
@@ -47,7 +47,7 @@ func main() {
 
 	// The DHT will initialize when we bootstrap the host.
 	// It is used for peer discovery internally by our host.
-	dhtValidator := &echalotte.PublicKeyValidator{}
+	relayValidator := echalotte.RelayRecordValidator{}
 	var kadDHT *dht.IpfsDHT
 
 	options := []libp2p.Option{
@@ -60,7 +60,11 @@ func main() {
 			// We can't just make a new DHT client because we want each peer to
 			// maintain its own local copy of the DHT, so that the bootstrapping node
 			// of the DHT can go down without inhibitting future peer discovery.
-			kadDHT, err = dht.New(ctx, h, dhtopts.Validator(dhtValidator))
+			kadDHT, err = dht.New(ctx, h,
+				dhtopts.NamespacedValidator(echalotte.EncryptionNamespace, echalotte.PublicKeyValidator{}),
+				dhtopts.NamespacedValidator(echalotte.RelayNamespace, relayValidator),
+				dhtopts.NamespacedValidator(echalotte.DescriptorNamespace, echalotte.DescriptorValidator{}),
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -96,6 +100,7 @@ func main() {
 		ctx,
 		discovery.NewRoutingDiscovery(kadDHT),
 		echalotte.CircuitSize(2),
+		echalotte.CircuitFilter(echalotte.RelayProofFilter(kadDHT, relayValidator)),
 	)
 	if err != nil {
 		log.Error(err)
@@ -103,8 +108,18 @@ func main() {
 	}
 	log.Info("Circuit builder ready.")
 
+	// Keep a few circuits ready to avoid paying the circuit building latency
+	// on every message.
+	circuitPool, err := echalotte.NewCircuitPool(ctx, circuitBuilder)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
 	log.Info("Connecting to echalotte network...")
-	eh, err := echalotte.Connect(ctx, host, kadDHT, circuitBuilder)
+	eh, err := echalotte.Connect(ctx, host, kadDHT, circuitPool,
+		echalotte.PublishRelayRecord(echalotte.DefaultRelayDifficulty),
+	)
 	if err != nil {
 		log.Error(err)
 		return
@@ -4,6 +4,7 @@ import (
 	"context"
 	crand "crypto/rand"
 	"encoding/json"
+	"sync"
 	"time"
 
 	pb "github.com/t-bast/go-libp2p-echalotte/pb"
@@ -15,7 +16,7 @@ import (
 	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
 	"gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
 	"gx/ipfs/QmaoXrM4Z41PD48JY36YqQGKQpLGjyLA2cKcLsES7YddAq/go-libp2p-host"
-	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
 )
 
 const (
@@ -29,14 +30,143 @@ const (
 // Errors used by the host.
 const (
 	ErrInvalidEncryptionKey = "invalid key: not a curve25519 key"
+	ErrInvalidRoles         = "exit hosts must also be relays"
+	ErrNotRelay             = "host does not relay messages"
 )
 
+// EncryptionKeyProvider is implemented by circuit builders that already know
+// the encryption keys of the relays they select.
+// These keys are only used for relays that didn't publish a key record.
+type EncryptionKeyProvider interface {
+	PeerEncryptionKey(peer.ID) (*[32]byte, bool)
+}
+
 // DHT interface needed to advertise encryption keys in the network.
 type DHT interface {
 	PutValue(context.Context, string, []byte, ...ropts.Option) error
 	GetValue(context.Context, string, ...ropts.Option) ([]byte, error)
 }
 
+// HostOption is a single host option.
+type HostOption func(opts *HostOptions) error
+
+// HostOptions is a set of host options.
+type HostOptions struct {
+	Roles       Roles
+	Reputation  *Reputation
+	RelayRecord *RelayRecordValidator
+
+	Descriptor       *pb.RelayDescriptor
+	CheckDescriptors bool
+
+	KeyRotation *KeyRotation
+	KeyEpochs   *KeyEpochs
+	Keystore    Keystore
+	KeyCache    *KeyCache
+
+	KeyResolutionTimeout time.Duration
+	RelayReplacements    int
+	DirectKeyExchange    bool
+	DerivedKeys          bool
+	HybridKeys           bool
+
+	KeyLog         KeyTransparencyLog
+	KeyLogVerifier *KeyLogVerifier
+
+	GossipInterval time.Duration
+	OnEquivocation func(Equivocation)
+
+	BlindingPeriod time.Duration
+
+	MaxMixingDelay  time.Duration
+	MixingQueueSize int
+}
+
+// Apply the given options to this HostOptions.
+func (opts *HostOptions) Apply(options ...HostOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HostRoles is an option to choose the roles of the host.
+// Only relays advertise themselves through the circuit builder.
+func HostRoles(roles Roles) HostOption {
+	return func(opts *HostOptions) error {
+		if roles.Has(RoleExit) && !roles.Has(RoleRelay) {
+			return errors.New(ErrInvalidRoles)
+		}
+
+		opts.Roles = roles
+		return nil
+	}
+}
+
+// RelayReputation is an option to record the outcome of our interactions
+// with relays.
+// Use the same Reputation with CircuitReputation to avoid misbehaving relays.
+func RelayReputation(reputation *Reputation) HostOption {
+	return func(opts *HostOptions) error {
+		opts.Reputation = reputation
+		return nil
+	}
+}
+
+// PublishRelayRecord is an option to publish a relay record containing a
+// proof-of-work with the given difficulty every relay epoch.
+// The host then only sends messages through relays that publish such records
+// too, and circuit builders using RelayProofFilter will ignore relays that
+// don't.
+func PublishRelayRecord(difficulty int) HostOption {
+	return func(opts *HostOptions) error {
+		opts.RelayRecord = &RelayRecordValidator{Difficulty: difficulty}
+		return nil
+	}
+}
+
+// PublishRelayDescriptor is an option to publish a signed descriptor of the
+// host's capabilities.
+// The supported protocols and roles are filled in automatically.
+// Family lists the other relays run by the same operator: they should not be
+// used in the same circuit.
+func PublishRelayDescriptor(bandwidth pb.BandwidthClass, family ...peer.ID) HostOption {
+	return func(opts *HostOptions) error {
+		descriptor := &pb.RelayDescriptor{Bandwidth: bandwidth}
+		for _, p := range family {
+			descriptor.Family = append(descriptor.Family, []byte(p))
+		}
+
+		opts.Descriptor = descriptor
+		return nil
+	}
+}
+
+// CheckRelayDescriptors is an option to verify that every relay of a circuit
+// supports our protocol version before sending a message.
+// Circuits containing relays of the same family are rejected, and the last
+// hop is always an exit (see RoleExit).
+func CheckRelayDescriptors() HostOption {
+	return func(opts *HostOptions) error {
+		opts.CheckDescriptors = true
+		return nil
+	}
+}
+
+// EncryptionKeyCache is an option to use the given cache for peer encryption
+// keys, for example to share it between hosts.
+// The caller is responsible for running the cache's background refresh.
+// By default, each host creates its own cache.
+func EncryptionKeyCache(cache *KeyCache) HostOption {
+	return func(opts *HostOptions) error {
+		opts.KeyCache = cache
+		return nil
+	}
+}
+
 // Host wraps a standard host with onion routing capabilities.
 type Host struct {
 	host.Host
@@ -44,20 +174,118 @@ type Host struct {
 	dht            DHT
 	circuitBuilder CircuitBuilder
 	validator      *PublicKeyValidator
+	reputation     *Reputation
+	relayRecord    *RelayRecordValidator
+
+	descriptor           *pb.RelayDescriptor
+	verifyDescriptors    bool
+	keyResolutionTimeout time.Duration
+	relayReplacements    int
+	directKeyExchange    bool
+	derivedKeys          bool
+	hybridEncryption     bool
+
+	keyRotation *KeyRotation
+	keyEpochs   *KeyEpochs
+	keystore    Keystore
+	keyCache    *KeyCache
+	keysLock    sync.Mutex
+	retiredKeys []retiredKey
+	nextKey     *nextKey
+	rotateAt    time.Time
+	revocations []*pb.KeyRevocation
+	epochKeys   []epochKey
+	hybridKey   *hybridKey
+
+	keyLog         KeyTransparencyLog
+	keyLogVerifier *KeyLogVerifier
+	keyObserver    *KeyObserver
+	onEquivocation func(Equivocation)
+	keyRecord      []byte
+
+	rolesLock    sync.RWMutex
+	roles        Roles
+	rolesChanged chan struct{}
+
+	mixQueue *delayQueue
 }
 
 // Connect to the echalotte network.
 // This will block until enough peers have been discovered.
 // It then returns a super-powered host instance that can use onion routing.
-func Connect(ctx context.Context, host host.Host, dht DHT, cb CircuitBuilder) (*Host, error) {
+func Connect(ctx context.Context, host host.Host, dht DHT, cb CircuitBuilder, opts ...HostOption) (*Host, error) {
+	options := &HostOptions{
+		Roles:                DefaultRoles,
+		KeyResolutionTimeout: DefaultKeyResolutionTimeout,
+		MaxMixingDelay:       DefaultMaxMixingDelay,
+		MixingQueueSize:      DefaultMixingQueueSize,
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DerivedKeys && (options.KeyEpochs != nil || options.HybridKeys) {
+		return nil, errors.New(ErrDerivedKeysConflict)
+	}
+
 	h := &Host{
 		Host:           host,
 		dht:            dht,
 		circuitBuilder: cb,
-		validator:      &PublicKeyValidator{},
+		validator:      &PublicKeyValidator{BlindingPeriod: options.BlindingPeriod},
+		reputation:     options.Reputation,
+		relayRecord:    options.RelayRecord,
+		roles:          options.Roles,
+		rolesChanged:   make(chan struct{}, 1),
+
+		descriptor:        options.Descriptor,
+		verifyDescriptors: options.CheckDescriptors,
+		keyRotation:       options.KeyRotation,
+		keyEpochs:         options.KeyEpochs,
+		keystore:          options.Keystore,
+		keyCache:          options.KeyCache,
+
+		keyResolutionTimeout: options.KeyResolutionTimeout,
+		relayReplacements:    options.RelayReplacements,
+		directKeyExchange:    options.DirectKeyExchange,
+		derivedKeys:          options.DerivedKeys,
+		hybridEncryption:     options.HybridKeys,
+
+		keyLog:         options.KeyLog,
+		keyLogVerifier: options.KeyLogVerifier,
+		onEquivocation: options.OnEquivocation,
+
+		mixQueue: newDelayQueue(options.MaxMixingDelay, options.MixingQueueSize),
 	}
 
-	_, err := h.DecryptionKey()
+	if h.keyCache == nil {
+		var cacheOpts []KeyCacheOption
+		if options.BlindingPeriod != 0 {
+			cacheOpts = append(cacheOpts, KeyCacheBlindingPeriod(options.BlindingPeriod))
+		}
+
+		h.keyCache, err = NewKeyCache(dht, cacheOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		go h.keyCache.Run(ctx, DefaultKeyCacheRefreshInterval)
+	}
+
+	h.hybridKey, err = h.newHybridKeyFor()
+	if err != nil {
+		return nil, err
+	}
+
+	if h.keystore != nil {
+		err = h.loadEncryptionKeys(ctx)
+		if err != nil && err.Error() != ErrKeysNotFound {
+			return nil, err
+		}
+	}
+
+	_, err = h.DecryptionKey()
 	if err != nil {
 		err = h.registerEncryptionKey(ctx)
 		if err != nil {
@@ -65,17 +293,72 @@ func Connect(ctx context.Context, host host.Host, dht DHT, cb CircuitBuilder) (*
 		}
 	}
 
-	h.SetStreamHandler(ProtocolID, func(stream inet.Stream) {
-		ctx := context.Background()
-		err := h.HandleMessage(ctx, stream)
+	if h.keyEpochs != nil {
+		err = h.publishEpochKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		go h.runKeyEpochs(ctx)
+	}
+
+	if options.RelayRecord != nil {
+		go h.publishRelayRecords(ctx, *options.RelayRecord)
+	}
+
+	if options.KeyRotation != nil {
+		go h.rotateEncryptionKeys(ctx)
+	}
+
+	go h.republishEncryptionKeys(ctx)
+
+	if options.Descriptor != nil {
+		go h.publishDescriptors(ctx, *h.descriptor)
+	}
+
+	if options.BlindingPeriod != 0 {
+		go h.publishBlindedKeys(ctx)
+	}
+
+	go h.mixQueue.run(ctx, func(message *OnionMessage) {
+		err := h.forwardMessage(context.Background(), message)
 		if err != nil {
-			log.Errorf("Message error: %s", err.Error())
+			log.Errorf("Could not forward message: %s", err.Error())
 		}
 	})
 
+	h.SetStreamHandler(ProtocolID, h.handleMessageStream)
+	h.setRelayProtocol(h.roles)
+
+	h.SetStreamHandler(KeysProtocolID, func(stream inet.Stream) {
+		err := h.handleKeyRequest(stream)
+		if err != nil {
+			log.Errorf("Key request error: %s", err.Error())
+		}
+	})
+
+	if options.GossipInterval > 0 {
+		h.keyObserver = NewKeyObserver()
+
+		h.SetStreamHandler(GossipProtocolID, func(stream inet.Stream) {
+			err := h.handleGossip(stream)
+			if err != nil {
+				log.Errorf("Gossip error: %s", err.Error())
+			}
+		})
+
+		go h.gossipKeys(ctx, options.GossipInterval)
+	}
+
+	if pool, ok := cb.(*CircuitPool); ok {
+		pool.Exclude(h.ID())
+	}
+
+	go h.advertiseRelay(ctx)
+
 	// Test the network readiness by generating a sample circuit.
 	for {
-		_, err = cb.Build(ctx)
+		_, err = cb.Build(ctx, CircuitExclude(h.ID()))
 		if err == nil {
 			break
 		}
@@ -128,37 +411,150 @@ func (h *Host) registerEncryptionKey(ctx context.Context) error {
 		return errors.WithStack(err)
 	}
 
-	err = h.Peerstore().Put(h.ID(), privateKeyStoreKey, encryptionPrivateKey)
+	err = h.storeEncryptionKey(encryptionPublicKey, encryptionPrivateKey)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
-	err = h.Peerstore().Put(h.ID(), publicKeyStoreKey, encryptionPublicKey)
+	err = h.saveEncryptionKeys()
+	if err != nil {
+		return err
+	}
+
+	err = h.publishEncryptionKey(ctx, encryptionPublicKey)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Encryption key registered")
+
+	return nil
+}
+
+// storeEncryptionKey makes the given key pair the host's current encryption
+// key.
+func (h *Host) storeEncryptionKey(encryptionPublicKey, encryptionPrivateKey *[32]byte) error {
+	err := h.Peerstore().Put(h.ID(), privateKeyStoreKey, encryptionPrivateKey)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	dhtRecord, err := h.validator.CreateRecord(h.Peerstore().PrivKey(h.ID()), encryptionPublicKey)
+	err = h.Peerstore().Put(h.ID(), publicKeyStoreKey, encryptionPublicKey)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = h.dht.PutValue(ctx, h.validator.CreateKey(h.ID()), dhtRecord)
+	return nil
+}
+
+// publishEncryptionKey publishes the given encryption key to the DHT (and
+// to the key transparency log, if any), along with the revocations of
+// previous keys and the epoch keys.
+// When keys are rotated, the record is only valid until the next key
+// replaces the current one, and announces the next key if any.
+func (h *Host) publishEncryptionKey(ctx context.Context, encryptionPublicKey *[32]byte) error {
+	publicKey := &pb.PublicKey{
+		Type:      pb.KeyType_Curve25519,
+		CreatedAt: ptypes.TimestampNow(),
+		Data:      encryptionPublicKey[:],
+	}
+
+	h.keysLock.Lock()
+	if h.keyRotation != nil {
+		now := time.Now()
+		if h.rotateAt.IsZero() {
+			h.rotateAt = now.Add(h.keyRotation.Period)
+		}
+
+		// The key is replaced once the next key is announced at rotateAt and
+		// the overlap has elapsed.
+		notAfter := h.rotateAt.Add(h.keyRotation.Overlap)
+		if h.nextKey != nil {
+			notAfter = h.nextKey.notBefore.Add(h.keyRotation.Overlap)
+		}
+
+		publicKey.NotBefore, _ = ptypes.TimestampProto(now)
+		publicKey.NotAfter, _ = ptypes.TimestampProto(notAfter)
+		publicKey.Next = h.nextKeyProto()
+	}
+
+	publicKey.Revocations = append([]*pb.KeyRevocation(nil), h.revocations...)
+	publicKey.EpochKeys = h.epochKeysProto()
+	publicKey.EpochKeysOnly = h.keyEpochs != nil
+	publicKey.DerivedKey = h.derivedKeys
+	if h.hybridKey != nil {
+		publicKey.Hybrid = &pb.HybridKey{
+			Type:    pb.KeyType_X25519MLKEM768,
+			KemData: h.hybridKey.public,
+		}
+	}
+	h.keysLock.Unlock()
+
+	dhtRecord, err := h.validator.createRecord(h.Peerstore().PrivKey(h.ID()), publicKey)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	log.Info("Encryption key registered")
+	h.keysLock.Lock()
+	h.keyRecord = dhtRecord
+	h.keysLock.Unlock()
+
+	for _, key := range h.validator.publicationKeys(h.ID(), time.Now()) {
+		err = h.dht.PutValue(ctx, key, dhtRecord)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if h.keyLog != nil {
+		err = h.keyLog.Append(ctx, h.ID(), dhtRecord)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// Descriptors carry the validity window of the current key.
+	if h.descriptor != nil && h.Roles().Has(RoleRelay) {
+		h.publishDescriptor(ctx, *h.descriptor)
+	}
 
 	return nil
 }
 
+// republishEncryptionKeys republishes the current encryption key before its
+// record expires or is dropped by DHT nodes, until the context is done.
+// Keys are republished even when they are rotated, since rotation periods
+// may be longer than DHTRecordMaxAge.
+func (h *Host) republishEncryptionKeys(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.validator.republishInterval()):
+		}
+
+		publicKey, err := h.EncryptionKey()
+		if err == nil {
+			err = h.publishEncryptionKey(ctx, publicKey)
+		}
+		if err != nil {
+			log.Errorf("Could not republish encryption key: %s", err.Error())
+		}
+	}
+}
+
 // SendMessage sends a private message to the given peer.
 // It leverages onion routing through the echalotte network.
-func (h *Host) SendMessage(ctx context.Context, to peer.ID, message []byte) error {
-	circuit, err := h.circuitBuilder.Build(ctx)
+func (h *Host) SendMessage(ctx context.Context, to peer.ID, message []byte, opts ...SendOption) error {
+	options := &SendOptions{}
+	err := options.Apply(opts...)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+
+	circuit, keys, err := h.buildCircuit(ctx, h.ID(), to)
+	if err != nil {
+		return err
 	}
 
 	m, err := NewMessage(h.ID(), h.Peerstore().PrivKey(h.ID()), message)
@@ -166,13 +562,20 @@ func (h *Host) SendMessage(ctx context.Context, to peer.ID, message []byte) erro
 		return errors.WithStack(err)
 	}
 
+	// Hybrid encryption is only used if every relay supports it.
+	hybridKeys, hybrid := h.hybridKeys(circuit)
+
 	for _, relay := range circuit {
-		key, err := h.peerEncryptionKey(ctx, relay)
-		if err != nil {
-			return errors.Wrapf(err, "could not get encryption key for %s", relay.Pretty())
+		// The delay is read by the relay that decrypts the layer.
+		if !m.IsLastHop() {
+			m.Delay = mixingDelay(options.MixingDelay)
 		}
 
-		m, err = m.Encapsulate(relay, key)
+		if hybrid {
+			m, err = m.EncapsulateHybrid(relay, keys[relay], hybridKeys[relay])
+		} else {
+			m, err = m.Encapsulate(relay, keys[relay])
+		}
 		if err != nil {
 			return errors.Wrapf(err, "could not encapsulate to peer %s", relay.Pretty())
 		}
@@ -180,7 +583,9 @@ func (h *Host) SendMessage(ctx context.Context, to peer.ID, message []byte) erro
 
 	firstHop := circuit[len(circuit)-1]
 	stream, err := h.NewStream(ctx, firstHop, ProtocolID)
+	h.recordOutcome(firstHop, err)
 	if err != nil {
+		h.discardCircuit(circuit)
 		return errors.WithStack(err)
 	}
 	defer stream.Close()
@@ -189,22 +594,92 @@ func (h *Host) SendMessage(ctx context.Context, to peer.ID, message []byte) erro
 	return enc.Encode(m)
 }
 
-func (h *Host) peerEncryptionKey(ctx context.Context, peerID peer.ID) (*[32]byte, error) {
-	record, err := h.dht.GetValue(ctx, h.validator.CreateKey(peerID))
+// discardCircuit notifies the circuit builder that a circuit failed, if it
+// keeps circuits around (see CircuitPool).
+func (h *Host) discardCircuit(circuit Circuit) {
+	if pool, ok := h.circuitBuilder.(*CircuitPool); ok {
+		pool.Discard(circuit)
+	}
+}
+
+// recordOutcome of a direct interaction with a relay, if reputation tracking
+// is enabled.
+func (h *Host) recordOutcome(relay peer.ID, err error) {
+	if h.reputation == nil {
+		return
+	}
+
 	if err != nil {
-		return nil, errors.WithStack(err)
+		h.reputation.Record(relay, OutcomeFailure)
+	} else {
+		h.reputation.Record(relay, OutcomeSuccess)
 	}
+}
 
-	var peerKey pb.PublicKey
-	err = proto.Unmarshal(record, &peerKey)
+func (h *Host) peerEncryptionKey(ctx context.Context, peerID peer.ID) (*[32]byte, error) {
+	key, record, err := h.fetchEncryptionKey(ctx, peerID)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		// Circuit builders only know long-term keys, that bypass the checks
+		// of signed records: they are only used when the peer has none.
+		if errors.Cause(err).Error() == ErrNoKeyRecord {
+			if provider, ok := h.circuitBuilder.(EncryptionKeyProvider); ok {
+				if key, ok := provider.PeerEncryptionKey(peerID); ok {
+					return key, nil
+				}
+			}
+		}
+
+		return nil, err
+	}
+
+	if h.keyLogVerifier != nil {
+		err = h.verifyLoggedKey(ctx, peerID, record)
+
+		// The peer may have published a newer record since we cached it.
+		if err != nil && err.Error() == ErrStaleLoggedKey {
+			key, record, err = h.fetchEncryptionKey(ctx, peerID)
+			if err == nil {
+				err = h.verifyLoggedKey(ctx, peerID, record)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var pubKey [32]byte
-	copy(pubKey[:], peerKey.Data)
+	if h.keyObserver != nil {
+		h.observeRecord(peerID, record)
+	}
 
-	return &pubKey, nil
+	if h.derivedKeys && h.keyCache.DerivedKey(peerID) {
+		derived, err := h.derivedEncryptionKey(peerID)
+		if err == nil {
+			return derived, nil
+		}
+
+		log.Debugf("Could not derive encryption key of %s: %s", peerID.Pretty(), err.Error())
+	}
+
+	return key, nil
+}
+
+// fetchEncryptionKey returns a peer's encryption key and its record, either
+// directly from the peer or from the DHT.
+func (h *Host) fetchEncryptionKey(ctx context.Context, peerID peer.ID) (*[32]byte, []byte, error) {
+	if h.directKeyExchange {
+		return h.directEncryptionKey(ctx, peerID)
+	}
+
+	return h.keyCache.GetRecord(ctx, peerID)
+}
+
+// handleMessageStream handles onion messages sent on ProtocolID or
+// RelayProtocolID.
+func (h *Host) handleMessageStream(stream inet.Stream) {
+	err := h.HandleMessage(context.Background(), stream)
+	if err != nil {
+		log.Errorf("Message error: %s", err.Error())
+	}
 }
 
 // HandleMessage receives an onion message and forwards it.
@@ -225,22 +700,46 @@ func (h *Host) HandleMessage(ctx context.Context, stream inet.Stream) error {
 		return errors.WithStack(err)
 	}
 
-	decryptionKey, err := h.DecryptionKey()
+	decryptionKeys, err := h.decryptionKeys()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	message, err = message.Decapsulate(h.Peerstore().PrivKey(h.ID()), decryptionKey)
+	// During key rotation, senders may still use our previous key.
+	var decapsulated *OnionMessage
+	for _, decryptionKey := range decryptionKeys {
+		decapsulated, err = h.decapsulate(message, decryptionKey)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil && h.derivedKeys {
+		decapsulated, err = message.DecapsulateDerived(h.Peerstore().PrivKey(h.ID()))
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	message = decapsulated
+
 	if message.IsLastHop() {
 		from, _ := peer.IDFromBytes(message.From)
 		log.Infof("Private message received from %s: %s", from.Pretty(), message.Content)
 		return nil
 	}
 
+	if !h.Roles().Has(RoleRelay) {
+		return errors.New(ErrNotRelay)
+	}
+
+	// The delay must not be visible to the next hop.
+	delay := message.Delay
+	message.Delay = 0
+
+	if delay > 0 {
+		return h.mixQueue.push(message, delay)
+	}
+
 	go func() {
 		err := h.forwardMessage(context.Background(), message)
 		if err != nil {
@@ -255,6 +754,7 @@ func (h *Host) HandleMessage(ctx context.Context, stream inet.Stream) error {
 func (h *Host) forwardMessage(ctx context.Context, message *OnionMessage) error {
 	to, _ := peer.IDFromBytes(message.To)
 	stream, err := h.NewStream(ctx, to, ProtocolID)
+	h.recordOutcome(to, err)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -0,0 +1,342 @@
+package echalotte
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/curve25519"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/hkdf"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/secretbox"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// Errors used by hybrid encryption.
+const (
+	ErrInvalidHybridKey   = "invalid hybrid encryption key"
+	ErrHybridUnsupported  = "hybrid encryption is not supported by this host"
+	ErrUnsupportedKeyType = "unsupported onion layer key type"
+	ErrInvalidHybridLayer = "invalid hybrid onion layer"
+)
+
+const (
+	// hybridSealInfo separates hybrid layer keys from other uses of the
+	// shared secrets.
+	hybridSealInfo = "echalotte/seal/x25519-mlkem768/v1"
+
+	// kemEncapsulationKeySize and kemCiphertextSize are the sizes of
+	// ML-KEM-768 encapsulation keys and ciphertexts.
+	kemEncapsulationKeySize = 1184
+	kemCiphertextSize       = 1088
+
+	// hybridCiphertextOffset is the size of the ephemeral Curve25519 key and
+	// ML-KEM ciphertext that prefix the secretbox.
+	hybridCiphertextOffset = 32 + kemCiphertextSize
+)
+
+// KEMDecapsulationKey is the private half of a post-quantum key, such as an
+// *mlkem.DecapsulationKey768.
+type KEMDecapsulationKey interface {
+	Decapsulate(ciphertext []byte) ([]byte, error)
+}
+
+// hybridKey is an ML-KEM-768 key pair.
+// Each Curve25519 key of the host (current, next, retired and epoch keys)
+// has its own, so that both halves are rotated together.
+type hybridKey struct {
+	seed   []byte
+	public []byte
+	key    KEMDecapsulationKey
+}
+
+// bytes returns the seed of the key, or nil if there is no key.
+func (k *hybridKey) bytes() []byte {
+	if k == nil {
+		return nil
+	}
+
+	return k.seed
+}
+
+// HybridEncryptionKeys is an option to publish a post-quantum ML-KEM-768 key
+// along with the Curve25519 key.
+// Senders combine both keys when every relay of a circuit publishes one, so
+// that recording traffic today doesn't allow decrypting it once quantum
+// computers can break Curve25519. The Curve25519 half keeps layers secure if
+// ML-KEM is broken.
+// It requires Go 1.24 or later.
+func HybridEncryptionKeys() HostOption {
+	return func(opts *HostOptions) error {
+		opts.HybridKeys = true
+		return nil
+	}
+}
+
+// validateHybridKey checks the post-quantum key of a record, if any.
+func validateHybridKey(publicKey *pb.PublicKey) error {
+	if publicKey.Hybrid == nil {
+		return nil
+	}
+
+	if publicKey.Hybrid.Type != pb.KeyType_X25519MLKEM768 {
+		return errors.New(ErrInvalidHybridKey)
+	}
+
+	err := validateKEMKey(publicKey.Hybrid.KemData)
+	if err != nil {
+		return err
+	}
+
+	if publicKey.Next != nil && len(publicKey.Next.KemData) > 0 {
+		err = validateKEMKey(publicKey.Next.KemData)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, epochKey := range publicKey.EpochKeys {
+		if len(epochKey.KemData) > 0 {
+			err = validateKEMKey(epochKey.KemData)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CurrentHybridKey returns the post-quantum key of the record that goes with
+// the key returned by CurrentEpochKey at the given time, if any.
+func CurrentHybridKey(publicKey *pb.PublicKey, t time.Time) ([]byte, bool) {
+	if publicKey.Hybrid == nil {
+		return nil, false
+	}
+
+	if len(publicKey.EpochKeys) == 0 {
+		data := publicKey.Hybrid.KemData
+		if isNextKeyValid(publicKey, t) {
+			data = publicKey.Next.KemData
+		}
+
+		return data, len(data) > 0
+	}
+
+	for _, epochKey := range publicKey.EpochKeys {
+		notBefore, err := ptypes.TimestampFromProto(epochKey.NotBefore)
+		if err != nil {
+			continue
+		}
+
+		notAfter, err := ptypes.TimestampFromProto(epochKey.NotAfter)
+		if err != nil {
+			continue
+		}
+
+		if !t.Before(notBefore) && t.Before(notAfter) {
+			return epochKey.KemData, len(epochKey.KemData) > 0
+		}
+	}
+
+	return nil, false
+}
+
+// EncapsulateHybrid adds another layer of onion encryption using both the
+// recipient's Curve25519 key and its ML-KEM-768 encapsulation key.
+func (l *OnionMessage) EncapsulateHybrid(to peer.ID, publicKey *[32]byte, kemKey []byte) (*OnionMessage, error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrMarshal)
+	}
+
+	ciphertext, err := sealHybrid(publicKey, kemKey, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OnionMessage{
+		To:      []byte(to),
+		KeyType: pb.KeyType_X25519MLKEM768,
+		Content: ciphertext,
+	}, nil
+}
+
+// DecapsulateHybrid decrypts a layer encrypted with EncapsulateHybrid.
+// The first argument is the peer's signing private key.
+// The other arguments are the peer's encryption private keys.
+func (l *OnionMessage) DecapsulateHybrid(signingKey crypto.PrivKey, encryptionPrivKey *[32]byte, kemKey KEMDecapsulationKey) (*OnionMessage, error) {
+	if l.IsLastHop() {
+		return nil, errors.New(ErrDecapsulateLastHop)
+	}
+
+	if l.KeyType != pb.KeyType_X25519MLKEM768 {
+		return nil, errors.New(ErrUnsupportedKeyType)
+	}
+
+	to, err := peer.IDFromBytes(l.To)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !to.MatchesPrivateKey(signingKey) {
+		return nil, errors.New(ErrDecapsulateKey)
+	}
+
+	content, err := openHybrid(encryptionPrivKey, kemKey, l.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var onionContent OnionMessage
+	err = json.Unmarshal(content, &onionContent)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrMarshal)
+	}
+
+	return &onionContent, nil
+}
+
+// hybridSecretKey combines both shared secrets into a secretbox key.
+// The ciphertexts and recipient key are bound to the key, like in X-Wing.
+func hybridSecretKey(kemSecret, dhSecret, kemCiphertext []byte, epk, to *[32]byte) (*[32]byte, error) {
+	info := []byte(hybridSealInfo)
+	info = append(info, kemCiphertext...)
+	info = append(info, epk[:]...)
+	info = append(info, to[:]...)
+
+	var key [32]byte
+	_, err := io.ReadFull(hkdf.New(sha256.New, append(kemSecret, dhSecret...), nil, info), key[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &key, nil
+}
+
+// Seal a message with both a Curve25519 key and an ML-KEM-768 key.
+// The ciphertext contains the ephemeral Curve25519 key, the ML-KEM
+// ciphertext and the secretbox.
+func sealHybrid(to *[32]byte, kemKey []byte, message []byte) ([]byte, error) {
+	kemSecret, kemCiphertext, err := kemEncapsulate(kemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	epk, esk, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var dhSecret [32]byte
+	curve25519.ScalarMult(&dhSecret, esk, to)
+
+	key, err := hybridSecretKey(kemSecret, dhSecret[:], kemCiphertext, epk, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are never reused, so a fixed nonce is safe.
+	var nonce [24]byte
+
+	ciphertext := append(epk[:], kemCiphertext...)
+	ciphertext = secretbox.Seal(ciphertext, message, &nonce, key)
+
+	return ciphertext, nil
+}
+
+// Open a message sealed with sealHybrid.
+func openHybrid(key *[32]byte, kemKey KEMDecapsulationKey, ciphertext []byte) ([]byte, error) {
+	if kemKey == nil {
+		return nil, errors.New(ErrHybridUnsupported)
+	}
+
+	if len(ciphertext) < hybridCiphertextOffset {
+		return nil, errors.New(ErrInvalidHybridLayer)
+	}
+
+	var pubKey [32]byte
+	curve25519.ScalarBaseMult(&pubKey, key)
+
+	var epk [32]byte
+	copy(epk[:], ciphertext[:32])
+	kemCiphertext := ciphertext[32:hybridCiphertextOffset]
+
+	kemSecret, err := kemKey.Decapsulate(kemCiphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrInvalidHybridLayer)
+	}
+
+	var dhSecret [32]byte
+	curve25519.ScalarMult(&dhSecret, key, &epk)
+
+	secretKey, err := hybridSecretKey(kemSecret, dhSecret[:], kemCiphertext, &epk, &pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	decrypted, ok := secretbox.Open(nil, ciphertext[hybridCiphertextOffset:], &nonce, secretKey)
+	if !ok {
+		return nil, errors.New(ErrCouldNotDecrypt)
+	}
+
+	return decrypted, nil
+}
+
+// decapsulate an onion layer with the given decryption key, using the key
+// type chosen by the sender.
+func (h *Host) decapsulate(message *OnionMessage, key decryptionKey) (*OnionMessage, error) {
+	signingKey := h.Peerstore().PrivKey(h.ID())
+
+	switch message.KeyType {
+	case pb.KeyType_Curve25519:
+		return message.Decapsulate(signingKey, key.key)
+	case pb.KeyType_X25519MLKEM768:
+		if key.hybrid == nil {
+			return nil, errors.New(ErrHybridUnsupported)
+		}
+
+		return message.DecapsulateHybrid(signingKey, key.key, key.hybrid.key)
+	default:
+		return nil, errors.New(ErrUnsupportedKeyType)
+	}
+}
+
+// newHybridKeyFor returns a new post-quantum key to pair with a new
+// Curve25519 key, or nil if hybrid keys are disabled.
+func (h *Host) newHybridKeyFor() (*hybridKey, error) {
+	if !h.hybridEncryption {
+		return nil, nil
+	}
+
+	return generateHybridKey()
+}
+
+// hybridKeys returns the post-quantum keys of all the circuit's relays, or
+// false if one of them doesn't support hybrid encryption.
+// Mixing key types in a circuit would make it easier to identify.
+func (h *Host) hybridKeys(circuit Circuit) (map[peer.ID][]byte, bool) {
+	if !kemSupported {
+		return nil, false
+	}
+
+	keys := make(map[peer.ID][]byte)
+	for _, relay := range circuit {
+		key, ok := h.keyCache.HybridKey(relay)
+		if !ok {
+			return nil, false
+		}
+
+		keys[relay] = key
+	}
+
+	return keys, true
+}
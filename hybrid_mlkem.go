@@ -0,0 +1,63 @@
+//go:build go1.24
+// +build go1.24
+
+package echalotte
+
+import (
+	"crypto/mlkem"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+)
+
+// kemSupported is true when the standard library provides ML-KEM.
+const kemSupported = true
+
+// generateHybridKey generates a new ML-KEM-768 key pair.
+func generateHybridKey() (*hybridKey, error) {
+	key, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &hybridKey{
+		seed:   key.Bytes(),
+		public: key.EncapsulationKey().Bytes(),
+		key:    key,
+	}, nil
+}
+
+// newHybridKey restores an ML-KEM-768 key pair from its seed.
+func newHybridKey(seed []byte) (*hybridKey, error) {
+	key, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrInvalidHybridKey)
+	}
+
+	return &hybridKey{
+		seed:   seed,
+		public: key.EncapsulationKey().Bytes(),
+		key:    key,
+	}, nil
+}
+
+// validateKEMKey checks that the data is an ML-KEM-768 encapsulation key.
+func validateKEMKey(data []byte) error {
+	_, err := mlkem.NewEncapsulationKey768(data)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidHybridKey)
+	}
+
+	return nil
+}
+
+// kemEncapsulate generates a shared secret and its ciphertext for the given
+// ML-KEM-768 encapsulation key.
+func kemEncapsulate(data []byte) ([]byte, []byte, error) {
+	ek, err := mlkem.NewEncapsulationKey768(data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, ErrInvalidHybridKey)
+	}
+
+	secret, ciphertext := ek.Encapsulate()
+	return secret, ciphertext, nil
+}
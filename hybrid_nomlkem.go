@@ -0,0 +1,34 @@
+//go:build !go1.24
+// +build !go1.24
+
+package echalotte
+
+import (
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+)
+
+// kemSupported is false before Go 1.24, which added ML-KEM to the standard
+// library: hosts can't use hybrid layers but still relay classic ones for
+// peers that publish hybrid keys.
+const kemSupported = false
+
+func generateHybridKey() (*hybridKey, error) {
+	return nil, errors.New(ErrHybridUnsupported)
+}
+
+func newHybridKey(seed []byte) (*hybridKey, error) {
+	return nil, errors.New(ErrHybridUnsupported)
+}
+
+// validateKEMKey only checks the size of the key.
+func validateKEMKey(data []byte) error {
+	if len(data) != kemEncapsulationKeySize {
+		return errors.New(ErrInvalidHybridKey)
+	}
+
+	return nil
+}
+
+func kemEncapsulate(data []byte) ([]byte, []byte, error) {
+	return nil, nil, errors.New(ErrHybridUnsupported)
+}
@@ -0,0 +1,367 @@
+//go:build go1.24
+// +build go1.24
+
+package echalotte_test
+
+import (
+	"context"
+	"crypto/mlkem"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// hybridMessageTo creates a hybrid onion layer for the given peer.
+func hybridMessageTo(t *testing.T, to peer.ID, key *[32]byte, kemKey []byte) *echalotte.OnionMessage {
+	sk, _, _ := crypto.GenerateEd25519Key(crand.Reader)
+	from, _ := peer.IDFromPrivateKey(sk)
+
+	m, err := echalotte.NewMessage(from, sk, []byte("Vienne la nuit sonne l'heure"))
+	require.NoError(t, err)
+
+	m, err = m.EncapsulateHybrid(to, key, kemKey)
+	require.NoError(t, err)
+
+	return m
+}
+
+func TestHybridEncryption(t *testing.T) {
+	t.Run("Encapsulate() and Decapsulate()", func(t *testing.T) {
+		sk, _, _ := crypto.GenerateEd25519Key(crand.Reader)
+		to, _ := peer.IDFromPrivateKey(sk)
+
+		publicKey, privateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		kemKey, err := mlkem.GenerateKey768()
+		require.NoError(t, err)
+
+		m, err := echalotte.NewMessage(to, sk, []byte("Comme l'eau courante et le vent,"))
+		require.NoError(t, err)
+
+		_, err = m.EncapsulateHybrid(to, publicKey, []byte("not a key"))
+		assert.Error(t, err)
+
+		layer, err := m.EncapsulateHybrid(to, publicKey, kemKey.EncapsulationKey().Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, pb.KeyType_X25519MLKEM768, layer.KeyType)
+
+		_, err = layer.Decapsulate(sk, privateKey)
+		assert.EqualError(t, err, echalotte.ErrUnsupportedKeyType)
+
+		_, err = layer.DecapsulateHybrid(sk, privateKey, nil)
+		assert.EqualError(t, err, echalotte.ErrHybridUnsupported)
+
+		otherKemKey, err := mlkem.GenerateKey768()
+		require.NoError(t, err)
+
+		_, err = layer.DecapsulateHybrid(sk, privateKey, otherKemKey)
+		assert.EqualError(t, err, echalotte.ErrCouldNotDecrypt)
+
+		_, otherPrivateKey, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		_, err = layer.DecapsulateHybrid(sk, otherPrivateKey, kemKey)
+		assert.EqualError(t, err, echalotte.ErrCouldNotDecrypt)
+
+		decapsulated, err := layer.DecapsulateHybrid(sk, privateKey, kemKey)
+		require.NoError(t, err)
+		assert.Equal(t, m, decapsulated)
+	})
+
+	t.Run("validates hybrid keys", func(t *testing.T) {
+		sk, _, _ := crypto.GenerateEd25519Key(crand.Reader)
+		peerID, _ := peer.IDFromPrivateKey(sk)
+
+		pkv := echalotte.PublicKeyValidator{}
+		kemKey, err := mlkem.GenerateKey768()
+		require.NoError(t, err)
+
+		for _, hybrid := range []*pb.HybridKey{
+			{Type: pb.KeyType_Curve25519, KemData: kemKey.EncapsulationKey().Bytes()},
+			{Type: pb.KeyType_X25519MLKEM768, KemData: []byte("not a key")},
+		} {
+			record := signKeyRecord(t, sk, &pb.PublicKey{
+				Type:      pb.KeyType_Curve25519,
+				CreatedAt: ptypes.TimestampNow(),
+				Data:      make([]byte, 32),
+				Hybrid:    hybrid,
+			})
+
+			assert.Error(t, pkv.Validate(pkv.CreateKey(peerID), record))
+		}
+
+		record := signKeyRecord(t, sk, &pb.PublicKey{
+			Type:      pb.KeyType_Curve25519,
+			CreatedAt: ptypes.TimestampNow(),
+			Data:      make([]byte, 32),
+			Hybrid: &pb.HybridKey{
+				Type:    pb.KeyType_X25519MLKEM768,
+				KemData: kemKey.EncapsulationKey().Bytes(),
+			},
+		})
+
+		assert.NoError(t, pkv.Validate(pkv.CreateKey(peerID), record))
+	})
+
+	t.Run("prefers hybrid when all relays support it", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.HybridEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		_, ok := cache.HybridKey(relay.ID())
+		assert.False(t, ok)
+
+		_, err = cache.Get(ctx, relay.ID())
+		require.NoError(t, err)
+
+		kemKey, ok := cache.HybridKey(relay.ID())
+		require.True(t, ok)
+		assert.Len(t, kemKey, mlkem.EncapsulationKeySize768)
+
+		classic, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		_, err = cache.Get(ctx, classic.ID())
+		require.NoError(t, err)
+
+		_, ok = cache.HybridKey(classic.ID())
+		assert.False(t, ok)
+
+		// The relay decrypts both hybrid and classic layers.
+		publicKey, err := relay.EncryptionKey()
+		require.NoError(t, err)
+
+		m := messageTo(t, relay.ID(), publicKey)
+		assert.NoError(t, sendTo(ctx, relay, m))
+
+		m, err = echalotte.NewMessage(classic.ID(), classic.Peerstore().PrivKey(classic.ID()), []byte("Passent les jours et passent les semaines,"))
+		require.NoError(t, err)
+
+		m, err = m.EncapsulateHybrid(relay.ID(), publicKey, kemKey)
+		require.NoError(t, err)
+		assert.NoError(t, sendTo(ctx, relay, m))
+
+		// Classic hosts can't decrypt hybrid layers.
+		classicKey, err := classic.EncryptionKey()
+		require.NoError(t, err)
+
+		m, err = m.EncapsulateHybrid(classic.ID(), classicKey, kemKey)
+		require.NoError(t, err)
+		assert.Error(t, sendTo(ctx, classic, m))
+
+		// Senders use hybrid layers with the relay.
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay.ID()}, echalotte.CircuitSize(1)),
+			echalotte.EncryptionKeyCache(cache),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), peerstore.AddressTTL)
+		assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Ni temps passé ni les amours reviennent")))
+	})
+
+	t.Run("rotates the post-quantum key with the Curve25519 key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.EncryptionKeyRotation(time.Hour, 50*time.Millisecond),
+			echalotte.HybridEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		previousKey, err := cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		previousKemKey, ok := cache.HybridKey(h.ID())
+		require.True(t, ok)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		previousSeed := stored.Current.HybridSeed
+		require.NotEmpty(t, previousSeed)
+
+		require.NoError(t, h.RotateEncryptionKey(ctx))
+
+		stored, err = ks.Load()
+		require.NoError(t, err)
+		assert.NotEmpty(t, stored.Current.HybridSeed)
+		assert.NotEqual(t, previousSeed, stored.Current.HybridSeed)
+		require.Len(t, stored.Retired, 1)
+		assert.Equal(t, previousSeed, stored.Retired[0].HybridSeed)
+
+		cache, err = echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		kemKey, ok := cache.HybridKey(h.ID())
+		require.True(t, ok)
+		assert.NotEqual(t, previousKemKey, kemKey)
+
+		assert.NoError(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), key, kemKey)))
+
+		// Retired keys decrypt with their own post-quantum key.
+		assert.NoError(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), previousKey, previousKemKey)))
+		assert.Error(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), previousKey, kemKey)))
+	})
+
+	t.Run("replaces the post-quantum key on revocation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.HybridEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		_, err = cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		revokedKemKey, ok := cache.HybridKey(h.ID())
+		require.True(t, ok)
+
+		require.NoError(t, h.RevokeEncryptionKey(ctx, "key leaked"))
+
+		cache, err = echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		kemKey, ok := cache.HybridKey(h.ID())
+		require.True(t, ok)
+		assert.NotEqual(t, revokedKemKey, kemKey)
+
+		assert.NoError(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), key, kemKey)))
+		assert.Error(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), key, revokedKemKey)))
+	})
+
+	t.Run("pairs a post-quantum key with each epoch key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+			echalotte.HybridEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		require.Len(t, stored.Epochs, 2)
+		assert.NotEqual(t, stored.Epochs[0].HybridSeed, stored.Epochs[1].HybridSeed)
+		for _, epochKey := range stored.Epochs {
+			assert.NotEqual(t, stored.Current.HybridSeed, epochKey.HybridSeed)
+		}
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, h.ID())
+		require.NoError(t, err)
+		kemKey, ok := cache.HybridKey(h.ID())
+		require.True(t, ok)
+
+		assert.NoError(t, sendTo(ctx, h, hybridMessageTo(t, h.ID(), key, kemKey)))
+	})
+
+	t.Run("only restores post-quantum keys when enabled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		_, err = echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+			echalotte.HybridEncryptionKeys(),
+		)
+		require.NoError(t, err)
+
+		dht := echalottetesting.NewInMemoryDHT()
+		restarted, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeystore(ks),
+		)
+		require.NoError(t, err)
+
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		_, err = cache.Get(ctx, restarted.ID())
+		require.NoError(t, err)
+
+		_, ok := cache.HybridKey(restarted.ID())
+		assert.False(t, ok)
+	})
+}
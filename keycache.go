@@ -0,0 +1,493 @@
+package echalotte
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+const (
+	// DefaultKeyCacheSize is the default maximum number of cached keys.
+	DefaultKeyCacheSize = 1024
+
+	// DefaultKeyCacheTTL is the default duration after which cached keys
+	// are fetched again from the DHT.
+	DefaultKeyCacheTTL = time.Hour
+
+	// DefaultKeyCacheRefreshInterval is the default interval between
+	// background refreshes of the cache.
+	DefaultKeyCacheRefreshInterval = 10 * time.Minute
+)
+
+// Errors used by the key cache.
+const (
+	ErrInvalidCacheSize = "key cache size should be strictly positive"
+	ErrInvalidCacheTTL  = "key cache TTL should be strictly positive"
+)
+
+// KeyCacheOption is a single key cache option.
+type KeyCacheOption func(opts *KeyCacheOptions) error
+
+// KeyCacheOptions is a set of key cache options.
+type KeyCacheOptions struct {
+	Size           int
+	TTL            time.Duration
+	BlindingPeriod time.Duration
+}
+
+// Apply the given options to this KeyCacheOptions.
+func (opts *KeyCacheOptions) Apply(options ...KeyCacheOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// KeyCacheSize is an option to bound the number of cached keys.
+// The least recently used keys are evicted first.
+func KeyCacheSize(size int) KeyCacheOption {
+	return func(opts *KeyCacheOptions) error {
+		if size <= 0 {
+			return errors.New(ErrInvalidCacheSize)
+		}
+
+		opts.Size = size
+		return nil
+	}
+}
+
+// KeyCacheTTL is an option to choose how long keys are cached.
+// Keys are never cached past the end of their validity window.
+func KeyCacheTTL(ttl time.Duration) KeyCacheOption {
+	return func(opts *KeyCacheOptions) error {
+		if ttl <= 0 {
+			return errors.New(ErrInvalidCacheTTL)
+		}
+
+		opts.TTL = ttl
+		return nil
+	}
+}
+
+// KeyCacheStats are the key cache metrics.
+type KeyCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Refreshes uint64
+	Evictions uint64
+}
+
+// HitRate returns the proportion of lookups served from the cache.
+func (s KeyCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// keyCacheEntry is a cached encryption key record.
+type keyCacheEntry struct {
+	peerID  peer.ID
+	record  *pb.PublicKey
+	raw     []byte
+	expires time.Time
+}
+
+// currentKey returns the key that should be used now: the key of the
+// current epoch if the peer publishes epoch keys, its long-term key
+// otherwise.
+func (entry *keyCacheEntry) currentKey(now time.Time) (*[32]byte, bool) {
+	if !now.Before(entry.expires) {
+		return nil, false
+	}
+
+	key, err := CurrentEpochKey(entry.record, now)
+	if err != nil {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// KeyCache caches validated peer encryption keys fetched from the DHT.
+// It avoids revealing the relays of every circuit to DHT nodes.
+// Revocations seen in a peer's records are remembered, even after its key
+// is evicted, and apply to all the records of that peer fetched later.
+type KeyCache struct {
+	dht       DHT
+	validator PublicKeyValidator
+	options   KeyCacheOptions
+
+	lock        sync.Mutex
+	entries     map[peer.ID]*list.Element
+	lru         *list.List
+	stats       KeyCacheStats
+	revocations map[peer.ID][]*pb.KeyRevocation
+}
+
+// NewKeyCache creates a key cache that fetches missing keys from the DHT.
+func NewKeyCache(dht DHT, opts ...KeyCacheOption) (*KeyCache, error) {
+	kc := &KeyCache{
+		dht: dht,
+		options: KeyCacheOptions{
+			Size: DefaultKeyCacheSize,
+			TTL:  DefaultKeyCacheTTL,
+		},
+		entries:     make(map[peer.ID]*list.Element),
+		lru:         list.New(),
+		revocations: make(map[peer.ID][]*pb.KeyRevocation),
+	}
+
+	err := kc.options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	kc.validator.BlindingPeriod = kc.options.BlindingPeriod
+
+	return kc, nil
+}
+
+// Get the encryption key of the given peer.
+func (kc *KeyCache) Get(ctx context.Context, peerID peer.ID) (*[32]byte, error) {
+	key, _, err := kc.GetRecord(ctx, peerID)
+	return key, err
+}
+
+// GetRecord returns the encryption key of the given peer and the serialized
+// record it comes from.
+func (kc *KeyCache) GetRecord(ctx context.Context, peerID peer.ID) (*[32]byte, []byte, error) {
+	kc.lock.Lock()
+	if elem, ok := kc.entries[peerID]; ok {
+		entry := elem.Value.(*keyCacheEntry)
+		if key, ok := entry.currentKey(time.Now()); ok {
+			kc.lru.MoveToFront(elem)
+			kc.stats.Hits++
+			kc.lock.Unlock()
+			return key, entry.raw, nil
+		}
+
+		kc.remove(elem)
+	}
+
+	kc.stats.Misses++
+	kc.lock.Unlock()
+
+	entry, err := kc.fetch(ctx, peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kc.lock.Lock()
+	kc.add(entry)
+	kc.lock.Unlock()
+
+	key, ok := entry.currentKey(time.Now())
+	if !ok {
+		return nil, nil, errors.New(ErrNoEpochKey)
+	}
+
+	return key, entry.raw, nil
+}
+
+// Lookup returns the peer's key if it is cached, without fetching it.
+func (kc *KeyCache) Lookup(peerID peer.ID) (*[32]byte, bool) {
+	key, _, ok := kc.LookupRecord(peerID)
+	return key, ok
+}
+
+// LookupRecord returns the peer's key and the serialized record it comes
+// from if it is cached, without fetching it.
+func (kc *KeyCache) LookupRecord(peerID peer.ID) (*[32]byte, []byte, bool) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	elem, ok := kc.entries[peerID]
+	if !ok {
+		kc.stats.Misses++
+		return nil, nil, false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	key, ok := entry.currentKey(time.Now())
+	if !ok {
+		kc.remove(elem)
+		kc.stats.Misses++
+		return nil, nil, false
+	}
+
+	kc.lru.MoveToFront(elem)
+	kc.stats.Hits++
+
+	return key, entry.raw, true
+}
+
+// Add a key record obtained without the DHT, for example directly from the
+// peer.
+// The record is validated before being cached.
+func (kc *KeyCache) Add(peerID peer.ID, record []byte) error {
+	entry, err := kc.parse(peerID, record)
+	if err != nil {
+		return err
+	}
+
+	kc.lock.Lock()
+	kc.add(entry)
+	kc.lock.Unlock()
+
+	return nil
+}
+
+// Record returns the serialized key record of the peer if it is cached.
+// It doesn't fetch missing records and doesn't update the cache metrics.
+func (kc *KeyCache) Record(peerID peer.ID) ([]byte, bool) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	elem, ok := kc.entries[peerID]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	if !time.Now().Before(entry.expires) {
+		return nil, false
+	}
+
+	return entry.raw, true
+}
+
+// HybridKey returns the post-quantum key of the peer if its cached record
+// has one for the current key (see CurrentHybridKey).
+// It doesn't fetch missing records and doesn't update the cache metrics.
+func (kc *KeyCache) HybridKey(peerID peer.ID) ([]byte, bool) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	elem, ok := kc.entries[peerID]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		return nil, false
+	}
+
+	return CurrentHybridKey(entry.record, now)
+}
+
+// DerivedKey returns whether the peer's cached record advertises that it
+// accepts layers encrypted to the key derived from its identity.
+// It doesn't fetch missing records and doesn't update the cache metrics.
+func (kc *KeyCache) DerivedKey(peerID peer.ID) bool {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	elem, ok := kc.entries[peerID]
+	if !ok {
+		return false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	return time.Now().Before(entry.expires) && entry.record.DerivedKey
+}
+
+// Revocations returns the revocations seen in the peer's records.
+func (kc *KeyCache) Revocations(peerID peer.ID) []*pb.KeyRevocation {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	return append([]*pb.KeyRevocation(nil), kc.revocations[peerID]...)
+}
+
+// addRevocations remembers the given revocations of the peer's keys and
+// returns all the revocations seen for that peer.
+func (kc *KeyCache) addRevocations(peerID peer.ID, revocations []*pb.KeyRevocation) []*pb.KeyRevocation {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	known := kc.revocations[peerID]
+	for _, revocation := range revocations {
+		if !isRevokedKey(revocation.Data, known) {
+			known = append(known, revocation)
+		}
+	}
+
+	if len(known) > 0 {
+		kc.revocations[peerID] = known
+	}
+
+	return append([]*pb.KeyRevocation(nil), known...)
+}
+
+// Invalidate removes a peer's key from the cache.
+func (kc *KeyCache) Invalidate(peerID peer.ID) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	if elem, ok := kc.entries[peerID]; ok {
+		kc.remove(elem)
+	}
+}
+
+// Len returns the number of cached keys.
+func (kc *KeyCache) Len() int {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	return kc.lru.Len()
+}
+
+// Stats returns the cache metrics.
+func (kc *KeyCache) Stats() KeyCacheStats {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	return kc.stats
+}
+
+// Refresh fetches again the keys that expire before the given deadline.
+// Keys that can't be refreshed are removed from the cache.
+func (kc *KeyCache) Refresh(ctx context.Context, before time.Time) {
+	kc.lock.Lock()
+	var expiring []peer.ID
+	for elem := kc.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*keyCacheEntry)
+		if entry.expires.Before(before) {
+			expiring = append(expiring, entry.peerID)
+		}
+	}
+	kc.lock.Unlock()
+
+	for _, peerID := range expiring {
+		entry, err := kc.fetch(ctx, peerID)
+
+		kc.lock.Lock()
+		if elem, ok := kc.entries[peerID]; ok {
+			if err != nil {
+				log.Debugf("Could not refresh encryption key of %s: %s", peerID.Pretty(), err.Error())
+				kc.remove(elem)
+			} else {
+				elem.Value = entry
+				kc.stats.Refreshes++
+			}
+		}
+		kc.lock.Unlock()
+	}
+}
+
+// Run refreshes the cache in the background until the context is done.
+// Keys are refreshed before they expire, so that sends rarely block on the
+// DHT.
+func (kc *KeyCache) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		kc.Refresh(ctx, time.Now().Add(interval))
+	}
+}
+
+// fetch and validate a peer's encryption key from the DHT.
+func (kc *KeyCache) fetch(ctx context.Context, peerID peer.ID) (*keyCacheEntry, error) {
+	record, err := kc.dht.GetValue(ctx, kc.validator.CreateKey(peerID))
+	if err != nil {
+		return nil, errors.Wrap(errors.New(ErrNoKeyRecord), err.Error())
+	}
+
+	return kc.parse(peerID, record)
+}
+
+// parse and validate a peer's encryption key record.
+func (kc *KeyCache) parse(peerID peer.ID, record []byte) (*keyCacheEntry, error) {
+	err := kc.validator.Validate(kc.validator.CreateKey(peerID), record)
+	if err != nil {
+		return nil, err
+	}
+
+	var peerKey pb.PublicKey
+	err = proto.Unmarshal(record, &peerKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Revocations were verified by the validator.
+	revocations := kc.addRevocations(peerID, peerKey.Revocations)
+	if IsRevoked(&peerKey, revocations) {
+		return nil, errors.New(ErrKeyRevoked)
+	}
+
+	if peerKey.Next != nil && isRevokedKey(peerKey.Next.Data, revocations) {
+		peerKey.Next = nil
+	}
+
+	now := time.Now()
+	if !IsValidAt(&peerKey, now) {
+		return nil, errors.New(ErrInvalidKeyValidity)
+	}
+
+	_, err = CurrentEpochKey(&peerKey, now)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := now.Add(kc.options.TTL)
+	recordExpiry, err := kc.validator.Expiry(&peerKey)
+	if err == nil && recordExpiry.Before(expires) {
+		expires = recordExpiry
+	}
+
+	// The record must be fetched again before its last epoch key expires.
+	epochsEnd := lastEpochEnd(&peerKey)
+	if !epochsEnd.IsZero() && epochsEnd.Before(expires) {
+		expires = epochsEnd
+	}
+
+	return &keyCacheEntry{
+		peerID:  peerID,
+		record:  &peerKey,
+		raw:     record,
+		expires: expires,
+	}, nil
+}
+
+// add an entry to the cache, evicting the least recently used entries if
+// needed.
+// The lock must be held by the caller.
+func (kc *KeyCache) add(entry *keyCacheEntry) {
+	if elem, ok := kc.entries[entry.peerID]; ok {
+		elem.Value = entry
+		kc.lru.MoveToFront(elem)
+		return
+	}
+
+	kc.entries[entry.peerID] = kc.lru.PushFront(entry)
+
+	for kc.lru.Len() > kc.options.Size {
+		kc.remove(kc.lru.Back())
+		kc.stats.Evictions++
+	}
+}
+
+// remove an entry from the cache.
+// The lock must be held by the caller.
+func (kc *KeyCache) remove(elem *list.Element) {
+	entry := kc.lru.Remove(elem).(*keyCacheEntry)
+	delete(kc.entries, entry.peerID)
+}
@@ -0,0 +1,140 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// publishKey publishes a new encryption key for the given peer.
+func publishKey(ctx context.Context, t *testing.T, dht echalotte.DHT, sk crypto.PrivKey) *[32]byte {
+	pk, _, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+
+	peerID, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	pkv := echalotte.PublicKeyValidator{}
+	record, err := pkv.CreateRecord(sk, pk)
+	require.NoError(t, err)
+	require.NoError(t, dht.PutValue(ctx, pkv.CreateKey(peerID), record))
+
+	return pk
+}
+
+func TestKeyCache(t *testing.T) {
+	ctx := context.Background()
+
+	var relays []peer.ID
+	var relayKeys []crypto.PrivKey
+	dht := echalottetesting.NewInMemoryDHT()
+	for i := 0; i < 3; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		relayID, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		publishKey(ctx, t, dht, sk)
+		relays = append(relays, relayID)
+		relayKeys = append(relayKeys, sk)
+	}
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := echalotte.NewKeyCache(dht, echalotte.KeyCacheSize(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidCacheSize)
+
+		_, err = echalotte.NewKeyCache(dht, echalotte.KeyCacheTTL(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidCacheTTL)
+	})
+
+	t.Run("caches keys", func(t *testing.T) {
+		kc, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		k1, err := kc.Get(ctx, relays[0])
+		require.NoError(t, err)
+
+		k2, err := kc.Get(ctx, relays[0])
+		require.NoError(t, err)
+		assert.Equal(t, k1, k2)
+
+		_, err = kc.Get(ctx, peer.ID("unknown"))
+		assert.Error(t, err)
+
+		stats := kc.Stats()
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint64(2), stats.Misses)
+		assert.InDelta(t, 1.0/3.0, stats.HitRate(), 0.001)
+	})
+
+	t.Run("evicts least recently used keys", func(t *testing.T) {
+		kc, err := echalotte.NewKeyCache(dht, echalotte.KeyCacheSize(2))
+		require.NoError(t, err)
+
+		for _, relay := range relays {
+			_, err := kc.Get(ctx, relay)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, kc.Len())
+		assert.Equal(t, uint64(1), kc.Stats().Evictions)
+
+		_, err = kc.Get(ctx, relays[2])
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), kc.Stats().Hits)
+
+		_, err = kc.Get(ctx, relays[0])
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), kc.Stats().Hits)
+	})
+
+	t.Run("expires and refreshes keys", func(t *testing.T) {
+		kc, err := echalotte.NewKeyCache(dht, echalotte.KeyCacheTTL(20*time.Millisecond))
+		require.NoError(t, err)
+
+		k1, err := kc.Get(ctx, relays[1])
+		require.NoError(t, err)
+
+		k2 := publishKey(ctx, t, dht, relayKeys[1])
+
+		kc.Refresh(ctx, time.Now().Add(time.Second))
+		assert.Equal(t, uint64(1), kc.Stats().Refreshes)
+
+		refreshed, err := kc.Get(ctx, relays[1])
+		require.NoError(t, err)
+		assert.NotEqual(t, k1, refreshed)
+		assert.Equal(t, k2, refreshed)
+
+		<-time.After(30 * time.Millisecond)
+		_, err = kc.Get(ctx, relays[1])
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), kc.Stats().Misses)
+	})
+
+	t.Run("rejects invalid records", func(t *testing.T) {
+		kc, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		record, err := dht.GetValue(ctx, pkv.CreateKey(relays[0]))
+		require.NoError(t, err)
+
+		// Store alice's record under bob's key.
+		require.NoError(t, dht.PutValue(ctx, pkv.CreateKey(relays[2]), record))
+
+		_, err = kc.Get(ctx, relays[2])
+		assert.Error(t, err)
+		assert.Equal(t, 0, kc.Len())
+	})
+}
@@ -0,0 +1,143 @@
+package echalotte
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	inet "gx/ipfs/QmNgLg1NTw37iWbYPKcyK85YJ9Whs1MkPtJwhfqbNYAyKg/go-libp2p-net"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	"gx/ipfs/QmaoXrM4Z41PD48JY36YqQGKQpLGjyLA2cKcLsES7YddAq/go-libp2p-host"
+)
+
+const (
+	// KeysProtocolID is the ID of the protocol used to exchange encryption
+	// keys directly with relays, without going through the DHT.
+	KeysProtocolID = protocol.ID("/echalotte/keys/v1.0.0")
+
+	// DefaultKeyExchangeTimeout is the default timeout of a direct key
+	// exchange.
+	DefaultKeyExchangeTimeout = 10 * time.Second
+)
+
+// Errors used by the key exchange protocol.
+const (
+	ErrNoKeyRecord = "no encryption key record available"
+)
+
+// keyExchangeResponse is sent by hosts on the keys protocol.
+// It wraps the same signed record that is published on the DHT.
+type keyExchangeResponse struct {
+	Record []byte `json:"record"`
+}
+
+// DirectKeyExchange is an option to fetch relay keys from the relays
+// themselves instead of the DHT, so that DHT nodes don't learn which relays
+// we use.
+// Use it with DirectKeyFilter so that keys are fetched while discovering
+// candidate relays, and share the same key cache with EncryptionKeyCache.
+func DirectKeyExchange() HostOption {
+	return func(opts *HostOptions) error {
+		opts.DirectKeyExchange = true
+		return nil
+	}
+}
+
+// RequestEncryptionKey asks a peer for its encryption key record.
+// The record isn't validated: callers should add it to a KeyCache, which
+// validates it with the cache's settings.
+func RequestEncryptionKey(ctx context.Context, h host.Host, peerID peer.ID) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultKeyExchangeTimeout)
+	defer cancel()
+
+	stream, err := h.NewStream(ctx, peerID, KeysProtocolID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+
+	var response keyExchangeResponse
+	err = json.NewDecoder(stream).Decode(&response)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response.Record, nil
+}
+
+// DirectKeyFilter returns a filter that fetches candidate relays' encryption
+// keys directly from them and adds them to the given cache.
+// Relays that don't answer with a valid key are rejected.
+// Keys of all candidates are fetched, not only those of the relays that end
+// up in circuits, which hides our relay selection from the relays.
+func DirectKeyFilter(h host.Host, cache *KeyCache) RelayFilter {
+	return func(ctx context.Context, relay peerstore.PeerInfo) bool {
+		if _, ok := cache.Lookup(relay.ID); ok {
+			return true
+		}
+
+		h.Peerstore().AddAddrs(relay.ID, relay.Addrs, peerstore.TempAddrTTL)
+
+		record, err := RequestEncryptionKey(ctx, h, relay.ID)
+		if err != nil {
+			log.Debugf("Could not get encryption key of %s: %s", relay.ID.Pretty(), err.Error())
+			return false
+		}
+
+		err = cache.Add(relay.ID, record)
+		if err != nil {
+			log.Debugf("Invalid encryption key for %s: %s", relay.ID.Pretty(), err.Error())
+			return false
+		}
+
+		return true
+	}
+}
+
+// handleKeyRequest answers with our current encryption key record.
+func (h *Host) handleKeyRequest(stream inet.Stream) error {
+	defer stream.Close()
+
+	h.keysLock.Lock()
+	record := h.keyRecord
+	h.keysLock.Unlock()
+
+	if record == nil {
+		stream.Reset()
+		return errors.New(ErrNoKeyRecord)
+	}
+
+	return json.NewEncoder(stream).Encode(&keyExchangeResponse{Record: record})
+}
+
+// directEncryptionKey returns a peer's encryption key and its record from
+// the cache, or asks the peer for them.
+func (h *Host) directEncryptionKey(ctx context.Context, peerID peer.ID) (*[32]byte, []byte, error) {
+	if key, record, ok := h.keyCache.LookupRecord(peerID); ok {
+		return key, record, nil
+	}
+
+	record, err := RequestEncryptionKey(ctx, h, peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = h.keyCache.Add(peerID, record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, record, ok := h.keyCache.LookupRecord(peerID)
+	if !ok {
+		return nil, nil, errors.New(ErrInvalidKeyValidity)
+	}
+
+	return key, record, nil
+}
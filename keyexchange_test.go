@@ -0,0 +1,76 @@
+package echalotte_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func TestKeyExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The relay publishes its key on a DHT that the client can't access.
+	relay, err := echalotte.Connect(
+		ctx,
+		echalottetesting.RandomHost(ctx, t),
+		echalottetesting.NewInMemoryDHT(),
+		echalottetesting.NewDummyCircuitBuilder(t),
+	)
+	require.NoError(t, err)
+
+	relayKey, err := relay.EncryptionKey()
+	require.NoError(t, err)
+
+	clientHost := echalottetesting.RandomHost(ctx, t)
+	relayInfo := peerstore.PeerInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	t.Run("RequestEncryptionKey()", func(t *testing.T) {
+		clientHost.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), peerstore.TempAddrTTL)
+
+		record, err := echalotte.RequestEncryptionKey(ctx, clientHost, relay.ID())
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		assert.NoError(t, pkv.Validate(pkv.CreateKey(relay.ID()), record))
+
+		_, err = echalotte.RequestEncryptionKey(ctx, clientHost, peer.ID("unknown"))
+		assert.Error(t, err)
+	})
+
+	t.Run("DirectKeyFilter()", func(t *testing.T) {
+		cache, err := echalotte.NewKeyCache(echalottetesting.NewInMemoryDHT())
+		require.NoError(t, err)
+
+		filter := echalotte.DirectKeyFilter(clientHost, cache)
+		assert.True(t, filter(ctx, relayInfo))
+		assert.False(t, filter(ctx, peerstore.PeerInfo{ID: peer.ID("unknown")}))
+
+		key, ok := cache.Lookup(relay.ID())
+		require.True(t, ok)
+		assert.Equal(t, relayKey, key)
+	})
+
+	t.Run("sends without DHT lookups", func(t *testing.T) {
+		client, err := echalotte.Connect(
+			ctx,
+			clientHost,
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay.ID()}, echalotte.CircuitSize(1)),
+			echalotte.DirectKeyExchange(),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), peerstore.TempAddrTTL)
+
+		err = client.SendMessage(ctx, peer.ID("alice"), []byte("D'où sortaient de noirs bataillons"))
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,534 @@
+package echalotte
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/secretbox"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/scrypt"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+)
+
+const (
+	// KeystoreNamespace is the datastore key under which encryption keys are
+	// persisted.
+	KeystoreNamespace = "/echalotte/keys"
+
+	// Scrypt parameters used to derive the keystore encryption key from a
+	// passphrase.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Errors used by keystores.
+const (
+	ErrKeysNotFound     = "no encryption keys in keystore"
+	ErrInvalidKeystore  = "invalid keystore content"
+	ErrKeystorePassword = "could not decrypt keystore: invalid passphrase"
+)
+
+// StoredKey is an encryption key pair persisted in a keystore.
+type StoredKey struct {
+	PublicKey  []byte `json:"public_key"`
+	PrivateKey []byte `json:"private_key"`
+
+	// RetiredUntil is only set for retired keys: they can decrypt messages
+	// until that time.
+	RetiredUntil time.Time `json:"retired_until,omitempty"`
+
+	// Epoch bounds are only set for epoch keys.
+	// NotBefore is also set for the next key.
+	Epoch     uint64    `json:"epoch,omitempty"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+
+	// HybridSeed is the seed of the post-quantum key paired with this key,
+	// if hybrid keys are enabled.
+	HybridSeed []byte `json:"hybrid_seed,omitempty"`
+}
+
+// StoredKeys are all the encryption keys of a host.
+type StoredKeys struct {
+	Current     StoredKey           `json:"current"`
+	Next        *StoredKey          `json:"next,omitempty"`
+	Retired     []StoredKey         `json:"retired,omitempty"`
+	Epochs      []StoredKey         `json:"epochs,omitempty"`
+	Revocations []*pb.KeyRevocation `json:"revocations,omitempty"`
+
+	// RotateAt is when the current key should be rotated, if keys are
+	// rotated.
+	RotateAt time.Time `json:"rotate_at,omitempty"`
+}
+
+// Keystore persists the host's encryption keys across restarts.
+type Keystore interface {
+	// Load the stored keys.
+	// It returns ErrKeysNotFound if no keys have been stored yet.
+	Load() (*StoredKeys, error)
+
+	// Store the given keys, replacing previously stored keys.
+	Store(*StoredKeys) error
+}
+
+// KeystoreOption is a single keystore option.
+type KeystoreOption func(opts *KeystoreOptions) error
+
+// KeystoreOptions is a set of keystore options.
+type KeystoreOptions struct {
+	Passphrase []byte
+}
+
+// Apply the given options to this KeystoreOptions.
+func (opts *KeystoreOptions) Apply(options ...KeystoreOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// KeystorePassphrase is an option to encrypt keys at rest with a key derived
+// from the given passphrase.
+func KeystorePassphrase(passphrase []byte) KeystoreOption {
+	return func(opts *KeystoreOptions) error {
+		opts.Passphrase = passphrase
+		return nil
+	}
+}
+
+// encryptedKeys is the at-rest format of passphrase-protected keys.
+type encryptedKeys struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encodeKeys serializes keys, encrypting them if a passphrase is provided.
+func encodeKeys(keys *StoredKeys, passphrase []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(passphrase) == 0 {
+		return plaintext, nil
+	}
+
+	encrypted := encryptedKeys{
+		Salt:  make([]byte, 32),
+		Nonce: make([]byte, 24),
+	}
+
+	if _, err := crand.Read(encrypted.Salt); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, err := crand.Read(encrypted.Nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	secretKey, err := passphraseKey(passphrase, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], encrypted.Nonce)
+	encrypted.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, secretKey)
+
+	serialized, err := json.Marshal(encrypted)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return serialized, nil
+}
+
+// decodeKeys deserializes keys, decrypting them if a passphrase is provided.
+func decodeKeys(serialized []byte, passphrase []byte) (*StoredKeys, error) {
+	plaintext := serialized
+
+	if len(passphrase) > 0 {
+		var encrypted encryptedKeys
+		err := json.Unmarshal(serialized, &encrypted)
+		if err != nil || len(encrypted.Nonce) != 24 {
+			return nil, errors.New(ErrInvalidKeystore)
+		}
+
+		secretKey, err := passphraseKey(passphrase, encrypted.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		var nonce [24]byte
+		copy(nonce[:], encrypted.Nonce)
+
+		var ok bool
+		plaintext, ok = secretbox.Open(nil, encrypted.Ciphertext, &nonce, secretKey)
+		if !ok {
+			return nil, errors.New(ErrKeystorePassword)
+		}
+	}
+
+	var keys StoredKeys
+	err := json.Unmarshal(plaintext, &keys)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrInvalidKeystore)
+	}
+
+	if len(keys.Current.PublicKey) != 32 || len(keys.Current.PrivateKey) != 32 {
+		return nil, errors.New(ErrInvalidKeystore)
+	}
+
+	return &keys, nil
+}
+
+// passphraseKey derives a secretbox key from the passphrase.
+func passphraseKey(passphrase, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var secretKey [32]byte
+	copy(secretKey[:], derived)
+
+	return &secretKey, nil
+}
+
+// DatastoreKeystore persists encryption keys in a datastore.
+type DatastoreKeystore struct {
+	ds      datastore.Datastore
+	options KeystoreOptions
+}
+
+// NewDatastoreKeystore creates a keystore backed by the given datastore.
+func NewDatastoreKeystore(ds datastore.Datastore, opts ...KeystoreOption) (*DatastoreKeystore, error) {
+	ks := &DatastoreKeystore{ds: ds}
+	err := ks.options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Load the stored keys.
+func (ks *DatastoreKeystore) Load() (*StoredKeys, error) {
+	serialized, err := ks.ds.Get(datastore.NewKey(KeystoreNamespace))
+	if err == datastore.ErrNotFound {
+		return nil, errors.New(ErrKeysNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return decodeKeys(serialized, ks.options.Passphrase)
+}
+
+// Store the given keys.
+func (ks *DatastoreKeystore) Store(keys *StoredKeys) error {
+	serialized, err := encodeKeys(keys, ks.options.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStack(ks.ds.Put(datastore.NewKey(KeystoreNamespace), serialized))
+}
+
+// FileKeystore persists encryption keys in a file.
+type FileKeystore struct {
+	lock    sync.Mutex
+	path    string
+	options KeystoreOptions
+}
+
+// NewFileKeystore creates a keystore backed by the file at the given path.
+// The file is created when keys are first stored.
+func NewFileKeystore(path string, opts ...KeystoreOption) (*FileKeystore, error) {
+	ks := &FileKeystore{path: path}
+	err := ks.options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Load the stored keys.
+func (ks *FileKeystore) Load() (*StoredKeys, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	serialized, err := ioutil.ReadFile(ks.path)
+	if os.IsNotExist(err) {
+		return nil, errors.New(ErrKeysNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return decodeKeys(serialized, ks.options.Passphrase)
+}
+
+// Store the given keys.
+// The file is replaced atomically and is only readable by its owner.
+func (ks *FileKeystore) Store(keys *StoredKeys) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	serialized, err := encodeKeys(keys, ks.options.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(ks.path), filepath.Base(ks.path))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = f.Write(serialized)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), ks.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// EncryptionKeystore is an option to persist the host's encryption keys.
+// When keys are found in the keystore, they are reused instead of generating
+// new ones.
+func EncryptionKeystore(keystore Keystore) HostOption {
+	return func(opts *HostOptions) error {
+		opts.Keystore = keystore
+		return nil
+	}
+}
+
+// loadEncryptionKeys loads the host's keys from its keystore and publishes
+// the current one.
+func (h *Host) loadEncryptionKeys(ctx context.Context) error {
+	keys, err := h.keystore.Load()
+	if err != nil {
+		return err
+	}
+
+	h.keysLock.Lock()
+	err = h.restoreEncryptionKeys(keys)
+	h.keysLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var publicKey [32]byte
+	copy(publicKey[:], keys.Current.PublicKey)
+
+	err = h.publishEncryptionKey(ctx, &publicKey)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Encryption key loaded from keystore")
+
+	return nil
+}
+
+// restoreEncryptionKeys replaces the host's keys with the stored ones.
+// The lock must be held by the caller.
+func (h *Host) restoreEncryptionKeys(keys *StoredKeys) error {
+	var publicKey, privateKey [32]byte
+	copy(publicKey[:], keys.Current.PublicKey)
+	copy(privateKey[:], keys.Current.PrivateKey)
+
+	err := h.storeEncryptionKey(&publicKey, &privateKey)
+	if err != nil {
+		return err
+	}
+
+	h.hybridKey, err = h.restoreHybridKey(keys.Current.HybridSeed, true)
+	if err != nil {
+		return err
+	}
+
+	h.retiredKeys = nil
+	for _, retired := range keys.Retired {
+		if !time.Now().Before(retired.RetiredUntil) {
+			continue
+		}
+
+		var retiredPublicKey, retiredPrivateKey [32]byte
+		copy(retiredPublicKey[:], retired.PublicKey)
+		copy(retiredPrivateKey[:], retired.PrivateKey)
+
+		hybrid, err := h.restoreHybridKey(retired.HybridSeed, false)
+		if err != nil {
+			return err
+		}
+
+		h.retiredKeys = append(h.retiredKeys, retiredKey{
+			public: &retiredPublicKey,
+			key:    &retiredPrivateKey,
+			hybrid: hybrid,
+			until:  retired.RetiredUntil,
+		})
+	}
+
+	h.nextKey = nil
+	if keys.Next != nil {
+		var nextPublicKey, nextPrivateKey [32]byte
+		copy(nextPublicKey[:], keys.Next.PublicKey)
+		copy(nextPrivateKey[:], keys.Next.PrivateKey)
+
+		hybrid, err := h.restoreHybridKey(keys.Next.HybridSeed, true)
+		if err != nil {
+			return err
+		}
+
+		h.nextKey = &nextKey{
+			public:    &nextPublicKey,
+			key:       &nextPrivateKey,
+			hybrid:    hybrid,
+			notBefore: keys.Next.NotBefore,
+		}
+	}
+
+	h.epochKeys = nil
+	for _, stored := range keys.Epochs {
+		var epochPublicKey, epochPrivateKey [32]byte
+		copy(epochPublicKey[:], stored.PublicKey)
+		copy(epochPrivateKey[:], stored.PrivateKey)
+
+		hybrid, err := h.restoreHybridKey(stored.HybridSeed, true)
+		if err != nil {
+			return err
+		}
+
+		h.epochKeys = append(h.epochKeys, epochKey{
+			epoch:     stored.Epoch,
+			notBefore: stored.NotBefore,
+			notAfter:  stored.NotAfter,
+			public:    &epochPublicKey,
+			key:       &epochPrivateKey,
+			hybrid:    hybrid,
+		})
+	}
+
+	h.revocations = keys.Revocations
+	h.rotateAt = keys.RotateAt
+
+	return nil
+}
+
+// restoreHybridKey restores a stored post-quantum key.
+// Stored keys are ignored unless hybrid keys are enabled, in which case a
+// new key is generated if generate is true and none was stored.
+// The lock must be held by the caller.
+func (h *Host) restoreHybridKey(seed []byte, generate bool) (*hybridKey, error) {
+	if !h.hybridEncryption {
+		return nil, nil
+	}
+
+	if len(seed) == 0 {
+		if !generate {
+			return nil, nil
+		}
+
+		return generateHybridKey()
+	}
+
+	key, err := newHybridKey(seed)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrInvalidKeystore)
+	}
+
+	return key, nil
+}
+
+// saveEncryptionKeys persists the host's keys in its keystore, if any.
+func (h *Host) saveEncryptionKeys() error {
+	if h.keystore == nil {
+		return nil
+	}
+
+	h.keysLock.Lock()
+	defer h.keysLock.Unlock()
+
+	publicKey, err := h.EncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := h.DecryptionKey()
+	if err != nil {
+		return err
+	}
+
+	keys := &StoredKeys{
+		Current: StoredKey{
+			PublicKey:  publicKey[:],
+			PrivateKey: privateKey[:],
+		},
+		Revocations: h.revocations,
+		RotateAt:    h.rotateAt,
+	}
+
+	if h.nextKey != nil {
+		keys.Next = &StoredKey{
+			PublicKey:  h.nextKey.public[:],
+			PrivateKey: h.nextKey.key[:],
+			NotBefore:  h.nextKey.notBefore,
+			HybridSeed: h.nextKey.hybrid.bytes(),
+		}
+	}
+
+	// Retired keys are never persisted past their overlap.
+	h.eraseRetiredKeys(time.Now())
+	for _, retired := range h.retiredKeys {
+		keys.Retired = append(keys.Retired, StoredKey{
+			PublicKey:    retired.public[:],
+			PrivateKey:   retired.key[:],
+			RetiredUntil: retired.until,
+			HybridSeed:   retired.hybrid.bytes(),
+		})
+	}
+
+	keys.Current.HybridSeed = h.hybridKey.bytes()
+
+	for _, k := range h.epochKeys {
+		keys.Epochs = append(keys.Epochs, StoredKey{
+			PublicKey:  k.public[:],
+			PrivateKey: k.key[:],
+			Epoch:      k.epoch,
+			NotBefore:  k.notBefore,
+			NotAfter:   k.notAfter,
+			HybridSeed: k.hybrid.bytes(),
+		})
+	}
+
+	return h.keystore.Store(keys)
+}
@@ -0,0 +1,179 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+func randomStoredKeys(t *testing.T) *echalotte.StoredKeys {
+	publicKey, privateKey, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+
+	_, retiredKey, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+
+	return &echalotte.StoredKeys{
+		Current: echalotte.StoredKey{
+			PublicKey:  publicKey[:],
+			PrivateKey: privateKey[:],
+		},
+		Retired: []echalotte.StoredKey{{
+			PrivateKey:   retiredKey[:],
+			RetiredUntil: time.Now().Add(time.Hour).Round(0),
+		}},
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "echalotte-keystore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("datastore", func(t *testing.T) {
+		ks, err := echalotte.NewDatastoreKeystore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+
+		_, err = ks.Load()
+		assert.EqualError(t, err, echalotte.ErrKeysNotFound)
+
+		keys := randomStoredKeys(t)
+		require.NoError(t, ks.Store(keys))
+
+		loaded, err := ks.Load()
+		require.NoError(t, err)
+		assert.Equal(t, keys.Current, loaded.Current)
+		assert.True(t, keys.Retired[0].RetiredUntil.Equal(loaded.Retired[0].RetiredUntil))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(dir, "keys.json")
+		ks, err := echalotte.NewFileKeystore(path)
+		require.NoError(t, err)
+
+		_, err = ks.Load()
+		assert.EqualError(t, err, echalotte.ErrKeysNotFound)
+
+		keys := randomStoredKeys(t)
+		require.NoError(t, ks.Store(keys))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		loaded, err := ks.Load()
+		require.NoError(t, err)
+		assert.Equal(t, keys.Current, loaded.Current)
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		path := filepath.Join(dir, "encrypted.json")
+		ks, err := echalotte.NewFileKeystore(path, echalotte.KeystorePassphrase([]byte("Ouvrait d'une façon nonchalante et cynique")))
+		require.NoError(t, err)
+
+		keys := randomStoredKeys(t)
+		require.NoError(t, ks.Store(keys))
+
+		// Keys are not stored in clear.
+		content, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "private_key")
+
+		loaded, err := ks.Load()
+		require.NoError(t, err)
+		assert.Equal(t, keys.Current, loaded.Current)
+
+		wrongPassphrase, err := echalotte.NewFileKeystore(path, echalotte.KeystorePassphrase([]byte("Son ventre plein d'exhalaisons.")))
+		require.NoError(t, err)
+
+		_, err = wrongPassphrase.Load()
+		assert.EqualError(t, err, echalotte.ErrKeystorePassword)
+	})
+
+	t.Run("keys survive restarts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		ks, err := echalotte.NewFileKeystore(filepath.Join(dir, "host.json"))
+		require.NoError(t, err)
+
+		dht := echalottetesting.NewInMemoryDHT()
+		connect := func() *echalotte.Host {
+			h, err := echalotte.Connect(
+				ctx,
+				echalottetesting.HostWithIdentity(ctx, t, sk),
+				dht,
+				echalottetesting.NewDummyCircuitBuilder(t),
+				echalotte.EncryptionKeystore(ks),
+				echalotte.EncryptionKeyRotation(time.Hour, 200*time.Millisecond),
+			)
+			require.NoError(t, err)
+			return h
+		}
+
+		publishedNotAfter := func(h *echalotte.Host) time.Time {
+			record, err := dht.GetValue(ctx, echalotte.PublicKeyValidator{}.CreateKey(h.ID()))
+			require.NoError(t, err)
+
+			var publicKey pb.PublicKey
+			require.NoError(t, proto.Unmarshal(record, &publicKey))
+
+			notAfter, err := ptypes.TimestampFromProto(publicKey.NotAfter)
+			require.NoError(t, err)
+			return notAfter
+		}
+
+		h := connect()
+		firstKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+
+		require.NoError(t, h.RotateEncryptionKey(ctx))
+		secondKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+		notAfter := publishedNotAfter(h)
+
+		stored, err := ks.Load()
+		require.NoError(t, err)
+		require.Len(t, stored.Retired, 1)
+		assert.Equal(t, firstKey[:], stored.Retired[0].PublicKey)
+		require.NoError(t, h.Close())
+
+		restarted := connect()
+		restartedKey, err := restarted.EncryptionKey()
+		require.NoError(t, err)
+		assert.Equal(t, secondKey, restartedKey)
+
+		// The rotation schedule survives the restart.
+		assert.True(t, notAfter.Equal(publishedNotAfter(restarted)))
+
+		// Retired keys are removed from the keystore once their overlap is
+		// over.
+		for i := 0; i < 100 && len(stored.Retired) > 0; i++ {
+			<-time.After(10 * time.Millisecond)
+			stored, err = ks.Load()
+			require.NoError(t, err)
+		}
+
+		assert.Empty(t, stored.Retired)
+	})
+}
@@ -4,6 +4,9 @@ import (
 	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
 
 	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
 	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
@@ -26,8 +29,12 @@ const (
 
 // OnionMessage contains the next recipient and some bytes supposedly encrypted
 // for that next recipient.
+// Delay is how long the relay that decrypted the message should hold it
+// before forwarding it to the next recipient.
 type OnionMessage struct {
 	To            []byte
+	KeyType       pb.KeyType    `json:",omitempty"`
+	Delay         time.Duration `json:",omitempty"`
 	From          []byte
 	FromPublicKey []byte
 	Content       []byte
@@ -138,10 +145,22 @@ func (l *OnionMessage) Encapsulate(to peer.ID, publicKey *[32]byte) (*OnionMessa
 // The first argument is the peer's signing private key.
 // The second argument is the peer's encryption private key (curve25519 point).
 func (l *OnionMessage) Decapsulate(signingKey crypto.PrivKey, encryptionPrivKey *[32]byte) (*OnionMessage, error) {
+	return l.decapsulateWith(signingKey, func(content []byte) ([]byte, error) {
+		return open(encryptionPrivKey, content)
+	})
+}
+
+// decapsulateWith decrypts the content of a Curve25519 layer with the given
+// open function.
+func (l *OnionMessage) decapsulateWith(signingKey crypto.PrivKey, open func([]byte) ([]byte, error)) (*OnionMessage, error) {
 	if l.IsLastHop() {
 		return nil, errors.New(ErrDecapsulateLastHop)
 	}
 
+	if l.KeyType != pb.KeyType_Curve25519 {
+		return nil, errors.New(ErrUnsupportedKeyType)
+	}
+
 	to, err := peer.IDFromBytes(l.To)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -151,7 +170,7 @@ func (l *OnionMessage) Decapsulate(signingKey crypto.PrivKey, encryptionPrivKey
 		return nil, errors.New(ErrDecapsulateKey)
 	}
 
-	content, err := open(encryptionPrivKey, l.Content)
+	content, err := open(l.Content)
 	if err != nil {
 		return nil, err
 	}
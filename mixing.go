@@ -0,0 +1,210 @@
+package echalotte
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+)
+
+const (
+	// DefaultMaxMixingDelay is the longest time relays hold a message,
+	// whatever delay the sender asked for.
+	// Exponential delays are unbounded, so senders should use a mean much
+	// smaller than this.
+	DefaultMaxMixingDelay = 10 * time.Minute
+
+	// DefaultMixingQueueSize is the number of messages relays hold at most.
+	DefaultMixingQueueSize = 1000
+)
+
+// Errors used by mixing.
+const (
+	ErrInvalidMixingDelay     = "mixing delay should be positive"
+	ErrInvalidMaxMixingDelay  = "maximum mixing delay should be strictly positive"
+	ErrInvalidMixingQueueSize = "mixing queue size should be strictly positive"
+	ErrMixingQueueFull        = "mixing queue is full"
+)
+
+// SendOption is a single send option.
+type SendOption func(opts *SendOptions) error
+
+// SendOptions is a set of send options.
+type SendOptions struct {
+	MixingDelay time.Duration
+}
+
+// Apply the given options to this SendOptions.
+func (opts *SendOptions) Apply(options ...SendOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MixingDelay is an option to have each relay hold the message for a random
+// delay before forwarding it, like in Loopix.
+// Delays are drawn independently for every hop from an exponential
+// distribution with the given mean. Since the exponential distribution is
+// memoryless, an observer can't tell which of the messages held by a relay
+// is released next, which breaks timing correlation between its inputs and
+// outputs.
+// Delays are encrypted with each layer so that only the relay sees its own.
+func MixingDelay(mean time.Duration) SendOption {
+	return func(opts *SendOptions) error {
+		if mean < 0 {
+			return errors.New(ErrInvalidMixingDelay)
+		}
+
+		opts.MixingDelay = mean
+		return nil
+	}
+}
+
+// RelayMixing is an option to configure how relays hold messages.
+// Delays requested by senders are capped to maxDelay, and messages received
+// while queueSize messages are already waiting are dropped.
+// By default, relays use DefaultMaxMixingDelay and DefaultMixingQueueSize.
+func RelayMixing(maxDelay time.Duration, queueSize int) HostOption {
+	return func(opts *HostOptions) error {
+		if maxDelay <= 0 {
+			return errors.New(ErrInvalidMaxMixingDelay)
+		}
+
+		if queueSize <= 0 {
+			return errors.New(ErrInvalidMixingQueueSize)
+		}
+
+		opts.MaxMixingDelay = maxDelay
+		opts.MixingQueueSize = queueSize
+		return nil
+	}
+}
+
+// mixingDelay draws a delay from an exponential distribution with the given
+// mean.
+func mixingDelay(mean time.Duration) time.Duration {
+	if mean == 0 {
+		return 0
+	}
+
+	return time.Duration(newCryptoRand().ExpFloat64() * float64(mean))
+}
+
+// delayedMessage is a message waiting in the delay queue.
+type delayedMessage struct {
+	message *OnionMessage
+	release time.Time
+}
+
+// delayHeap orders delayed messages by release time.
+type delayHeap []*delayedMessage
+
+func (dh delayHeap) Len() int           { return len(dh) }
+func (dh delayHeap) Less(i, j int) bool { return dh[i].release.Before(dh[j].release) }
+func (dh delayHeap) Swap(i, j int)      { dh[i], dh[j] = dh[j], dh[i] }
+
+func (dh *delayHeap) Push(x interface{}) {
+	*dh = append(*dh, x.(*delayedMessage))
+}
+
+func (dh *delayHeap) Pop() interface{} {
+	old := *dh
+	n := len(old)
+	m := old[n-1]
+	old[n-1] = nil
+	*dh = old[:n-1]
+	return m
+}
+
+// delayQueue holds messages until their release time.
+type delayQueue struct {
+	maxDelay time.Duration
+	size     int
+
+	lock     sync.Mutex
+	messages delayHeap
+	wake     chan struct{}
+}
+
+// newDelayQueue creates an empty delay queue holding at most size messages.
+func newDelayQueue(maxDelay time.Duration, size int) *delayQueue {
+	return &delayQueue{
+		maxDelay: maxDelay,
+		size:     size,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// push a message to release after the given delay.
+// Delays are capped to the queue's maximum delay.
+// Messages are dropped when the queue is full: forwarding them right away
+// would let an attacker flush the relay and link its inputs and outputs.
+func (q *delayQueue) push(message *OnionMessage, delay time.Duration) error {
+	if delay > q.maxDelay {
+		delay = q.maxDelay
+	}
+
+	q.lock.Lock()
+	if q.messages.Len() >= q.size {
+		q.lock.Unlock()
+		return errors.New(ErrMixingQueueFull)
+	}
+
+	heap.Push(&q.messages, &delayedMessage{message: message, release: time.Now().Add(delay)})
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// popReleased removes the messages that should be released now.
+// It returns the time until the next release, or a negative duration if the
+// queue is empty.
+func (q *delayQueue) popReleased(now time.Time) ([]*OnionMessage, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var released []*OnionMessage
+	for q.messages.Len() > 0 && !q.messages[0].release.After(now) {
+		released = append(released, heap.Pop(&q.messages).(*delayedMessage).message)
+	}
+
+	if q.messages.Len() == 0 {
+		return released, -1
+	}
+
+	return released, q.messages[0].release.Sub(now)
+}
+
+// run releases messages on schedule until the context is done.
+// Messages still waiting are dropped.
+func (q *delayQueue) run(ctx context.Context, release func(*OnionMessage)) {
+	for {
+		released, next := q.popReleased(time.Now())
+		for _, message := range released {
+			go release(message)
+		}
+
+		var timer <-chan time.Time
+		if next >= 0 {
+			timer = time.After(next)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer:
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	inet "gx/ipfs/QmNgLg1NTw37iWbYPKcyK85YJ9Whs1MkPtJwhfqbNYAyKg/go-libp2p-net"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func TestMixing(t *testing.T) {
+	t.Run("MixingDelay()", func(t *testing.T) {
+		options := &echalotte.SendOptions{}
+		assert.EqualError(t, options.Apply(echalotte.MixingDelay(-time.Second)), echalotte.ErrInvalidMixingDelay)
+		assert.NoError(t, options.Apply(echalotte.MixingDelay(time.Second)))
+		assert.Equal(t, time.Second, options.MixingDelay)
+	})
+
+	t.Run("RelayMixing()", func(t *testing.T) {
+		options := &echalotte.HostOptions{}
+		assert.EqualError(t, options.Apply(echalotte.RelayMixing(0, 10)), echalotte.ErrInvalidMaxMixingDelay)
+		assert.EqualError(t, options.Apply(echalotte.RelayMixing(time.Minute, 0)), echalotte.ErrInvalidMixingQueueSize)
+		assert.NoError(t, options.Apply(echalotte.RelayMixing(time.Minute, 10)))
+		assert.Equal(t, time.Minute, options.MaxMixingDelay)
+		assert.Equal(t, 10, options.MixingQueueSize)
+	})
+
+	t.Run("relays hold messages", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		type received struct {
+			message *echalotte.OnionMessage
+			at      time.Time
+		}
+
+		forwarded := make(chan received, 2)
+		next := echalottetesting.RandomHost(ctx, t)
+		next.SetStreamHandler(echalotte.ProtocolID, func(stream inet.Stream) {
+			defer stream.Close()
+
+			var m echalotte.OnionMessage
+			if json.NewDecoder(stream).Decode(&m) == nil {
+				forwarded <- received{message: &m, at: time.Now()}
+			}
+		})
+
+		relay.Peerstore().AddAddrs(next.ID(), next.Addrs(), peerstore.AddressTTL)
+
+		relayKey, err := relay.EncryptionKey()
+		require.NoError(t, err)
+
+		nextKey, _, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		for _, delay := range []time.Duration{0, 300 * time.Millisecond} {
+			m := messageTo(t, next.ID(), nextKey)
+			m.Delay = delay
+
+			m, err = m.Encapsulate(relay.ID(), relayKey)
+			require.NoError(t, err)
+
+			sent := time.Now()
+			require.NoError(t, sendTo(ctx, relay, m))
+
+			select {
+			case r := <-forwarded:
+				assert.True(t, r.at.Sub(sent) >= delay)
+				assert.Equal(t, time.Duration(0), r.message.Delay)
+				assert.Equal(t, []byte(next.ID()), r.message.To)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "message not forwarded")
+			}
+		}
+	})
+
+	t.Run("sends with mixing delays", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+
+		var relays []*echalotte.Host
+		for i := 0; i < 2; i++ {
+			relay, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				dht,
+				echalottetesting.NewDummyCircuitBuilder(t),
+			)
+			require.NoError(t, err)
+			relays = append(relays, relay)
+		}
+
+		relays[1].Peerstore().AddAddrs(relays[0].ID(), relays[0].Addrs(), peerstore.AddressTTL)
+
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relays[0].ID(), relays[1].ID()}, echalotte.CircuitSize(2)),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relays[1].ID(), relays[1].Addrs(), peerstore.AddressTTL)
+
+		err = client.SendMessage(ctx, peer.ID("alice"), []byte("Qui n'avait ja-ja-jamais navigué"), echalotte.MixingDelay(-time.Second))
+		assert.EqualError(t, err, echalotte.ErrInvalidMixingDelay)
+
+		assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Ohé ohé"), echalotte.MixingDelay(10*time.Millisecond)))
+	})
+
+	t.Run("caps delays and drops messages when full", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.RelayMixing(200*time.Millisecond, 1),
+		)
+		require.NoError(t, err)
+
+		forwarded := make(chan struct{}, 2)
+		next := echalottetesting.RandomHost(ctx, t)
+		next.SetStreamHandler(echalotte.ProtocolID, func(stream inet.Stream) {
+			defer stream.Close()
+
+			var m echalotte.OnionMessage
+			if json.NewDecoder(stream).Decode(&m) == nil {
+				forwarded <- struct{}{}
+			}
+		})
+
+		relay.Peerstore().AddAddrs(next.ID(), next.Addrs(), peerstore.AddressTTL)
+
+		relayKey, err := relay.EncryptionKey()
+		require.NoError(t, err)
+
+		nextKey, _, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		delayed := func() *echalotte.OnionMessage {
+			m := messageTo(t, next.ID(), nextKey)
+			m.Delay = time.Hour
+
+			m, err := m.Encapsulate(relay.ID(), relayKey)
+			require.NoError(t, err)
+			return m
+		}
+
+		require.NoError(t, sendTo(ctx, relay, delayed()))
+		assert.EqualError(t, sendTo(ctx, relay, delayed()), echalotte.ErrMixingQueueFull)
+
+		select {
+		case <-forwarded:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message not forwarded")
+		}
+
+		assert.NoError(t, sendTo(ctx, relay, delayed()))
+	})
+}
@@ -0,0 +1,927 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: pb/descriptor.proto
+
+package echalotte_pb
+
+import (
+	fmt "fmt"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	types "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+	io "io"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+
+// The bandwidth a relay is willing to dedicate to forwarding messages.
+type BandwidthClass int32
+
+const (
+	BandwidthClass_Unknown BandwidthClass = 0
+	BandwidthClass_Low     BandwidthClass = 1
+	BandwidthClass_Medium  BandwidthClass = 2
+	BandwidthClass_High    BandwidthClass = 3
+)
+
+var BandwidthClass_name = map[int32]string{
+	0: "Unknown",
+	1: "Low",
+	2: "Medium",
+	3: "High",
+}
+
+var BandwidthClass_value = map[string]int32{
+	"Unknown": 0,
+	"Low":     1,
+	"Medium":  2,
+	"High":    3,
+}
+
+func (x BandwidthClass) String() string {
+	return proto.EnumName(BandwidthClass_name, int32(x))
+}
+
+func (BandwidthClass) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d91d8caa66d1f29b, []int{0}
+}
+
+// A relay descriptor advertising the capabilities of a relay.
+type RelayDescriptor struct {
+	// Protocol versions supported by the relay.
+	Protocols []string `protobuf:"bytes,1,rep,name=protocols,proto3" json:"protocols,omitempty"`
+	// Roles of the relay (see echalotte.Roles).
+	Roles     uint32         `protobuf:"varint,2,opt,name=roles,proto3" json:"roles,omitempty"`
+	Bandwidth BandwidthClass `protobuf:"varint,3,opt,name=bandwidth,proto3,enum=echalotte.pb.BandwidthClass" json:"bandwidth,omitempty"`
+	// Peer IDs of relays run by the same operator.
+	Family [][]byte `protobuf:"bytes,4,rep,name=family,proto3" json:"family,omitempty"`
+	// Validity window of this descriptor.
+	ValidAfter *types.Timestamp `protobuf:"bytes,5,opt,name=valid_after,json=validAfter,proto3" json:"valid_after,omitempty"`
+	ValidUntil *types.Timestamp `protobuf:"bytes,6,opt,name=valid_until,json=validUntil,proto3" json:"valid_until,omitempty"`
+	CreatedAt  *types.Timestamp `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// Validity window of the relay's encryption key when the descriptor was
+	// published.
+	KeyNotBefore *types.Timestamp `protobuf:"bytes,8,opt,name=key_not_before,json=keyNotBefore,proto3" json:"key_not_before,omitempty"`
+	KeyNotAfter  *types.Timestamp `protobuf:"bytes,9,opt,name=key_not_after,json=keyNotAfter,proto3" json:"key_not_after,omitempty"`
+	SignatureKey []byte           `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature    []byte           `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *RelayDescriptor) Reset()         { *m = RelayDescriptor{} }
+func (m *RelayDescriptor) String() string { return proto.CompactTextString(m) }
+func (*RelayDescriptor) ProtoMessage()    {}
+func (*RelayDescriptor) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d91d8caa66d1f29b, []int{0}
+}
+func (m *RelayDescriptor) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *RelayDescriptor) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_RelayDescriptor.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *RelayDescriptor) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RelayDescriptor.Merge(m, src)
+}
+func (m *RelayDescriptor) XXX_Size() int {
+	return m.Size()
+}
+func (m *RelayDescriptor) XXX_DiscardUnknown() {
+	xxx_messageInfo_RelayDescriptor.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RelayDescriptor proto.InternalMessageInfo
+
+func (m *RelayDescriptor) GetProtocols() []string {
+	if m != nil {
+		return m.Protocols
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetRoles() uint32 {
+	if m != nil {
+		return m.Roles
+	}
+	return 0
+}
+
+func (m *RelayDescriptor) GetBandwidth() BandwidthClass {
+	if m != nil {
+		return m.Bandwidth
+	}
+	return BandwidthClass_Unknown
+}
+
+func (m *RelayDescriptor) GetFamily() [][]byte {
+	if m != nil {
+		return m.Family
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetValidAfter() *types.Timestamp {
+	if m != nil {
+		return m.ValidAfter
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetValidUntil() *types.Timestamp {
+	if m != nil {
+		return m.ValidUntil
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetCreatedAt() *types.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetKeyNotBefore() *types.Timestamp {
+	if m != nil {
+		return m.KeyNotBefore
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetKeyNotAfter() *types.Timestamp {
+	if m != nil {
+		return m.KeyNotAfter
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetSignatureKey() []byte {
+	if m != nil {
+		return m.SignatureKey
+	}
+	return nil
+}
+
+func (m *RelayDescriptor) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("echalotte.pb.BandwidthClass", BandwidthClass_name, BandwidthClass_value)
+	proto.RegisterType((*RelayDescriptor)(nil), "echalotte.pb.RelayDescriptor")
+}
+
+func init() { proto.RegisterFile("pb/descriptor.proto", fileDescriptor_d91d8caa66d1f29b) }
+
+var fileDescriptor_d91d8caa66d1f29b = []byte{
+	// 412 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x91, 0x3f, 0x8f, 0xd3, 0x40,
+	0x10, 0xc5, 0xb3, 0xe7, 0x5c, 0x72, 0x1e, 0x3b, 0x21, 0x5a, 0x10, 0x5a, 0x9d, 0x4e, 0xc6, 0x82,
+	0xc6, 0xa2, 0x70, 0xa4, 0xa3, 0xe2, 0x8f, 0x10, 0x17, 0x28, 0x90, 0xf8, 0x53, 0x58, 0x5c, 0x6d,
+	0xad, 0xe3, 0x49, 0xb2, 0xca, 0xc6, 0x6b, 0xad, 0x37, 0x44, 0xfe, 0x16, 0x48, 0x7c, 0x29, 0xca,
+	0x2b, 0x29, 0x51, 0xf2, 0x45, 0x50, 0xec, 0x38, 0x86, 0x2a, 0x57, 0xce, 0xbc, 0xdf, 0x7b, 0x9a,
+	0xa7, 0x81, 0x87, 0x79, 0x32, 0x4e, 0xb1, 0x98, 0x6a, 0x91, 0x1b, 0xa5, 0xc3, 0x5c, 0x2b, 0xa3,
+	0xa8, 0x8b, 0xd3, 0x05, 0x97, 0xca, 0x18, 0x0c, 0xf3, 0xe4, 0xf2, 0xc9, 0x5c, 0xa9, 0xb9, 0xc4,
+	0x71, 0xa5, 0x25, 0xeb, 0xd9, 0xd8, 0x88, 0x15, 0x16, 0x86, 0xaf, 0xf2, 0x1a, 0x7f, 0xfa, 0xb3,
+	0x0b, 0x0f, 0x22, 0x94, 0xbc, 0xfc, 0x70, 0x0c, 0xa2, 0x57, 0x60, 0x57, 0xe2, 0x54, 0xc9, 0x82,
+	0x11, 0xdf, 0x0a, 0xec, 0xa8, 0x5d, 0xd0, 0x47, 0x70, 0xae, 0x95, 0xc4, 0x82, 0x9d, 0xf9, 0x24,
+	0x18, 0x44, 0xf5, 0x40, 0x5f, 0x81, 0x9d, 0xf0, 0x2c, 0xdd, 0x88, 0xd4, 0x2c, 0x98, 0xe5, 0x93,
+	0x60, 0x78, 0x7d, 0x15, 0xfe, 0x7b, 0x4a, 0x38, 0x69, 0xe4, 0xf7, 0x92, 0x17, 0x45, 0xd4, 0xe2,
+	0xf4, 0x31, 0xf4, 0x66, 0x7c, 0x25, 0x64, 0xc9, 0xba, 0xbe, 0x15, 0xb8, 0xd1, 0x61, 0xa2, 0xaf,
+	0xc1, 0xf9, 0xce, 0xa5, 0x48, 0x63, 0x3e, 0x33, 0xa8, 0xd9, 0xb9, 0x4f, 0x02, 0xe7, 0xfa, 0x32,
+	0xac, 0x2b, 0x85, 0x4d, 0xa5, 0xf0, 0x5b, 0x53, 0x29, 0x82, 0x0a, 0xbf, 0xd9, 0xd3, 0xad, 0x79,
+	0x9d, 0x19, 0x21, 0x59, 0xef, 0x9e, 0xe6, 0xdb, 0x3d, 0x4d, 0x5f, 0x02, 0x4c, 0x35, 0x72, 0x83,
+	0x69, 0xcc, 0x0d, 0xeb, 0x9f, 0xf4, 0xda, 0x07, 0xfa, 0xc6, 0xd0, 0x77, 0x30, 0x5c, 0x62, 0x19,
+	0x67, 0xca, 0xc4, 0x09, 0xce, 0x94, 0x46, 0x76, 0x71, 0xd2, 0xee, 0x2e, 0xb1, 0xfc, 0xaa, 0xcc,
+	0xa4, 0xe2, 0xe9, 0x5b, 0x18, 0x34, 0x09, 0x75, 0x71, 0xfb, 0x64, 0x80, 0x53, 0x07, 0xd4, 0xcd,
+	0x9f, 0xc1, 0xa0, 0x10, 0xf3, 0x8c, 0x9b, 0xb5, 0xc6, 0x78, 0x89, 0x25, 0x03, 0x9f, 0x04, 0x6e,
+	0xe4, 0x1e, 0x97, 0x9f, 0xb0, 0xdc, 0xff, 0xf8, 0x38, 0x33, 0xa7, 0x02, 0xda, 0xc5, 0xf3, 0x37,
+	0x30, 0xfc, 0xff, 0x5d, 0xd4, 0x81, 0xfe, 0x6d, 0xb6, 0xcc, 0xd4, 0x26, 0x1b, 0x75, 0x68, 0x1f,
+	0xac, 0xcf, 0x6a, 0x33, 0x22, 0x14, 0xa0, 0xf7, 0x05, 0x53, 0xb1, 0x5e, 0x8d, 0xce, 0xe8, 0x05,
+	0x74, 0x3f, 0x8a, 0xf9, 0x62, 0x64, 0x4d, 0xd8, 0xaf, 0xad, 0x47, 0xee, 0xb6, 0x1e, 0xf9, 0xb3,
+	0xf5, 0xc8, 0x8f, 0x9d, 0xd7, 0xb9, 0xdb, 0x79, 0x9d, 0xdf, 0x3b, 0xaf, 0x93, 0xf4, 0xaa, 0xdb,
+	0x5f, 0xfc, 0x1d, 0x00, 0xaa, 0xa0, 0x2c, 0x24, 0xba, 0x02, 0x00, 0x00,
+}
+
+func (m *RelayDescriptor) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *RelayDescriptor) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Protocols) > 0 {
+		for _, s := range m.Protocols {
+			dAtA[i] = 0xa
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.Roles != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.Roles))
+	}
+	if m.Bandwidth != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.Bandwidth))
+	}
+	if len(m.Family) > 0 {
+		for _, b := range m.Family {
+			dAtA[i] = 0x22
+			i++
+			i = encodeVarintDescriptor(dAtA, i, uint64(len(b)))
+			i += copy(dAtA[i:], b)
+		}
+	}
+	if m.ValidAfter != nil {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.ValidAfter.Size()))
+		n1, err := m.ValidAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if m.ValidUntil != nil {
+		dAtA[i] = 0x32
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.ValidUntil.Size()))
+		n2, err := m.ValidUntil.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n2
+	}
+	if m.CreatedAt != nil {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.CreatedAt.Size()))
+		n3, err := m.CreatedAt.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n3
+	}
+	if m.KeyNotBefore != nil {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.KeyNotBefore.Size()))
+		n4, err := m.KeyNotBefore.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n4
+	}
+	if m.KeyNotAfter != nil {
+		dAtA[i] = 0x4a
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(m.KeyNotAfter.Size()))
+		n5, err := m.KeyNotAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n5
+	}
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintDescriptor(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	return i, nil
+}
+
+func encodeVarintDescriptor(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *RelayDescriptor) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Protocols) > 0 {
+		for _, s := range m.Protocols {
+			l = len(s)
+			n += 1 + l + sovDescriptor(uint64(l))
+		}
+	}
+	if m.Roles != 0 {
+		n += 1 + sovDescriptor(uint64(m.Roles))
+	}
+	if m.Bandwidth != 0 {
+		n += 1 + sovDescriptor(uint64(m.Bandwidth))
+	}
+	if len(m.Family) > 0 {
+		for _, b := range m.Family {
+			l = len(b)
+			n += 1 + l + sovDescriptor(uint64(l))
+		}
+	}
+	if m.ValidAfter != nil {
+		l = m.ValidAfter.Size()
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	if m.ValidUntil != nil {
+		l = m.ValidUntil.Size()
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	if m.CreatedAt != nil {
+		l = m.CreatedAt.Size()
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	if m.KeyNotBefore != nil {
+		l = m.KeyNotBefore.Size()
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	if m.KeyNotAfter != nil {
+		l = m.KeyNotAfter.Size()
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovDescriptor(uint64(l))
+	}
+	return n
+}
+
+func sovDescriptor(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozDescriptor(x uint64) (n int) {
+	return sovDescriptor(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *RelayDescriptor) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDescriptor
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: RelayDescriptor: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: RelayDescriptor: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Protocols", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Protocols = append(m.Protocols, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Roles", wireType)
+			}
+			m.Roles = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Roles |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Bandwidth", wireType)
+			}
+			m.Bandwidth = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Bandwidth |= BandwidthClass(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Family", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Family = append(m.Family, make([]byte, postIndex-iNdEx))
+			copy(m.Family[len(m.Family)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValidAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ValidAfter == nil {
+				m.ValidAfter = &types.Timestamp{}
+			}
+			if err := m.ValidAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValidUntil", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ValidUntil == nil {
+				m.ValidUntil = &types.Timestamp{}
+			}
+			if err := m.ValidUntil.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreatedAt", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.CreatedAt == nil {
+				m.CreatedAt = &types.Timestamp{}
+			}
+			if err := m.CreatedAt.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KeyNotBefore", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.KeyNotBefore == nil {
+				m.KeyNotBefore = &types.Timestamp{}
+			}
+			if err := m.KeyNotBefore.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KeyNotAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.KeyNotAfter == nil {
+				m.KeyNotAfter = &types.Timestamp{}
+			}
+			if err := m.KeyNotAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDescriptor(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthDescriptor
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipDescriptor(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowDescriptor
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+			return iNdEx, nil
+		case 1:
+			iNdEx += 8
+			return iNdEx, nil
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowDescriptor
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthDescriptor
+			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthDescriptor
+			}
+			return iNdEx, nil
+		case 3:
+			for {
+				var innerWire uint64
+				var start int = iNdEx
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return 0, ErrIntOverflowDescriptor
+					}
+					if iNdEx >= l {
+						return 0, io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					innerWire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				innerWireType := int(innerWire & 0x7)
+				if innerWireType == 4 {
+					break
+				}
+				next, err := skipDescriptor(dAtA[start:])
+				if err != nil {
+					return 0, err
+				}
+				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthDescriptor
+				}
+			}
+			return iNdEx, nil
+		case 4:
+			return iNdEx, nil
+		case 5:
+			iNdEx += 4
+			return iNdEx, nil
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+	}
+	panic("unreachable")
+}
+
+var (
+	ErrInvalidLengthDescriptor = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowDescriptor   = fmt.Errorf("proto: integer overflow")
+)
@@ -0,0 +1,1160 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: pb/directory.proto
+
+package echalotte_pb
+
+import (
+	fmt "fmt"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	types "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+	io "io"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+
+// A relay listed in a directory consensus.
+type DirectoryEntry struct {
+	PeerId        []byte   `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	Addrs         [][]byte `protobuf:"bytes,2,rep,name=addrs,proto3" json:"addrs,omitempty"`
+	EncryptionKey []byte   `protobuf:"bytes,3,opt,name=encryption_key,json=encryptionKey,proto3" json:"encryption_key,omitempty"`
+	Flags         []string `protobuf:"bytes,4,rep,name=flags,proto3" json:"flags,omitempty"`
+}
+
+func (m *DirectoryEntry) Reset()         { *m = DirectoryEntry{} }
+func (m *DirectoryEntry) String() string { return proto.CompactTextString(m) }
+func (*DirectoryEntry) ProtoMessage()    {}
+func (*DirectoryEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_08d7b02c4023a6af, []int{0}
+}
+func (m *DirectoryEntry) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *DirectoryEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_DirectoryEntry.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *DirectoryEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DirectoryEntry.Merge(m, src)
+}
+func (m *DirectoryEntry) XXX_Size() int {
+	return m.Size()
+}
+func (m *DirectoryEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_DirectoryEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DirectoryEntry proto.InternalMessageInfo
+
+func (m *DirectoryEntry) GetPeerId() []byte {
+	if m != nil {
+		return m.PeerId
+	}
+	return nil
+}
+
+func (m *DirectoryEntry) GetAddrs() [][]byte {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *DirectoryEntry) GetEncryptionKey() []byte {
+	if m != nil {
+		return m.EncryptionKey
+	}
+	return nil
+}
+
+func (m *DirectoryEntry) GetFlags() []string {
+	if m != nil {
+		return m.Flags
+	}
+	return nil
+}
+
+// A signature from a directory authority.
+type AuthoritySignature struct {
+	SignatureKey []byte `protobuf:"bytes,1,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature    []byte `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *AuthoritySignature) Reset()         { *m = AuthoritySignature{} }
+func (m *AuthoritySignature) String() string { return proto.CompactTextString(m) }
+func (*AuthoritySignature) ProtoMessage()    {}
+func (*AuthoritySignature) Descriptor() ([]byte, []int) {
+	return fileDescriptor_08d7b02c4023a6af, []int{1}
+}
+func (m *AuthoritySignature) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *AuthoritySignature) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_AuthoritySignature.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *AuthoritySignature) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuthoritySignature.Merge(m, src)
+}
+func (m *AuthoritySignature) XXX_Size() int {
+	return m.Size()
+}
+func (m *AuthoritySignature) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuthoritySignature.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuthoritySignature proto.InternalMessageInfo
+
+func (m *AuthoritySignature) GetSignatureKey() []byte {
+	if m != nil {
+		return m.SignatureKey
+	}
+	return nil
+}
+
+func (m *AuthoritySignature) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// A consensus document listing relays, signed by directory authorities.
+type Consensus struct {
+	ValidAfter *types.Timestamp      `protobuf:"bytes,1,opt,name=valid_after,json=validAfter,proto3" json:"valid_after,omitempty"`
+	FreshUntil *types.Timestamp      `protobuf:"bytes,2,opt,name=fresh_until,json=freshUntil,proto3" json:"fresh_until,omitempty"`
+	ValidUntil *types.Timestamp      `protobuf:"bytes,3,opt,name=valid_until,json=validUntil,proto3" json:"valid_until,omitempty"`
+	Relays     []*DirectoryEntry     `protobuf:"bytes,4,rep,name=relays,proto3" json:"relays,omitempty"`
+	Signatures []*AuthoritySignature `protobuf:"bytes,10,rep,name=signatures,proto3" json:"signatures,omitempty"`
+}
+
+func (m *Consensus) Reset()         { *m = Consensus{} }
+func (m *Consensus) String() string { return proto.CompactTextString(m) }
+func (*Consensus) ProtoMessage()    {}
+func (*Consensus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_08d7b02c4023a6af, []int{2}
+}
+func (m *Consensus) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Consensus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Consensus.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Consensus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Consensus.Merge(m, src)
+}
+func (m *Consensus) XXX_Size() int {
+	return m.Size()
+}
+func (m *Consensus) XXX_DiscardUnknown() {
+	xxx_messageInfo_Consensus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Consensus proto.InternalMessageInfo
+
+func (m *Consensus) GetValidAfter() *types.Timestamp {
+	if m != nil {
+		return m.ValidAfter
+	}
+	return nil
+}
+
+func (m *Consensus) GetFreshUntil() *types.Timestamp {
+	if m != nil {
+		return m.FreshUntil
+	}
+	return nil
+}
+
+func (m *Consensus) GetValidUntil() *types.Timestamp {
+	if m != nil {
+		return m.ValidUntil
+	}
+	return nil
+}
+
+func (m *Consensus) GetRelays() []*DirectoryEntry {
+	if m != nil {
+		return m.Relays
+	}
+	return nil
+}
+
+func (m *Consensus) GetSignatures() []*AuthoritySignature {
+	if m != nil {
+		return m.Signatures
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*DirectoryEntry)(nil), "echalotte.pb.DirectoryEntry")
+	proto.RegisterType((*AuthoritySignature)(nil), "echalotte.pb.AuthoritySignature")
+	proto.RegisterType((*Consensus)(nil), "echalotte.pb.Consensus")
+}
+
+func init() { proto.RegisterFile("pb/directory.proto", fileDescriptor_08d7b02c4023a6af) }
+
+var fileDescriptor_08d7b02c4023a6af = []byte{
+	// 370 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x91, 0xcd, 0x4a, 0xfb, 0x40,
+	0x14, 0xc5, 0x9b, 0xe4, 0xff, 0xaf, 0x74, 0x9a, 0x76, 0x31, 0x08, 0x0e, 0xa5, 0xc4, 0x50, 0x11,
+	0xba, 0x4a, 0xa1, 0xba, 0x73, 0x63, 0xfd, 0x58, 0x88, 0xbb, 0xa8, 0xb8, 0x0c, 0x93, 0x66, 0x92,
+	0x0e, 0xa6, 0x99, 0x30, 0x33, 0x11, 0x02, 0x3e, 0x84, 0xcf, 0xe3, 0x13, 0xb8, 0xec, 0xd2, 0xa5,
+	0xb4, 0x2f, 0x22, 0x99, 0x31, 0xfd, 0xc0, 0x85, 0x2e, 0xcf, 0x9d, 0xf3, 0x3b, 0x73, 0x39, 0x17,
+	0xc0, 0x3c, 0x1c, 0x45, 0x94, 0x93, 0xa9, 0x64, 0xbc, 0xf4, 0x72, 0xce, 0x24, 0x83, 0x36, 0x99,
+	0xce, 0x70, 0xca, 0xa4, 0x24, 0x5e, 0x1e, 0xf6, 0x0e, 0x13, 0xc6, 0x92, 0x94, 0x8c, 0xd4, 0x5b,
+	0x58, 0xc4, 0x23, 0x49, 0xe7, 0x44, 0x48, 0x3c, 0xcf, 0xb5, 0x7d, 0xf0, 0x02, 0xba, 0x57, 0x75,
+	0xc2, 0x75, 0x26, 0x79, 0x09, 0x0f, 0xc0, 0x5e, 0x4e, 0x08, 0x0f, 0x68, 0x84, 0x0c, 0xd7, 0x18,
+	0xda, 0x7e, 0xb3, 0x92, 0x37, 0x11, 0xdc, 0x07, 0xff, 0x71, 0x14, 0x71, 0x81, 0x4c, 0xd7, 0x1a,
+	0xda, 0xbe, 0x16, 0xf0, 0x18, 0x74, 0x49, 0x36, 0xe5, 0x65, 0x2e, 0x29, 0xcb, 0x82, 0x27, 0x52,
+	0x22, 0x4b, 0x51, 0x9d, 0xcd, 0xf4, 0x96, 0x94, 0x15, 0x1c, 0xa7, 0x38, 0x11, 0xe8, 0x9f, 0x6b,
+	0x0d, 0x5b, 0xbe, 0x16, 0x83, 0x47, 0x00, 0x27, 0x85, 0x9c, 0x31, 0x4e, 0x65, 0x79, 0x47, 0x93,
+	0x0c, 0xcb, 0x82, 0x13, 0x78, 0x04, 0x3a, 0xa2, 0x16, 0x2a, 0x51, 0xef, 0x61, 0xaf, 0x87, 0x55,
+	0x60, 0x1f, 0xb4, 0xd6, 0x1a, 0x99, 0xca, 0xb0, 0x19, 0x0c, 0xde, 0x4c, 0xd0, 0xba, 0x64, 0x99,
+	0x20, 0x99, 0x28, 0x04, 0x3c, 0x03, 0xed, 0x67, 0x9c, 0xd2, 0x28, 0xc0, 0xb1, 0x24, 0x5c, 0xc5,
+	0xb5, 0xc7, 0x3d, 0x4f, 0x77, 0xe3, 0xd5, 0xdd, 0x78, 0xf7, 0x75, 0x37, 0x3e, 0x50, 0xf6, 0x49,
+	0xe5, 0xae, 0xe0, 0x98, 0x13, 0x31, 0x0b, 0x8a, 0x4c, 0xd2, 0x14, 0x99, 0xbf, 0xc3, 0xca, 0xfe,
+	0x50, 0xb9, 0x37, 0x3f, 0x6b, 0xd8, 0xfa, 0xe3, 0xcf, 0x1a, 0x3e, 0x05, 0x4d, 0x4e, 0x52, 0x5c,
+	0xea, 0xd2, 0xda, 0xe3, 0xbe, 0xb7, 0x7d, 0x5b, 0x6f, 0xf7, 0x6e, 0xfe, 0xb7, 0x17, 0x9e, 0x03,
+	0xb0, 0xee, 0x41, 0x20, 0xa0, 0x48, 0x77, 0x97, 0xfc, 0xd9, 0xb9, 0xbf, 0xc5, 0x5c, 0xa0, 0xf7,
+	0xa5, 0x63, 0x2c, 0x96, 0x8e, 0xf1, 0xb9, 0x74, 0x8c, 0xd7, 0x95, 0xd3, 0x58, 0xac, 0x9c, 0xc6,
+	0xc7, 0xca, 0x69, 0x84, 0x4d, 0xb5, 0xf1, 0xc9, 0xd7, 0x00, 0xac, 0xa2, 0x26, 0xeb, 0x79, 0x02,
+	0x00, 0x00,
+}
+
+func (m *DirectoryEntry) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *DirectoryEntry) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.PeerId) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(len(m.PeerId)))
+		i += copy(dAtA[i:], m.PeerId)
+	}
+	if len(m.Addrs) > 0 {
+		for _, b := range m.Addrs {
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintDirectory(dAtA, i, uint64(len(b)))
+			i += copy(dAtA[i:], b)
+		}
+	}
+	if len(m.EncryptionKey) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(len(m.EncryptionKey)))
+		i += copy(dAtA[i:], m.EncryptionKey)
+	}
+	if len(m.Flags) > 0 {
+		for _, s := range m.Flags {
+			dAtA[i] = 0x22
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	return i, nil
+}
+
+func (m *AuthoritySignature) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *AuthoritySignature) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	return i, nil
+}
+
+func (m *Consensus) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Consensus) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.ValidAfter != nil {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(m.ValidAfter.Size()))
+		n1, err := m.ValidAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if m.FreshUntil != nil {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(m.FreshUntil.Size()))
+		n2, err := m.FreshUntil.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n2
+	}
+	if m.ValidUntil != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintDirectory(dAtA, i, uint64(m.ValidUntil.Size()))
+		n3, err := m.ValidUntil.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n3
+	}
+	if len(m.Relays) > 0 {
+		for _, msg := range m.Relays {
+			dAtA[i] = 0x22
+			i++
+			i = encodeVarintDirectory(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Signatures) > 0 {
+		for _, msg := range m.Signatures {
+			dAtA[i] = 0x52
+			i++
+			i = encodeVarintDirectory(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func encodeVarintDirectory(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *DirectoryEntry) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.PeerId)
+	if l > 0 {
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	if len(m.Addrs) > 0 {
+		for _, b := range m.Addrs {
+			l = len(b)
+			n += 1 + l + sovDirectory(uint64(l))
+		}
+	}
+	l = len(m.EncryptionKey)
+	if l > 0 {
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	if len(m.Flags) > 0 {
+		for _, s := range m.Flags {
+			l = len(s)
+			n += 1 + l + sovDirectory(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *AuthoritySignature) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	return n
+}
+
+func (m *Consensus) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.ValidAfter != nil {
+		l = m.ValidAfter.Size()
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	if m.FreshUntil != nil {
+		l = m.FreshUntil.Size()
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	if m.ValidUntil != nil {
+		l = m.ValidUntil.Size()
+		n += 1 + l + sovDirectory(uint64(l))
+	}
+	if len(m.Relays) > 0 {
+		for _, e := range m.Relays {
+			l = e.Size()
+			n += 1 + l + sovDirectory(uint64(l))
+		}
+	}
+	if len(m.Signatures) > 0 {
+		for _, e := range m.Signatures {
+			l = e.Size()
+			n += 1 + l + sovDirectory(uint64(l))
+		}
+	}
+	return n
+}
+
+func sovDirectory(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozDirectory(x uint64) (n int) {
+	return sovDirectory(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *DirectoryEntry) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDirectory
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: DirectoryEntry: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: DirectoryEntry: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeerId", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PeerId = append(m.PeerId[:0], dAtA[iNdEx:postIndex]...)
+			if m.PeerId == nil {
+				m.PeerId = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Addrs = append(m.Addrs, make([]byte, postIndex-iNdEx))
+			copy(m.Addrs[len(m.Addrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EncryptionKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.EncryptionKey = append(m.EncryptionKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.EncryptionKey == nil {
+				m.EncryptionKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Flags", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Flags = append(m.Flags, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDirectory(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *AuthoritySignature) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDirectory
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: AuthoritySignature: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: AuthoritySignature: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDirectory(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Consensus) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowDirectory
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Consensus: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Consensus: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValidAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ValidAfter == nil {
+				m.ValidAfter = &types.Timestamp{}
+			}
+			if err := m.ValidAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FreshUntil", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.FreshUntil == nil {
+				m.FreshUntil = &types.Timestamp{}
+			}
+			if err := m.FreshUntil.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValidUntil", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ValidUntil == nil {
+				m.ValidUntil = &types.Timestamp{}
+			}
+			if err := m.ValidUntil.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Relays", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Relays = append(m.Relays, &DirectoryEntry{})
+			if err := m.Relays[len(m.Relays)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signatures", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signatures = append(m.Signatures, &AuthoritySignature{})
+			if err := m.Signatures[len(m.Signatures)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipDirectory(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthDirectory
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipDirectory(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowDirectory
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+			return iNdEx, nil
+		case 1:
+			iNdEx += 8
+			return iNdEx, nil
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowDirectory
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthDirectory
+			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthDirectory
+			}
+			return iNdEx, nil
+		case 3:
+			for {
+				var innerWire uint64
+				var start int = iNdEx
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return 0, ErrIntOverflowDirectory
+					}
+					if iNdEx >= l {
+						return 0, io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					innerWire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				innerWireType := int(innerWire & 0x7)
+				if innerWireType == 4 {
+					break
+				}
+				next, err := skipDirectory(dAtA[start:])
+				if err != nil {
+					return 0, err
+				}
+				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthDirectory
+				}
+			}
+			return iNdEx, nil
+		case 4:
+			return iNdEx, nil
+		case 5:
+			iNdEx += 4
+			return iNdEx, nil
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+	}
+	panic("unreachable")
+}
+
+var (
+	ErrInvalidLengthDirectory = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowDirectory   = fmt.Errorf("proto: integer overflow")
+)
@@ -27,14 +27,18 @@ type KeyType int32
 
 const (
 	KeyType_Curve25519 KeyType = 0
+	// X25519 combined with ML-KEM-768.
+	KeyType_X25519MLKEM768 KeyType = 1
 )
 
 var KeyType_name = map[int32]string{
 	0: "Curve25519",
+	1: "X25519MLKEM768",
 }
 
 var KeyType_value = map[string]int32{
-	"Curve25519": 0,
+	"Curve25519":     0,
+	"X25519MLKEM768": 1,
 }
 
 func (x KeyType) String() string {
@@ -47,11 +51,30 @@ func (KeyType) EnumDescriptor() ([]byte, []int) {
 
 // An encryption public key.
 type PublicKey struct {
-	Type         KeyType          `protobuf:"varint,1,opt,name=type,proto3,enum=echalotte.pb.KeyType" json:"type,omitempty"`
-	CreatedAt    *types.Timestamp `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
-	Data         []byte           `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
-	SignatureKey []byte           `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
-	Signature    []byte           `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
+	Type      KeyType          `protobuf:"varint,1,opt,name=type,proto3,enum=echalotte.pb.KeyType" json:"type,omitempty"`
+	CreatedAt *types.Timestamp `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Data      []byte           `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// Validity window of the key.
+	// Keys without bounds are valid until a newer key is published.
+	NotBefore *types.Timestamp `protobuf:"bytes,4,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  *types.Timestamp `protobuf:"bytes,5,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// Previous keys that must not be used anymore.
+	Revocations []*KeyRevocation `protobuf:"bytes,6,rep,name=revocations,proto3" json:"revocations,omitempty"`
+	// Short-lived keys published in advance, one per epoch.
+	// Senders should prefer the key of the current epoch.
+	EpochKeys []*EpochKey `protobuf:"bytes,7,rep,name=epoch_keys,json=epochKeys,proto3" json:"epoch_keys,omitempty"`
+	// Post-quantum key that senders can combine with the Curve25519 key.
+	Hybrid *HybridKey `protobuf:"bytes,8,opt,name=hybrid,proto3" json:"hybrid,omitempty"`
+	// Key announced in advance to replace the current one.
+	Next *NextKey `protobuf:"bytes,9,opt,name=next,proto3" json:"next,omitempty"`
+	// The peer also accepts layers encrypted to the key derived from its
+	// identity.
+	DerivedKey bool `protobuf:"varint,12,opt,name=derived_key,json=derivedKey,proto3" json:"derived_key,omitempty"`
+	// The peer only accepts layers encrypted to its epoch keys, the
+	// long-term key must not be used.
+	EpochKeysOnly bool   `protobuf:"varint,13,opt,name=epoch_keys_only,json=epochKeysOnly,proto3" json:"epoch_keys_only,omitempty"`
+	SignatureKey  []byte `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature     []byte `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
 }
 
 func (m *PublicKey) Reset()         { *m = PublicKey{} }
@@ -108,6 +131,62 @@ func (m *PublicKey) GetData() []byte {
 	return nil
 }
 
+func (m *PublicKey) GetNotBefore() *types.Timestamp {
+	if m != nil {
+		return m.NotBefore
+	}
+	return nil
+}
+
+func (m *PublicKey) GetNotAfter() *types.Timestamp {
+	if m != nil {
+		return m.NotAfter
+	}
+	return nil
+}
+
+func (m *PublicKey) GetRevocations() []*KeyRevocation {
+	if m != nil {
+		return m.Revocations
+	}
+	return nil
+}
+
+func (m *PublicKey) GetEpochKeys() []*EpochKey {
+	if m != nil {
+		return m.EpochKeys
+	}
+	return nil
+}
+
+func (m *PublicKey) GetHybrid() *HybridKey {
+	if m != nil {
+		return m.Hybrid
+	}
+	return nil
+}
+
+func (m *PublicKey) GetNext() *NextKey {
+	if m != nil {
+		return m.Next
+	}
+	return nil
+}
+
+func (m *PublicKey) GetDerivedKey() bool {
+	if m != nil {
+		return m.DerivedKey
+	}
+	return false
+}
+
+func (m *PublicKey) GetEpochKeysOnly() bool {
+	if m != nil {
+		return m.EpochKeysOnly
+	}
+	return false
+}
+
 func (m *PublicKey) GetSignatureKey() []byte {
 	if m != nil {
 		return m.SignatureKey
@@ -122,32 +201,340 @@ func (m *PublicKey) GetSignature() []byte {
 	return nil
 }
 
+// A revocation of a compromised encryption key.
+// It is signed independently of the key record that carries it.
+type KeyRevocation struct {
+	Data         []byte           `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	RevokedAt    *types.Timestamp `protobuf:"bytes,2,opt,name=revoked_at,json=revokedAt,proto3" json:"revoked_at,omitempty"`
+	Reason       string           `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	SignatureKey []byte           `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature    []byte           `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *KeyRevocation) Reset()         { *m = KeyRevocation{} }
+func (m *KeyRevocation) String() string { return proto.CompactTextString(m) }
+func (*KeyRevocation) ProtoMessage()    {}
+func (*KeyRevocation) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5f12ca58fa90a3e4, []int{1}
+}
+func (m *KeyRevocation) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *KeyRevocation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_KeyRevocation.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *KeyRevocation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KeyRevocation.Merge(m, src)
+}
+func (m *KeyRevocation) XXX_Size() int {
+	return m.Size()
+}
+func (m *KeyRevocation) XXX_DiscardUnknown() {
+	xxx_messageInfo_KeyRevocation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KeyRevocation proto.InternalMessageInfo
+
+func (m *KeyRevocation) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *KeyRevocation) GetRevokedAt() *types.Timestamp {
+	if m != nil {
+		return m.RevokedAt
+	}
+	return nil
+}
+
+func (m *KeyRevocation) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *KeyRevocation) GetSignatureKey() []byte {
+	if m != nil {
+		return m.SignatureKey
+	}
+	return nil
+}
+
+func (m *KeyRevocation) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// An encryption public key only valid during an epoch.
+// Relays delete the private key once the epoch is over, which provides
+// forward secrecy.
+type EpochKey struct {
+	Epoch     uint64           `protobuf:"varint,1,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	NotBefore *types.Timestamp `protobuf:"bytes,2,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  *types.Timestamp `protobuf:"bytes,3,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	Data      []byte           `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	// Post-quantum key of the epoch, for hosts using hybrid keys.
+	KemData []byte `protobuf:"bytes,5,opt,name=kem_data,json=kemData,proto3" json:"kem_data,omitempty"`
+}
+
+func (m *EpochKey) Reset()         { *m = EpochKey{} }
+func (m *EpochKey) String() string { return proto.CompactTextString(m) }
+func (*EpochKey) ProtoMessage()    {}
+func (*EpochKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5f12ca58fa90a3e4, []int{2}
+}
+func (m *EpochKey) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *EpochKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_EpochKey.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *EpochKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EpochKey.Merge(m, src)
+}
+func (m *EpochKey) XXX_Size() int {
+	return m.Size()
+}
+func (m *EpochKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_EpochKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EpochKey proto.InternalMessageInfo
+
+func (m *EpochKey) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *EpochKey) GetNotBefore() *types.Timestamp {
+	if m != nil {
+		return m.NotBefore
+	}
+	return nil
+}
+
+func (m *EpochKey) GetNotAfter() *types.Timestamp {
+	if m != nil {
+		return m.NotAfter
+	}
+	return nil
+}
+
+func (m *EpochKey) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *EpochKey) GetKemData() []byte {
+	if m != nil {
+		return m.KemData
+	}
+	return nil
+}
+
+// The post-quantum half of a hybrid encryption key.
+// The classical half is the record's Curve25519 key. Next and epoch keys
+// carry their own post-quantum half.
+type HybridKey struct {
+	Type    KeyType `protobuf:"varint,1,opt,name=type,proto3,enum=echalotte.pb.KeyType" json:"type,omitempty"`
+	KemData []byte  `protobuf:"bytes,2,opt,name=kem_data,json=kemData,proto3" json:"kem_data,omitempty"`
+}
+
+func (m *HybridKey) Reset()         { *m = HybridKey{} }
+func (m *HybridKey) String() string { return proto.CompactTextString(m) }
+func (*HybridKey) ProtoMessage()    {}
+func (*HybridKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5f12ca58fa90a3e4, []int{3}
+}
+func (m *HybridKey) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *HybridKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_HybridKey.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *HybridKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HybridKey.Merge(m, src)
+}
+func (m *HybridKey) XXX_Size() int {
+	return m.Size()
+}
+func (m *HybridKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_HybridKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HybridKey proto.InternalMessageInfo
+
+func (m *HybridKey) GetType() KeyType {
+	if m != nil {
+		return m.Type
+	}
+	return KeyType_Curve25519
+}
+
+func (m *HybridKey) GetKemData() []byte {
+	if m != nil {
+		return m.KemData
+	}
+	return nil
+}
+
+// An encryption public key announced before it replaces the current key.
+// Senders switch to it at not_before.
+type NextKey struct {
+	Data      []byte           `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	NotBefore *types.Timestamp `protobuf:"bytes,2,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  *types.Timestamp `protobuf:"bytes,3,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// Post-quantum key that replaces the current one along with data.
+	KemData []byte `protobuf:"bytes,4,opt,name=kem_data,json=kemData,proto3" json:"kem_data,omitempty"`
+}
+
+func (m *NextKey) Reset()         { *m = NextKey{} }
+func (m *NextKey) String() string { return proto.CompactTextString(m) }
+func (*NextKey) ProtoMessage()    {}
+func (*NextKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5f12ca58fa90a3e4, []int{4}
+}
+func (m *NextKey) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *NextKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_NextKey.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *NextKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NextKey.Merge(m, src)
+}
+func (m *NextKey) XXX_Size() int {
+	return m.Size()
+}
+func (m *NextKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_NextKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NextKey proto.InternalMessageInfo
+
+func (m *NextKey) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *NextKey) GetNotBefore() *types.Timestamp {
+	if m != nil {
+		return m.NotBefore
+	}
+	return nil
+}
+
+func (m *NextKey) GetNotAfter() *types.Timestamp {
+	if m != nil {
+		return m.NotAfter
+	}
+	return nil
+}
+
+func (m *NextKey) GetKemData() []byte {
+	if m != nil {
+		return m.KemData
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("echalotte.pb.KeyType", KeyType_name, KeyType_value)
 	proto.RegisterType((*PublicKey)(nil), "echalotte.pb.PublicKey")
+	proto.RegisterType((*KeyRevocation)(nil), "echalotte.pb.KeyRevocation")
+	proto.RegisterType((*EpochKey)(nil), "echalotte.pb.EpochKey")
+	proto.RegisterType((*HybridKey)(nil), "echalotte.pb.HybridKey")
+	proto.RegisterType((*NextKey)(nil), "echalotte.pb.NextKey")
 }
 
 func init() { proto.RegisterFile("pb/pubkey.proto", fileDescriptor_5f12ca58fa90a3e4) }
 
 var fileDescriptor_5f12ca58fa90a3e4 = []byte{
-	// 268 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8e, 0xbd, 0x4e, 0xc3, 0x30,
-	0x14, 0x85, 0x63, 0xa8, 0x40, 0xbd, 0x0d, 0x05, 0x59, 0x42, 0x32, 0x15, 0x32, 0x11, 0x2c, 0x81,
-	0xc1, 0x11, 0x41, 0x1d, 0x3a, 0x02, 0x63, 0x17, 0x14, 0x75, 0xaf, 0xec, 0xf4, 0x12, 0xa2, 0xa6,
-	0xc4, 0x4a, 0x1d, 0x24, 0xbf, 0x05, 0x8f, 0xd5, 0xb1, 0x23, 0x23, 0x4a, 0x5e, 0x04, 0xe1, 0xfe,
-	0xc0, 0x66, 0x9f, 0xf3, 0xe9, 0x9e, 0x0f, 0x4e, 0xb5, 0x8a, 0x74, 0xad, 0xe6, 0x68, 0x85, 0xae,
-	0x4a, 0x53, 0x52, 0x1f, 0xd3, 0x37, 0x59, 0x94, 0xc6, 0xa0, 0xd0, 0x6a, 0x70, 0x95, 0x95, 0x65,
-	0x56, 0x60, 0xe4, 0x3a, 0x55, 0xbf, 0x46, 0x26, 0x5f, 0xe0, 0xd2, 0xc8, 0x85, 0xde, 0xe0, 0xd7,
-	0x2b, 0x02, 0xdd, 0x97, 0x5a, 0x15, 0x79, 0x3a, 0x46, 0x4b, 0x6f, 0xa1, 0x63, 0xac, 0x46, 0x46,
-	0x02, 0x12, 0xf6, 0xe3, 0x73, 0xf1, 0xff, 0x96, 0x18, 0xa3, 0x9d, 0x58, 0x8d, 0x89, 0x43, 0xe8,
-	0x08, 0x20, 0xad, 0x50, 0x1a, 0x9c, 0x4d, 0xa5, 0x61, 0x07, 0x01, 0x09, 0x7b, 0xf1, 0x40, 0x6c,
-	0xe6, 0xc4, 0x6e, 0x4e, 0x4c, 0x76, 0x73, 0x49, 0x77, 0x4b, 0x3f, 0x1a, 0x4a, 0xa1, 0x33, 0x93,
-	0x46, 0xb2, 0xc3, 0x80, 0x84, 0x7e, 0xe2, 0xde, 0xf4, 0x06, 0x4e, 0x96, 0x79, 0xf6, 0x2e, 0x4d,
-	0x5d, 0xe1, 0x74, 0x8e, 0x96, 0x81, 0x2b, 0xfd, 0x7d, 0xf8, 0xab, 0x77, 0x09, 0xdd, 0xfd, 0x9f,
-	0xf5, 0x1c, 0xf0, 0x17, 0xdc, 0x5d, 0xc0, 0xf1, 0x56, 0x91, 0xf6, 0x01, 0x9e, 0xeb, 0xea, 0x03,
-	0xe3, 0xe1, 0xf0, 0x7e, 0x74, 0xe6, 0x3d, 0xb1, 0x55, 0xc3, 0xc9, 0xba, 0xe1, 0xe4, 0xbb, 0xe1,
-	0xe4, 0xb3, 0xe5, 0xde, 0xba, 0xe5, 0xde, 0x57, 0xcb, 0x3d, 0x75, 0xe4, 0x54, 0x1f, 0x7e, 0x02,
-	0x00, 0x00, 0xff, 0xff, 0x18, 0x4d, 0x25, 0xc2, 0x48, 0x01, 0x00, 0x00,
+	// 577 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0xcd, 0xb6, 0x6e, 0x12, 0x4f, 0x92, 0xb6, 0x5a, 0x41, 0x59, 0x0a, 0x72, 0xa3, 0x22, 0xa1,
+	0x80, 0x84, 0x23, 0x82, 0x4a, 0xe9, 0x81, 0x43, 0x0b, 0x95, 0x90, 0x4a, 0xf9, 0xb0, 0x7a, 0xe0,
+	0x66, 0xad, 0x93, 0x69, 0x62, 0x25, 0xf1, 0x5a, 0xf6, 0x26, 0xaa, 0xff, 0x05, 0x3f, 0x84, 0x3f,
+	0xc0, 0x99, 0x0b, 0xc7, 0xde, 0xe0, 0x88, 0x92, 0x3f, 0x82, 0x76, 0xe3, 0xb8, 0x71, 0x11, 0x2a,
+	0x45, 0x88, 0xdb, 0xce, 0xec, 0x9b, 0xb7, 0xcf, 0xf3, 0x9e, 0x61, 0x2d, 0xf4, 0x9a, 0xe1, 0xc8,
+	0xeb, 0x63, 0x62, 0x87, 0x91, 0x90, 0x82, 0x56, 0xb1, 0xdd, 0xe3, 0x03, 0x21, 0x25, 0xda, 0xa1,
+	0xb7, 0xb9, 0xd5, 0x15, 0xa2, 0x3b, 0xc0, 0xa6, 0xbe, 0xf3, 0x46, 0xa7, 0x4d, 0xe9, 0x0f, 0x31,
+	0x96, 0x7c, 0x18, 0xce, 0xe0, 0xdb, 0xdf, 0x0c, 0x30, 0xdf, 0x8d, 0xbc, 0x81, 0xdf, 0x3e, 0xc2,
+	0x84, 0x3e, 0x00, 0x43, 0x26, 0x21, 0x32, 0x52, 0x27, 0x8d, 0xd5, 0xd6, 0x4d, 0x7b, 0x91, 0xcb,
+	0x3e, 0xc2, 0xe4, 0x24, 0x09, 0xd1, 0xd1, 0x10, 0xba, 0x07, 0xd0, 0x8e, 0x90, 0x4b, 0xec, 0xb8,
+	0x5c, 0xb2, 0xa5, 0x3a, 0x69, 0x54, 0x5a, 0x9b, 0xf6, 0xec, 0x39, 0x7b, 0xfe, 0x9c, 0x7d, 0x32,
+	0x7f, 0xce, 0x31, 0x53, 0xf4, 0xbe, 0xa4, 0x14, 0x8c, 0x0e, 0x97, 0x9c, 0x2d, 0xd7, 0x49, 0xa3,
+	0xea, 0xe8, 0xb3, 0xa2, 0x0b, 0x84, 0x74, 0x3d, 0x3c, 0x15, 0x11, 0x32, 0xe3, 0x6a, 0xba, 0x40,
+	0xc8, 0x03, 0x0d, 0xa6, 0xbb, 0xa0, 0x0a, 0x97, 0x9f, 0x4a, 0x8c, 0xd8, 0xca, 0x95, 0x93, 0xe5,
+	0x40, 0xc8, 0x7d, 0x85, 0xa5, 0xcf, 0xa1, 0x12, 0xe1, 0x58, 0xb4, 0xb9, 0xf4, 0x45, 0x10, 0xb3,
+	0x62, 0x7d, 0xb9, 0x51, 0x69, 0xdd, 0xf9, 0xe5, 0xa3, 0x9d, 0x0c, 0xe3, 0x2c, 0xe2, 0xe9, 0x0e,
+	0x00, 0x86, 0xa2, 0xdd, 0x73, 0xfb, 0x98, 0xc4, 0xac, 0xa4, 0xa7, 0x37, 0xf2, 0xd3, 0x87, 0xea,
+	0x5e, 0x51, 0x98, 0x98, 0x9e, 0x62, 0xda, 0x84, 0x62, 0x2f, 0xf1, 0x22, 0xbf, 0xc3, 0xca, 0x5a,
+	0xeb, 0xad, 0xfc, 0xc8, 0x2b, 0x7d, 0xa7, 0x66, 0x52, 0x98, 0x32, 0x25, 0xc0, 0x33, 0xc9, 0x4c,
+	0x0d, 0xbf, 0x64, 0xca, 0x1b, 0x3c, 0x93, 0x0a, 0xac, 0x21, 0x74, 0x0b, 0x2a, 0x1d, 0x8c, 0xfc,
+	0x31, 0x76, 0x94, 0x28, 0x56, 0xad, 0x93, 0x46, 0xd9, 0x81, 0xb4, 0xa5, 0x0c, 0xbe, 0x0f, 0x6b,
+	0x17, 0x9a, 0x5d, 0x11, 0x0c, 0x12, 0x56, 0xd3, 0xa0, 0x5a, 0x26, 0xf0, 0x6d, 0x30, 0x48, 0xe8,
+	0x3d, 0xa8, 0xc5, 0x7e, 0x37, 0xe0, 0x72, 0x14, 0xa1, 0xa6, 0x02, 0xed, 0x55, 0x35, 0x6b, 0x2a,
+	0xb2, 0xbb, 0x60, 0x66, 0x35, 0xab, 0x68, 0xc0, 0x45, 0x63, 0xfb, 0x33, 0x81, 0x5a, 0x6e, 0x7b,
+	0x99, 0xef, 0x24, 0xef, 0xbb, 0xda, 0x69, 0xff, 0x8f, 0x63, 0x94, 0xa2, 0xf7, 0x25, 0xdd, 0x80,
+	0x62, 0x84, 0x3c, 0x16, 0x81, 0x0e, 0x92, 0xe9, 0xa4, 0xd5, 0xbf, 0xd0, 0xfe, 0x85, 0x40, 0x79,
+	0xee, 0x1d, 0xbd, 0x01, 0x2b, 0x7a, 0x39, 0x5a, 0xb7, 0xe1, 0xcc, 0x8a, 0x4b, 0x81, 0x5d, 0xfa,
+	0xeb, 0xc0, 0x2e, 0x5f, 0x23, 0xb0, 0xf3, 0x05, 0x1a, 0x0b, 0x0b, 0xbc, 0x0d, 0xe5, 0x3e, 0x0e,
+	0x5d, 0xdd, 0x5f, 0xd1, 0xfd, 0x52, 0x1f, 0x87, 0x2f, 0xb9, 0xe4, 0xdb, 0xef, 0xc1, 0xcc, 0xd2,
+	0x74, 0x9d, 0x5f, 0x7b, 0x91, 0x72, 0x29, 0x4f, 0xf9, 0x89, 0x40, 0x29, 0x8d, 0xdc, 0xef, 0xec,
+	0xfc, 0xef, 0x5b, 0x59, 0x94, 0x6b, 0xe4, 0xe4, 0x3e, 0x7c, 0x04, 0xa5, 0xf4, 0xd3, 0xe8, 0x2a,
+	0xc0, 0x8b, 0x51, 0x34, 0xc6, 0xd6, 0xce, 0xce, 0xe3, 0xbd, 0xf5, 0x02, 0xa5, 0xb0, 0xfa, 0x41,
+	0x9f, 0x8f, 0x5f, 0x1f, 0x1d, 0x1e, 0xef, 0x3e, 0x7d, 0xb6, 0x4e, 0x0e, 0xd8, 0xd7, 0x89, 0x45,
+	0xce, 0x27, 0x16, 0xf9, 0x31, 0xb1, 0xc8, 0xc7, 0xa9, 0x55, 0x38, 0x9f, 0x5a, 0x85, 0xef, 0x53,
+	0xab, 0xe0, 0x15, 0xb5, 0x82, 0x27, 0x3f, 0x07, 0x00, 0x42, 0x30, 0xf8, 0xdc, 0x6f, 0x05, 0x00,
+	0x00,
 }
 
 func (m *PublicKey) Marshal() (dAtA []byte, err error) {
@@ -178,80 +565,1379 @@ func (m *PublicKey) MarshalTo(dAtA []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
-		i += n1
-	}
-	if len(m.Data) > 0 {
-		dAtA[i] = 0x1a
-		i++
-		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Data)))
-		i += copy(dAtA[i:], m.Data)
-	}
-	if len(m.SignatureKey) > 0 {
-		dAtA[i] = 0x52
-		i++
-		i = encodeVarintPubkey(dAtA, i, uint64(len(m.SignatureKey)))
-		i += copy(dAtA[i:], m.SignatureKey)
-	}
-	if len(m.Signature) > 0 {
-		dAtA[i] = 0x5a
-		i++
-		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Signature)))
-		i += copy(dAtA[i:], m.Signature)
-	}
-	return i, nil
-}
-
-func encodeVarintPubkey(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
-	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
-}
-func (m *PublicKey) Size() (n int) {
-	if m == nil {
-		return 0
-	}
-	var l int
-	_ = l
-	if m.Type != 0 {
-		n += 1 + sovPubkey(uint64(m.Type))
-	}
-	if m.CreatedAt != nil {
-		l = m.CreatedAt.Size()
-		n += 1 + l + sovPubkey(uint64(l))
-	}
-	l = len(m.Data)
-	if l > 0 {
-		n += 1 + l + sovPubkey(uint64(l))
-	}
-	l = len(m.SignatureKey)
-	if l > 0 {
-		n += 1 + l + sovPubkey(uint64(l))
-	}
-	l = len(m.Signature)
-	if l > 0 {
-		n += 1 + l + sovPubkey(uint64(l))
+		i += n1
+	}
+	if len(m.Data) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Data)))
+		i += copy(dAtA[i:], m.Data)
+	}
+	if m.NotBefore != nil {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotBefore.Size()))
+		n2, err := m.NotBefore.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n2
+	}
+	if m.NotAfter != nil {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotAfter.Size()))
+		n3, err := m.NotAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n3
+	}
+	if len(m.Revocations) > 0 {
+		for _, msg := range m.Revocations {
+			dAtA[i] = 0x32
+			i++
+			i = encodeVarintPubkey(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.EpochKeys) > 0 {
+		for _, msg := range m.EpochKeys {
+			dAtA[i] = 0x3a
+			i++
+			i = encodeVarintPubkey(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Hybrid != nil {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.Hybrid.Size()))
+		n4, err := m.Hybrid.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n4
+	}
+	if m.Next != nil {
+		dAtA[i] = 0x4a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.Next.Size()))
+		n5, err := m.Next.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n5
+	}
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	if m.DerivedKey {
+		dAtA[i] = 0x60
+		i++
+		if m.DerivedKey {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.EpochKeysOnly {
+		dAtA[i] = 0x68
+		i++
+		if m.EpochKeysOnly {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	return i, nil
+}
+
+func (m *KeyRevocation) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *KeyRevocation) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Data) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Data)))
+		i += copy(dAtA[i:], m.Data)
+	}
+	if m.RevokedAt != nil {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.RevokedAt.Size()))
+		n6, err := m.RevokedAt.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n6
+	}
+	if len(m.Reason) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Reason)))
+		i += copy(dAtA[i:], m.Reason)
+	}
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	return i, nil
+}
+
+func (m *EpochKey) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *EpochKey) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Epoch != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.Epoch))
+	}
+	if m.NotBefore != nil {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotBefore.Size()))
+		n7, err := m.NotBefore.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n7
+	}
+	if m.NotAfter != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotAfter.Size()))
+		n8, err := m.NotAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n8
+	}
+	if len(m.Data) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Data)))
+		i += copy(dAtA[i:], m.Data)
+	}
+	if len(m.KemData) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.KemData)))
+		i += copy(dAtA[i:], m.KemData)
+	}
+	return i, nil
+}
+
+func (m *HybridKey) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *HybridKey) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Type != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.Type))
+	}
+	if len(m.KemData) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.KemData)))
+		i += copy(dAtA[i:], m.KemData)
+	}
+	return i, nil
+}
+
+func (m *NextKey) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *NextKey) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Data) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.Data)))
+		i += copy(dAtA[i:], m.Data)
+	}
+	if m.NotBefore != nil {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotBefore.Size()))
+		n9, err := m.NotBefore.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n9
+	}
+	if m.NotAfter != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(m.NotAfter.Size()))
+		n10, err := m.NotAfter.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n10
+	}
+	if len(m.KemData) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintPubkey(dAtA, i, uint64(len(m.KemData)))
+		i += copy(dAtA[i:], m.KemData)
+	}
+	return i, nil
+}
+
+func encodeVarintPubkey(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *PublicKey) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Type != 0 {
+		n += 1 + sovPubkey(uint64(m.Type))
+	}
+	if m.CreatedAt != nil {
+		l = m.CreatedAt.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.Data)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.NotBefore != nil {
+		l = m.NotBefore.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.NotAfter != nil {
+		l = m.NotAfter.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if len(m.Revocations) > 0 {
+		for _, e := range m.Revocations {
+			l = e.Size()
+			n += 1 + l + sovPubkey(uint64(l))
+		}
+	}
+	if len(m.EpochKeys) > 0 {
+		for _, e := range m.EpochKeys {
+			l = e.Size()
+			n += 1 + l + sovPubkey(uint64(l))
+		}
+	}
+	if m.Hybrid != nil {
+		l = m.Hybrid.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.Next != nil {
+		l = m.Next.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.DerivedKey {
+		n += 2
+	}
+	if m.EpochKeysOnly {
+		n += 2
+	}
+	return n
+}
+
+func (m *KeyRevocation) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Data)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.RevokedAt != nil {
+		l = m.RevokedAt.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.Reason)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	return n
+}
+
+func (m *EpochKey) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Epoch != 0 {
+		n += 1 + sovPubkey(uint64(m.Epoch))
+	}
+	if m.NotBefore != nil {
+		l = m.NotBefore.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.NotAfter != nil {
+		l = m.NotAfter.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.Data)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.KemData)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	return n
+}
+
+func (m *HybridKey) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Type != 0 {
+		n += 1 + sovPubkey(uint64(m.Type))
+	}
+	l = len(m.KemData)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	return n
+}
+
+func (m *NextKey) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Data)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.NotBefore != nil {
+		l = m.NotBefore.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	if m.NotAfter != nil {
+		l = m.NotAfter.Size()
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	l = len(m.KemData)
+	if l > 0 {
+		n += 1 + l + sovPubkey(uint64(l))
+	}
+	return n
+}
+
+func sovPubkey(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozPubkey(x uint64) (n int) {
+	return sovPubkey(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *PublicKey) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPubkey
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PublicKey: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PublicKey: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			}
+			m.Type = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Type |= KeyType(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreatedAt", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.CreatedAt == nil {
+				m.CreatedAt = &types.Timestamp{}
+			}
+			if err := m.CreatedAt.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Data = append(m.Data[:0], dAtA[iNdEx:postIndex]...)
+			if m.Data == nil {
+				m.Data = []byte{}
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NotBefore", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.NotBefore == nil {
+				m.NotBefore = &types.Timestamp{}
+			}
+			if err := m.NotBefore.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NotAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.NotAfter == nil {
+				m.NotAfter = &types.Timestamp{}
+			}
+			if err := m.NotAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Revocations", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Revocations = append(m.Revocations, &KeyRevocation{})
+			if err := m.Revocations[len(m.Revocations)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EpochKeys", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.EpochKeys = append(m.EpochKeys, &EpochKey{})
+			if err := m.EpochKeys[len(m.EpochKeys)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hybrid", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Hybrid == nil {
+				m.Hybrid = &HybridKey{}
+			}
+			if err := m.Hybrid.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Next", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Next == nil {
+				m.Next = &NextKey{}
+			}
+			if err := m.Next.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DerivedKey", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.DerivedKey = bool(v != 0)
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EpochKeysOnly", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.EpochKeysOnly = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPubkey(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *KeyRevocation) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPubkey
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: KeyRevocation: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: KeyRevocation: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Data = append(m.Data[:0], dAtA[iNdEx:postIndex]...)
+			if m.Data == nil {
+				m.Data = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RevokedAt", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.RevokedAt == nil {
+				m.RevokedAt = &types.Timestamp{}
+			}
+			if err := m.RevokedAt.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Reason", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Reason = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPubkey(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *EpochKey) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPubkey
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: EpochKey: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: EpochKey: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Epoch", wireType)
+			}
+			m.Epoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Epoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NotBefore", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.NotBefore == nil {
+				m.NotBefore = &types.Timestamp{}
+			}
+			if err := m.NotBefore.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NotAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.NotAfter == nil {
+				m.NotAfter = &types.Timestamp{}
+			}
+			if err := m.NotAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Data = append(m.Data[:0], dAtA[iNdEx:postIndex]...)
+			if m.Data == nil {
+				m.Data = []byte{}
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KemData", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KemData = append(m.KemData[:0], dAtA[iNdEx:postIndex]...)
+			if m.KemData == nil {
+				m.KemData = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPubkey(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
 	}
-	return n
-}
 
-func sovPubkey(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
 	}
-	return n
-}
-func sozPubkey(x uint64) (n int) {
-	return sovPubkey(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+	return nil
 }
-func (m *PublicKey) Unmarshal(dAtA []byte) error {
+func (m *HybridKey) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -266,7 +1952,7 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -274,10 +1960,10 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PublicKey: wiretype end group for non-group")
+			return fmt.Errorf("proto: HybridKey: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PublicKey: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: HybridKey: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
@@ -294,16 +1980,16 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Type |= (KeyType(b) & 0x7F) << shift
+				m.Type |= KeyType(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field CreatedAt", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field KemData", wireType)
 			}
-			var msglen int
+			var byteLen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowPubkey
@@ -313,26 +1999,80 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				byteLen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			if byteLen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
 				return ErrInvalidLengthPubkey
 			}
-			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.CreatedAt == nil {
-				m.CreatedAt = &types.Timestamp{}
+			m.KemData = append(m.KemData[:0], dAtA[iNdEx:postIndex]...)
+			if m.KemData == nil {
+				m.KemData = []byte{}
 			}
-			if err := m.CreatedAt.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPubkey(dAtA[iNdEx:])
+			if err != nil {
 				return err
 			}
-			iNdEx = postIndex
-		case 3:
+			if skippy < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *NextKey) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPubkey
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: NextKey: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: NextKey: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
 			if wireType != 2 {
 				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
 			}
@@ -346,7 +2086,7 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				byteLen |= (int(b) & 0x7F) << shift
+				byteLen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -355,6 +2095,9 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthPubkey
 			}
 			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -363,11 +2106,11 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				m.Data = []byte{}
 			}
 			iNdEx = postIndex
-		case 10:
+		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field NotBefore", wireType)
 			}
-			var byteLen int
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowPubkey
@@ -377,26 +2120,67 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				byteLen |= (int(b) & 0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if byteLen < 0 {
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
 				return ErrInvalidLengthPubkey
 			}
-			postIndex := iNdEx + byteLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
-			if m.SignatureKey == nil {
-				m.SignatureKey = []byte{}
+			if m.NotBefore == nil {
+				m.NotBefore = &types.Timestamp{}
+			}
+			if err := m.NotBefore.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
 			iNdEx = postIndex
-		case 11:
+		case 3:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field NotAfter", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPubkey
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.NotAfter == nil {
+				m.NotAfter = &types.Timestamp{}
+			}
+			if err := m.NotAfter.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KemData", wireType)
 			}
 			var byteLen int
 			for shift := uint(0); ; shift += 7 {
@@ -408,7 +2192,7 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				byteLen |= (int(b) & 0x7F) << shift
+				byteLen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -417,12 +2201,15 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthPubkey
 			}
 			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPubkey
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
-			if m.Signature == nil {
-				m.Signature = []byte{}
+			m.KemData = append(m.KemData[:0], dAtA[iNdEx:postIndex]...)
+			if m.KemData == nil {
+				m.KemData = []byte{}
 			}
 			iNdEx = postIndex
 		default:
@@ -434,6 +2221,9 @@ func (m *PublicKey) Unmarshal(dAtA []byte) error {
 			if skippy < 0 {
 				return ErrInvalidLengthPubkey
 			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthPubkey
+			}
 			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -500,10 +2290,13 @@ func skipPubkey(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			iNdEx += length
 			if length < 0 {
 				return 0, ErrInvalidLengthPubkey
 			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthPubkey
+			}
 			return iNdEx, nil
 		case 3:
 			for {
@@ -532,6 +2325,9 @@ func skipPubkey(dAtA []byte) (n int, err error) {
 					return 0, err
 				}
 				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthPubkey
+				}
 			}
 			return iNdEx, nil
 		case 4:
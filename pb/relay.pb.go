@@ -0,0 +1,567 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: pb/relay.proto
+
+package echalotte_pb
+
+import (
+	fmt "fmt"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	types "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+	io "io"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+
+// A relay record advertising an onion relay.
+// It contains a proof-of-work bound to the relay's peer ID and epoch.
+type RelayRecord struct {
+	Epoch        uint64           `protobuf:"varint,1,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Difficulty   uint32           `protobuf:"varint,2,opt,name=difficulty,proto3" json:"difficulty,omitempty"`
+	Nonce        uint64           `protobuf:"varint,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	CreatedAt    *types.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	SignatureKey []byte           `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature    []byte           `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *RelayRecord) Reset()         { *m = RelayRecord{} }
+func (m *RelayRecord) String() string { return proto.CompactTextString(m) }
+func (*RelayRecord) ProtoMessage()    {}
+func (*RelayRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0720dc4a6bb4abb5, []int{0}
+}
+func (m *RelayRecord) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *RelayRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_RelayRecord.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *RelayRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RelayRecord.Merge(m, src)
+}
+func (m *RelayRecord) XXX_Size() int {
+	return m.Size()
+}
+func (m *RelayRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_RelayRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RelayRecord proto.InternalMessageInfo
+
+func (m *RelayRecord) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *RelayRecord) GetDifficulty() uint32 {
+	if m != nil {
+		return m.Difficulty
+	}
+	return 0
+}
+
+func (m *RelayRecord) GetNonce() uint64 {
+	if m != nil {
+		return m.Nonce
+	}
+	return 0
+}
+
+func (m *RelayRecord) GetCreatedAt() *types.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *RelayRecord) GetSignatureKey() []byte {
+	if m != nil {
+		return m.SignatureKey
+	}
+	return nil
+}
+
+func (m *RelayRecord) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*RelayRecord)(nil), "echalotte.pb.RelayRecord")
+}
+
+func init() { proto.RegisterFile("pb/relay.proto", fileDescriptor_0720dc4a6bb4abb5) }
+
+var fileDescriptor_0720dc4a6bb4abb5 = []byte{
+	// 250 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8e, 0xb1, 0x4e, 0xc3, 0x30,
+	0x10, 0x86, 0x63, 0x28, 0x48, 0xbd, 0xa4, 0x0c, 0x16, 0x83, 0x55, 0x21, 0x13, 0xc1, 0x92, 0x29,
+	0x91, 0x60, 0x62, 0x84, 0x95, 0xcd, 0x62, 0xaf, 0x1c, 0xe7, 0x92, 0x46, 0xa4, 0x71, 0xe4, 0x5e,
+	0x86, 0xbc, 0x05, 0x8f, 0xc5, 0xd8, 0x0d, 0x46, 0x94, 0xbc, 0x08, 0x6a, 0x42, 0x4b, 0xc7, 0xff,
+	0xbf, 0xff, 0xd3, 0x7d, 0x70, 0xd5, 0xa4, 0x89, 0xc3, 0x4a, 0x77, 0x71, 0xe3, 0x2c, 0x59, 0x1e,
+	0xa0, 0x59, 0xeb, 0xca, 0x12, 0x61, 0xdc, 0xa4, 0xcb, 0xdb, 0xc2, 0xda, 0xa2, 0xc2, 0x64, 0xbc,
+	0xa5, 0x6d, 0x9e, 0x50, 0xb9, 0xc1, 0x2d, 0xe9, 0x4d, 0x33, 0xcd, 0xef, 0xbe, 0x18, 0xf8, 0x6a,
+	0x8f, 0x2b, 0x34, 0xd6, 0x65, 0xfc, 0x1a, 0x2e, 0xb0, 0xb1, 0x66, 0x2d, 0x58, 0xc8, 0xa2, 0x99,
+	0x9a, 0x02, 0x97, 0x00, 0x59, 0x99, 0xe7, 0xa5, 0x69, 0x2b, 0xea, 0xc4, 0x59, 0xc8, 0xa2, 0x85,
+	0x3a, 0x69, 0xf6, 0x54, 0x6d, 0x6b, 0x83, 0xe2, 0x7c, 0xa2, 0xc6, 0xc0, 0x9f, 0x00, 0x8c, 0x43,
+	0x4d, 0x98, 0xad, 0x34, 0x89, 0x59, 0xc8, 0x22, 0xff, 0x61, 0x19, 0x4f, 0x46, 0xf1, 0xc1, 0x28,
+	0x7e, 0x3b, 0x18, 0xa9, 0xf9, 0xdf, 0xfa, 0x99, 0xf8, 0x3d, 0x2c, 0xb6, 0x65, 0x51, 0x6b, 0x6a,
+	0x1d, 0xae, 0xde, 0xb1, 0x13, 0x10, 0xb2, 0x28, 0x50, 0xc1, 0xb1, 0x7c, 0xc5, 0x8e, 0xdf, 0xc0,
+	0xfc, 0x98, 0x85, 0x3f, 0x0e, 0xfe, 0x8b, 0x17, 0xf1, 0xd9, 0x4b, 0xb6, 0xeb, 0x25, 0xfb, 0xe9,
+	0x25, 0xfb, 0x18, 0xa4, 0xb7, 0x1b, 0xa4, 0xf7, 0x3d, 0x48, 0x2f, 0xbd, 0x1c, 0x7f, 0x3f, 0xfe,
+	0x0e, 0x00, 0x71, 0x36, 0x36, 0x64, 0x3b, 0x01, 0x00, 0x00,
+}
+
+func (m *RelayRecord) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *RelayRecord) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Epoch != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(m.Epoch))
+	}
+	if m.Difficulty != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(m.Difficulty))
+	}
+	if m.Nonce != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(m.Nonce))
+	}
+	if m.CreatedAt != nil {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(m.CreatedAt.Size()))
+		n1, err := m.CreatedAt.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintRelay(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	return i, nil
+}
+
+func encodeVarintRelay(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *RelayRecord) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Epoch != 0 {
+		n += 1 + sovRelay(uint64(m.Epoch))
+	}
+	if m.Difficulty != 0 {
+		n += 1 + sovRelay(uint64(m.Difficulty))
+	}
+	if m.Nonce != 0 {
+		n += 1 + sovRelay(uint64(m.Nonce))
+	}
+	if m.CreatedAt != nil {
+		l = m.CreatedAt.Size()
+		n += 1 + l + sovRelay(uint64(l))
+	}
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovRelay(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovRelay(uint64(l))
+	}
+	return n
+}
+
+func sovRelay(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozRelay(x uint64) (n int) {
+	return sovRelay(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *RelayRecord) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRelay
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: RelayRecord: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: RelayRecord: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Epoch", wireType)
+			}
+			m.Epoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Epoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Difficulty", wireType)
+			}
+			m.Difficulty = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Difficulty |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Nonce", wireType)
+			}
+			m.Nonce = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Nonce |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreatedAt", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRelay
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRelay
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.CreatedAt == nil {
+				m.CreatedAt = &types.Timestamp{}
+			}
+			if err := m.CreatedAt.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthRelay
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRelay
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthRelay
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRelay
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRelay(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRelay
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRelay
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipRelay(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowRelay
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+			return iNdEx, nil
+		case 1:
+			iNdEx += 8
+			return iNdEx, nil
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowRelay
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthRelay
+			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthRelay
+			}
+			return iNdEx, nil
+		case 3:
+			for {
+				var innerWire uint64
+				var start int = iNdEx
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return 0, ErrIntOverflowRelay
+					}
+					if iNdEx >= l {
+						return 0, io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					innerWire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				innerWireType := int(innerWire & 0x7)
+				if innerWireType == 4 {
+					break
+				}
+				next, err := skipRelay(dAtA[start:])
+				if err != nil {
+					return 0, err
+				}
+				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthRelay
+				}
+			}
+			return iNdEx, nil
+		case 4:
+			return iNdEx, nil
+		case 5:
+			iNdEx += 4
+			return iNdEx, nil
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+	}
+	panic("unreachable")
+}
+
+var (
+	ErrInvalidLengthRelay = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowRelay   = fmt.Errorf("proto: integer overflow")
+)
@@ -0,0 +1,549 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: pb/transparency.proto
+
+package echalotte_pb
+
+import (
+	fmt "fmt"
+	proto "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	types "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+	io "io"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+
+// The head of a key transparency log, signed by the log operator.
+type TreeHead struct {
+	// Number of records in the log.
+	TreeSize uint64 `protobuf:"varint,1,opt,name=tree_size,json=treeSize,proto3" json:"tree_size,omitempty"`
+	// Merkle tree root of the log (RFC 6962).
+	Root         []byte           `protobuf:"bytes,2,opt,name=root,proto3" json:"root,omitempty"`
+	Timestamp    *types.Timestamp `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	SignatureKey []byte           `protobuf:"bytes,10,opt,name=signature_key,json=signatureKey,proto3" json:"signature_key,omitempty"`
+	Signature    []byte           `protobuf:"bytes,11,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *TreeHead) Reset()         { *m = TreeHead{} }
+func (m *TreeHead) String() string { return proto.CompactTextString(m) }
+func (*TreeHead) ProtoMessage()    {}
+func (*TreeHead) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a86b56b5fd86747a, []int{0}
+}
+func (m *TreeHead) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *TreeHead) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_TreeHead.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *TreeHead) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TreeHead.Merge(m, src)
+}
+func (m *TreeHead) XXX_Size() int {
+	return m.Size()
+}
+func (m *TreeHead) XXX_DiscardUnknown() {
+	xxx_messageInfo_TreeHead.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TreeHead proto.InternalMessageInfo
+
+func (m *TreeHead) GetTreeSize() uint64 {
+	if m != nil {
+		return m.TreeSize
+	}
+	return 0
+}
+
+func (m *TreeHead) GetRoot() []byte {
+	if m != nil {
+		return m.Root
+	}
+	return nil
+}
+
+func (m *TreeHead) GetTimestamp() *types.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *TreeHead) GetSignatureKey() []byte {
+	if m != nil {
+		return m.SignatureKey
+	}
+	return nil
+}
+
+func (m *TreeHead) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*TreeHead)(nil), "echalotte.pb.TreeHead")
+}
+
+func init() { proto.RegisterFile("pb/transparency.proto", fileDescriptor_a86b56b5fd86747a) }
+
+var fileDescriptor_a86b56b5fd86747a = []byte{
+	// 238 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0x8d, 0x31, 0x4e, 0xc3, 0x30,
+	0x14, 0x86, 0x63, 0xa8, 0x50, 0xf3, 0x1a, 0x16, 0x4b, 0x48, 0x56, 0x41, 0x26, 0x82, 0x25, 0x93,
+	0x23, 0xc1, 0xc2, 0xcc, 0x84, 0xc4, 0x16, 0xba, 0x57, 0x4e, 0x79, 0x84, 0x88, 0x36, 0xb6, 0xec,
+	0xd7, 0x21, 0x3d, 0x05, 0xc7, 0xe1, 0x08, 0x8c, 0x1d, 0x19, 0x51, 0x72, 0x11, 0x84, 0xab, 0x26,
+	0x9b, 0xfd, 0xbd, 0x4f, 0xff, 0x07, 0x17, 0xb6, 0xcc, 0xc9, 0xe9, 0xc6, 0x5b, 0xed, 0xb0, 0x59,
+	0xb5, 0xca, 0x3a, 0x43, 0x86, 0x27, 0xb8, 0x7a, 0xd7, 0x6b, 0x43, 0x84, 0xca, 0x96, 0xf3, 0xeb,
+	0xca, 0x98, 0x6a, 0x8d, 0x79, 0xb8, 0x95, 0xdb, 0xb7, 0x9c, 0xea, 0x0d, 0x7a, 0xd2, 0x1b, 0x7b,
+	0xd0, 0x6f, 0xbe, 0x18, 0x4c, 0x17, 0x0e, 0xf1, 0x09, 0xf5, 0x2b, 0xbf, 0x84, 0x98, 0x1c, 0xe2,
+	0xd2, 0xd7, 0x3b, 0x14, 0x2c, 0x65, 0xd9, 0xa4, 0x98, 0xfe, 0x83, 0x97, 0x7a, 0x87, 0x9c, 0xc3,
+	0xc4, 0x19, 0x43, 0xe2, 0x24, 0x65, 0x59, 0x52, 0x84, 0x37, 0x7f, 0x80, 0x78, 0x18, 0x14, 0xa7,
+	0x29, 0xcb, 0x66, 0x77, 0x73, 0x75, 0x48, 0xaa, 0x63, 0x52, 0x2d, 0x8e, 0x46, 0x31, 0xca, 0xfc,
+	0x16, 0xce, 0x7d, 0x5d, 0x35, 0x9a, 0xb6, 0x0e, 0x97, 0x1f, 0xd8, 0x0a, 0x08, 0xb3, 0xc9, 0x00,
+	0x9f, 0xb1, 0xe5, 0x57, 0x10, 0x0f, 0x7f, 0x31, 0x0b, 0xc2, 0x08, 0x1e, 0xc5, 0x77, 0x27, 0xd9,
+	0xbe, 0x93, 0xec, 0xb7, 0x93, 0xec, 0xb3, 0x97, 0xd1, 0xbe, 0x97, 0xd1, 0x4f, 0x2f, 0xa3, 0xf2,
+	0x2c, 0xb4, 0xef, 0xff, 0x06, 0x00, 0x77, 0x05, 0xa3, 0x55, 0x23, 0x01, 0x00, 0x00,
+}
+
+func (m *TreeHead) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *TreeHead) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.TreeSize != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintTransparency(dAtA, i, uint64(m.TreeSize))
+	}
+	if len(m.Root) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintTransparency(dAtA, i, uint64(len(m.Root)))
+		i += copy(dAtA[i:], m.Root)
+	}
+	if m.Timestamp != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintTransparency(dAtA, i, uint64(m.Timestamp.Size()))
+		n1, err := m.Timestamp.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if len(m.SignatureKey) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintTransparency(dAtA, i, uint64(len(m.SignatureKey)))
+		i += copy(dAtA[i:], m.SignatureKey)
+	}
+	if len(m.Signature) > 0 {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintTransparency(dAtA, i, uint64(len(m.Signature)))
+		i += copy(dAtA[i:], m.Signature)
+	}
+	return i, nil
+}
+
+func encodeVarintTransparency(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *TreeHead) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.TreeSize != 0 {
+		n += 1 + sovTransparency(uint64(m.TreeSize))
+	}
+	l = len(m.Root)
+	if l > 0 {
+		n += 1 + l + sovTransparency(uint64(l))
+	}
+	if m.Timestamp != nil {
+		l = m.Timestamp.Size()
+		n += 1 + l + sovTransparency(uint64(l))
+	}
+	l = len(m.SignatureKey)
+	if l > 0 {
+		n += 1 + l + sovTransparency(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovTransparency(uint64(l))
+	}
+	return n
+}
+
+func sovTransparency(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozTransparency(x uint64) (n int) {
+	return sovTransparency(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *TreeHead) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowTransparency
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: TreeHead: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: TreeHead: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TreeSize", wireType)
+			}
+			m.TreeSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TreeSize |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Root", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Root = append(m.Root[:0], dAtA[iNdEx:postIndex]...)
+			if m.Root == nil {
+				m.Root = []byte{}
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Timestamp == nil {
+				m.Timestamp = &types.Timestamp{}
+			}
+			if err := m.Timestamp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignatureKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignatureKey = append(m.SignatureKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignatureKey == nil {
+				m.SignatureKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipTransparency(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthTransparency
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipTransparency(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowTransparency
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+			return iNdEx, nil
+		case 1:
+			iNdEx += 8
+			return iNdEx, nil
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowTransparency
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthTransparency
+			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthTransparency
+			}
+			return iNdEx, nil
+		case 3:
+			for {
+				var innerWire uint64
+				var start int = iNdEx
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return 0, ErrIntOverflowTransparency
+					}
+					if iNdEx >= l {
+						return 0, io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					innerWire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				innerWireType := int(innerWire & 0x7)
+				if innerWireType == 4 {
+					break
+				}
+				next, err := skipTransparency(dAtA[start:])
+				if err != nil {
+					return 0, err
+				}
+				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthTransparency
+				}
+			}
+			return iNdEx, nil
+		case 4:
+			return iNdEx, nil
+		case 5:
+			iNdEx += 4
+			return iNdEx, nil
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+	}
+	panic("unreachable")
+}
+
+var (
+	ErrInvalidLengthTransparency = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowTransparency   = fmt.Errorf("proto: integer overflow")
+)
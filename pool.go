@@ -0,0 +1,376 @@
+package echalotte
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+const (
+	// DefaultPoolSize is the default number of ready circuits kept by a
+	// CircuitPool.
+	DefaultPoolSize = 3
+
+	// DefaultCircuitLifetime is the default duration after which a pooled
+	// circuit is rotated.
+	DefaultCircuitLifetime = 10 * time.Minute
+
+	// DefaultCircuitMaxUses is the default number of messages that can be sent
+	// through a pooled circuit before it is rotated.
+	DefaultCircuitMaxUses = 20
+
+	// DefaultPoolRetryInterval is the default delay between two attempts to
+	// build circuits when the underlying builder fails.
+	DefaultPoolRetryInterval = 10 * time.Second
+)
+
+// Errors used by the CircuitPool.
+const (
+	ErrInvalidLifetime = "circuit lifetime should be strictly positive"
+	ErrInvalidMaxUses  = "circuit max uses should be strictly positive"
+	ErrInvalidPoolSize = "pool size should be strictly positive"
+	ErrInvalidRetry    = "pool retry interval should be strictly positive"
+)
+
+// PoolOption is a single circuit pool option.
+type PoolOption func(opts *PoolOptions) error
+
+// PoolOptions is a set of circuit pool options.
+type PoolOptions struct {
+	Size          int
+	Lifetime      time.Duration
+	MaxUses       int
+	RetryInterval time.Duration
+}
+
+// Apply the given options to this PoolOptions.
+func (opts *PoolOptions) Apply(options ...PoolOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PoolSize is an option to choose the number of ready circuits to keep.
+func PoolSize(size int) PoolOption {
+	return func(opts *PoolOptions) error {
+		if size <= 0 {
+			return errors.New(ErrInvalidPoolSize)
+		}
+
+		opts.Size = size
+		return nil
+	}
+}
+
+// CircuitLifetime is an option to choose how long a circuit can be used
+// before being rotated.
+func CircuitLifetime(lifetime time.Duration) PoolOption {
+	return func(opts *PoolOptions) error {
+		if lifetime <= 0 {
+			return errors.New(ErrInvalidLifetime)
+		}
+
+		opts.Lifetime = lifetime
+		return nil
+	}
+}
+
+// CircuitMaxUses is an option to choose how many times a circuit can be used
+// before being rotated.
+func CircuitMaxUses(uses int) PoolOption {
+	return func(opts *PoolOptions) error {
+		if uses <= 0 {
+			return errors.New(ErrInvalidMaxUses)
+		}
+
+		opts.MaxUses = uses
+		return nil
+	}
+}
+
+// PoolRetryInterval is an option to choose how long to wait before retrying
+// when circuits can't be built.
+func PoolRetryInterval(interval time.Duration) PoolOption {
+	return func(opts *PoolOptions) error {
+		if interval <= 0 {
+			return errors.New(ErrInvalidRetry)
+		}
+
+		opts.RetryInterval = interval
+		return nil
+	}
+}
+
+// pooledCircuit is a ready circuit with its usage statistics.
+type pooledCircuit struct {
+	circuit   Circuit
+	createdAt time.Time
+	uses      int
+}
+
+// CircuitPool keeps ready circuits built in the background to avoid paying
+// the circuit building latency on every message.
+// It implements CircuitBuilder so it can be used in place of the builder it
+// wraps.
+type CircuitPool struct {
+	builder CircuitBuilder
+	options PoolOptions
+
+	lock     sync.Mutex
+	circuits []*pooledCircuit
+	exclude  []peer.ID
+	refill   chan struct{}
+}
+
+// NewCircuitPool creates a circuit pool on top of the given builder.
+// Circuits are built in the background until the context is done.
+func NewCircuitPool(ctx context.Context, cb CircuitBuilder, opts ...PoolOption) (*CircuitPool, error) {
+	options := &PoolOptions{
+		Size:          DefaultPoolSize,
+		Lifetime:      DefaultCircuitLifetime,
+		MaxUses:       DefaultCircuitMaxUses,
+		RetryInterval: DefaultPoolRetryInterval,
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &CircuitPool{
+		builder: cb,
+		options: *options,
+		refill:  make(chan struct{}, 1),
+	}
+
+	go p.maintain(ctx)
+
+	return p, nil
+}
+
+// Build returns a ready circuit from the pool.
+// If no pooled circuit is usable, a circuit is built synchronously.
+// Custom circuit options bypass the pool since pooled circuits are built with
+// the underlying builder's defaults, except CircuitExclude: pooled circuits
+// that don't contain excluded peers can still be used.
+func (p *CircuitPool) Build(ctx context.Context, opts ...CircuitOption) (Circuit, error) {
+	exclude, ok := exclusionOnly(opts)
+	if !ok {
+		return p.builder.Build(ctx, opts...)
+	}
+
+	c, ok := p.take(exclude)
+	if ok {
+		return c, nil
+	}
+
+	log.Debug("No usable pooled circuit, building circuit synchronously")
+	p.triggerRefill()
+
+	return p.builder.Build(ctx, opts...)
+}
+
+// exclusionOnly returns the excluded peers if the given options only exclude
+// peers.
+func exclusionOnly(opts []CircuitOption) ([]peer.ID, bool) {
+	var options CircuitOptions
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, false
+	}
+
+	return options.Exclude, reflect.DeepEqual(options, CircuitOptions{Exclude: options.Exclude})
+}
+
+// Discard removes a circuit from the pool, for example after a failure to
+// send a message through it.
+// Pooled circuits using the same relays in a different order are removed
+// too, since hosts may reorder relays (see CheckRelayDescriptors).
+// A replacement circuit will be built in the background.
+func (p *CircuitPool) Discard(c Circuit) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, pc := range p.circuits {
+		if pc.circuit.sameRelays(c) {
+			p.circuits = append(p.circuits[:i], p.circuits[i+1:]...)
+			break
+		}
+	}
+
+	p.triggerRefill()
+}
+
+// Exclude peers from pooled circuits, for example the local host.
+// Pooled circuits containing them are discarded.
+// Connect excludes the host automatically.
+func (p *CircuitPool) Exclude(peers ...peer.ID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.exclude = append(p.exclude, peers...)
+
+	circuits := p.circuits[:0]
+	for _, pc := range p.circuits {
+		if !pc.circuit.contains(peers) {
+			circuits = append(circuits, pc)
+		}
+	}
+
+	if len(circuits) < len(p.circuits) {
+		p.triggerRefill()
+	}
+
+	p.circuits = circuits
+}
+
+// PeerEncryptionKey returns the encryption key of a relay if the underlying
+// builder knows it.
+func (p *CircuitPool) PeerEncryptionKey(relay peer.ID) (*[32]byte, bool) {
+	if provider, ok := p.builder.(EncryptionKeyProvider); ok {
+		return provider.PeerEncryptionKey(relay)
+	}
+
+	return nil, false
+}
+
+// Len returns the number of ready circuits.
+func (p *CircuitPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.circuits)
+}
+
+// take a circuit that doesn't contain excluded peers from the pool and update
+// its usage.
+// Circuits that reached their maximum number of uses are removed.
+func (p *CircuitPool) take(exclude []peer.ID) (Circuit, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.prune()
+
+	index := -1
+	for i, pc := range p.circuits {
+		if !pc.circuit.contains(exclude) {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return nil, false
+	}
+
+	// Round-robin between ready circuits.
+	pc := p.circuits[index]
+	p.circuits = append(append(p.circuits[:index:index], p.circuits[index+1:]...), pc)
+
+	pc.uses++
+	if pc.uses >= p.options.MaxUses {
+		p.circuits = p.circuits[:len(p.circuits)-1]
+		p.triggerRefill()
+	}
+
+	c := make(Circuit, len(pc.circuit))
+	copy(c, pc.circuit)
+
+	return c, true
+}
+
+// prune removes expired circuits.
+// The caller must hold the lock.
+func (p *CircuitPool) prune() {
+	now := time.Now()
+	circuits := p.circuits[:0]
+	for _, pc := range p.circuits {
+		if now.Sub(pc.createdAt) < p.options.Lifetime {
+			circuits = append(circuits, pc)
+		}
+	}
+
+	if len(circuits) < len(p.circuits) {
+		log.Debugf("Rotating %d expired circuit(s)", len(p.circuits)-len(circuits))
+		p.triggerRefill()
+	}
+
+	p.circuits = circuits
+}
+
+func (p *CircuitPool) triggerRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// missing returns the number of circuits needed to fill the pool.
+func (p *CircuitPool) missing() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.prune()
+	return p.options.Size - len(p.circuits)
+}
+
+// maintain keeps the pool filled with fresh circuits.
+func (p *CircuitPool) maintain(ctx context.Context) {
+	// Check regularly for expired circuits.
+	interval := p.options.Lifetime / 2
+	if interval <= 0 {
+		interval = p.options.Lifetime
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		retry := p.fill(ctx)
+
+		var retryChan <-chan time.Time
+		if retry {
+			retryChan = time.After(p.options.RetryInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.refill:
+		case <-ticker.C:
+		case <-retryChan:
+		}
+	}
+}
+
+// fill builds circuits until the pool is full.
+// It returns true if some circuits could not be built.
+func (p *CircuitPool) fill(ctx context.Context) bool {
+	for p.missing() > 0 {
+		p.lock.Lock()
+		exclude := append([]peer.ID(nil), p.exclude...)
+		p.lock.Unlock()
+
+		c, err := p.builder.Build(ctx, CircuitExclude(exclude...))
+		if err != nil {
+			log.Errorf("Could not build pooled circuit: %s", err.Error())
+			return true
+		}
+
+		p.lock.Lock()
+		p.circuits = append(p.circuits, &pooledCircuit{
+			circuit:   c,
+			createdAt: time.Now(),
+		})
+		p.lock.Unlock()
+	}
+
+	return false
+}
@@ -0,0 +1,244 @@
+package echalotte_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// countingCircuitBuilder builds a different single-relay circuit every time.
+type countingCircuitBuilder struct {
+	lock     sync.Mutex
+	count    int
+	fail     bool
+	excluded []peer.ID
+}
+
+func (cb *countingCircuitBuilder) Build(_ context.Context, opts ...echalotte.CircuitOption) (echalotte.Circuit, error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	options := &echalotte.CircuitOptions{}
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+
+	cb.excluded = options.Exclude
+
+	if cb.fail {
+		return nil, errors.New(echalottetesting.ErrBuildCircuit)
+	}
+
+	cb.count++
+	return echalotte.Circuit{peer.ID(string(rune('a' + cb.count)))}, nil
+}
+
+func (cb *countingCircuitBuilder) SetFailing(fail bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	cb.fail = fail
+}
+
+func (cb *countingCircuitBuilder) Excluded() []peer.ID {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return cb.excluded
+}
+
+func (cb *countingCircuitBuilder) Count() int {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return cb.count
+}
+
+func TestCircuitPool(t *testing.T) {
+	t.Run("rejects invalid options", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := echalottetesting.NewDummyCircuitBuilder(t)
+
+		_, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolSize(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidPoolSize)
+
+		_, err = echalotte.NewCircuitPool(ctx, cb, echalotte.CircuitMaxUses(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidMaxUses)
+
+		_, err = echalotte.NewCircuitPool(ctx, cb, echalotte.CircuitLifetime(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidLifetime)
+
+		_, err = echalotte.NewCircuitPool(ctx, cb, echalotte.PoolRetryInterval(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidRetry)
+	})
+
+	t.Run("fills pool in the background", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolSize(3))
+		require.NoError(t, err)
+
+		<-time.After(50 * time.Millisecond)
+		assert.Equal(t, 3, pool.Len())
+		assert.Equal(t, 3, cb.Count())
+
+		c, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.Len(t, c, 1)
+		assert.Equal(t, 3, cb.Count())
+	})
+
+	t.Run("builds synchronously when empty", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{fail: true}
+		pool, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolRetryInterval(time.Hour))
+		require.NoError(t, err)
+
+		<-time.After(20 * time.Millisecond)
+		assert.Equal(t, 0, pool.Len())
+
+		_, err = pool.Build(ctx)
+		assert.EqualError(t, err, echalottetesting.ErrBuildCircuit)
+
+		cb.SetFailing(false)
+
+		c, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.Len(t, c, 1)
+	})
+
+	t.Run("rotates circuits after max uses", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb,
+			echalotte.PoolSize(1),
+			echalotte.CircuitMaxUses(2),
+		)
+		require.NoError(t, err)
+
+		<-time.After(20 * time.Millisecond)
+
+		c1, err := pool.Build(ctx)
+		require.NoError(t, err)
+
+		c2, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, c1, c2)
+
+		<-time.After(20 * time.Millisecond)
+
+		c3, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, c1, c3)
+		assert.Equal(t, 2, cb.Count())
+	})
+
+	t.Run("rotates expired circuits", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb,
+			echalotte.PoolSize(1),
+			echalotte.CircuitLifetime(30*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		<-time.After(10 * time.Millisecond)
+
+		c1, err := pool.Build(ctx)
+		require.NoError(t, err)
+
+		<-time.After(60 * time.Millisecond)
+
+		c2, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, c1, c2)
+	})
+
+	t.Run("rebuilds discarded circuits", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolSize(1))
+		require.NoError(t, err)
+
+		<-time.After(10 * time.Millisecond)
+
+		c1, err := pool.Build(ctx)
+		require.NoError(t, err)
+
+		pool.Discard(c1)
+		<-time.After(10 * time.Millisecond)
+
+		c2, err := pool.Build(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, c1, c2)
+		assert.Equal(t, 2, cb.Count())
+	})
+
+	t.Run("skips pooled circuits containing excluded peers", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolSize(2))
+		require.NoError(t, err)
+
+		<-time.After(10 * time.Millisecond)
+		require.Equal(t, 2, cb.Count())
+
+		c1, err := pool.Build(ctx)
+		require.NoError(t, err)
+
+		c2, err := pool.Build(ctx, echalotte.CircuitExclude(c1[0]))
+		require.NoError(t, err)
+		assert.NotEqual(t, c1, c2)
+		assert.Equal(t, 2, cb.Count())
+
+		// Other options bypass the pool.
+		_, err = pool.Build(ctx, echalotte.CircuitSize(1))
+		require.NoError(t, err)
+		assert.Equal(t, 3, cb.Count())
+	})
+
+	t.Run("excludes peers from pooled circuits", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &countingCircuitBuilder{}
+		pool, err := echalotte.NewCircuitPool(ctx, cb, echalotte.PoolSize(2))
+		require.NoError(t, err)
+
+		<-time.After(10 * time.Millisecond)
+		require.Equal(t, 2, pool.Len())
+
+		count := cb.Count()
+
+		// Circuits going through excluded peers are replaced.
+		pool.Exclude(peer.ID(string(rune('a' + count))))
+
+		<-time.After(10 * time.Millisecond)
+		assert.Equal(t, 2, pool.Len())
+		assert.Equal(t, count+1, cb.Count())
+		assert.Equal(t, []peer.ID{peer.ID(string(rune('a' + count)))}, cb.Excluded())
+	})
+}
@@ -0,0 +1,216 @@
+package echalotte
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// DefaultKeyResolutionTimeout is the default deadline for resolving the
+// encryption keys of all the relays of a circuit.
+const DefaultKeyResolutionTimeout = 10 * time.Second
+
+// Errors used by key resolution.
+const (
+	ErrInvalidResolutionTimeout = "key resolution timeout should be strictly positive"
+	ErrInvalidReplacements      = "relay replacements should be positive"
+)
+
+// KeyResolutionError is returned when some relays' encryption keys can't be
+// resolved.
+type KeyResolutionError struct {
+	Failures map[peer.ID]error
+}
+
+// Error describes the first failure (in peer ID order).
+func (e *KeyResolutionError) Error() string {
+	var relays []peer.ID
+	for relay := range e.Failures {
+		relays = append(relays, relay)
+	}
+
+	sort.Slice(relays, func(i, j int) bool { return relays[i] < relays[j] })
+
+	msg := fmt.Sprintf("could not get encryption key for %s: %s", relays[0].Pretty(), e.Failures[relays[0]].Error())
+	if len(relays) > 1 {
+		msg = fmt.Sprintf("%s (and %d other relays)", msg, len(relays)-1)
+	}
+
+	return msg
+}
+
+// KeyResolutionTimeout is an option to choose the deadline for resolving
+// the encryption keys of a circuit.
+func KeyResolutionTimeout(timeout time.Duration) HostOption {
+	return func(opts *HostOptions) error {
+		if timeout <= 0 {
+			return errors.New(ErrInvalidResolutionTimeout)
+		}
+
+		opts.KeyResolutionTimeout = timeout
+		return nil
+	}
+}
+
+// ReplaceUnresolvedRelays is an option to build new circuits, without the
+// relays whose encryption keys can't be resolved, up to the given number of
+// times.
+func ReplaceUnresolvedRelays(attempts int) HostOption {
+	return func(opts *HostOptions) error {
+		if attempts < 0 {
+			return errors.New(ErrInvalidReplacements)
+		}
+
+		opts.RelayReplacements = attempts
+		return nil
+	}
+}
+
+// resolveKeys fetches the encryption keys of all the circuit's relays
+// concurrently.
+// On failure, it also returns the keys that could be resolved.
+func (h *Host) resolveKeys(ctx context.Context, circuit Circuit) (map[peer.ID]*[32]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.keyResolutionTimeout)
+	defer cancel()
+
+	var lock sync.Mutex
+	keys := make(map[peer.ID]*[32]byte)
+	failures := make(map[peer.ID]error)
+
+	var wg sync.WaitGroup
+	for _, relay := range circuit {
+		wg.Add(1)
+		go func(relay peer.ID) {
+			defer wg.Done()
+
+			key, err := h.peerEncryptionKey(ctx, relay)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				failures[relay] = err
+			} else {
+				keys[relay] = key
+			}
+		}(relay)
+	}
+
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return keys, &KeyResolutionError{Failures: failures}
+	}
+
+	return keys, nil
+}
+
+// buildCircuit builds a circuit and resolves the encryption keys of its
+// relays.
+// Relays whose keys can't be resolved are replaced if the host allows it:
+// the other relays are kept and the builder is only asked for replacements.
+func (h *Host) buildCircuit(ctx context.Context, exclude ...peer.ID) (Circuit, map[peer.ID]*[32]byte, error) {
+	exclude = append([]peer.ID(nil), exclude...)
+
+	circuit, err := h.circuitBuilder.Build(ctx, CircuitExclude(exclude...))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	circuit, err = h.checkCircuit(ctx, circuit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[peer.ID]*[32]byte, len(circuit))
+	for attempt := 0; ; attempt++ {
+		var unresolved Circuit
+		for _, relay := range circuit {
+			if _, ok := keys[relay]; !ok {
+				unresolved = append(unresolved, relay)
+			}
+		}
+
+		resolved, err := h.resolveKeys(ctx, unresolved)
+		if err == nil {
+			for relay, key := range resolved {
+				keys[relay] = key
+			}
+
+			return circuit, keys, nil
+		}
+
+		if attempt >= h.relayReplacements {
+			return nil, nil, err
+		}
+
+		h.discardCircuit(circuit)
+
+		failures := err.(*KeyResolutionError).Failures
+		for relay, failure := range failures {
+			log.Debugf("Replacing relay %s: %s", relay.Pretty(), failure.Error())
+		}
+
+		for relay, key := range resolved {
+			keys[relay] = key
+		}
+
+		circuit, err = h.replaceRelays(ctx, circuit, failures, append(exclude, circuit...))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// replaceRelays builds a new circuit where the failed relays are replaced
+// and the other ones are kept in place.
+func (h *Host) replaceRelays(ctx context.Context, circuit Circuit, failures map[peer.ID]error, exclude []peer.ID) (Circuit, error) {
+	replacements, err := h.circuitBuilder.Build(ctx, CircuitSize(len(failures)), CircuitExclude(exclude...))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(replacements) < len(failures) {
+		return nil, errors.New(ErrNotEnoughRelays)
+	}
+
+	replaced := append(Circuit(nil), circuit...)
+	for i, relay := range replaced {
+		if _, ok := failures[relay]; ok {
+			replaced[i] = replacements[0]
+			replacements = replacements[1:]
+		}
+	}
+
+	return h.checkCircuit(ctx, replaced)
+}
+
+// checkCircuit verifies the relay proofs and descriptors of the circuit's
+// relays, if the host requires them.
+// Circuits that fail verification are discarded.
+func (h *Host) checkCircuit(ctx context.Context, circuit Circuit) (Circuit, error) {
+	if h.relayRecord != nil {
+		err := h.checkRelayProofs(ctx, circuit)
+		if err != nil {
+			h.discardCircuit(circuit)
+			return nil, err
+		}
+	}
+
+	if h.verifyDescriptors {
+		checked, err := h.checkDescriptors(ctx, circuit)
+		if err != nil {
+			h.discardCircuit(circuit)
+			return nil, err
+		}
+
+		circuit = checked
+	}
+
+	return circuit, nil
+}
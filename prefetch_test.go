@@ -0,0 +1,234 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	ropts "gx/ipfs/QmTiRqrF5zkdZyrdsL5qndG1UbeWi8k8N2pYxCtXWrahR2/go-libp2p-routing/options"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// slowDHT delays reads.
+type slowDHT struct {
+	*echalottetesting.InMemoryDHT
+	delay time.Duration
+}
+
+func (dht *slowDHT) GetValue(ctx context.Context, key string, opts ...ropts.Option) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(dht.delay):
+	}
+
+	return dht.InMemoryDHT.GetValue(ctx, key, opts...)
+}
+
+// excludingCircuitBuilder builds circuits from the first non-excluded peers.
+// Requested sizes are only honored when they are smaller than its size.
+type excludingCircuitBuilder struct {
+	peers    []peer.ID
+	size     int
+	lastSize int
+}
+
+func (cb *excludingCircuitBuilder) Build(_ context.Context, opts ...echalotte.CircuitOption) (echalotte.Circuit, error) {
+	options := &echalotte.CircuitOptions{Size: cb.size}
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+
+	size := cb.size
+	if options.Size < size {
+		size = options.Size
+	}
+
+	cb.lastSize = size
+
+	var circuit echalotte.Circuit
+	for _, p := range cb.peers {
+		excluded := false
+		for _, e := range options.Exclude {
+			excluded = excluded || p == e
+		}
+
+		if !excluded && len(circuit) < size {
+			circuit = append(circuit, p)
+		}
+	}
+
+	if len(circuit) < size {
+		return nil, errors.New(echalotte.ErrNotEnoughRelays)
+	}
+
+	return circuit, nil
+}
+
+// newRelays creates relays, only publishing the encryption keys of the
+// first withKeys ones.
+func newRelays(ctx context.Context, t *testing.T, dht echalotte.DHT, count, withKeys int) []peer.ID {
+	var relays []peer.ID
+	for i := 0; i < count; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		relayID, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		if i < withKeys {
+			publishKey(ctx, t, dht, sk)
+		}
+
+		relays = append(relays, relayID)
+	}
+
+	return relays
+}
+
+func TestKeyResolution(t *testing.T) {
+	t.Run("reports every relay failure", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		relays := newRelays(ctx, t, dht, 5, 2)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, relays),
+		)
+		require.NoError(t, err)
+
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Et le ciel regardait la carcasse superbe"))
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "could not get encryption key"))
+		assert.True(t, strings.HasSuffix(err.Error(), "(and 2 other relays)"))
+
+		resolutionErr, ok := err.(*echalotte.KeyResolutionError)
+		require.True(t, ok)
+		assert.Len(t, resolutionErr.Failures, 3)
+		for _, relay := range relays[2:] {
+			assert.Contains(t, resolutionErr.Failures, relay)
+		}
+	})
+
+	t.Run("fetches keys concurrently", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := &slowDHT{
+			InMemoryDHT: echalottetesting.NewInMemoryDHT(),
+			delay:       100 * time.Millisecond,
+		}
+		relays := newRelays(ctx, t, dht, 5, 5)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, relays),
+		)
+		require.NoError(t, err)
+
+		start := time.Now()
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Comme une fleur s'épanouir."))
+
+		// Keys are resolved, but relays aren't reachable.
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "dial attempt failed"))
+		assert.True(t, time.Since(start) < 400*time.Millisecond)
+	})
+
+	t.Run("shares a deadline", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := &slowDHT{
+			InMemoryDHT: echalottetesting.NewInMemoryDHT(),
+			delay:       time.Second,
+		}
+		relays := newRelays(ctx, t, dht, 5, 5)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, relays),
+			echalotte.KeyResolutionTimeout(20*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		start := time.Now()
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("La puanteur était si forte,"))
+		require.Error(t, err)
+
+		resolutionErr, ok := err.(*echalotte.KeyResolutionError)
+		require.True(t, ok)
+		assert.Len(t, resolutionErr.Failures, 5)
+		assert.True(t, time.Since(start) < 500*time.Millisecond)
+	})
+
+	t.Run("replaces unresolved relays", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		withKeys := newRelays(ctx, t, dht, 3, 3)
+		withoutKeys := newRelays(ctx, t, dht, 2, 0)
+		cb := &excludingCircuitBuilder{
+			peers: append(withoutKeys, withKeys...),
+			size:  3,
+		}
+
+		_, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			cb,
+			echalotte.ReplaceUnresolvedRelays(-1),
+		)
+		assert.EqualError(t, err, echalotte.ErrInvalidReplacements)
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			cb,
+		)
+		require.NoError(t, err)
+
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Que sur l'herbe vous crûtes vous évanouir."))
+		require.Error(t, err)
+		_, ok := err.(*echalotte.KeyResolutionError)
+		assert.True(t, ok)
+
+		h, err = echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			cb,
+			echalotte.ReplaceUnresolvedRelays(1),
+		)
+		require.NoError(t, err)
+
+		// Keys are resolved after replacement, but relays aren't reachable.
+		err = h.SendMessage(ctx, peer.ID("alice"), []byte("Les mouches bourdonnaient sur ce ventre putride,"))
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "dial attempt failed"))
+
+		// Only the relays without keys were replaced.
+		assert.Equal(t, len(withoutKeys), cb.lastSize)
+	})
+}
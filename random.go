@@ -0,0 +1,53 @@
+package echalotte
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+)
+
+// cryptoSource is a math/rand source backed by crypto/rand.
+// It is safe for concurrent use.
+type cryptoSource struct{}
+
+func (cryptoSource) Seed(int64) {}
+
+func (s cryptoSource) Int63() int64 {
+	return int64(s.Uint64() & (1<<63 - 1))
+}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	_, err := crand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// lockedSource makes a math/rand source safe for concurrent use.
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.src.Seed(seed)
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.src.Int63()
+}
+
+// newCryptoRand returns a cryptographically secure random generator.
+func newCryptoRand() *rand.Rand {
+	return rand.New(cryptoSource{})
+}
@@ -0,0 +1,279 @@
+package echalotte
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+const (
+	// RelayNamespace is the namespace used for storing relay records on a DHT.
+	RelayNamespace = "relay"
+
+	// RelayEpochDuration is the duration of a relay epoch.
+	// Relays need to compute a new proof-of-work every epoch, which prevents
+	// attackers from accumulating cheap Sybil identities over time.
+	RelayEpochDuration = 24 * time.Hour
+
+	// DefaultRelayDifficulty is the default number of leading zero bits
+	// required in relay proofs-of-work.
+	DefaultRelayDifficulty = 20
+)
+
+// Errors used by the relay record validator.
+const (
+	ErrInvalidRelayEpoch = "invalid relay record epoch"
+	ErrInvalidRelayProof = "invalid relay proof-of-work"
+)
+
+// RelayEpoch returns the relay epoch containing the given time.
+func RelayEpoch(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(RelayEpochDuration/time.Second))
+}
+
+// relayProofHash hashes the proof-of-work input.
+func relayProofHash(peerID peer.ID, epoch uint64, nonce uint64) [32]byte {
+	b := make([]byte, len(peerID)+16)
+	copy(b, []byte(peerID))
+	binary.BigEndian.PutUint64(b[len(peerID):], epoch)
+	binary.BigEndian.PutUint64(b[len(peerID)+8:], nonce)
+
+	return sha256.Sum256(b)
+}
+
+// leadingZeros returns the number of leading zero bits in the given hash.
+func leadingZeros(h [32]byte) int {
+	n := 0
+	for _, b := range h {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+
+		n += 8
+	}
+
+	return n
+}
+
+// solveRelayProof finds a nonce satisfying the given difficulty.
+func solveRelayProof(peerID peer.ID, epoch uint64, difficulty int) uint64 {
+	var nonce uint64
+	for leadingZeros(relayProofHash(peerID, epoch, nonce)) < difficulty {
+		nonce++
+	}
+
+	return nonce
+}
+
+// RelayRecordValidator validates relay records before storing them in the
+// DHT.
+// Relay records contain a proof-of-work that makes it costly to advertise
+// many relays.
+type RelayRecordValidator struct {
+	// Difficulty is the minimum number of leading zero bits required in
+	// proofs-of-work. Defaults to DefaultRelayDifficulty.
+	Difficulty int
+}
+
+func (rv RelayRecordValidator) difficulty() int {
+	if rv.Difficulty <= 0 {
+		return DefaultRelayDifficulty
+	}
+
+	return rv.Difficulty
+}
+
+// CreateKey returns a namespaced DHT key for the given peer's relay record.
+func (rv RelayRecordValidator) CreateKey(peerID peer.ID) string {
+	return fmt.Sprintf("/%s/%s", RelayNamespace, peerID.Pretty())
+}
+
+// CreateRecord creates a relay record for the given epoch.
+// This computes a proof-of-work so it might take a while.
+func (rv RelayRecordValidator) CreateRecord(signingKey crypto.PrivKey, epoch uint64) ([]byte, error) {
+	peerID, err := peer.IDFromPrivateKey(signingKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	difficulty := rv.difficulty()
+	relayRecord := &pb.RelayRecord{
+		Epoch:      epoch,
+		Difficulty: uint32(difficulty),
+		Nonce:      solveRelayProof(peerID, epoch, difficulty),
+		CreatedAt:  ptypes.TimestampNow(),
+	}
+
+	toSign, err := proto.Marshal(relayRecord)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	relayRecord.Signature, err = signingKey.Sign(toSign)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	relayRecord.SignatureKey, err = signingKey.GetPublic().Bytes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	serialized, err := proto.Marshal(relayRecord)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return serialized, nil
+}
+
+// Validate the relay record.
+// The record must be signed by the relay, be for the current epoch (or an
+// adjacent one to tolerate clock skew) and contain a valid proof-of-work.
+func (rv RelayRecordValidator) Validate(key string, value []byte) error {
+	peerID, err := parseKey(key, RelayNamespace)
+	if err != nil {
+		return err
+	}
+
+	var relayRecord pb.RelayRecord
+	err = proto.Unmarshal(value, &relayRecord)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	current := RelayEpoch(time.Now())
+	if relayRecord.Epoch+1 < current || relayRecord.Epoch > current+1 {
+		return errors.New(ErrInvalidRelayEpoch)
+	}
+
+	difficulty := rv.difficulty()
+	if int(relayRecord.Difficulty) < difficulty {
+		return errors.New(ErrInvalidRelayProof)
+	}
+
+	proof := relayProofHash(peerID, relayRecord.Epoch, relayRecord.Nonce)
+	if leadingZeros(proof) < difficulty {
+		return errors.New(ErrInvalidRelayProof)
+	}
+
+	signatureKey := relayRecord.SignatureKey
+	signature := relayRecord.Signature
+
+	relayRecord.SignatureKey = nil
+	relayRecord.Signature = nil
+
+	signedBytes, err := proto.Marshal(&relayRecord)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return verifySignature(peerID, signatureKey, signature, signedBytes)
+}
+
+// Select the relay record with the most recent epoch.
+func (rv RelayRecordValidator) Select(_ string, values [][]byte) (int, error) {
+	i := 0
+	epoch := uint64(0)
+
+	for index, value := range values {
+		var relayRecord pb.RelayRecord
+		err := proto.Unmarshal(value, &relayRecord)
+		if err != nil {
+			continue
+		}
+
+		if relayRecord.Epoch > epoch {
+			i = index
+			epoch = relayRecord.Epoch
+		}
+	}
+
+	return i, nil
+}
+
+// RelayProofFilter returns a filter that only accepts relays that published
+// a valid relay record in the DHT.
+func RelayProofFilter(dht DHT, validator RelayRecordValidator) RelayFilter {
+	return func(ctx context.Context, relay peerstore.PeerInfo) bool {
+		key := validator.CreateKey(relay.ID)
+		record, err := dht.GetValue(ctx, key)
+		if err != nil {
+			log.Debugf("No relay record found for %s: %s", relay.ID.Pretty(), err.Error())
+			return false
+		}
+
+		err = validator.Validate(key, record)
+		if err != nil {
+			log.Debugf("Invalid relay record for %s: %s", relay.ID.Pretty(), err.Error())
+			return false
+		}
+
+		return true
+	}
+}
+
+// checkRelayProofs verifies that circuit relays published a valid relay
+// record, so that relays avoiding the proof-of-work are never used.
+func (h *Host) checkRelayProofs(ctx context.Context, circuit Circuit) error {
+	filter := RelayProofFilter(h.dht, *h.relayRecord)
+	for _, relay := range circuit {
+		if !filter(ctx, peerstore.PeerInfo{ID: relay}) {
+			return errors.Wrapf(errors.New(ErrInvalidRelayProof), "relay %s", relay.Pretty())
+		}
+	}
+
+	return nil
+}
+
+// publishRelayRecords publishes a relay record for every epoch until the
+// context is done.
+// Nothing is published while the host isn't a relay.
+func (h *Host) publishRelayRecords(ctx context.Context, validator RelayRecordValidator) {
+	for {
+		epoch := RelayEpoch(time.Now())
+
+		if h.Roles().Has(RoleRelay) {
+			h.publishRelayRecord(ctx, validator, epoch)
+		} else {
+			log.Debugf("Not a relay, skipping relay record for epoch %d", epoch)
+		}
+
+		nextEpoch := time.Unix(int64(epoch+1)*int64(RelayEpochDuration/time.Second), 0)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(nextEpoch)):
+		}
+	}
+}
+
+// publishRelayRecord publishes a relay record for the given epoch.
+func (h *Host) publishRelayRecord(ctx context.Context, validator RelayRecordValidator, epoch uint64) {
+	record, err := validator.CreateRecord(h.Peerstore().PrivKey(h.ID()), epoch)
+	if err != nil {
+		log.Errorf("Could not create relay record: %s", err.Error())
+		return
+	}
+
+	err = h.dht.PutValue(ctx, validator.CreateKey(h.ID()), record)
+	if err != nil {
+		log.Errorf("Could not publish relay record: %s", err.Error())
+		return
+	}
+
+	log.Infof("Relay record published for epoch %d", epoch)
+}
@@ -0,0 +1,167 @@
+package echalotte_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func TestRelayRecordValidator(t *testing.T) {
+	aliceSigPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	alice, err := peer.IDFromPrivateKey(aliceSigPrivKey)
+	require.NoError(t, err)
+
+	rv := echalotte.RelayRecordValidator{Difficulty: 8}
+	epoch := echalotte.RelayEpoch(time.Now())
+
+	aliceRecord, err := rv.CreateRecord(aliceSigPrivKey, epoch)
+	require.NoError(t, err)
+
+	t.Run("Validate()", func(t *testing.T) {
+		t.Run("Invalid key namespace", func(t *testing.T) {
+			err := rv.Validate(fmt.Sprintf("/enc/%s", alice.Pretty()), aliceRecord)
+			assert.EqualError(t, err, echalotte.ErrInvalidNamespace)
+		})
+
+		t.Run("Invalid message format", func(t *testing.T) {
+			err := rv.Validate(rv.CreateKey(alice), []byte{42})
+			assert.Error(t, err)
+		})
+
+		t.Run("Signature key mismatch", func(t *testing.T) {
+			_, pk, err := crypto.GenerateEd25519Key(rand.Reader)
+			require.NoError(t, err)
+
+			otherPeerID, err := peer.IDFromPublicKey(pk)
+			require.NoError(t, err)
+
+			err = rv.Validate(rv.CreateKey(otherPeerID), aliceRecord)
+			assert.Error(t, err)
+		})
+
+		t.Run("Expired epoch", func(t *testing.T) {
+			oldRecord, err := rv.CreateRecord(aliceSigPrivKey, epoch-2)
+			require.NoError(t, err)
+
+			err = rv.Validate(rv.CreateKey(alice), oldRecord)
+			assert.EqualError(t, err, echalotte.ErrInvalidRelayEpoch)
+		})
+
+		t.Run("Insufficient difficulty", func(t *testing.T) {
+			strictValidator := echalotte.RelayRecordValidator{Difficulty: 16}
+			err := strictValidator.Validate(rv.CreateKey(alice), aliceRecord)
+			assert.EqualError(t, err, echalotte.ErrInvalidRelayProof)
+		})
+
+		t.Run("Invalid proof", func(t *testing.T) {
+			var relayRecord pb.RelayRecord
+			require.NoError(t, proto.Unmarshal(aliceRecord, &relayRecord))
+
+			// A different nonce has a small chance of also satisfying the
+			// difficulty, so we try until we find an invalid one.
+			relayRecord.Nonce++
+			for i := 0; i < 1000; i++ {
+				invalidRecord, err := proto.Marshal(&relayRecord)
+				require.NoError(t, err)
+
+				err = rv.Validate(rv.CreateKey(alice), invalidRecord)
+				if err != nil && err.Error() == echalotte.ErrInvalidRelayProof {
+					return
+				}
+
+				relayRecord.Nonce++
+			}
+
+			assert.Fail(t, "proof should be invalid")
+		})
+
+		t.Run("Valid record", func(t *testing.T) {
+			err := rv.Validate(rv.CreateKey(alice), aliceRecord)
+			assert.NoError(t, err)
+		})
+	})
+
+	t.Run("Select()", func(t *testing.T) {
+		previousRecord, err := rv.CreateRecord(aliceSigPrivKey, epoch-1)
+		require.NoError(t, err)
+
+		i, err := rv.Select(rv.CreateKey(alice), [][]byte{previousRecord, []byte{42}, aliceRecord})
+		require.NoError(t, err)
+		assert.Equal(t, 2, i)
+	})
+
+	t.Run("RelayProofFilter()", func(t *testing.T) {
+		ctx := context.Background()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		err := dht.PutValue(ctx, rv.CreateKey(alice), aliceRecord)
+		require.NoError(t, err)
+
+		filter := echalotte.RelayProofFilter(dht, rv)
+		assert.True(t, filter(ctx, peerstore.PeerInfo{ID: alice}))
+		assert.False(t, filter(ctx, peerstore.PeerInfo{ID: peer.ID("bob")}))
+	})
+
+	t.Run("hosts publishing relay records require them", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.PublishRelayRecord(8),
+		)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			_, err := dht.GetValue(ctx, rv.CreateKey(relay.ID()))
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		unproven, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		for _, r := range []*echalotte.Host{relay, unproven} {
+			client, err := echalotte.Connect(
+				ctx,
+				echalottetesting.RandomHost(ctx, t),
+				dht,
+				echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{r.ID()}, echalotte.CircuitSize(1)),
+				echalotte.PublishRelayRecord(8),
+			)
+			require.NoError(t, err)
+
+			client.Peerstore().AddAddrs(r.ID(), r.Addrs(), peerstore.AddressTTL)
+			err = client.SendMessage(ctx, peer.ID("alice"), []byte("Il pleut sur la ville"))
+			if r == relay {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), echalotte.ErrInvalidRelayProof)
+			}
+		}
+	})
+}
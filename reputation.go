@@ -0,0 +1,419 @@
+package echalotte
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	"gx/ipfs/QmaoXrM4Z41PD48JY36YqQGKQpLGjyLA2cKcLsES7YddAq/go-libp2p-host"
+)
+
+const (
+	// ReputationNamespace is the datastore namespace used to persist relay
+	// reputation scores.
+	ReputationNamespace = "/echalotte/reputation"
+
+	// DefaultReputationHalfLife is the default duration after which past
+	// observations weigh half as much as new ones.
+	DefaultReputationHalfLife = 24 * time.Hour
+
+	// DefaultMinObservations is the default number of observations needed
+	// before a relay can be excluded.
+	DefaultMinObservations = 5
+
+	// DefaultExclusionThreshold is the default score below which a relay is
+	// excluded from circuits.
+	DefaultExclusionThreshold = 0.2
+
+	// DefaultFailureInterval is the default minimum duration between two
+	// failures counted against the same relay.
+	DefaultFailureInterval = time.Minute
+
+	// DefaultSuccessInterval is the default minimum duration between two
+	// successes counted for the same relay.
+	DefaultSuccessInterval = time.Minute
+)
+
+// Errors used by the reputation tracker.
+const (
+	ErrInvalidHalfLife  = "reputation half-life should be strictly positive"
+	ErrInvalidThreshold = "exclusion threshold should be between 0 and 1"
+)
+
+// Outcome of an interaction with a relay.
+type Outcome int
+
+// Possible outcomes of an interaction with a relay.
+const (
+	OutcomeSuccess Outcome = iota
+	OutcomeFailure
+)
+
+// ReputationOption is a single reputation option.
+type ReputationOption func(opts *ReputationOptions) error
+
+// ReputationOptions is a set of reputation options.
+type ReputationOptions struct {
+	Datastore          datastore.Datastore
+	HalfLife           time.Duration
+	MinObservations    float64
+	ExclusionThreshold float64
+	FailureInterval    time.Duration
+	SuccessInterval    time.Duration
+}
+
+// Apply the given options to this ReputationOptions.
+func (opts *ReputationOptions) Apply(options ...ReputationOption) error {
+	for _, o := range options {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReputationDatastore is an option to persist reputation scores in the given
+// datastore.
+func ReputationDatastore(ds datastore.Datastore) ReputationOption {
+	return func(opts *ReputationOptions) error {
+		opts.Datastore = ds
+		return nil
+	}
+}
+
+// ReputationHalfLife is an option to choose how fast old observations are
+// forgotten.
+func ReputationHalfLife(halfLife time.Duration) ReputationOption {
+	return func(opts *ReputationOptions) error {
+		if halfLife <= 0 {
+			return errors.New(ErrInvalidHalfLife)
+		}
+
+		opts.HalfLife = halfLife
+		return nil
+	}
+}
+
+// ReputationExclusion is an option to choose when relays are excluded: they
+// need at least minObservations observations and a score below threshold.
+func ReputationExclusion(minObservations int, threshold float64) ReputationOption {
+	return func(opts *ReputationOptions) error {
+		if threshold < 0 || threshold > 1 {
+			return errors.New(ErrInvalidThreshold)
+		}
+
+		opts.MinObservations = float64(minObservations)
+		opts.ExclusionThreshold = threshold
+		return nil
+	}
+}
+
+// ReputationFailureInterval is an option to choose the minimum duration
+// between two failures counted against the same relay.
+func ReputationFailureInterval(interval time.Duration) ReputationOption {
+	return func(opts *ReputationOptions) error {
+		opts.FailureInterval = interval
+		return nil
+	}
+}
+
+// ReputationSuccessInterval is an option to choose the minimum duration
+// between two successes counted for the same relay.
+// It prevents a relay from quickly building up a score that outweighs its
+// failures.
+func ReputationSuccessInterval(interval time.Duration) ReputationOption {
+	return func(opts *ReputationOptions) error {
+		opts.SuccessInterval = interval
+		return nil
+	}
+}
+
+// relayScore contains decayed observation counters for a relay.
+type relayScore struct {
+	Successes   float64
+	Failures    float64
+	UpdatedAt   time.Time
+	LastFailure time.Time
+	LastSuccess time.Time
+	Blacklisted bool
+}
+
+// decay the counters up to the given time.
+func (s *relayScore) decay(now time.Time, halfLife time.Duration) {
+	if s.UpdatedAt.IsZero() || !now.After(s.UpdatedAt) {
+		s.UpdatedAt = now
+		return
+	}
+
+	factor := math.Pow(0.5, float64(now.Sub(s.UpdatedAt))/float64(halfLife))
+	s.Successes *= factor
+	s.Failures *= factor
+	s.UpdatedAt = now
+}
+
+// value returns the smoothed success ratio.
+// Unknown relays start at a neutral 0.5.
+func (s *relayScore) value() float64 {
+	return (s.Successes + 1) / (s.Successes + s.Failures + 2)
+}
+
+// Reputation tracks relay reliability from locally observed outcomes.
+//
+// It is designed to resist poisoning: only first-hand observations should be
+// recorded (never reports from other peers), failures against a given relay
+// are rate-limited so that an attacker disrupting the network can't quickly
+// sink an honest relay's score, successes are rate-limited too so that a
+// relay can't drown its failures, relays are only excluded after enough
+// observations, and scores decay back towards neutral over time.
+type Reputation struct {
+	options ReputationOptions
+
+	lock   sync.Mutex
+	scores map[peer.ID]*relayScore
+}
+
+// NewReputation creates a reputation tracker.
+// If a datastore is provided, previously persisted scores are loaded.
+func NewReputation(opts ...ReputationOption) (*Reputation, error) {
+	options := &ReputationOptions{
+		HalfLife:           DefaultReputationHalfLife,
+		MinObservations:    DefaultMinObservations,
+		ExclusionThreshold: DefaultExclusionThreshold,
+		FailureInterval:    DefaultFailureInterval,
+		SuccessInterval:    DefaultSuccessInterval,
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reputation{
+		options: *options,
+		scores:  make(map[peer.ID]*relayScore),
+	}
+
+	err = r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Record the outcome of a direct interaction with a relay.
+func (r *Reputation) Record(relay peer.ID, outcome Outcome) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	s, ok := r.scores[relay]
+	if !ok {
+		s = &relayScore{}
+		r.scores[relay] = s
+	}
+
+	s.decay(now, r.options.HalfLife)
+
+	switch outcome {
+	case OutcomeSuccess:
+		if now.Sub(s.LastSuccess) < r.options.SuccessInterval {
+			return
+		}
+
+		s.Successes++
+		s.LastSuccess = now
+	case OutcomeFailure:
+		if now.Sub(s.LastFailure) < r.options.FailureInterval {
+			return
+		}
+
+		s.Failures++
+		s.LastFailure = now
+	}
+
+	r.persist(relay, s)
+}
+
+// Score returns the current score of a relay, between 0 and 1.
+// Relays we never interacted with have a neutral score of 0.5.
+func (r *Reputation) Score(relay peer.ID) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.scores[relay]
+	if !ok {
+		return (&relayScore{}).value()
+	}
+
+	s.decay(time.Now(), r.options.HalfLife)
+	return s.value()
+}
+
+// Blacklist a relay that we have proof of misbehaving.
+// Blacklisted relays are always excluded from circuits.
+// Only verifiable proofs should lead to blacklisting, never unverified
+// reports from other peers.
+func (r *Reputation) Blacklist(relay peer.ID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.scores[relay]
+	if !ok {
+		s = &relayScore{}
+		r.scores[relay] = s
+	}
+
+	s.Blacklisted = true
+	r.persist(relay, s)
+}
+
+// Blacklisted returns true if the relay has been blacklisted.
+func (r *Reputation) Blacklisted(relay peer.ID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.scores[relay]
+	return ok && s.Blacklisted
+}
+
+// Excluded returns true if the relay misbehaved often enough to be excluded
+// from circuits, or has been blacklisted.
+func (r *Reputation) Excluded(relay peer.ID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.scores[relay]
+	if !ok {
+		return false
+	}
+
+	if s.Blacklisted {
+		return true
+	}
+
+	s.decay(time.Now(), r.options.HalfLife)
+	if s.Successes+s.Failures < r.options.MinObservations {
+		return false
+	}
+
+	return s.value() < r.options.ExclusionThreshold
+}
+
+// Probe checks that a relay is reachable and records the outcome.
+func (r *Reputation) Probe(ctx context.Context, h host.Host, relay peer.ID) error {
+	err := h.Connect(ctx, peerstore.PeerInfo{ID: relay})
+	if err != nil {
+		r.Record(relay, OutcomeFailure)
+		return errors.WithStack(err)
+	}
+
+	r.Record(relay, OutcomeSuccess)
+	return nil
+}
+
+// filter removes excluded relays.
+// If too many relays would be removed to build a circuit of the given size,
+// the best excluded relays are kept: this prevents an attacker from starving
+// us of relays by disrupting the network.
+// Blacklisted relays are never kept.
+func (r *Reputation) filter(relays []peerstore.PeerInfo, size int) []peerstore.PeerInfo {
+	var kept, excluded []peerstore.PeerInfo
+	for _, relay := range relays {
+		if r.Blacklisted(relay.ID) {
+			continue
+		}
+
+		if r.Excluded(relay.ID) {
+			excluded = append(excluded, relay)
+		} else {
+			kept = append(kept, relay)
+		}
+	}
+
+	if len(excluded) > 0 {
+		log.Debugf("Excluding %d relay(s) because of their reputation", len(excluded))
+	}
+
+	for len(kept) < size && len(excluded) > 0 {
+		best := 0
+		for i := range excluded {
+			if r.Score(excluded[i].ID) > r.Score(excluded[best].ID) {
+				best = i
+			}
+		}
+
+		kept = append(kept, excluded[best])
+		excluded = append(excluded[:best], excluded[best+1:]...)
+	}
+
+	return kept
+}
+
+func (r *Reputation) datastoreKey(relay peer.ID) datastore.Key {
+	return datastore.NewKey(ReputationNamespace).ChildString(relay.Pretty())
+}
+
+// persist a relay score.
+// The caller must hold the lock.
+func (r *Reputation) persist(relay peer.ID, s *relayScore) {
+	if r.options.Datastore == nil {
+		return
+	}
+
+	value, err := json.Marshal(s)
+	if err != nil {
+		log.Errorf("Could not marshal reputation for %s: %s", relay.Pretty(), err.Error())
+		return
+	}
+
+	err = r.options.Datastore.Put(r.datastoreKey(relay), value)
+	if err != nil {
+		log.Errorf("Could not persist reputation for %s: %s", relay.Pretty(), err.Error())
+	}
+}
+
+// load persisted relay scores.
+func (r *Reputation) load() error {
+	if r.options.Datastore == nil {
+		return nil
+	}
+
+	results, err := r.options.Datastore.Query(query.Query{Prefix: ReputationNamespace})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	entries, err := results.Rest()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, entry := range entries {
+		relay, err := peer.IDB58Decode(datastore.NewKey(entry.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("Invalid reputation entry %s: %s", entry.Key, err.Error())
+			continue
+		}
+
+		var s relayScore
+		err = json.Unmarshal(entry.Value, &s)
+		if err != nil {
+			log.Errorf("Invalid reputation entry %s: %s", entry.Key, err.Error())
+			continue
+		}
+
+		r.scores[relay] = &s
+	}
+
+	log.Debugf("Loaded %d relay reputation(s)", len(r.scores))
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package echalotte_test
+
+import (
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "gx/ipfs/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+)
+
+func TestReputation(t *testing.T) {
+	_, pk, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	relay, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := echalotte.NewReputation(echalotte.ReputationHalfLife(0))
+		assert.EqualError(t, err, echalotte.ErrInvalidHalfLife)
+
+		_, err = echalotte.NewReputation(echalotte.ReputationExclusion(1, 1.5))
+		assert.EqualError(t, err, echalotte.ErrInvalidThreshold)
+	})
+
+	t.Run("unknown relays are neutral", func(t *testing.T) {
+		r, err := echalotte.NewReputation()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0.5, r.Score(relay))
+		assert.False(t, r.Excluded(relay))
+	})
+
+	t.Run("successes increase score", func(t *testing.T) {
+		r, err := echalotte.NewReputation()
+		require.NoError(t, err)
+
+		r.Record(relay, echalotte.OutcomeSuccess)
+		assert.True(t, r.Score(relay) > 0.5)
+	})
+
+	t.Run("successes are rate-limited", func(t *testing.T) {
+		r, err := echalotte.NewReputation(echalotte.ReputationSuccessInterval(time.Hour))
+		require.NoError(t, err)
+
+		r.Record(relay, echalotte.OutcomeSuccess)
+		score := r.Score(relay)
+
+		for i := 0; i < 10; i++ {
+			r.Record(relay, echalotte.OutcomeSuccess)
+		}
+
+		assert.InDelta(t, score, r.Score(relay), 0.001)
+	})
+
+	t.Run("failures are rate-limited", func(t *testing.T) {
+		r, err := echalotte.NewReputation(echalotte.ReputationFailureInterval(time.Hour))
+		require.NoError(t, err)
+
+		r.Record(relay, echalotte.OutcomeFailure)
+		score := r.Score(relay)
+		assert.True(t, score < 0.5)
+
+		for i := 0; i < 10; i++ {
+			r.Record(relay, echalotte.OutcomeFailure)
+		}
+
+		assert.InDelta(t, score, r.Score(relay), 0.001)
+	})
+
+	t.Run("excludes after enough observations", func(t *testing.T) {
+		r, err := echalotte.NewReputation(
+			echalotte.ReputationFailureInterval(0),
+			echalotte.ReputationExclusion(5, 0.2),
+		)
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			r.Record(relay, echalotte.OutcomeFailure)
+		}
+
+		assert.False(t, r.Excluded(relay))
+
+		for i := 0; i < 4; i++ {
+			r.Record(relay, echalotte.OutcomeFailure)
+		}
+
+		assert.True(t, r.Excluded(relay))
+	})
+
+	t.Run("blacklists relays", func(t *testing.T) {
+		r, err := echalotte.NewReputation()
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			r.Record(relay, echalotte.OutcomeSuccess)
+		}
+
+		assert.False(t, r.Excluded(relay))
+
+		r.Blacklist(relay)
+		assert.True(t, r.Blacklisted(relay))
+		assert.True(t, r.Excluded(relay))
+	})
+
+	t.Run("scores decay towards neutral", func(t *testing.T) {
+		r, err := echalotte.NewReputation(
+			echalotte.ReputationFailureInterval(0),
+			echalotte.ReputationHalfLife(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			r.Record(relay, echalotte.OutcomeFailure)
+		}
+
+		assert.True(t, r.Score(relay) < 0.2)
+
+		<-time.After(100 * time.Millisecond)
+		assert.InDelta(t, 0.5, r.Score(relay), 0.05)
+	})
+
+	t.Run("persists scores", func(t *testing.T) {
+		ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+		r1, err := echalotte.NewReputation(echalotte.ReputationDatastore(ds))
+		require.NoError(t, err)
+
+		r1.Record(relay, echalotte.OutcomeSuccess)
+		r1.Record(relay, echalotte.OutcomeSuccess)
+
+		r2, err := echalotte.NewReputation(echalotte.ReputationDatastore(ds))
+		require.NoError(t, err)
+
+		assert.InDelta(t, r1.Score(relay), r2.Score(relay), 0.001)
+	})
+}
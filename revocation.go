@@ -0,0 +1,166 @@
+package echalotte
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// Errors used by key revocation.
+const (
+	ErrKeyRevoked = "encryption key revoked"
+)
+
+// CreateRevocation creates a revocation for the given encryption key.
+// Revocations are published inside the peer's next key record.
+func (pkv PublicKeyValidator) CreateRevocation(signingKey crypto.PrivKey, encryptionKey *[32]byte, reason string) (*pb.KeyRevocation, error) {
+	revocation := &pb.KeyRevocation{
+		Data:      encryptionKey[:],
+		RevokedAt: ptypes.TimestampNow(),
+		Reason:    reason,
+	}
+
+	toSign, err := proto.Marshal(revocation)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	revocation.Signature, err = signingKey.Sign(toSign)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	revocation.SignatureKey, err = signingKey.GetPublic().Bytes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return revocation, nil
+}
+
+// verifyRevocation verifies that the revocation was signed by the given
+// peer.
+func verifyRevocation(peerID peer.ID, revocation *pb.KeyRevocation) error {
+	unsigned := *revocation
+	unsigned.SignatureKey = nil
+	unsigned.Signature = nil
+
+	signedBytes, err := proto.Marshal(&unsigned)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return verifySignature(peerID, revocation.SignatureKey, revocation.Signature, signedBytes)
+}
+
+// IsRevoked returns true if the given key appears in the revocations.
+func IsRevoked(publicKey *pb.PublicKey, revocations []*pb.KeyRevocation) bool {
+	return isRevokedKey(publicKey.Data, revocations)
+}
+
+// isRevokedKey returns true if the given key data appears in the
+// revocations.
+func isRevokedKey(data []byte, revocations []*pb.KeyRevocation) bool {
+	for _, revocation := range revocations {
+		if bytes.Equal(revocation.Data, data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RevokeEncryptionKey revokes the host's current encryption key and
+// replaces it with a fresh one.
+// Use it when the decryption key may have leaked: contrary to rotation, the
+// revoked key is immediately unusable, even by senders that cached it.
+// The post-quantum key, the announced next key and the epoch keys may have
+// leaked too: they are revoked and replaced as well.
+func (h *Host) RevokeEncryptionKey(ctx context.Context, reason string) error {
+	encryptionPublicKey, encryptionPrivateKey, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	hybrid, err := h.newHybridKeyFor()
+	if err != nil {
+		return err
+	}
+
+	h.keysLock.Lock()
+	revoked, err := h.EncryptionKey()
+	if err != nil {
+		h.keysLock.Unlock()
+		return err
+	}
+
+	revokedKeys := []*[32]byte{revoked}
+	if h.nextKey != nil {
+		revokedKeys = append(revokedKeys, h.nextKey.public)
+	}
+
+	for _, k := range h.epochKeys {
+		revokedKeys = append(revokedKeys, k.public)
+	}
+
+	for _, key := range revokedKeys {
+		revocation, err := h.validator.CreateRevocation(h.Peerstore().PrivKey(h.ID()), key, reason)
+		if err != nil {
+			h.keysLock.Unlock()
+			return err
+		}
+
+		h.revocations = append(h.revocations, revocation)
+	}
+
+	err = h.storeEncryptionKey(encryptionPublicKey, encryptionPrivateKey)
+	if err != nil {
+		h.keysLock.Unlock()
+		return err
+	}
+
+	h.hybridKey = hybrid
+
+	// A pending rotation fails instead of switching to the revoked key.
+	if h.nextKey != nil {
+		eraseKey(h.nextKey.key, h.nextKey.hybrid)
+		h.nextKey = nil
+	}
+
+	if h.keyEpochs != nil {
+		for _, k := range h.epochKeys {
+			eraseKey(k.key, k.hybrid)
+		}
+
+		h.epochKeys = nil
+		err = h.updateEpochKeys(time.Now())
+	}
+	h.keysLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = h.saveEncryptionKeys()
+	if err != nil {
+		return err
+	}
+
+	err = h.publishEncryptionKey(ctx, encryptionPublicKey)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Encryption key revoked: %s", reason)
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func TestKeyRevocation(t *testing.T) {
+	t.Run("Select() ignores revoked keys", func(t *testing.T) {
+		aliceSigPrivKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		alice, err := peer.IDFromPrivateKey(aliceSigPrivKey)
+		require.NoError(t, err)
+
+		leakedKey, _, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		leakedRecord, err := pkv.CreateRecord(aliceSigPrivKey, leakedKey)
+		require.NoError(t, err)
+
+		revocation, err := pkv.CreateRevocation(aliceSigPrivKey, leakedKey, "key leaked")
+		require.NoError(t, err)
+
+		// Build a record carrying the revocation by hand, as a host would.
+		freshKey, _, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		freshRecord, err := pkv.CreateRecord(aliceSigPrivKey, freshKey)
+		require.NoError(t, err)
+
+		var fresh pb.PublicKey
+		require.NoError(t, proto.Unmarshal(freshRecord, &fresh))
+		fresh.Revocations = []*pb.KeyRevocation{revocation}
+		fresh.CreatedAt.Seconds--
+		fresh.SignatureKey = nil
+		fresh.Signature = nil
+
+		toSign, err := proto.Marshal(&fresh)
+		require.NoError(t, err)
+
+		fresh.Signature, err = aliceSigPrivKey.Sign(toSign)
+		require.NoError(t, err)
+
+		fresh.SignatureKey, err = aliceSigPrivKey.GetPublic().Bytes()
+		require.NoError(t, err)
+
+		revokingRecord, err := proto.Marshal(&fresh)
+		require.NoError(t, err)
+
+		require.NoError(t, pkv.Validate(pkv.CreateKey(alice), revokingRecord))
+
+		// The leaked record is more recent but revoked.
+		i, err := pkv.Select(pkv.CreateKey(alice), [][]byte{leakedRecord, revokingRecord})
+		require.NoError(t, err)
+		assert.Equal(t, 1, i)
+
+		t.Run("forged revocation", func(t *testing.T) {
+			malloryKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+			require.NoError(t, err)
+
+			forged, err := pkv.CreateRevocation(malloryKey, freshKey, "forged")
+			require.NoError(t, err)
+
+			fresh.Revocations = append(fresh.Revocations, forged)
+			forgedRecord, err := proto.Marshal(&fresh)
+			require.NoError(t, err)
+
+			assert.Error(t, pkv.Validate(pkv.CreateKey(alice), forgedRecord))
+		})
+	})
+
+	t.Run("RevokeEncryptionKey()", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+
+		revokedKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+
+		require.NoError(t, h.RevokeEncryptionKey(ctx, "key leaked"))
+
+		newKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+		assert.NotEqual(t, revokedKey, newKey)
+
+		pkv := echalotte.PublicKeyValidator{}
+		record, err := dht.GetValue(ctx, pkv.CreateKey(h.ID()))
+		require.NoError(t, err)
+		require.NoError(t, pkv.Validate(pkv.CreateKey(h.ID()), record))
+
+		var published pb.PublicKey
+		require.NoError(t, proto.Unmarshal(record, &published))
+		assert.Equal(t, newKey[:], published.Data)
+		require.Len(t, published.Revocations, 1)
+		assert.Equal(t, revokedKey[:], published.Revocations[0].Data)
+		assert.Equal(t, "key leaked", published.Revocations[0].Reason)
+
+		// Messages encrypted with the revoked key are refused.
+		senderKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		sender, err := peer.IDFromPrivateKey(senderKey)
+		require.NoError(t, err)
+
+		m, err := echalotte.NewMessage(sender, senderKey, []byte("Cuisant à point,"))
+		require.NoError(t, err)
+
+		m, err = m.Encapsulate(h.ID(), revokedKey)
+		require.NoError(t, err)
+
+		assert.Error(t, sendTo(ctx, h, m))
+	})
+
+	t.Run("RevokeEncryptionKey() replaces epoch keys", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EpochEncryptionKeys(time.Hour, 1),
+		)
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		publishedKey := func() *pb.PublicKey {
+			record, err := dht.GetValue(ctx, pkv.CreateKey(h.ID()))
+			require.NoError(t, err)
+
+			var publicKey pb.PublicKey
+			require.NoError(t, proto.Unmarshal(record, &publicKey))
+			return &publicKey
+		}
+
+		revoked := publishedKey()
+		revokedEpochKey, err := echalotte.CurrentEpochKey(revoked, time.Now())
+		require.NoError(t, err)
+
+		require.NoError(t, h.RevokeEncryptionKey(ctx, "relay compromised"))
+
+		published := publishedKey()
+		require.Len(t, published.EpochKeys, len(revoked.EpochKeys))
+		assert.Len(t, published.Revocations, 1+len(revoked.EpochKeys))
+		for _, epochKey := range revoked.EpochKeys {
+			assert.True(t, echalotte.IsRevoked(&pb.PublicKey{Data: epochKey.Data}, published.Revocations))
+		}
+
+		epochKey, err := echalotte.CurrentEpochKey(published, time.Now())
+		require.NoError(t, err)
+		assert.NotEqual(t, revokedEpochKey, epochKey)
+
+		assert.NoError(t, sendTo(ctx, h, messageTo(t, h.ID(), epochKey)))
+		assert.Error(t, sendTo(ctx, h, messageTo(t, h.ID(), revokedEpochKey)))
+	})
+
+	t.Run("RevokeEncryptionKey() cancels the announced key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeyRotation(time.Hour, 200*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		pkv := echalotte.PublicKeyValidator{}
+		publishedKey := func() *pb.PublicKey {
+			record, err := dht.GetValue(ctx, pkv.CreateKey(h.ID()))
+			require.NoError(t, err)
+
+			var publicKey pb.PublicKey
+			require.NoError(t, proto.Unmarshal(record, &publicKey))
+			return &publicKey
+		}
+
+		rotated := make(chan error, 1)
+		go func() {
+			rotated <- h.RotateEncryptionKey(ctx)
+		}()
+
+		var announced *pb.PublicKey
+		for i := 0; i < 100 && (announced == nil || announced.Next == nil); i++ {
+			<-time.After(2 * time.Millisecond)
+			announced = publishedKey()
+		}
+
+		require.NotNil(t, announced.Next)
+		require.NoError(t, h.RevokeEncryptionKey(ctx, "key leaked"))
+
+		// The rotation doesn't switch to the revoked next key.
+		assert.EqualError(t, <-rotated, echalotte.ErrNextKeyReplaced)
+
+		published := publishedKey()
+		assert.Nil(t, published.Next)
+		assert.True(t, echalotte.IsRevoked(&pb.PublicKey{Data: announced.Next.Data}, published.Revocations))
+
+		current, err := h.EncryptionKey()
+		require.NoError(t, err)
+		assert.Equal(t, published.Data, current[:])
+	})
+
+	t.Run("KeyCache remembers revocations", func(t *testing.T) {
+		ctx := context.Background()
+
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		relay, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.HostWithIdentity(ctx, t, sk),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+		)
+		require.NoError(t, err)
+		defer h.Close()
+
+		revokedKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+
+		require.NoError(t, h.RevokeEncryptionKey(ctx, "key leaked"))
+
+		pkv := echalotte.PublicKeyValidator{}
+		cache, err := echalotte.NewKeyCache(dht)
+		require.NoError(t, err)
+
+		key, err := cache.Get(ctx, relay)
+		require.NoError(t, err)
+		assert.NotEqual(t, revokedKey, key)
+		require.Len(t, cache.Revocations(relay), 1)
+
+		// A record for the revoked key that doesn't carry the revocation is
+		// still refused, even once the revoking record was evicted.
+		cache.Invalidate(relay)
+
+		replayed, err := pkv.CreateRecord(sk, revokedKey)
+		require.NoError(t, err)
+		assert.EqualError(t, cache.Add(relay, replayed), echalotte.ErrKeyRevoked)
+	})
+}
@@ -0,0 +1,146 @@
+package echalotte
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+)
+
+// Roles of a host in the echalotte network.
+type Roles uint8
+
+// Available roles.
+const (
+	// RoleClient hosts send messages and receive messages addressed to them.
+	RoleClient Roles = 1 << iota
+
+	// RoleRelay hosts forward messages for other peers.
+	RoleRelay
+
+	// RoleExit hosts are relays that accept to be the last hop of circuits.
+	// Senders checking relay descriptors only use exits as last hops.
+	RoleExit
+)
+
+// RelayProtocolID is only supported by hosts with the RoleRelay role.
+// Every host supports ProtocolID to receive messages, so peers learnt via
+// identify should be selected as relays on this protocol instead.
+// Messages sent on it are handled like messages sent on ProtocolID.
+const RelayProtocolID = protocol.ID("/echalotte/relay/v1.0.0")
+
+// DefaultRoles are the roles of hosts that don't configure them.
+const DefaultRoles = RoleClient | RoleRelay | RoleExit
+
+// DefaultAdvertiseInterval is how often relays advertise themselves when
+// the discovery doesn't say how long advertisements last.
+const DefaultAdvertiseInterval = 10 * time.Minute
+
+// relayAdvertiser is implemented by circuit builders that can advertise the
+// host as an onion relay (see DiscoveryCircuitBuilder).
+type relayAdvertiser interface {
+	Advertise(context.Context) (time.Duration, error)
+}
+
+// Has returns true if all the given roles are set.
+func (r Roles) Has(roles Roles) bool {
+	return r&roles == roles
+}
+
+// String representation of the roles.
+func (r Roles) String() string {
+	var names []string
+	if r.Has(RoleClient) {
+		names = append(names, "client")
+	}
+
+	if r.Has(RoleRelay) {
+		names = append(names, "relay")
+	}
+
+	if r.Has(RoleExit) {
+		names = append(names, "exit")
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Roles returns the current roles of the host.
+func (h *Host) Roles() Roles {
+	h.rolesLock.RLock()
+	defer h.rolesLock.RUnlock()
+
+	return h.roles
+}
+
+// SetRoles changes the roles of the host.
+// Hosts that stop being relays immediately refuse to forward messages and
+// stop publishing relay records and advertisements, but advertisements that
+// were already published only disappear once they expire.
+// Hosts that become relays advertise themselves right away.
+func (h *Host) SetRoles(roles Roles) error {
+	if roles.Has(RoleExit) && !roles.Has(RoleRelay) {
+		return errors.New(ErrInvalidRoles)
+	}
+
+	h.rolesLock.Lock()
+	log.Infof("Host roles changed from %s to %s", h.roles, roles)
+	h.roles = roles
+	h.setRelayProtocol(roles)
+	h.rolesLock.Unlock()
+
+	select {
+	case h.rolesChanged <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// setRelayProtocol supports RelayProtocolID only if the roles include
+// RoleRelay.
+func (h *Host) setRelayProtocol(roles Roles) {
+	if roles.Has(RoleRelay) {
+		h.SetStreamHandler(RelayProtocolID, h.handleMessageStream)
+	} else {
+		h.RemoveStreamHandler(RelayProtocolID)
+	}
+}
+
+// advertiseRelay advertises the host as an onion relay through its circuit
+// builder while it has the RoleRelay role, until the context is done.
+// Advertisements are refreshed before they expire.
+func (h *Host) advertiseRelay(ctx context.Context) {
+	cb := h.circuitBuilder
+	if pool, ok := cb.(*CircuitPool); ok {
+		cb = pool.builder
+	}
+
+	advertiser, ok := cb.(relayAdvertiser)
+	if !ok {
+		return
+	}
+
+	for {
+		interval := DefaultAdvertiseInterval
+		if h.Roles().Has(RoleRelay) {
+			ttl, err := advertiser.Advertise(ctx)
+			if err != nil {
+				log.Errorf("Could not advertise onion relay: %s", err.Error())
+			} else if ttl > 0 {
+				interval = ttl / 2
+			}
+		} else {
+			log.Debug("Not a relay, skipping relay advertisement")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.rolesChanged:
+		case <-time.After(interval):
+		}
+	}
+}
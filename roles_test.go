@@ -0,0 +1,193 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// advertisingCircuitBuilder counts relay advertisements.
+type advertisingCircuitBuilder struct {
+	*echalottetesting.DummyCircuitBuilder
+	advertised chan struct{}
+}
+
+func (cb *advertisingCircuitBuilder) Advertise(context.Context) (time.Duration, error) {
+	cb.advertised <- struct{}{}
+	return time.Hour, nil
+}
+
+// sendTo sends an onion message directly to the host's message handler.
+func sendTo(ctx context.Context, h *echalotte.Host, m *echalotte.OnionMessage) error {
+	sender, receiver := echalottetesting.NewPipeStreams(echalotte.ProtocolID)
+	go func() {
+		json.NewEncoder(sender).Encode(m)
+	}()
+
+	return h.HandleMessage(ctx, receiver)
+}
+
+func TestRoles(t *testing.T) {
+	t.Run("String()", func(t *testing.T) {
+		assert.Equal(t, "client|relay|exit", echalotte.DefaultRoles.String())
+		assert.Equal(t, "relay|exit", (echalotte.RoleRelay | echalotte.RoleExit).String())
+	})
+
+	t.Run("Has()", func(t *testing.T) {
+		assert.True(t, echalotte.DefaultRoles.Has(echalotte.RoleRelay))
+		assert.True(t, echalotte.DefaultRoles.Has(echalotte.RoleRelay|echalotte.RoleExit))
+		assert.False(t, echalotte.RoleRelay.Has(echalotte.RoleRelay|echalotte.RoleExit))
+	})
+
+	t.Run("exit requires relay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.HostRoles(echalotte.RoleClient|echalotte.RoleExit),
+		)
+		assert.EqualError(t, err, echalotte.ErrInvalidRoles)
+	})
+
+	t.Run("client-only hosts refuse to forward", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.HostRoles(echalotte.RoleClient),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, echalotte.RoleClient, h.Roles())
+
+		senderKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		sender, err := peer.IDFromPrivateKey(senderKey)
+		require.NoError(t, err)
+
+		encryptionKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+
+		nextHopKey, _, err := box.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+
+		newMessage := func(forward bool) *echalotte.OnionMessage {
+			m, err := echalotte.NewMessage(sender, senderKey, []byte("Les jambes en l'air, comme une femme lubrique,"))
+			require.NoError(t, err)
+
+			if forward {
+				m, err = m.Encapsulate(peer.ID("next-hop"), nextHopKey)
+				require.NoError(t, err)
+			}
+
+			m, err = m.Encapsulate(h.ID(), encryptionKey)
+			require.NoError(t, err)
+
+			return m
+		}
+
+		// Messages addressed to the host are still received.
+		assert.NoError(t, sendTo(ctx, h, newMessage(false)))
+
+		err = sendTo(ctx, h, newMessage(true))
+		assert.EqualError(t, err, echalotte.ErrNotRelay)
+
+		// Relaying can be switched on and off at runtime.
+		require.NoError(t, h.SetRoles(echalotte.DefaultRoles))
+		assert.NoError(t, sendTo(ctx, h, newMessage(true)))
+
+		require.NoError(t, h.SetRoles(echalotte.RoleClient))
+		err = sendTo(ctx, h, newMessage(true))
+		assert.EqualError(t, err, echalotte.ErrNotRelay)
+
+		assert.EqualError(t, h.SetRoles(echalotte.RoleExit), echalotte.ErrInvalidRoles)
+	})
+
+	t.Run("only relays support the relay protocol", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.HostRoles(echalotte.RoleClient),
+		)
+		require.NoError(t, err)
+
+		other := echalottetesting.RandomHost(ctx, t)
+		other.Peerstore().AddAddrs(h.ID(), h.Addrs(), peerstore.AddressTTL)
+
+		supportsRelayProtocol := func() bool {
+			stream, err := other.NewStream(ctx, h.ID(), echalotte.RelayProtocolID)
+			if err != nil {
+				return false
+			}
+
+			stream.Close()
+			return true
+		}
+
+		assert.False(t, supportsRelayProtocol())
+
+		require.NoError(t, h.SetRoles(echalotte.RoleClient|echalotte.RoleRelay))
+		assert.True(t, supportsRelayProtocol())
+
+		require.NoError(t, h.SetRoles(echalotte.RoleClient))
+		assert.False(t, supportsRelayProtocol())
+	})
+
+	t.Run("only relays advertise", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cb := &advertisingCircuitBuilder{
+			DummyCircuitBuilder: echalottetesting.NewDummyCircuitBuilder(t),
+			advertised:          make(chan struct{}, 1),
+		}
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			cb,
+			echalotte.HostRoles(echalotte.RoleClient),
+		)
+		require.NoError(t, err)
+
+		select {
+		case <-cb.advertised:
+			assert.Fail(t, "client-only host advertised")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, h.SetRoles(echalotte.DefaultRoles))
+
+		select {
+		case <-cb.advertised:
+		case <-time.After(time.Second):
+			assert.Fail(t, "relay not advertised")
+		}
+	})
+}
@@ -0,0 +1,402 @@
+package echalotte
+
+import (
+	"context"
+	crand "crypto/rand"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// Errors used by key rotation.
+const (
+	ErrInvalidKeyRotation = "key rotation period and overlap must be positive"
+	ErrInvalidNextKey     = "invalid next encryption key"
+	ErrNextKeyReplaced    = "announced encryption key was replaced"
+)
+
+// KeyRotation configures the rotation of the host's encryption keys.
+type KeyRotation struct {
+	// Period after which a new key is generated.
+	Period time.Duration
+
+	// Overlap during which a retired key can still be used to decrypt
+	// messages. Senders that fetched the previous key before the rotation
+	// can keep using it during that window.
+	Overlap time.Duration
+}
+
+// retiredKey is a previous decryption key that can still be used until a
+// given time.
+type retiredKey struct {
+	public *[32]byte
+	key    *[32]byte
+	hybrid *hybridKey
+	until  time.Time
+}
+
+// nextKey is a key pair announced in advance, that becomes the current key
+// at notBefore.
+type nextKey struct {
+	public    *[32]byte
+	key       *[32]byte
+	hybrid    *hybridKey
+	notBefore time.Time
+}
+
+// decryptionKey is a Curve25519 private key and the post-quantum key paired
+// with it, if any.
+type decryptionKey struct {
+	key    *[32]byte
+	hybrid *hybridKey
+}
+
+// copyKey returns a copy of a private key, so that it can be used once the
+// lock is released even if the original is erased meanwhile.
+func copyKey(key *[32]byte) *[32]byte {
+	copied := *key
+	return &copied
+}
+
+// EncryptionKeyRotation is an option to periodically rotate the host's
+// encryption keys.
+// Published keys are valid for period+overlap, and retired keys are kept for
+// decryption during the overlap.
+func EncryptionKeyRotation(period, overlap time.Duration) HostOption {
+	return func(opts *HostOptions) error {
+		if period <= 0 || overlap <= 0 {
+			return errors.New(ErrInvalidKeyRotation)
+		}
+
+		opts.KeyRotation = &KeyRotation{Period: period, Overlap: overlap}
+		return nil
+	}
+}
+
+// RotateEncryptionKey generates a new encryption key and switches to it.
+// The new key is first announced in the current record, and only replaces
+// the current key once the rotation overlap has elapsed, so that senders
+// that fetched the announcement switch over on time.
+// It blocks until the switch, or until the context is done.
+// The previous key is then retired but can still decrypt messages until the
+// end of the next overlap.
+func (h *Host) RotateEncryptionKey(ctx context.Context) error {
+	if h.keyRotation == nil {
+		return errors.New(ErrInvalidKeyRotation)
+	}
+
+	next, err := h.announceNextKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-time.After(time.Until(next.notBefore)):
+	}
+
+	return h.switchToNextKey(ctx, next)
+}
+
+// announceNextKey generates the next encryption key and publishes it along
+// with the current one.
+// If a key was already announced, it is returned instead.
+func (h *Host) announceNextKey(ctx context.Context) (*nextKey, error) {
+	encryptionPublicKey, encryptionPrivateKey, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	hybrid, err := h.newHybridKeyFor()
+	if err != nil {
+		return nil, err
+	}
+
+	next := &nextKey{
+		public:    encryptionPublicKey,
+		key:       encryptionPrivateKey,
+		hybrid:    hybrid,
+		notBefore: time.Now().Add(h.keyRotation.Overlap),
+	}
+
+	// A concurrent rotation may have announced a key while we generated
+	// ours: we use it instead.
+	h.keysLock.Lock()
+	if h.nextKey != nil {
+		announced := h.nextKey
+		h.keysLock.Unlock()
+		return announced, nil
+	}
+
+	h.nextKey = next
+	current, err := h.EncryptionKey()
+	h.keysLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.saveEncryptionKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.publishEncryptionKey(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Next encryption key announced")
+
+	return next, nil
+}
+
+// switchToNextKey makes the announced key the current one and retires the
+// previous key.
+// Nothing happens if a concurrent rotation already switched to it, and an
+// error is returned if the announced key was replaced (e.g. by a
+// revocation).
+func (h *Host) switchToNextKey(ctx context.Context, next *nextKey) error {
+	h.keysLock.Lock()
+	if h.nextKey != next {
+		current, err := h.EncryptionKey()
+		h.keysLock.Unlock()
+		if err == nil && *current == *next.public {
+			return nil
+		}
+
+		return errors.New(ErrNextKeyReplaced)
+	}
+
+	previousPublic, publicErr := h.EncryptionKey()
+	previous, err := h.DecryptionKey()
+	if err == nil && publicErr == nil {
+		h.retiredKeys = append(h.retiredKeys, retiredKey{
+			public: previousPublic,
+			key:    previous,
+			hybrid: h.hybridKey,
+			until:  time.Now().Add(h.keyRotation.Overlap),
+		})
+	}
+
+	err = h.storeEncryptionKey(next.public, next.key)
+	if err == nil {
+		h.hybridKey = next.hybrid
+		h.nextKey = nil
+		h.rotateAt = time.Now().Add(h.keyRotation.Period)
+	}
+	h.keysLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = h.saveEncryptionKeys()
+	if err != nil {
+		return err
+	}
+
+	err = h.publishEncryptionKey(ctx, next.public)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Encryption key rotated")
+
+	return nil
+}
+
+// nextKeyProto returns the announced next key, if any, with the validity it
+// will have once it is current.
+// It should be called with the keys lock held.
+func (h *Host) nextKeyProto() *pb.NextKey {
+	if h.nextKey == nil {
+		return nil
+	}
+
+	notBefore, _ := ptypes.TimestampProto(h.nextKey.notBefore)
+	notAfter, _ := ptypes.TimestampProto(h.nextKey.notBefore.Add(h.keyRotation.Period + h.keyRotation.Overlap))
+
+	next := &pb.NextKey{
+		Data:      h.nextKey.public[:],
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	if h.nextKey.hybrid != nil {
+		next.KemData = h.nextKey.hybrid.public
+	}
+
+	return next
+}
+
+// isNextKeyValid returns true if the record announces a next key that is
+// valid at the given time.
+func isNextKeyValid(publicKey *pb.PublicKey, t time.Time) bool {
+	if publicKey.Next == nil {
+		return false
+	}
+
+	notBefore, err := ptypes.TimestampFromProto(publicKey.Next.NotBefore)
+	if err != nil || t.Before(notBefore) {
+		return false
+	}
+
+	notAfter, err := ptypes.TimestampFromProto(publicKey.Next.NotAfter)
+	return err == nil && t.Before(notAfter)
+}
+
+// validateNextKey checks that the next key of a record is well-formed.
+func validateNextKey(publicKey *pb.PublicKey) error {
+	if publicKey.Next == nil {
+		return nil
+	}
+
+	if len(publicKey.Next.Data) != 32 {
+		return errors.New(ErrInvalidNextKey)
+	}
+
+	notBefore, err := ptypes.TimestampFromProto(publicKey.Next.NotBefore)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidNextKey)
+	}
+
+	notAfter, err := ptypes.TimestampFromProto(publicKey.Next.NotAfter)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidNextKey)
+	}
+
+	if !notAfter.After(notBefore) {
+		return errors.New(ErrInvalidNextKey)
+	}
+
+	return nil
+}
+
+// rotateEncryptionKeys rotates the host's encryption key every period and
+// erases retired keys once their overlap is over, until the context is
+// done.
+// A next key restored from the keystore is switched to first.
+func (h *Host) rotateEncryptionKeys(ctx context.Context) {
+	for {
+		h.keysLock.Lock()
+		pending := h.nextKey != nil
+		wait := time.Until(h.rotateAt)
+		erase := h.nextRetiredKeyErasure()
+		h.keysLock.Unlock()
+
+		if !erase.IsZero() && time.Until(erase) < wait {
+			wait = time.Until(erase)
+		}
+
+		// Manual rotations postpone the next one, so the deadline is checked
+		// again after waiting.
+		if !pending && wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			err := h.eraseExpiredRetiredKeys()
+			if err != nil {
+				log.Errorf("Could not erase retired keys: %s", err.Error())
+			}
+
+			continue
+		}
+
+		err := h.RotateEncryptionKey(ctx)
+		if err != nil {
+			log.Errorf("Could not rotate encryption key: %s", err.Error())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(h.keyRotation.Overlap):
+			}
+		}
+	}
+}
+
+// decryptionKeys returns the current decryption key followed by the
+// announced next key, the retired keys that are still in their overlap
+// window and the keys of the current epochs.
+// With epoch keys, only the keys of the current epochs are returned.
+// Layers encrypted to the key derived from our identity are opened
+// separately (see DecapsulateDerived).
+func (h *Host) decryptionKeys() ([]decryptionKey, error) {
+	h.keysLock.Lock()
+	defer h.keysLock.Unlock()
+
+	now := time.Now()
+	if h.keyEpochs != nil {
+		return h.epochDecryptionKeys(now), nil
+	}
+
+	current, err := h.DecryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []decryptionKey{{key: current, hybrid: h.hybridKey}}
+	if h.nextKey != nil {
+		keys = append(keys, decryptionKey{key: copyKey(h.nextKey.key), hybrid: h.nextKey.hybrid})
+	}
+
+	h.eraseRetiredKeys(now)
+	for _, retired := range h.retiredKeys {
+		keys = append(keys, decryptionKey{key: copyKey(retired.key), hybrid: retired.hybrid})
+	}
+
+	return keys, nil
+}
+
+// eraseRetiredKeys deletes the retired keys whose overlap is over.
+// It returns whether keys were deleted.
+// The lock must be held by the caller.
+func (h *Host) eraseRetiredKeys(now time.Time) bool {
+	var stillValid []retiredKey
+	for _, retired := range h.retiredKeys {
+		if now.Before(retired.until) {
+			stillValid = append(stillValid, retired)
+			continue
+		}
+
+		eraseKey(retired.key, retired.hybrid)
+	}
+
+	erased := len(stillValid) < len(h.retiredKeys)
+	h.retiredKeys = stillValid
+
+	return erased
+}
+
+// nextRetiredKeyErasure returns when the next retired key should be erased,
+// or the zero time if there are no retired keys.
+// The lock must be held by the caller.
+func (h *Host) nextRetiredKeyErasure() time.Time {
+	var next time.Time
+	for _, retired := range h.retiredKeys {
+		if next.IsZero() || retired.until.Before(next) {
+			next = retired.until
+		}
+	}
+
+	return next
+}
+
+// eraseExpiredRetiredKeys deletes the retired keys whose overlap is over
+// and removes them from the keystore.
+func (h *Host) eraseExpiredRetiredKeys() error {
+	h.keysLock.Lock()
+	erased := h.eraseRetiredKeys(time.Now())
+	h.keysLock.Unlock()
+	if !erased {
+		return nil
+	}
+
+	return h.saveEncryptionKeys()
+}
@@ -0,0 +1,168 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+func TestKeyRotation(t *testing.T) {
+	t.Run("invalid configuration", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeyRotation(time.Hour, 0),
+		)
+		assert.EqualError(t, err, echalotte.ErrInvalidKeyRotation)
+	})
+
+	t.Run("decrypts with previous key during overlap", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeyRotation(time.Hour, 100*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		publishedKey := func() *pb.PublicKey {
+			record, err := dht.GetValue(ctx, echalotte.PublicKeyValidator{}.CreateKey(h.ID()))
+			require.NoError(t, err)
+
+			var publicKey pb.PublicKey
+			require.NoError(t, proto.Unmarshal(record, &publicKey))
+			return &publicKey
+		}
+
+		firstKey := publishedKey()
+		assert.True(t, echalotte.IsValidAt(firstKey, time.Now()))
+		assert.False(t, echalotte.IsValidAt(firstKey, time.Now().Add(2*time.Hour)))
+
+		senderKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		sender, err := peer.IDFromPrivateKey(senderKey)
+		require.NoError(t, err)
+
+		newMessage := func(key []byte) *echalotte.OnionMessage {
+			var encryptionKey [32]byte
+			copy(encryptionKey[:], key)
+
+			m, err := echalotte.NewMessage(sender, senderKey, []byte("Le soleil rayonnait sur cette pourriture,"))
+			require.NoError(t, err)
+
+			m, err = m.Encapsulate(h.ID(), &encryptionKey)
+			require.NoError(t, err)
+
+			return m
+		}
+
+		rotated := make(chan error, 1)
+		go func() {
+			rotated <- h.RotateEncryptionKey(ctx)
+		}()
+
+		// The next key is announced before the switch.
+		var announced *pb.PublicKey
+		for i := 0; i < 100 && (announced == nil || announced.Next == nil); i++ {
+			<-time.After(2 * time.Millisecond)
+			announced = publishedKey()
+		}
+
+		require.NotNil(t, announced.Next)
+		assert.Equal(t, firstKey.Data, announced.Data)
+
+		currentKey, err := echalotte.CurrentEpochKey(announced, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, firstKey.Data, currentKey[:])
+
+		nextKey, err := echalotte.CurrentEpochKey(announced, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, announced.Next.Data, nextKey[:])
+
+		// The relay accepts the next key as soon as it is announced.
+		assert.NoError(t, sendTo(ctx, h, newMessage(announced.Next.Data)))
+
+		require.NoError(t, <-rotated)
+
+		secondKey := publishedKey()
+		assert.NotEqual(t, firstKey.Data, secondKey.Data)
+		assert.Equal(t, announced.Next.Data, secondKey.Data)
+		assert.Nil(t, secondKey.Next)
+
+		currentKey, err = h.EncryptionKey()
+		require.NoError(t, err)
+		assert.Equal(t, secondKey.Data, currentKey[:])
+
+		assert.NoError(t, sendTo(ctx, h, newMessage(secondKey.Data)))
+		assert.NoError(t, sendTo(ctx, h, newMessage(firstKey.Data)))
+
+		// Once the overlap is over, the previous key is forgotten.
+		<-time.After(150 * time.Millisecond)
+		assert.NoError(t, sendTo(ctx, h, newMessage(secondKey.Data)))
+		assert.Error(t, sendTo(ctx, h, newMessage(firstKey.Data)))
+	})
+
+	t.Run("concurrent rotations switch to the same key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		h, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			echalottetesting.NewInMemoryDHT(),
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.EncryptionKeyRotation(time.Hour, 50*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		firstKey, err := h.EncryptionKey()
+		require.NoError(t, err)
+
+		rotated := make(chan *[32]byte, 4)
+		for i := 0; i < 4; i++ {
+			go func() {
+				if err := h.RotateEncryptionKey(ctx); err != nil {
+					rotated <- nil
+					return
+				}
+
+				current, _ := h.EncryptionKey()
+				rotated <- current
+			}()
+		}
+
+		var keys []*[32]byte
+		for i := 0; i < 4; i++ {
+			current := <-rotated
+			require.NotNil(t, current)
+			assert.NotEqual(t, *firstKey, *current)
+			keys = append(keys, current)
+		}
+
+		for _, key := range keys[1:] {
+			assert.Equal(t, *keys[0], *key)
+		}
+	})
+}
@@ -0,0 +1,371 @@
+package echalotte
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmNTCey11oxhb1AxDnQBRHtdhap6Ctud872NjAYPYYXPuc/go-multiaddr"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+const (
+	// DefaultIPv4DiversityPrefix is the default IPv4 prefix length used to
+	// decide if two relays are in the same network.
+	DefaultIPv4DiversityPrefix = 16
+
+	// DefaultIPv6DiversityPrefix is the default IPv6 prefix length used to
+	// decide if two relays are in the same network.
+	DefaultIPv6DiversityPrefix = 32
+
+	// DefaultGuardCount is the default number of guards pinned by a
+	// GuardSelector.
+	DefaultGuardCount = 3
+
+	// DefaultGuardLifetime is the default duration after which a guard is
+	// rotated.
+	DefaultGuardLifetime = 30 * 24 * time.Hour
+)
+
+// Errors used by relay selectors.
+const (
+	ErrNotEnoughRelays = "not enough candidate relays to build circuit"
+	ErrNotDiverse      = "not enough relays in distinct networks to build circuit"
+)
+
+// SelectionConstraints are the constraints that a circuit must satisfy.
+type SelectionConstraints struct {
+	// Size of the circuit.
+	Size int
+
+	// Exclude contains peers that must not be part of the circuit.
+	Exclude []peer.ID
+
+	// Reputation of relays, if available.
+	Reputation *Reputation
+
+	// Rand is the source of randomness that selectors should use.
+	Rand *rand.Rand
+}
+
+func (c SelectionConstraints) rand() *rand.Rand {
+	if c.Rand == nil {
+		return newCryptoRand()
+	}
+
+	return c.Rand
+}
+
+// RelaySelector chooses the relays of a circuit among candidates.
+// The returned circuit is ordered like the ones produced by circuit builders:
+// its last relay is the first hop.
+type RelaySelector interface {
+	Select(candidates []peerstore.PeerInfo, constraints SelectionConstraints) (Circuit, error)
+}
+
+// UniformSelector selects relays uniformly at random.
+type UniformSelector struct{}
+
+// Select relays uniformly at random.
+func (UniformSelector) Select(candidates []peerstore.PeerInfo, constraints SelectionConstraints) (Circuit, error) {
+	candidates = excludeCandidates(candidates, constraints.Exclude)
+	if len(candidates) < constraints.Size {
+		return nil, errors.New(ErrNotEnoughRelays)
+	}
+
+	return selectRelays(candidates, constraints.Size, nil, constraints.rand()), nil
+}
+
+// WeightedSelector selects relays at random, favoring relays with a good
+// reputation.
+// Without reputation it behaves like UniformSelector.
+// This is the default selector.
+type WeightedSelector struct{}
+
+// Select relays at random, weighted by their reputation.
+func (WeightedSelector) Select(candidates []peerstore.PeerInfo, constraints SelectionConstraints) (Circuit, error) {
+	candidates = excludeCandidates(candidates, constraints.Exclude)
+	if len(candidates) < constraints.Size {
+		return nil, errors.New(ErrNotEnoughRelays)
+	}
+
+	return selectRelays(candidates, constraints.Size, constraints.Reputation, constraints.rand()), nil
+}
+
+// DiversitySelector selects relays in distinct networks, which makes it
+// harder for an adversary controlling a network to observe both ends of a
+// circuit.
+// Relays without known IP addresses are considered to be in their own
+// network.
+type DiversitySelector struct {
+	// IPv4Prefix is the prefix length of IPv4 networks.
+	// Defaults to DefaultIPv4DiversityPrefix.
+	IPv4Prefix int
+
+	// IPv6Prefix is the prefix length of IPv6 networks.
+	// Defaults to DefaultIPv6DiversityPrefix.
+	IPv6Prefix int
+
+	// Strict selectors fail when there aren't enough distinct networks.
+	// Otherwise relays in the same networks are used to complete circuits.
+	Strict bool
+}
+
+// Select relays in distinct networks.
+func (ds DiversitySelector) Select(candidates []peerstore.PeerInfo, constraints SelectionConstraints) (Circuit, error) {
+	candidates = excludeCandidates(candidates, constraints.Exclude)
+	if len(candidates) < constraints.Size {
+		return nil, errors.New(ErrNotEnoughRelays)
+	}
+
+	// Random order in which relays are considered.
+	ordered := selectRelays(candidates, len(candidates), constraints.Reputation, constraints.rand())
+
+	byID := make(map[peer.ID]peerstore.PeerInfo, len(candidates))
+	for _, c := range candidates {
+		byID[c.ID] = c
+	}
+
+	usedNetworks := make(map[string]struct{})
+	var circuit, skipped Circuit
+	for _, relay := range ordered {
+		if len(circuit) == constraints.Size {
+			break
+		}
+
+		networks := ds.networks(byID[relay])
+		if overlaps(usedNetworks, networks) {
+			skipped = append(skipped, relay)
+			continue
+		}
+
+		for _, n := range networks {
+			usedNetworks[n] = struct{}{}
+		}
+
+		circuit = append(circuit, relay)
+	}
+
+	if len(circuit) < constraints.Size {
+		if ds.Strict {
+			return nil, errors.New(ErrNotDiverse)
+		}
+
+		log.Debugf("Only %d relay(s) in distinct networks, completing circuit", len(circuit))
+		circuit = append(circuit, skipped[:constraints.Size-len(circuit)]...)
+	}
+
+	return circuit, nil
+}
+
+// networks returns the networks a relay belongs to.
+func (ds DiversitySelector) networks(relay peerstore.PeerInfo) []string {
+	ipv4Prefix := ds.IPv4Prefix
+	if ipv4Prefix <= 0 {
+		ipv4Prefix = DefaultIPv4DiversityPrefix
+	}
+
+	ipv6Prefix := ds.IPv6Prefix
+	if ipv6Prefix <= 0 {
+		ipv6Prefix = DefaultIPv6DiversityPrefix
+	}
+
+	var networks []string
+	for _, addr := range relay.Addrs {
+		if addr == nil {
+			continue
+		}
+
+		if ip, err := addr.ValueForProtocol(multiaddr.P_IP4); err == nil {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				networks = append(networks, parsed.Mask(net.CIDRMask(ipv4Prefix, 32)).String())
+			}
+		}
+
+		if ip, err := addr.ValueForProtocol(multiaddr.P_IP6); err == nil {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				networks = append(networks, parsed.Mask(net.CIDRMask(ipv6Prefix, 128)).String())
+			}
+		}
+	}
+
+	return networks
+}
+
+// overlaps returns true if one of the networks is already used.
+func overlaps(used map[string]struct{}, networks []string) bool {
+	for _, n := range networks {
+		if _, ok := used[n]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GuardSelector pins the first hop of circuits to a small set of long-lived
+// guard relays.
+// Choosing a new random first hop for every circuit eventually lets an
+// adversary running a few relays observe the sender; with guards the sender
+// is either exposed to them or never.
+type GuardSelector struct {
+	count    int
+	lifetime time.Duration
+	next     RelaySelector
+
+	lock   sync.Mutex
+	guards []guard
+}
+
+type guard struct {
+	relay     peerstore.PeerInfo
+	chosenAt  time.Time
+	expiresIn time.Duration
+}
+
+// NewGuardSelector creates a guard selector with the given number of guards
+// and guard lifetime.
+// The other hops are selected by the next selector (WeightedSelector if nil).
+func NewGuardSelector(count int, lifetime time.Duration, next RelaySelector) *GuardSelector {
+	if count <= 0 {
+		count = DefaultGuardCount
+	}
+
+	if lifetime <= 0 {
+		lifetime = DefaultGuardLifetime
+	}
+
+	if next == nil {
+		next = WeightedSelector{}
+	}
+
+	return &GuardSelector{
+		count:    count,
+		lifetime: lifetime,
+		next:     next,
+	}
+}
+
+// Guards returns the currently pinned guards.
+func (gs *GuardSelector) Guards() []peer.ID {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	var guards []peer.ID
+	for _, g := range gs.guards {
+		guards = append(guards, g.relay.ID)
+	}
+
+	return guards
+}
+
+// Select a circuit whose first hop is one of our guards.
+// Guards don't need to be part of the candidates since they are pinned.
+func (gs *GuardSelector) Select(candidates []peerstore.PeerInfo, constraints SelectionConstraints) (Circuit, error) {
+	rng := constraints.rand()
+	first, err := gs.pick(candidates, constraints, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	nextConstraints := constraints
+	nextConstraints.Size = constraints.Size - 1
+	nextConstraints.Exclude = append(append([]peer.ID(nil), constraints.Exclude...), first.ID)
+
+	circuit := Circuit{}
+	if nextConstraints.Size > 0 {
+		circuit, err = gs.next.Select(candidates, nextConstraints)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return append(circuit, first.ID), nil
+}
+
+// pick a usable guard, rotating expired or misbehaving guards.
+func (gs *GuardSelector) pick(
+	candidates []peerstore.PeerInfo,
+	constraints SelectionConstraints,
+	rng *rand.Rand,
+) (peerstore.PeerInfo, error) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	now := time.Now()
+	guards := gs.guards[:0]
+	for _, g := range gs.guards {
+		if now.Sub(g.chosenAt) >= g.expiresIn {
+			log.Debugf("Rotating expired guard %s", g.relay.ID.Pretty())
+			continue
+		}
+
+		if constraints.Reputation != nil && constraints.Reputation.Excluded(g.relay.ID) {
+			log.Debugf("Rotating misbehaving guard %s", g.relay.ID.Pretty())
+			continue
+		}
+
+		guards = append(guards, g)
+	}
+
+	gs.guards = guards
+
+	current := make([]peer.ID, 0, len(gs.guards))
+	for _, g := range gs.guards {
+		current = append(current, g.relay.ID)
+	}
+
+	if len(gs.guards) < gs.count {
+		fresh := excludeCandidates(candidates, current)
+		fresh = excludeCandidates(fresh, constraints.Exclude)
+		if constraints.Reputation != nil {
+			fresh = constraints.Reputation.filter(fresh, 0)
+		}
+
+		rng.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+		for _, relay := range fresh {
+			if len(gs.guards) == gs.count {
+				break
+			}
+
+			// Randomize lifetimes so that guards don't all rotate at once.
+			gs.guards = append(gs.guards, guard{
+				relay:     relay,
+				chosenAt:  now,
+				expiresIn: gs.lifetime/2 + time.Duration(rng.Int63n(int64(gs.lifetime/2)+1)),
+			})
+		}
+	}
+
+	var usable []peerstore.PeerInfo
+	for _, g := range gs.guards {
+		if !containsPeer(constraints.Exclude, g.relay.ID) {
+			usable = append(usable, g.relay)
+		}
+	}
+
+	if len(usable) == 0 {
+		return peerstore.PeerInfo{}, errors.New(ErrNotEnoughRelays)
+	}
+
+	return usable[rng.Intn(len(usable))], nil
+}
+
+// excludeCandidates returns the candidates that aren't excluded.
+func excludeCandidates(candidates []peerstore.PeerInfo, exclude []peer.ID) []peerstore.PeerInfo {
+	if len(exclude) == 0 {
+		return candidates
+	}
+
+	var kept []peerstore.PeerInfo
+	for _, c := range candidates {
+		if !containsPeer(exclude, c.ID) {
+			kept = append(kept, c)
+		}
+	}
+
+	return kept
+}
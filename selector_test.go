@@ -0,0 +1,193 @@
+package echalotte_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+
+	"gx/ipfs/QmNTCey11oxhb1AxDnQBRHtdhap6Ctud872NjAYPYYXPuc/go-multiaddr"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func candidateRelays(count int) []peerstore.PeerInfo {
+	var candidates []peerstore.PeerInfo
+	for i := 0; i < count; i++ {
+		candidates = append(candidates, peerstore.PeerInfo{ID: peer.ID(fmt.Sprintf("relay-%d", i))})
+	}
+
+	return candidates
+}
+
+func TestUniformSelector(t *testing.T) {
+	constraints := echalotte.SelectionConstraints{
+		Size:    3,
+		Exclude: []peer.ID{peer.ID("relay-0")},
+		Rand:    rand.New(rand.NewSource(42)),
+	}
+
+	t.Run("not enough relays", func(t *testing.T) {
+		_, err := echalotte.UniformSelector{}.Select(candidateRelays(3), constraints)
+		assert.EqualError(t, err, echalotte.ErrNotEnoughRelays)
+	})
+
+	t.Run("selects distinct relays", func(t *testing.T) {
+		c, err := echalotte.UniformSelector{}.Select(candidateRelays(10), constraints)
+		require.NoError(t, err)
+		require.Len(t, c, 3)
+		assert.NotContains(t, c, peer.ID("relay-0"))
+		assert.NotEqual(t, c[0], c[1])
+		assert.NotEqual(t, c[1], c[2])
+		assert.NotEqual(t, c[0], c[2])
+	})
+}
+
+func TestWeightedSelector(t *testing.T) {
+	reputation, err := echalotte.NewReputation(
+		echalotte.ReputationFailureInterval(0),
+		echalotte.ReputationSuccessInterval(0),
+	)
+	require.NoError(t, err)
+
+	good, bad := peer.ID("relay-0"), peer.ID("relay-1")
+	for i := 0; i < 10; i++ {
+		reputation.Record(good, echalotte.OutcomeSuccess)
+		reputation.Record(bad, echalotte.OutcomeFailure)
+	}
+
+	constraints := echalotte.SelectionConstraints{
+		Size:       1,
+		Reputation: reputation,
+		Rand:       rand.New(rand.NewSource(42)),
+	}
+
+	goodCount := 0
+	for i := 0; i < 200; i++ {
+		c, err := echalotte.WeightedSelector{}.Select(candidateRelays(2), constraints)
+		require.NoError(t, err)
+		require.Len(t, c, 1)
+
+		if c[0] == good {
+			goodCount++
+		}
+	}
+
+	assert.True(t, goodCount > 150)
+}
+
+func TestDiversitySelector(t *testing.T) {
+	// Two relays in each of three /16 networks.
+	var candidates []peerstore.PeerInfo
+	for i := 0; i < 6; i++ {
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/10.%d.0.%d/tcp/4001", i/2, i))
+		require.NoError(t, err)
+
+		candidates = append(candidates, peerstore.PeerInfo{
+			ID:    peer.ID(fmt.Sprintf("relay-%d", i)),
+			Addrs: []multiaddr.Multiaddr{addr},
+		})
+	}
+
+	networks := func(c echalotte.Circuit) map[byte]struct{} {
+		n := make(map[byte]struct{})
+		for _, relay := range c {
+			n[relay[len(relay)-1]/2] = struct{}{}
+		}
+
+		return n
+	}
+
+	t.Run("selects relays in distinct networks", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			c, err := echalotte.DiversitySelector{}.Select(candidates, echalotte.SelectionConstraints{Size: 3})
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+			assert.Len(t, networks(c), 3)
+		}
+	})
+
+	t.Run("strict mode fails without enough networks", func(t *testing.T) {
+		_, err := echalotte.DiversitySelector{Strict: true}.Select(candidates, echalotte.SelectionConstraints{Size: 4})
+		assert.EqualError(t, err, echalotte.ErrNotDiverse)
+	})
+
+	t.Run("completes circuits without enough networks", func(t *testing.T) {
+		c, err := echalotte.DiversitySelector{}.Select(candidates, echalotte.SelectionConstraints{Size: 4})
+		require.NoError(t, err)
+		require.Len(t, c, 4)
+		assert.Len(t, networks(c), 3)
+	})
+
+	t.Run("uses prefix length", func(t *testing.T) {
+		_, err := echalotte.DiversitySelector{IPv4Prefix: 8, Strict: true}.Select(candidates, echalotte.SelectionConstraints{Size: 2})
+		assert.EqualError(t, err, echalotte.ErrNotDiverse)
+	})
+}
+
+func TestGuardSelector(t *testing.T) {
+	t.Run("pins first hop to guards", func(t *testing.T) {
+		gs := echalotte.NewGuardSelector(2, time.Hour, nil)
+
+		_, err := gs.Select(candidateRelays(10), echalotte.SelectionConstraints{Size: 3})
+		require.NoError(t, err)
+
+		guards := gs.Guards()
+		require.Len(t, guards, 2)
+
+		for i := 0; i < 20; i++ {
+			// Guards are used even if they're not part of the candidates.
+			c, err := gs.Select(candidateRelays(10)[i%5:], echalotte.SelectionConstraints{Size: 3})
+			require.NoError(t, err)
+			require.Len(t, c, 3)
+			assert.Contains(t, guards, c[2])
+			assert.NotContains(t, c[:2], c[2])
+		}
+
+		assert.Equal(t, guards, gs.Guards())
+	})
+
+	t.Run("does not use excluded guards", func(t *testing.T) {
+		gs := echalotte.NewGuardSelector(2, time.Hour, nil)
+
+		_, err := gs.Select(candidateRelays(10), echalotte.SelectionConstraints{Size: 3})
+		require.NoError(t, err)
+
+		guards := gs.Guards()
+		for i := 0; i < 10; i++ {
+			c, err := gs.Select(candidateRelays(10), echalotte.SelectionConstraints{
+				Size:    3,
+				Exclude: guards[:1],
+			})
+			require.NoError(t, err)
+			assert.Equal(t, guards[1], c[2])
+			assert.NotContains(t, c, guards[0])
+		}
+	})
+
+	t.Run("rotates misbehaving guards", func(t *testing.T) {
+		reputation, err := echalotte.NewReputation(
+			echalotte.ReputationFailureInterval(0),
+			echalotte.ReputationExclusion(1, 0.4),
+		)
+		require.NoError(t, err)
+
+		gs := echalotte.NewGuardSelector(1, time.Hour, nil)
+		constraints := echalotte.SelectionConstraints{Size: 2, Reputation: reputation}
+
+		c1, err := gs.Select(candidateRelays(10), constraints)
+		require.NoError(t, err)
+
+		reputation.Record(c1[1], echalotte.OutcomeFailure)
+		reputation.Record(c1[1], echalotte.OutcomeFailure)
+
+		c2, err := gs.Select(candidateRelays(10), constraints)
+		require.NoError(t, err)
+		assert.NotEqual(t, c1[1], c2[1])
+		assert.Equal(t, []peer.ID{c2[1]}, gs.Guards())
+	})
+}
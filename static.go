@@ -0,0 +1,283 @@
+package echalotte
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gx/ipfs/QmNTCey11oxhb1AxDnQBRHtdhap6Ctud872NjAYPYYXPuc/go-multiaddr"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// Errors used by the static circuit builder.
+const (
+	ErrInvalidStaticConfig = "invalid static circuit configuration"
+	ErrNoStaticPath        = "no configured path satisfies the circuit constraints"
+)
+
+// StaticRelay is a relay entry of a static configuration.
+type StaticRelay struct {
+	// ID is the base58-encoded peer ID of the relay.
+	ID string `json:"id"`
+
+	// Addrs are the relay's multiaddrs.
+	Addrs []string `json:"addrs,omitempty"`
+
+	// EncryptionKey is an optional hex-encoded curve25519 key.
+	// When it's not set, the key is looked up in the DHT.
+	EncryptionKey string `json:"encryption_key,omitempty"`
+}
+
+// StaticConfig is the configuration file format of a StaticCircuitBuilder.
+// Paths are lists of relay IDs ordered from the first hop to the last one.
+// When paths are configured, circuits are always one of these paths;
+// otherwise circuits are built from random configured relays.
+type StaticConfig struct {
+	Relays []StaticRelay `json:"relays"`
+	Paths  [][]string    `json:"paths,omitempty"`
+}
+
+// staticState is a parsed configuration.
+type staticState struct {
+	relays []peerstore.PeerInfo
+	keys   map[peer.ID]*[32]byte
+	paths  []Circuit
+}
+
+// StaticCircuitBuilder builds circuits from a JSON configuration file listing
+// relays and explicit paths.
+// It is meant for testbeds and controlled deployments.
+type StaticCircuitBuilder struct {
+	path      string
+	peerstore peerstore.Peerstore
+	options   CircuitOptions
+
+	lock    sync.RWMutex
+	state   *staticState
+	modTime time.Time
+}
+
+// NewStaticCircuitBuilder creates a circuit builder from the configuration
+// file at the given path.
+// If a peerstore is provided, relay addresses are added to it.
+func NewStaticCircuitBuilder(path string, ps peerstore.Peerstore, opts ...CircuitOption) (*StaticCircuitBuilder, error) {
+	options := &CircuitOptions{
+		Size:    DefaultCircuitSize,
+		Timeout: DefaultCircuitTimeout,
+		Rand:    newCryptoRand(),
+	}
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cb := &StaticCircuitBuilder{
+		path:      path,
+		peerstore: ps,
+		options:   *options,
+	}
+
+	err = cb.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cb, nil
+}
+
+// Reload the configuration file.
+// The current configuration is kept if the new one is invalid.
+func (cb *StaticCircuitBuilder) Reload() error {
+	info, err := os.Stat(cb.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := ioutil.ReadFile(cb.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var config StaticConfig
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidStaticConfig)
+	}
+
+	state, err := parseStaticConfig(&config)
+	if err != nil {
+		return err
+	}
+
+	if cb.peerstore != nil {
+		for _, relay := range state.relays {
+			cb.peerstore.AddAddrs(relay.ID, relay.Addrs, peerstore.PermanentAddrTTL)
+		}
+	}
+
+	cb.lock.Lock()
+	cb.state = state
+	cb.modTime = info.ModTime()
+	cb.lock.Unlock()
+
+	log.Infof("Static circuit configuration loaded: %d relay(s), %d path(s)", len(state.relays), len(state.paths))
+
+	return nil
+}
+
+// Watch reloads the configuration file when it changes, until the context is
+// done.
+func (cb *StaticCircuitBuilder) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(cb.path)
+		if err != nil {
+			log.Errorf("Could not stat static circuit configuration: %s", err.Error())
+			continue
+		}
+
+		cb.lock.RLock()
+		modified := !info.ModTime().Equal(cb.modTime)
+		cb.lock.RUnlock()
+
+		if modified {
+			err = cb.Reload()
+			if err != nil {
+				log.Errorf("Could not reload static circuit configuration: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Build a circuit from the configuration.
+// When paths are configured, a random path that doesn't contain excluded
+// peers is chosen and the circuit size is ignored.
+func (cb *StaticCircuitBuilder) Build(ctx context.Context, opts ...CircuitOption) (Circuit, error) {
+	options := cb.options
+	options.Filters = append([]RelayFilter(nil), cb.options.Filters...)
+	options.Exclude = append([]peer.ID(nil), cb.options.Exclude...)
+	err := options.Apply(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cb.lock.RLock()
+	state := cb.state
+	cb.lock.RUnlock()
+
+	if len(state.paths) > 0 {
+		var paths []Circuit
+		for _, path := range state.paths {
+			if !path.contains(options.Exclude) {
+				paths = append(paths, path)
+			}
+		}
+
+		if len(paths) == 0 {
+			return nil, errors.New(ErrNoStaticPath)
+		}
+
+		path := paths[options.Rand.Intn(len(paths))]
+		c := make(Circuit, len(path))
+		copy(c, path)
+
+		return c, nil
+	}
+
+	relays := excludeCandidates(state.relays, options.Exclude)
+	if len(options.Filters) > 0 {
+		relays = filterRelays(ctx, relays, options.Filters, options.Timeout)
+	}
+
+	if options.Reputation != nil {
+		relays = options.Reputation.filter(relays, options.Size)
+	}
+
+	if len(relays) < options.Size {
+		return nil, errors.Wrap(errors.New("not enough configured relays"), ErrFindRelays)
+	}
+
+	// Selectors may reorder candidates, which must not affect our state.
+	return options.selectCircuit(append([]peerstore.PeerInfo(nil), relays...))
+}
+
+// PeerEncryptionKey returns the encryption key pinned in the configuration.
+func (cb *StaticCircuitBuilder) PeerEncryptionKey(relay peer.ID) (*[32]byte, bool) {
+	cb.lock.RLock()
+	defer cb.lock.RUnlock()
+
+	key, ok := cb.state.keys[relay]
+	return key, ok
+}
+
+// parseStaticConfig validates a configuration.
+func parseStaticConfig(config *StaticConfig) (*staticState, error) {
+	state := &staticState{keys: make(map[peer.ID]*[32]byte)}
+	known := make(map[string]peer.ID)
+
+	for _, r := range config.Relays {
+		relayID, err := peer.IDB58Decode(r.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid relay ID %s", ErrInvalidStaticConfig, r.ID)
+		}
+
+		relay := peerstore.PeerInfo{ID: relayID}
+		for _, a := range r.Addrs {
+			addr, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: invalid address %s", ErrInvalidStaticConfig, a)
+			}
+
+			relay.Addrs = append(relay.Addrs, addr)
+		}
+
+		if r.EncryptionKey != "" {
+			b, err := hex.DecodeString(r.EncryptionKey)
+			if err != nil || len(b) != 32 {
+				return nil, errors.Errorf("%s: invalid encryption key for %s", ErrInvalidStaticConfig, r.ID)
+			}
+
+			var key [32]byte
+			copy(key[:], b)
+			state.keys[relayID] = &key
+		}
+
+		known[r.ID] = relayID
+		state.relays = append(state.relays, relay)
+	}
+
+	for _, p := range config.Paths {
+		if len(p) == 0 {
+			return nil, errors.Errorf("%s: empty path", ErrInvalidStaticConfig)
+		}
+
+		// Circuits start with the last hop.
+		path := make(Circuit, len(p))
+		for i, id := range p {
+			relayID, ok := known[id]
+			if !ok {
+				return nil, errors.Errorf("%s: unknown relay %s in path", ErrInvalidStaticConfig, id)
+			}
+
+			path[len(p)-1-i] = relayID
+		}
+
+		state.paths = append(state.paths, path)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,129 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+func writeStaticConfig(t *testing.T, path string, config echalotte.StaticConfig) {
+	b, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, b, 0600))
+}
+
+func TestStaticCircuitBuilder(t *testing.T) {
+	ctx := context.Background()
+
+	var relays []peer.ID
+	var config echalotte.StaticConfig
+	for i := 0; i < 5; i++ {
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		relayID, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		relays = append(relays, relayID)
+		config.Relays = append(config.Relays, echalotte.StaticRelay{
+			ID:            peer.IDB58Encode(relayID),
+			Addrs:         []string{"/ip4/127.0.0.1/tcp/4001"},
+			EncryptionKey: hex.EncodeToString(append(make([]byte, 31), byte(i))),
+		})
+	}
+
+	f, err := ioutil.TempFile("", "echalotte-static")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	defer os.Remove(f.Name())
+
+	t.Run("rejects invalid configuration", func(t *testing.T) {
+		invalid := echalotte.StaticConfig{
+			Relays: config.Relays[:1],
+			Paths:  [][]string{{config.Relays[1].ID}},
+		}
+		writeStaticConfig(t, f.Name(), invalid)
+
+		_, err := echalotte.NewStaticCircuitBuilder(f.Name(), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("builds circuits from relays", func(t *testing.T) {
+		writeStaticConfig(t, f.Name(), config)
+
+		cb, err := echalotte.NewStaticCircuitBuilder(f.Name(), nil, echalotte.CircuitSize(3))
+		require.NoError(t, err)
+
+		c, err := cb.Build(ctx, echalotte.CircuitExclude(relays[0], relays[1]))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, relays[2:], c)
+
+		key, ok := cb.PeerEncryptionKey(relays[3])
+		require.True(t, ok)
+		assert.Equal(t, byte(3), key[31])
+	})
+
+	t.Run("builds circuits from paths", func(t *testing.T) {
+		withPaths := config
+		withPaths.Paths = [][]string{
+			{config.Relays[0].ID, config.Relays[1].ID},
+			{config.Relays[2].ID, config.Relays[3].ID, config.Relays[4].ID},
+		}
+		writeStaticConfig(t, f.Name(), withPaths)
+
+		cb, err := echalotte.NewStaticCircuitBuilder(f.Name(), nil)
+		require.NoError(t, err)
+
+		// Circuits start with the last hop.
+		c, err := cb.Build(ctx, echalotte.CircuitExclude(relays[0]))
+		require.NoError(t, err)
+		assert.Equal(t, echalotte.Circuit{relays[4], relays[3], relays[2]}, c)
+
+		_, err = cb.Build(ctx, echalotte.CircuitExclude(relays[0], relays[2]))
+		assert.EqualError(t, err, echalotte.ErrNoStaticPath)
+	})
+
+	t.Run("reloads configuration", func(t *testing.T) {
+		writeStaticConfig(t, f.Name(), config)
+
+		cb, err := echalotte.NewStaticCircuitBuilder(f.Name(), nil, echalotte.CircuitSize(2))
+		require.NoError(t, err)
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go cb.Watch(watchCtx, 5*time.Millisecond)
+
+		pinned := config
+		pinned.Paths = [][]string{{config.Relays[0].ID, config.Relays[1].ID}}
+
+		// Make sure the modification time changes.
+		<-time.After(10 * time.Millisecond)
+		writeStaticConfig(t, f.Name(), pinned)
+		require.NoError(t, os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Second)))
+		<-time.After(50 * time.Millisecond)
+
+		c, err := cb.Build(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, echalotte.Circuit{relays[1], relays[0]}, c)
+
+		// Invalid configurations are ignored.
+		require.NoError(t, ioutil.WriteFile(f.Name(), []byte("{"), 0600))
+		assert.Error(t, cb.Reload())
+
+		c, err = cb.Build(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, echalotte.Circuit{relays[1], relays[0]}, c)
+	})
+}
@@ -0,0 +1,693 @@
+package echalotte
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+	"gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	ptypes "gx/ipfs/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/types"
+)
+
+// Errors used by the key transparency log.
+const (
+	ErrInvalidProof       = "invalid Merkle proof"
+	ErrInconsistentLog    = "key log is not consistent with previously seen tree head"
+	ErrInvalidTreeHead    = "invalid key log tree head"
+	ErrKeyNotLogged       = "encryption key record is not in the key log"
+	ErrInvalidLogRange    = "invalid key log range"
+	ErrUnknownLoggedEntry = "unknown key log entry"
+	ErrStaleLoggedKey     = "encryption key record is not the latest logged record of the peer"
+	ErrStaleTreeHead      = "key log tree head is too old"
+)
+
+const (
+	// DefaultMaxTreeHeadAge is the default maximum age of the tree heads
+	// accepted by a KeyLogVerifier.
+	DefaultMaxTreeHeadAge = time.Hour
+
+	// keyLogRecheckInterval is how long a verified record is trusted to
+	// still be the latest logged record of its peer.
+	keyLogRecheckInterval = time.Minute
+)
+
+// KeyTransparencyLog is an append-only log of encryption key records.
+// Clients verify that the records they get from the DHT are in the log, and
+// that the log never rewrites its history, so a DHT node can't serve
+// different keys to different clients without getting caught.
+type KeyTransparencyLog interface {
+	// Append a peer's key record to the log.
+	Append(ctx context.Context, peerID peer.ID, record []byte) error
+
+	// TreeHead returns the signed head of the log.
+	TreeHead(ctx context.Context) (*pb.TreeHead, error)
+
+	// InclusionProof proves that a record is in the tree of the given size.
+	// It returns the index of the record.
+	InclusionProof(ctx context.Context, record []byte, size uint64) (uint64, [][32]byte, error)
+
+	// LatestRecord returns the most recent record of the peer in the tree of
+	// the given size, with its index and inclusion proof.
+	LatestRecord(ctx context.Context, peerID peer.ID, size uint64) ([]byte, uint64, [][32]byte, error)
+
+	// ConsistencyProof proves that the tree of the old size is a prefix of
+	// the tree of the new size.
+	ConsistencyProof(ctx context.Context, oldSize, newSize uint64) ([][32]byte, error)
+}
+
+// LeafHash returns the Merkle tree hash of a log entry (RFC 6962).
+func LeafHash(data []byte) [32]byte {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+// nodeHash returns the Merkle tree hash of an inner node (RFC 6962).
+func nodeHash(left, right [32]byte) [32]byte {
+	b := make([]byte, 0, 65)
+	b = append(b, 1)
+	b = append(b, left[:]...)
+	b = append(b, right[:]...)
+	return sha256.Sum256(b)
+}
+
+// splitPoint returns the largest power of two smaller than n.
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+
+	return k
+}
+
+// merkleRoot computes the root of the given leaves.
+func merkleRoot(leaves [][32]byte) [32]byte {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// inclusionPath computes the audit path of the m-th leaf.
+func inclusionPath(m uint64, leaves [][32]byte) [][32]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+
+	return append(inclusionPath(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// consistencyPath computes the proof that the first m leaves are a prefix of
+// the given leaves.
+func consistencyPath(m uint64, leaves [][32]byte, complete bool) [][32]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+
+		return [][32]byte{merkleRoot(leaves)}
+	}
+
+	k := splitPoint(n)
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+
+	return append(consistencyPath(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion verifies that a leaf is at the given index of the tree
+// with the given size and root (RFC 9162).
+func VerifyInclusion(leaf [32]byte, index, size uint64, proof [][32]byte, root [32]byte) error {
+	if index >= size {
+		return errors.New(ErrInvalidProof)
+	}
+
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New(ErrInvalidProof)
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || r != root {
+		return errors.New(ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// VerifyConsistency verifies that the tree with the old size and root is a
+// prefix of the tree with the new size and root (RFC 9162).
+func VerifyConsistency(oldSize, newSize uint64, oldRoot, newRoot [32]byte, proof [][32]byte) error {
+	if oldSize > newSize {
+		return errors.New(ErrInvalidProof)
+	}
+
+	if oldSize == newSize {
+		if len(proof) != 0 || oldRoot != newRoot {
+			return errors.New(ErrInvalidProof)
+		}
+
+		return nil
+	}
+
+	// Every tree extends the empty tree.
+	if oldSize == 0 {
+		if len(proof) != 0 {
+			return errors.New(ErrInvalidProof)
+		}
+
+		return nil
+	}
+
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][32]byte{oldRoot}, proof...)
+	}
+
+	if len(proof) == 0 {
+		return errors.New(ErrInvalidProof)
+	}
+
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New(ErrInvalidProof)
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || fr != oldRoot || sr != newRoot {
+		return errors.New(ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// signTreeHead signs the given tree head with the log's key.
+func signTreeHead(sk crypto.PrivKey, head *pb.TreeHead) error {
+	toSign, err := proto.Marshal(head)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	head.Signature, err = sk.Sign(toSign)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	head.SignatureKey, err = sk.GetPublic().Bytes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// VerifyTreeHead verifies that a tree head is signed by the given log key.
+func VerifyTreeHead(logKey crypto.PubKey, head *pb.TreeHead) error {
+	if head == nil || len(head.Root) != 32 {
+		return errors.New(ErrInvalidTreeHead)
+	}
+
+	expectedKey, err := logKey.Bytes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !bytes.Equal(expectedKey, head.SignatureKey) {
+		return errors.New(ErrInvalidTreeHead)
+	}
+
+	unsigned := &pb.TreeHead{
+		TreeSize:  head.TreeSize,
+		Root:      head.Root,
+		Timestamp: head.Timestamp,
+	}
+
+	signedBytes, err := proto.Marshal(unsigned)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ok, err := logKey.Verify(signedBytes, head.Signature)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidTreeHead)
+	}
+	if !ok {
+		return errors.New(ErrInvalidTreeHead)
+	}
+
+	return nil
+}
+
+// MerkleKeyLog is an in-memory key transparency log.
+// Relays and clients can share it directly, or access it through a network
+// service implementing KeyTransparencyLog.
+type MerkleKeyLog struct {
+	signingKey crypto.PrivKey
+	validator  PublicKeyValidator
+
+	lock    sync.RWMutex
+	leaves  [][32]byte
+	records [][]byte
+	indexes map[[32]byte]uint64
+	peers   map[peer.ID][]uint64
+}
+
+// NewMerkleKeyLog creates an empty log that signs its tree heads with the
+// given key.
+func NewMerkleKeyLog(signingKey crypto.PrivKey) *MerkleKeyLog {
+	return &MerkleKeyLog{
+		signingKey: signingKey,
+		indexes:    make(map[[32]byte]uint64),
+		peers:      make(map[peer.ID][]uint64),
+	}
+}
+
+// Append a valid key record to the log.
+// Records that are already in the log are not appended again.
+func (l *MerkleKeyLog) Append(_ context.Context, peerID peer.ID, record []byte) error {
+	err := l.validator.Validate(l.validator.CreateKey(peerID), record)
+	if err != nil {
+		return err
+	}
+
+	leaf := LeafHash(record)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.indexes[leaf]; ok {
+		return nil
+	}
+
+	index := uint64(len(l.leaves))
+	l.indexes[leaf] = index
+	l.leaves = append(l.leaves, leaf)
+	l.records = append(l.records, record)
+	l.peers[peerID] = append(l.peers[peerID], index)
+
+	return nil
+}
+
+// TreeHead returns the signed head of the log.
+func (l *MerkleKeyLog) TreeHead(_ context.Context) (*pb.TreeHead, error) {
+	l.lock.RLock()
+	root := merkleRoot(l.leaves)
+	size := uint64(len(l.leaves))
+	l.lock.RUnlock()
+
+	head := &pb.TreeHead{
+		TreeSize:  size,
+		Root:      root[:],
+		Timestamp: ptypes.TimestampNow(),
+	}
+
+	err := signTreeHead(l.signingKey, head)
+	if err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+// InclusionProof proves that a record is in the tree of the given size.
+func (l *MerkleKeyLog) InclusionProof(_ context.Context, record []byte, size uint64) (uint64, [][32]byte, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if size > uint64(len(l.leaves)) {
+		return 0, nil, errors.New(ErrInvalidLogRange)
+	}
+
+	index, ok := l.indexes[LeafHash(record)]
+	if !ok || index >= size {
+		return 0, nil, errors.New(ErrUnknownLoggedEntry)
+	}
+
+	return index, inclusionPath(index, l.leaves[:size]), nil
+}
+
+// LatestRecord returns the most recent record of the peer in the tree of the
+// given size, with its index and inclusion proof.
+func (l *MerkleKeyLog) LatestRecord(_ context.Context, peerID peer.ID, size uint64) ([]byte, uint64, [][32]byte, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if size > uint64(len(l.leaves)) {
+		return nil, 0, nil, errors.New(ErrInvalidLogRange)
+	}
+
+	indexes := l.peers[peerID]
+	i := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= size })
+	if i == 0 {
+		return nil, 0, nil, errors.New(ErrUnknownLoggedEntry)
+	}
+
+	index := indexes[i-1]
+	return l.records[index], index, inclusionPath(index, l.leaves[:size]), nil
+}
+
+// ConsistencyProof proves that the tree of the old size is a prefix of the
+// tree of the new size.
+func (l *MerkleKeyLog) ConsistencyProof(_ context.Context, oldSize, newSize uint64) ([][32]byte, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if oldSize > newSize || newSize > uint64(len(l.leaves)) {
+		return nil, errors.New(ErrInvalidLogRange)
+	}
+
+	if oldSize == 0 {
+		return nil, nil
+	}
+
+	return consistencyPath(oldSize, l.leaves[:newSize], true), nil
+}
+
+// maxVerifiedRecords is the number of peers whose latest verified record a
+// KeyLogVerifier remembers.
+// The least recently verified peers are forgotten first.
+const maxVerifiedRecords = 4 * DefaultKeyCacheSize
+
+// KeyLogVerifier verifies key records against a transparency log.
+// It remembers the latest tree head it verified and checks that the log
+// only ever grows from it, and that peers' records are never rolled back.
+type KeyLogVerifier struct {
+	// MaxTreeHeadAge is the maximum age of accepted tree heads.
+	// Defaults to DefaultMaxTreeHeadAge.
+	MaxTreeHeadAge time.Duration
+
+	log    KeyTransparencyLog
+	logKey crypto.PubKey
+
+	lock     sync.Mutex
+	head     *pb.TreeHead
+	verified map[peer.ID]*list.Element
+	lru      *list.List
+}
+
+// verifiedRecord is the latest logged record of a peer.
+type verifiedRecord struct {
+	peerID     peer.ID
+	leaf       [32]byte
+	index      uint64
+	verifiedAt time.Time
+}
+
+// NewKeyLogVerifier creates a verifier for the log signed with the given key.
+func NewKeyLogVerifier(log KeyTransparencyLog, logKey crypto.PubKey) *KeyLogVerifier {
+	return &KeyLogVerifier{
+		log:      log,
+		logKey:   logKey,
+		verified: make(map[peer.ID]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// maxTreeHeadAge returns the maximum age of accepted tree heads.
+func (v *KeyLogVerifier) maxTreeHeadAge() time.Duration {
+	if v.MaxTreeHeadAge == 0 {
+		return DefaultMaxTreeHeadAge
+	}
+
+	return v.MaxTreeHeadAge
+}
+
+// checkTreeHeadTime verifies that a tree head is recent, and not older than
+// the previous one, so that the log can't hide newer records by replaying
+// old heads.
+func (v *KeyLogVerifier) checkTreeHeadTime(head, previous *pb.TreeHead) error {
+	timestamp, err := ptypes.TimestampFromProto(head.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidTreeHead)
+	}
+
+	if time.Since(timestamp) > v.maxTreeHeadAge() {
+		return errors.New(ErrStaleTreeHead)
+	}
+
+	if previous != nil {
+		previousTimestamp, err := ptypes.TimestampFromProto(previous.Timestamp)
+		if err == nil && timestamp.Before(previousTimestamp) {
+			return errors.New(ErrStaleTreeHead)
+		}
+	}
+
+	return nil
+}
+
+// TreeHead returns the latest verified tree head, or nil.
+func (v *KeyLogVerifier) TreeHead() *pb.TreeHead {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.head
+}
+
+// Update fetches the latest tree head of the log and verifies that it is
+// consistent with the previous one.
+func (v *KeyLogVerifier) Update(ctx context.Context) (*pb.TreeHead, error) {
+	head, err := v.log.TreeHead(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = VerifyTreeHead(v.logKey, head)
+	if err != nil {
+		return nil, err
+	}
+
+	v.lock.Lock()
+	previous := v.head
+	v.lock.Unlock()
+
+	err = v.checkTreeHeadTime(head, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		if head.TreeSize < previous.TreeSize {
+			return nil, errors.New(ErrInconsistentLog)
+		}
+
+		err = v.verifyConsistency(ctx, previous, head)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		v.lock.Lock()
+		current := v.head
+		if current == previous {
+			v.head = head
+			v.lock.Unlock()
+			return head, nil
+		}
+		v.lock.Unlock()
+
+		// A concurrent update replaced the head we checked against: both
+		// heads must be consistent too.
+		if head.TreeSize <= current.TreeSize {
+			err = v.verifyConsistency(ctx, head, current)
+			if err != nil {
+				return nil, err
+			}
+
+			return current, nil
+		}
+
+		err = v.verifyConsistency(ctx, current, head)
+		if err != nil {
+			return nil, err
+		}
+
+		previous = current
+	}
+}
+
+// verifyConsistency verifies that the newer tree head extends the older one.
+func (v *KeyLogVerifier) verifyConsistency(ctx context.Context, older, newer *pb.TreeHead) error {
+	var proof [][32]byte
+	if older.TreeSize < newer.TreeSize {
+		var err error
+		proof, err = v.log.ConsistencyProof(ctx, older.TreeSize, newer.TreeSize)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	var oldRoot, newRoot [32]byte
+	copy(oldRoot[:], older.Root)
+	copy(newRoot[:], newer.Root)
+
+	err := VerifyConsistency(older.TreeSize, newer.TreeSize, oldRoot, newRoot, proof)
+	if err != nil {
+		return errors.Wrap(err, ErrInconsistentLog)
+	}
+
+	return nil
+}
+
+// Verify that a key record is the latest record of the peer included in
+// the log.
+// Records older than a record of the peer verified previously are rejected.
+func (v *KeyLogVerifier) Verify(ctx context.Context, peerID peer.ID, record []byte) error {
+	leaf := LeafHash(record)
+
+	v.lock.Lock()
+	var verified verifiedRecord
+	elem, ok := v.verified[peerID]
+	if ok {
+		verified = *elem.Value.(*verifiedRecord)
+	}
+	v.lock.Unlock()
+
+	if ok && verified.leaf == leaf && time.Since(verified.verifiedAt) < keyLogRecheckInterval {
+		return nil
+	}
+
+	head, err := v.Update(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest, index, proof, err := v.log.LatestRecord(ctx, peerID, head.TreeSize)
+	if err != nil {
+		return errors.Wrap(err, ErrKeyNotLogged)
+	}
+
+	var root [32]byte
+	copy(root[:], head.Root)
+
+	err = VerifyInclusion(LeafHash(latest), index, head.TreeSize, proof, root)
+	if err != nil {
+		return errors.Wrap(err, ErrKeyNotLogged)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if elem, ok := v.verified[peerID]; ok && index < elem.Value.(*verifiedRecord).index {
+		return errors.New(ErrStaleLoggedKey)
+	}
+
+	v.remember(peerID, LeafHash(latest), index)
+
+	if !bytes.Equal(latest, record) {
+		return errors.New(ErrStaleLoggedKey)
+	}
+
+	return nil
+}
+
+// remember the latest logged record of a peer.
+// Records older than the one already remembered are ignored, and the least
+// recently verified peer is forgotten when too many are remembered.
+// The lock must be held by the caller.
+func (v *KeyLogVerifier) remember(peerID peer.ID, leaf [32]byte, index uint64) {
+	if elem, ok := v.verified[peerID]; ok {
+		v.lru.MoveToFront(elem)
+
+		verified := elem.Value.(*verifiedRecord)
+		if index >= verified.index {
+			verified.leaf = leaf
+			verified.index = index
+			verified.verifiedAt = time.Now()
+		}
+
+		return
+	}
+
+	v.verified[peerID] = v.lru.PushFront(&verifiedRecord{
+		peerID:     peerID,
+		leaf:       leaf,
+		index:      index,
+		verifiedAt: time.Now(),
+	})
+
+	if v.lru.Len() > maxVerifiedRecords {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.verified, oldest.Value.(*verifiedRecord).peerID)
+	}
+}
+
+// KeyTransparency is an option to publish our key records to a transparency
+// log, and only use peer keys that are included in it.
+// The log's tree heads must be signed with logKey.
+func KeyTransparency(log KeyTransparencyLog, logKey crypto.PubKey) HostOption {
+	return func(opts *HostOptions) error {
+		opts.KeyLog = log
+		opts.KeyLogVerifier = NewKeyLogVerifier(log, logKey)
+		return nil
+	}
+}
+
+// verifyLoggedKey verifies that the key record of a peer is its latest
+// record in the transparency log.
+func (h *Host) verifyLoggedKey(ctx context.Context, peerID peer.ID, record []byte) error {
+	err := h.keyLogVerifier.Verify(ctx, peerID, record)
+	if err != nil {
+		h.keyCache.Invalidate(peerID)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,366 @@
+package echalotte_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t-bast/go-libp2p-echalotte"
+	"github.com/t-bast/go-libp2p-echalotte/echalottetesting"
+	pb "github.com/t-bast/go-libp2p-echalotte/pb"
+
+	"gx/ipfs/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	"gx/ipfs/QmPiemjiKBC9VA7vZF82m4x1oygtg2c2YVqag8PX7dN1BD/go-libp2p-peerstore"
+	"gx/ipfs/QmW7VUmSvhvSGbYbdsh7uRjhGmsYkc9fL8aJ5CorxxrU5N/go-crypto/nacl/box"
+	"gx/ipfs/QmY5Grm8pJdiSSVsYxx4uNRgweY72EmYwuSDbRnbFok3iY/go-libp2p-peer"
+)
+
+// forkedLog serves tree heads and proofs from one of two logs.
+type forkedLog struct {
+	echalotte.KeyTransparencyLog
+}
+
+// staleLog serves the given record, if any, as the latest record of every
+// peer.
+type staleLog struct {
+	echalotte.KeyTransparencyLog
+	record []byte
+}
+
+func (l *staleLog) LatestRecord(ctx context.Context, peerID peer.ID, size uint64) ([]byte, uint64, [][32]byte, error) {
+	if l.record == nil {
+		return l.KeyTransparencyLog.LatestRecord(ctx, peerID, size)
+	}
+
+	index, proof, err := l.InclusionProof(ctx, l.record, size)
+	return l.record, index, proof, err
+}
+
+// replayedLog serves the given tree head.
+type replayedLog struct {
+	echalotte.KeyTransparencyLog
+	head *pb.TreeHead
+}
+
+func (l *replayedLog) TreeHead(context.Context) (*pb.TreeHead, error) {
+	return l.head, nil
+}
+
+// racingLog runs race once, after computing its first consistency proof.
+type racingLog struct {
+	echalotte.KeyTransparencyLog
+	race func()
+}
+
+func (l *racingLog) ConsistencyProof(ctx context.Context, oldSize, newSize uint64) ([][32]byte, error) {
+	proof, err := l.KeyTransparencyLog.ConsistencyProof(ctx, oldSize, newSize)
+	if l.race != nil {
+		race := l.race
+		l.race = nil
+		race()
+	}
+
+	return proof, err
+}
+
+// randomKeyRecord creates a valid key record for a random peer.
+func randomKeyRecord(t *testing.T) (peer.ID, []byte) {
+	sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	peerID, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	pk, _, err := box.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+
+	record, err := echalotte.PublicKeyValidator{}.CreateRecord(sk, pk)
+	require.NoError(t, err)
+
+	return peerID, record
+}
+
+// rootOf returns the Merkle root of a tree head.
+func rootOf(head *pb.TreeHead) [32]byte {
+	var root [32]byte
+	copy(root[:], head.Root)
+	return root
+}
+
+func TestKeyTransparency(t *testing.T) {
+	ctx := context.Background()
+
+	logKey, logPublicKey, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	t.Run("Merkle proofs", func(t *testing.T) {
+		log := echalotte.NewMerkleKeyLog(logKey)
+
+		var records [][]byte
+		var heads []*pb.TreeHead
+
+		head, err := log.TreeHead(ctx)
+		require.NoError(t, err)
+		heads = append(heads, head)
+
+		for i := 0; i < 9; i++ {
+			peerID, record := randomKeyRecord(t)
+			require.NoError(t, log.Append(ctx, peerID, record))
+			require.NoError(t, log.Append(ctx, peerID, record))
+			records = append(records, record)
+
+			head, err := log.TreeHead(ctx)
+			require.NoError(t, err)
+			require.NoError(t, echalotte.VerifyTreeHead(logPublicKey, head))
+			assert.Equal(t, uint64(i+1), head.TreeSize)
+			heads = append(heads, head)
+		}
+
+		for size := uint64(1); size <= uint64(len(records)); size++ {
+			root := rootOf(heads[size])
+
+			for i, record := range records[:size] {
+				index, proof, err := log.InclusionProof(ctx, record, size)
+				require.NoError(t, err)
+				assert.Equal(t, uint64(i), index)
+
+				leaf := echalotte.LeafHash(record)
+				assert.NoError(t, echalotte.VerifyInclusion(leaf, index, size, proof, root))
+				assert.Error(t, echalotte.VerifyInclusion(leaf, index, size, proof, rootOf(heads[size-1])))
+				assert.Error(t, echalotte.VerifyInclusion(echalotte.LeafHash([]byte("forged")), index, size, proof, root))
+			}
+
+			_, _, err := log.InclusionProof(ctx, records[size-1], size-1)
+			assert.EqualError(t, err, echalotte.ErrUnknownLoggedEntry)
+
+			for oldSize := uint64(0); oldSize <= size; oldSize++ {
+				proof, err := log.ConsistencyProof(ctx, oldSize, size)
+				require.NoError(t, err)
+
+				oldRoot := rootOf(heads[oldSize])
+				assert.NoError(t, echalotte.VerifyConsistency(oldSize, size, oldRoot, root, proof))
+
+				if oldSize > 0 && oldSize < size {
+					assert.Error(t, echalotte.VerifyConsistency(oldSize, size, root, root, proof))
+					assert.Error(t, echalotte.VerifyConsistency(oldSize, size, oldRoot, oldRoot, proof))
+				}
+			}
+		}
+
+		_, err = log.ConsistencyProof(ctx, 3, 2)
+		assert.EqualError(t, err, echalotte.ErrInvalidLogRange)
+
+		peerID, record := randomKeyRecord(t)
+		assert.Error(t, log.Append(ctx, peerID, records[0]))
+		assert.Error(t, log.Append(ctx, peer.ID("unknown"), record))
+	})
+
+	t.Run("detects forks", func(t *testing.T) {
+		log1 := echalotte.NewMerkleKeyLog(logKey)
+		log2 := echalotte.NewMerkleKeyLog(logKey)
+
+		for i := 0; i < 2; i++ {
+			peerID, record := randomKeyRecord(t)
+			require.NoError(t, log1.Append(ctx, peerID, record))
+		}
+
+		for i := 0; i < 3; i++ {
+			peerID, record := randomKeyRecord(t)
+			require.NoError(t, log2.Append(ctx, peerID, record))
+		}
+
+		log := &forkedLog{KeyTransparencyLog: log1}
+		verifier := echalotte.NewKeyLogVerifier(log, logPublicKey)
+
+		head, err := verifier.Update(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), head.TreeSize)
+		assert.Equal(t, head, verifier.TreeHead())
+
+		log.KeyTransparencyLog = log2
+		_, err = verifier.Update(ctx)
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), echalotte.ErrInconsistentLog))
+
+		_, otherLogKey, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+
+		_, err = echalotte.NewKeyLogVerifier(log1, otherLogKey).Update(ctx)
+		assert.EqualError(t, err, echalotte.ErrInvalidTreeHead)
+	})
+
+	t.Run("only uses logged keys", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		dht := echalottetesting.NewInMemoryDHT()
+		log := echalotte.NewMerkleKeyLog(logKey)
+
+		relay, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilder(t),
+			echalotte.KeyTransparency(log, logPublicKey),
+		)
+		require.NoError(t, err)
+
+		head, err := log.TreeHead(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), head.TreeSize)
+
+		client, err := echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, []peer.ID{relay.ID()}, echalotte.CircuitSize(1)),
+			echalotte.KeyTransparency(log, logPublicKey),
+		)
+		require.NoError(t, err)
+
+		client.Peerstore().AddAddrs(relay.ID(), relay.Addrs(), peerstore.AddressTTL)
+		assert.NoError(t, client.SendMessage(ctx, peer.ID("alice"), []byte("Sous le pont Mirabeau coule la Seine")))
+
+		// A DHT node serves a record that isn't in the log.
+		unlogged := newRelays(ctx, t, dht, 1, 1)
+
+		client, err = echalotte.Connect(
+			ctx,
+			echalottetesting.RandomHost(ctx, t),
+			dht,
+			echalottetesting.NewDummyCircuitBuilderFromNetwork(t, unlogged, echalotte.CircuitSize(1)),
+			echalotte.KeyTransparency(log, logPublicKey),
+		)
+		require.NoError(t, err)
+
+		err = client.SendMessage(ctx, peer.ID("alice"), []byte("Vienne la nuit sonne l'heure"))
+		require.Error(t, err)
+
+		resolutionErr, ok := err.(*echalotte.KeyResolutionError)
+		require.True(t, ok)
+		assert.True(t, strings.HasPrefix(resolutionErr.Failures[unlogged[0]].Error(), echalotte.ErrKeyNotLogged))
+	})
+
+	t.Run("only accepts the latest record of a peer", func(t *testing.T) {
+		log := echalotte.NewMerkleKeyLog(logKey)
+
+		sk, _, err := crypto.GenerateEd25519Key(crand.Reader)
+		require.NoError(t, err)
+		peerID, err := peer.IDFromPrivateKey(sk)
+		require.NoError(t, err)
+
+		var records [][]byte
+		for i := 0; i < 2; i++ {
+			pk, _, err := box.GenerateKey(crand.Reader)
+			require.NoError(t, err)
+
+			record, err := echalotte.PublicKeyValidator{}.CreateRecord(sk, pk)
+			require.NoError(t, err)
+			require.NoError(t, log.Append(ctx, peerID, record))
+			records = append(records, record)
+
+			otherPeerID, otherRecord := randomKeyRecord(t)
+			require.NoError(t, log.Append(ctx, otherPeerID, otherRecord))
+		}
+
+		latest, index, _, err := log.LatestRecord(ctx, peerID, 4)
+		require.NoError(t, err)
+		assert.Equal(t, records[1], latest)
+		assert.Equal(t, uint64(2), index)
+
+		latest, index, _, err = log.LatestRecord(ctx, peerID, 2)
+		require.NoError(t, err)
+		assert.Equal(t, records[0], latest)
+		assert.Equal(t, uint64(0), index)
+
+		verifier := echalotte.NewKeyLogVerifier(log, logPublicKey)
+		assert.EqualError(t, verifier.Verify(ctx, peerID, records[0]), echalotte.ErrStaleLoggedKey)
+		assert.NoError(t, verifier.Verify(ctx, peerID, records[1]))
+
+		unknown, _ := randomKeyRecord(t)
+		err = verifier.Verify(ctx, unknown, records[1])
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), echalotte.ErrKeyNotLogged))
+
+		// The log can't roll a peer back to an older record.
+		stale := &staleLog{KeyTransparencyLog: log}
+		verifier = echalotte.NewKeyLogVerifier(stale, logPublicKey)
+		require.NoError(t, verifier.Verify(ctx, peerID, records[1]))
+
+		stale.record = records[0]
+		assert.EqualError(t, verifier.Verify(ctx, peerID, records[0]), echalotte.ErrStaleLoggedKey)
+	})
+
+	t.Run("rejects old tree heads", func(t *testing.T) {
+		log := echalotte.NewMerkleKeyLog(logKey)
+		peerID, record := randomKeyRecord(t)
+		require.NoError(t, log.Append(ctx, peerID, record))
+
+		old, err := log.TreeHead(ctx)
+		require.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+
+		replayed := &replayedLog{KeyTransparencyLog: log, head: old}
+		verifier := echalotte.NewKeyLogVerifier(replayed, logPublicKey)
+		verifier.MaxTreeHeadAge = 10 * time.Millisecond
+
+		_, err = verifier.Update(ctx)
+		assert.EqualError(t, err, echalotte.ErrStaleTreeHead)
+
+		// Heads can't go back in time either.
+		verifier.MaxTreeHeadAge = 0
+		replayed.head, err = log.TreeHead(ctx)
+		require.NoError(t, err)
+
+		_, err = verifier.Update(ctx)
+		require.NoError(t, err)
+
+		replayed.head = old
+		_, err = verifier.Update(ctx)
+		assert.EqualError(t, err, echalotte.ErrStaleTreeHead)
+	})
+
+	t.Run("checks concurrent tree heads against each other", func(t *testing.T) {
+		peerID, record := randomKeyRecord(t)
+
+		// Both logs start with the same record, then fork.
+		log1 := echalotte.NewMerkleKeyLog(logKey)
+		log2 := echalotte.NewMerkleKeyLog(logKey)
+		require.NoError(t, log1.Append(ctx, peerID, record))
+		require.NoError(t, log2.Append(ctx, peerID, record))
+
+		forkPeerID, forkRecord := randomKeyRecord(t)
+		require.NoError(t, log1.Append(ctx, forkPeerID, forkRecord))
+		for i := 0; i < 2; i++ {
+			forkPeerID, forkRecord := randomKeyRecord(t)
+			require.NoError(t, log2.Append(ctx, forkPeerID, forkRecord))
+		}
+
+		racing := &racingLog{KeyTransparencyLog: echalotte.NewMerkleKeyLog(logKey)}
+		require.NoError(t, racing.Append(ctx, peerID, record))
+
+		verifier := echalotte.NewKeyLogVerifier(racing, logPublicKey)
+		_, err := verifier.Update(ctx)
+		require.NoError(t, err)
+
+		// While the verifier checks the first fork, a concurrent update
+		// accepts a larger head of the second fork.
+		racing.KeyTransparencyLog = log1
+		racing.race = func() {
+			racing.KeyTransparencyLog = log2
+			head, err := verifier.Update(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(3), head.TreeSize)
+		}
+
+		_, err = verifier.Update(ctx)
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), echalotte.ErrInconsistentLog))
+		assert.Equal(t, uint64(3), verifier.TreeHead().TreeSize)
+	})
+}
@@ -3,6 +3,7 @@ package echalotte
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	pb "github.com/t-bast/go-libp2p-echalotte/pb"
 
@@ -18,32 +19,98 @@ const (
 	ErrInvalidKeyFormat       = "invalid DHT key format"
 	ErrInvalidNamespace       = "invalid DHT key namespace"
 	ErrInvalidSenderSignature = "invalid sender signature"
+	ErrInvalidKeyValidity     = "invalid encryption key validity window"
+	ErrKeyFromFuture          = "encryption key created in the future"
+	ErrKeyExpired             = "encryption key expired"
+	ErrNoValidRecord          = "no valid record to select"
 )
 
 const (
 	// EncryptionNamespace is the namespace used for storing encryption public
 	// keys on a DHT for node-to-node encryption.
 	EncryptionNamespace = "enc"
+
+	// DefaultClockSkew is the default clock difference tolerated between
+	// peers when validating timestamps.
+	DefaultClockSkew = 5 * time.Minute
+
+	// DefaultKeyMaxAge is the default lifetime of key records that don't
+	// have an explicit expiry.
+	// Hosts republish their key well before it expires.
+	DefaultKeyMaxAge = 7 * 24 * time.Hour
+
+	// DHTRecordMaxAge is the age after which DHT nodes drop records, whatever
+	// their content.
+	DHTRecordMaxAge = 36 * time.Hour
 )
 
 // PublicKeyValidator validates public keys used for node-to-node encryption
 // before storing them in the DHT.
-type PublicKeyValidator struct{}
+type PublicKeyValidator struct {
+	// ClockSkew tolerated when validating timestamps.
+	// Defaults to DefaultClockSkew.
+	ClockSkew time.Duration
+
+	// MaxAge of records without an explicit expiry.
+	// Defaults to DefaultKeyMaxAge.
+	MaxAge time.Duration
+
+	// BlindingPeriod of the DHT keys (see BlindedKeys).
+	// Defaults to no blinding.
+	BlindingPeriod time.Duration
+}
 
 // CreateKey returns a namespaced DHT key for the given peer's encryption key.
+// If the validator uses a blinding period, the key is blinded with the
+// current period.
 func (pkv PublicKeyValidator) CreateKey(peerID peer.ID) string {
+	if pkv.BlindingPeriod != 0 {
+		return pkv.CreateBlindedKey(peerID, pkv.BlindingPeriod, KeyEpoch(time.Now(), pkv.BlindingPeriod))
+	}
+
 	return fmt.Sprintf("/%s/%s", EncryptionNamespace, peerID.Pretty())
 }
 
 // CreateRecord creates a record for a Curve25519 encryption key.
 // This record is suitable for storage on a DHT.
+// The key stays valid until a newer key is published.
 func (pkv PublicKeyValidator) CreateRecord(signingKey crypto.PrivKey, encryptionKey *[32]byte) ([]byte, error) {
-	publicKey := &pb.PublicKey{
+	return pkv.createRecord(signingKey, &pb.PublicKey{
 		Type:      pb.KeyType_Curve25519,
 		CreatedAt: ptypes.TimestampNow(),
 		Data:      encryptionKey[:],
+	})
+}
+
+// CreateRecordWithValidity creates a record for a Curve25519 encryption key
+// that is only valid between notBefore and notAfter.
+// This record is suitable for storage on a DHT.
+func (pkv PublicKeyValidator) CreateRecordWithValidity(signingKey crypto.PrivKey, encryptionKey *[32]byte, notBefore, notAfter time.Time) ([]byte, error) {
+	if !notAfter.After(notBefore) {
+		return nil, errors.New(ErrInvalidKeyValidity)
+	}
+
+	notBeforeProto, err := ptypes.TimestampProto(notBefore)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	notAfterProto, err := ptypes.TimestampProto(notAfter)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
+	return pkv.createRecord(signingKey, &pb.PublicKey{
+		Type:      pb.KeyType_Curve25519,
+		CreatedAt: ptypes.TimestampNow(),
+		Data:      encryptionKey[:],
+		NotBefore: notBeforeProto,
+		NotAfter:  notAfterProto,
+	})
+}
+
+// createRecord signs and serializes the given public key.
+func (pkv PublicKeyValidator) createRecord(signingKey crypto.PrivKey, publicKey *pb.PublicKey) ([]byte, error) {
 	toSign, err := proto.Marshal(publicKey)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -68,6 +135,8 @@ func (pkv PublicKeyValidator) CreateRecord(signingKey crypto.PrivKey, encryption
 }
 
 // Validate the node-to-node encryption record.
+// Records must be signed by the peer, must not be created in the future and
+// must not be expired.
 func (pkv PublicKeyValidator) Validate(key string, value []byte) (err error) {
 	defer func() {
 		if err != nil {
@@ -77,26 +146,30 @@ func (pkv PublicKeyValidator) Validate(key string, value []byte) (err error) {
 		}
 	}()
 
-	peerID, err := pkv.getPeerID(key)
-	if err != nil {
-		return err
-	}
+	_, err = pkv.validate(key, value, time.Now())
+	return err
+}
 
+// validate the record at the given time and return the parsed key.
+// Both plain and blinded keys are accepted.
+func (pkv PublicKeyValidator) validate(key string, value []byte, now time.Time) (*pb.PublicKey, error) {
 	var publicKey pb.PublicKey
-	err = proto.Unmarshal(value, &publicKey)
+	err := proto.Unmarshal(value, &publicKey)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	signatureKey, err := crypto.UnmarshalPublicKey(publicKey.SignatureKey)
-	if err != nil {
-		return errors.WithStack(err)
+	var peerID peer.ID
+	if isBlindedKey(key) {
+		peerID, err = pkv.getBlindedPeerID(key, publicKey.SignatureKey, now)
+	} else {
+		peerID, err = pkv.getPeerID(key)
 	}
-
-	if !peerID.MatchesPublicKey(signatureKey) {
-		return errors.New(ErrInvalidSenderSignature)
+	if err != nil {
+		return nil, err
 	}
 
+	signatureKey := publicKey.SignatureKey
 	signature := publicKey.Signature
 
 	publicKey.SignatureKey = nil
@@ -104,15 +177,72 @@ func (pkv PublicKeyValidator) Validate(key string, value []byte) (err error) {
 
 	signedBytes, err := proto.Marshal(&publicKey)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	ok, err := signatureKey.Verify(signedBytes, signature)
+	err = verifySignature(peerID, signatureKey, signature, signedBytes)
 	if err != nil {
-		return errors.Wrap(err, ErrInvalidSenderSignature)
+		return nil, err
 	}
-	if !ok {
-		return errors.New(ErrInvalidSenderSignature)
+
+	createdAt, err := ptypes.TimestampFromProto(publicKey.CreatedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if createdAt.After(now.Add(pkv.clockSkew())) {
+		return nil, errors.New(ErrKeyFromFuture)
+	}
+
+	if publicKey.NotBefore != nil && publicKey.NotAfter != nil {
+		notBefore, err := ptypes.TimestampFromProto(publicKey.NotBefore)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		notAfter, err := ptypes.TimestampFromProto(publicKey.NotAfter)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !notAfter.After(notBefore) {
+			return nil, errors.New(ErrInvalidKeyValidity)
+		}
+	}
+
+	expiry, err := pkv.Expiry(&publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if now.Add(-pkv.clockSkew()).After(expiry) {
+		return nil, errors.New(ErrKeyExpired)
+	}
+
+	err = validateEpochKeys(&publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateHybridKey(&publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateNextKey(&publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revocation := range publicKey.Revocations {
+		err = verifyRevocation(peerID, revocation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if IsRevoked(&publicKey, publicKey.Revocations) {
+		return nil, errors.New(ErrKeyRevoked)
 	}
 
 	// No need to validate that the point is on the curve because we only use
@@ -120,33 +250,159 @@ func (pkv PublicKeyValidator) Validate(key string, value []byte) (err error) {
 	// If we support more elliptic curves, we might need to check here that the
 	// public key received is a valid curve point.
 
-	return nil
+	return &publicKey, nil
+}
+
+// Expiry returns the time after which the key record must not be used.
+// Records without an explicit expiry are valid for MaxAge after their
+// creation.
+func (pkv PublicKeyValidator) Expiry(publicKey *pb.PublicKey) (time.Time, error) {
+	if publicKey.NotAfter != nil {
+		notAfter, err := ptypes.TimestampFromProto(publicKey.NotAfter)
+		return notAfter, errors.WithStack(err)
+	}
+
+	createdAt, err := ptypes.TimestampFromProto(publicKey.CreatedAt)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return createdAt.Add(pkv.maxAge()), nil
+}
+
+// maxAge returns the lifetime of records without an explicit expiry.
+func (pkv PublicKeyValidator) maxAge() time.Duration {
+	if pkv.MaxAge == 0 {
+		return DefaultKeyMaxAge
+	}
+
+	return pkv.MaxAge
 }
 
-// Select the most recently published encryption key.
-// We likely need to work on a more robust story around key revokation.
-func (pkv PublicKeyValidator) Select(_ string, values [][]byte) (int, error) {
-	i := 0
-	createdAt := int64(0)
+// republishInterval returns how often records should be republished so that
+// neither they nor the DHT entries storing them expire.
+func (pkv PublicKeyValidator) republishInterval() time.Duration {
+	lifetime := pkv.maxAge()
+	if lifetime > DHTRecordMaxAge {
+		lifetime = DHTRecordMaxAge
+	}
+
+	return lifetime / 3
+}
+
+// clockSkew returns the tolerated clock skew.
+func (pkv PublicKeyValidator) clockSkew() time.Duration {
+	if pkv.ClockSkew == 0 {
+		return DefaultClockSkew
+	}
+
+	return pkv.ClockSkew
+}
+
+// Select the most recently published encryption key that is currently
+// valid and hasn't been revoked.
+// Only fully validated records are considered, so that forged or
+// future-dated records can't win.
+// Revocations carried by any of the values apply to all of them, so that a
+// revoked key can't be reinstated by replaying an older record.
+// If none of the valid keys is usable yet, the most recently published one
+// is selected.
+func (pkv PublicKeyValidator) Select(key string, values [][]byte) (int, error) {
+	now := time.Now()
+
+	publicKeys := make([]*pb.PublicKey, len(values))
+	var revocations []*pb.KeyRevocation
 
 	for index, value := range values {
-		var publicKey pb.PublicKey
-		err := proto.Unmarshal(value, &publicKey)
+		publicKey, err := pkv.validate(key, value, now)
 		if err != nil {
 			continue
 		}
 
-		if publicKey.CreatedAt.GetSeconds() > createdAt {
+		publicKeys[index] = publicKey
+		revocations = append(revocations, publicKey.Revocations...)
+	}
+
+	i := -1
+	var createdAt time.Time
+	valid := false
+
+	for index, publicKey := range publicKeys {
+		if publicKey == nil {
+			continue
+		}
+
+		keyCreatedAt, _ := ptypes.TimestampFromProto(publicKey.CreatedAt)
+
+		isValid := IsValidAt(publicKey, now) && !IsRevoked(publicKey, revocations)
+		if valid && !isValid {
+			continue
+		}
+
+		if i < 0 || (isValid && !valid) || keyCreatedAt.After(createdAt) {
 			i = index
-			createdAt = publicKey.CreatedAt.GetSeconds()
+			createdAt = keyCreatedAt
+			valid = isValid
 		}
 	}
 
+	if i < 0 {
+		return 0, errors.New(ErrNoValidRecord)
+	}
+
 	return i, nil
 }
 
+// IsValidAt returns true if the given key can be used at the given time.
+func IsValidAt(publicKey *pb.PublicKey, t time.Time) bool {
+	if publicKey.NotBefore != nil {
+		notBefore, err := ptypes.TimestampFromProto(publicKey.NotBefore)
+		if err != nil || t.Before(notBefore) {
+			return false
+		}
+	}
+
+	if publicKey.NotAfter != nil {
+		notAfter, err := ptypes.TimestampFromProto(publicKey.NotAfter)
+		if err != nil || !t.Before(notAfter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifySignature verifies that the given bytes were signed by the given
+// peer's identity key.
+func verifySignature(peerID peer.ID, signatureKeyBytes, signature, signedBytes []byte) error {
+	signatureKey, err := crypto.UnmarshalPublicKey(signatureKeyBytes)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !peerID.MatchesPublicKey(signatureKey) {
+		return errors.New(ErrInvalidSenderSignature)
+	}
+
+	ok, err := signatureKey.Verify(signedBytes, signature)
+	if err != nil {
+		return errors.Wrap(err, ErrInvalidSenderSignature)
+	}
+	if !ok {
+		return errors.New(ErrInvalidSenderSignature)
+	}
+
+	return nil
+}
+
 // getPeerID takes a key in the form `/enc/$peerID` and extracts the peer ID.
 func (pkv PublicKeyValidator) getPeerID(key string) (peer.ID, error) {
+	return parseKey(key, EncryptionNamespace)
+}
+
+// parseKey takes a key in the form `/$namespace/$peerID` and extracts the
+// peer ID.
+func parseKey(key string, namespace string) (peer.ID, error) {
 	if len(key) == 0 || key[0] != '/' {
 		return "", errors.New(ErrInvalidKeyFormat)
 	}
@@ -159,7 +415,7 @@ func (pkv PublicKeyValidator) getPeerID(key string) (peer.ID, error) {
 	}
 
 	ns := key[:i]
-	if ns != EncryptionNamespace {
+	if ns != namespace {
 		return "", errors.New(ErrInvalidNamespace)
 	}
 
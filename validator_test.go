@@ -106,6 +106,11 @@ func TestPublicKeyValidator(t *testing.T) {
 			assert.EqualError(t, err, echalotte.ErrInvalidSenderSignature)
 		})
 
+		t.Run("Invalid validity window", func(t *testing.T) {
+			_, err := pkv.CreateRecordWithValidity(aliceSigPrivKey, aliceEncPubKey, time.Now(), time.Now().Add(-time.Hour))
+			assert.EqualError(t, err, echalotte.ErrInvalidKeyValidity)
+		})
+
 		t.Run("Valid record", func(t *testing.T) {
 			err := pkv.Validate(pkv.CreateKey(alice), aliceRecord)
 			assert.NoError(t, err)
@@ -135,5 +140,105 @@ func TestPublicKeyValidator(t *testing.T) {
 			require.NoError(t, err)
 			assert.Equal(t, 1, i)
 		})
+
+		t.Run("Selects currently valid key", func(t *testing.T) {
+			now := time.Now()
+			next, err := pkv.CreateRecordWithValidity(aliceSigPrivKey, key1, now.Add(time.Hour), now.Add(2*time.Hour))
+			require.NoError(t, err)
+
+			current, err := pkv.CreateRecordWithValidity(aliceSigPrivKey, key2, now.Add(-time.Hour), now.Add(time.Hour))
+			require.NoError(t, err)
+
+			i, err := pkv.Select(pkv.CreateKey(alice), [][]byte{next, current})
+			require.NoError(t, err)
+			assert.Equal(t, 1, i)
+
+			// Keys without bounds are valid until they expire.
+			i, err = pkv.Select(pkv.CreateKey(alice), [][]byte{next, record1})
+			require.NoError(t, err)
+			assert.Equal(t, 1, i)
+		})
+	})
+
+	t.Run("Freshness", func(t *testing.T) {
+		key, _, err := box.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		createdAt := func(d time.Duration) []byte {
+			ts, err := ptypes.TimestampProto(time.Now().Add(d))
+			require.NoError(t, err)
+
+			return signKeyRecord(t, aliceSigPrivKey, &pb.PublicKey{
+				Type:      pb.KeyType_Curve25519,
+				CreatedAt: ts,
+				Data:      key[:],
+			})
+		}
+
+		t.Run("Rejects future-dated keys", func(t *testing.T) {
+			err := pkv.Validate(pkv.CreateKey(alice), createdAt(365*24*time.Hour))
+			assert.EqualError(t, err, echalotte.ErrKeyFromFuture)
+		})
+
+		t.Run("Tolerates clock skew", func(t *testing.T) {
+			err := pkv.Validate(pkv.CreateKey(alice), createdAt(time.Minute))
+			assert.NoError(t, err)
+
+			strict := echalotte.PublicKeyValidator{ClockSkew: time.Second}
+			err = strict.Validate(pkv.CreateKey(alice), createdAt(time.Minute))
+			assert.EqualError(t, err, echalotte.ErrKeyFromFuture)
+		})
+
+		t.Run("Rejects expired keys", func(t *testing.T) {
+			err := pkv.Validate(pkv.CreateKey(alice), createdAt(-2*echalotte.DefaultKeyMaxAge))
+			assert.EqualError(t, err, echalotte.ErrKeyExpired)
+
+			now := time.Now()
+			expired, err := pkv.CreateRecordWithValidity(aliceSigPrivKey, key, now.Add(-2*time.Hour), now.Add(-time.Hour))
+			require.NoError(t, err)
+
+			err = pkv.Validate(pkv.CreateKey(alice), expired)
+			assert.EqualError(t, err, echalotte.ErrKeyExpired)
+		})
+
+		t.Run("Future-dated keys don't win Select()", func(t *testing.T) {
+			i, err := pkv.Select(pkv.CreateKey(alice), [][]byte{createdAt(365 * 24 * time.Hour), aliceRecord})
+			require.NoError(t, err)
+			assert.Equal(t, 1, i)
+		})
+
+		t.Run("Select() ignores unsigned values", func(t *testing.T) {
+			var forged pb.PublicKey
+			require.NoError(t, proto.Unmarshal(aliceRecord, &forged))
+			forged.CreatedAt.Seconds += 60
+			forged.Data = key[:]
+
+			forgedRecord, err := proto.Marshal(&forged)
+			require.NoError(t, err)
+
+			i, err := pkv.Select(pkv.CreateKey(alice), [][]byte{forgedRecord, aliceRecord})
+			require.NoError(t, err)
+			assert.Equal(t, 1, i)
+
+			_, err = pkv.Select(pkv.CreateKey(alice), [][]byte{forgedRecord})
+			assert.EqualError(t, err, echalotte.ErrNoValidRecord)
+		})
 	})
 }
+
+// signKeyRecord signs and serializes the given key record.
+func signKeyRecord(t *testing.T, sk crypto.PrivKey, publicKey *pb.PublicKey) []byte {
+	toSign, err := proto.Marshal(publicKey)
+	require.NoError(t, err)
+
+	publicKey.Signature, err = sk.Sign(toSign)
+	require.NoError(t, err)
+
+	publicKey.SignatureKey, err = sk.GetPublic().Bytes()
+	require.NoError(t, err)
+
+	record, err := proto.Marshal(publicKey)
+	require.NoError(t, err)
+
+	return record
+}